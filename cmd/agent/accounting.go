@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/accounting"
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+)
+
+// runAccounting handles the 'accounting' subcommand
+func runAccounting() {
+	if len(os.Args) < 3 {
+		fmt.Fprintf(os.Stderr, "Usage: ocserv-agent accounting <export|whois> [flags]\n")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "export":
+		runAccountingExport()
+	case "whois":
+		runAccountingWhois()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown accounting command %q (expected export or whois)\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+// runAccountingExport handles 'accounting export'
+func runAccountingExport() {
+	exportCmd := flag.NewFlagSet("accounting export", flag.ExitOnError)
+	configPath := exportCmd.String("config", "config.yaml", "Path to configuration file")
+	formatName := exportCmd.String("format", "csv", "Export format: csv or jsonl")
+	output := exportCmd.String("output", "", "Output file (default: stdout)")
+	username := exportCmd.String("user", "", "Only sessions of this user")
+	group := exportCmd.String("group", "", "Only sessions of this group")
+	ip := exportCmd.String("ip", "", "Only sessions with this client or VPN IP")
+	from := exportCmd.String("from", "", "Sessions active at or after this time (RFC3339 or YYYY-MM-DD)")
+	to := exportCmd.String("to", "", "Sessions active before this time (RFC3339 or YYYY-MM-DD)")
+
+	if err := exportCmd.Parse(os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	format, err := accounting.ParseFormat(*formatName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	filter := accounting.Filter{
+		Username:  *username,
+		GroupName: *group,
+		IP:        *ip,
+	}
+	if filter.From, err = parseCLITime(*from); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -from: %v\n", err)
+		os.Exit(1)
+	}
+	if filter.To, err = parseCLITime(*to); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -to: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Ledger files are append-only, so reading them while the agent runs
+	// is safe. Retention is left to the agent.
+	ledger, err := accounting.Open(accounting.Config{Dir: cfg.Accounting.Dir})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open accounting ledger: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = ledger.Close() }()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	count, err := ledger.Export(context.Background(), w, format, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d sessions to %s\n", count, *output)
+	}
+}
+
+// runAccountingWhois handles 'accounting whois': which users and sessions
+// held a VPN IP at a point in time or during a range
+func runAccountingWhois() {
+	whoisCmd := flag.NewFlagSet("accounting whois", flag.ExitOnError)
+	configPath := whoisCmd.String("config", "config.yaml", "Path to configuration file")
+	ip := whoisCmd.String("ip", "", "VPN IPv4 or IPv6 address (required)")
+	at := whoisCmd.String("at", "", "Point in time (RFC3339 or YYYY-MM-DD)")
+	from := whoisCmd.String("from", "", "Range start (RFC3339 or YYYY-MM-DD)")
+	to := whoisCmd.String("to", "", "Range end (RFC3339 or YYYY-MM-DD)")
+
+	if err := whoisCmd.Parse(os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		os.Exit(1)
+	}
+	if *ip == "" {
+		fmt.Fprintf(os.Stderr, "Error: -ip is required\n")
+		os.Exit(1)
+	}
+
+	var rangeFrom, rangeTo time.Time
+	var err error
+	if *at != "" {
+		if rangeFrom, err = parseCLITime(*at); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -at: %v\n", err)
+			os.Exit(1)
+		}
+		rangeTo = rangeFrom
+	} else {
+		if rangeFrom, err = parseCLITime(*from); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -from: %v\n", err)
+			os.Exit(1)
+		}
+		if rangeTo, err = parseCLITime(*to); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -to: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	ledger, err := accounting.Open(accounting.Config{Dir: cfg.Accounting.Dir})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open accounting ledger: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() { _ = ledger.Close() }()
+
+	index, err := accounting.OpenLeaseIndex(ledger, filepath.Join(cfg.Accounting.Dir, accounting.ActiveLeasesFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open VPN IP lease index: %v\n", err)
+		os.Exit(1)
+	}
+
+	leases, err := index.Lookup(context.Background(), *ip, rangeFrom, rangeTo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Lookup failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(leases) == 0 {
+		fmt.Printf("No sessions held %s in the requested time\n", *ip)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "USERNAME\tGROUP\tSESSION\tCLIENT IP\tSTART\tEND\n")
+	for _, lease := range leases {
+		end := "active"
+		if !lease.Active() {
+			end = lease.End.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			lease.Username,
+			lease.GroupName,
+			lease.SessionID,
+			lease.ClientIP,
+			lease.Start.Local().Format(time.RFC3339),
+			end,
+		)
+	}
+	_ = tw.Flush()
+}
+
+// parseCLITime parses a command line timestamp (empty = zero time)
+func parseCLITime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/admission"
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+)
+
+// fixedSessions is a SessionCounter reporting the same count for every user
+type fixedSessions int
+
+func (n fixedSessions) CountSessions(string) int {
+	return int(n)
+}
+
+// runAdmission handles the 'admission' subcommand
+func runAdmission() {
+	if len(os.Args) < 3 || os.Args[2] != "test" {
+		fmt.Fprintf(os.Stderr, "Usage: ocserv-agent admission test [flags]\n")
+		os.Exit(1)
+	}
+
+	testCmd := flag.NewFlagSet("admission test", flag.ExitOnError)
+	configPath := testCmd.String("config", "config.yaml", "Path to configuration file")
+	username := testCmd.String("user", "", "Username of the sample request (required)")
+	group := testCmd.String("group", "", "Group of the sample request")
+	ip := testCmd.String("ip", "", "Client IP of the sample request")
+	vpnIP := testCmd.String("vpn-ip", "", "VPN IP of the sample request")
+	device := testCmd.String("device", "", "Device of the sample request")
+	userAgent := testCmd.String("user-agent", "", "User agent of the sample request")
+	sessions := testCmd.Int("sessions", 0, "Active sessions of the user, returned by sessions()")
+	at := testCmd.String("at", "", "Time of the request (RFC3339 or YYYY-MM-DD, default: now)")
+
+	if err := testCmd.Parse(os.Args[3:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
+		os.Exit(1)
+	}
+	if *username == "" {
+		fmt.Fprintf(os.Stderr, "Error: -user is required\n")
+		os.Exit(1)
+	}
+
+	now, err := parseCLITime(*at)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -at: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if !cfg.Admission.Enabled {
+		fmt.Fprintf(os.Stderr, "Warning: admission rules are disabled in %s\n", *configPath)
+	}
+
+	engine, err := newAdmissionEngine(&cfg.Admission, fixedSessions(*sessions), metricnoop.NewMeterProvider().Meter("cli"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to compile admission rules: %v\n", err)
+		os.Exit(1)
+	}
+
+	in := admission.Input{
+		Username:  *username,
+		GroupName: *group,
+		ClientIP:  *ip,
+		VPNIP:     *vpnIP,
+		Device:    *device,
+		UserAgent: *userAgent,
+		Time:      now,
+	}
+	if in.Time.IsZero() {
+		in.Time = time.Now()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "RULE\tACTION\tRESULT\tEXPR\n")
+	for _, result := range engine.EvaluateAll(in) {
+		outcome := "no match"
+		switch {
+		case result.Err != nil:
+			outcome = "error: " + result.Err.Error()
+		case result.Matched:
+			outcome = "match"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Rule.Name, result.Rule.Action, outcome, result.Rule.Expr)
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	decision := engine.Evaluate(context.Background(), in)
+	switch {
+	case decision.Action == admission.ActionDeny:
+		fmt.Printf("\nDecision: deny (rule %s)", decision.Rule)
+		if decision.Reason != "" {
+			fmt.Printf(": %s", decision.Reason)
+		}
+		fmt.Println()
+		os.Exit(1)
+	case decision.Rule != "":
+		fmt.Printf("\nDecision: allow (rule %s), portal decides\n", decision.Rule)
+	default:
+		fmt.Printf("\nDecision: no rule matched, portal decides\n")
+	}
+}
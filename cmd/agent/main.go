@@ -26,6 +26,12 @@ func main() {
 		case "gencert":
 			runGenCert()
 			return
+		case "accounting":
+			runAccounting()
+			return
+		case "admission":
+			runAdmission()
+			return
 		case "version", "--version", "-v":
 			fmt.Printf("ocserv-agent version %s\n", version)
 			os.Exit(0)
@@ -207,6 +213,9 @@ func printUsage() {
 Usage:
   ocserv-agent [flags]                Run the agent server
   ocserv-agent gencert [flags]        Generate certificates
+  ocserv-agent accounting export      Export closed-session history
+  ocserv-agent accounting whois       Find who held a VPN IP at a given time
+  ocserv-agent admission test         Evaluate admission rules against a sample request
   ocserv-agent version                Show version
   ocserv-agent help                   Show this help
 
@@ -226,6 +235,41 @@ GenCert Flags:
   -ca string
         Path to CA certificate for signing (not implemented yet)
 
+Accounting Export Flags:
+  -config string
+        Path to configuration file (default "config.yaml")
+  -format string
+        Export format: csv or jsonl (default "csv")
+  -output string
+        Output file (default: stdout)
+  -user, -group, -ip string
+        Only sessions matching the user, group or client/VPN IP
+  -from, -to string
+        Only sessions active in [from, to) (RFC3339 or YYYY-MM-DD)
+
+Accounting Whois Flags:
+  -config string
+        Path to configuration file (default "config.yaml")
+  -ip string
+        VPN IPv4 or IPv6 address (required)
+  -at string
+        Point in time (RFC3339 or YYYY-MM-DD)
+  -from, -to string
+        Time range, lists every user that held the address in it
+
+Admission Test Flags:
+  -config string
+        Path to configuration file (default "config.yaml")
+  -user string
+        Username of the sample request (required)
+  -group, -ip, -vpn-ip, -device, -user-agent string
+        Other fields of the sample request
+  -sessions int
+        Active sessions of the user, returned by sessions() (default 0)
+  -at string
+        Time of the request (RFC3339 or YYYY-MM-DD, default: now)
+  Exits with status 1 if the request is denied.
+
 Examples:
   # Run agent server with default config
   ocserv-agent
@@ -239,6 +283,15 @@ Examples:
   # Generate with custom hostname
   ocserv-agent gencert -hostname vpn.example.com -output /etc/ocserv-agent/certs
 
+  # Export last month's sessions for a usage report
+  ocserv-agent accounting export -from 2025-09-01 -to 2025-10-01 -output sessions.csv
+
+  # Who had 10.0.16.23 at 14:05?
+  ocserv-agent accounting whois -ip 10.0.16.23 -at 2025-10-18T14:05:00+03:00
+
+  # Would a contractor's second session be admitted?
+  ocserv-agent admission test -user alice -group contractors -ip 203.0.113.10 -sessions 1
+
 For more information, visit: https://github.com/dantte-lp/ocserv-agent
 `)
 }
@@ -6,19 +6,29 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/dantte-lp/ocserv-agent/internal/accounting"
+	"github.com/dantte-lp/ocserv-agent/internal/admission"
 	"github.com/dantte-lp/ocserv-agent/internal/config"
 	"github.com/dantte-lp/ocserv-agent/internal/ipc"
 	"github.com/dantte-lp/ocserv-agent/internal/logging"
 	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	"github.com/dantte-lp/ocserv-agent/internal/policy"
 	"github.com/dantte-lp/ocserv-agent/internal/portal"
+	"github.com/dantte-lp/ocserv-agent/internal/radius"
 	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
 	"github.com/dantte-lp/ocserv-agent/internal/stats"
 	"github.com/dantte-lp/ocserv-agent/internal/telemetry"
+	"github.com/dantte-lp/ocserv-agent/internal/webhook"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
 )
 
 // runServerPhase2 запускает агент с поддержкой IPC server и stats poller (Фаза 2)
@@ -51,26 +61,18 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 	meterProvider := otel.GetMeterProvider()
 	meter := meterProvider.Meter(cfg.Telemetry.ServiceName)
 
-	// Создаем Circuit Breaker для Portal Client
+	// Настройки Circuit Breaker: portal client создает отдельный
+	// breaker для каждого endpoint
 	logger.InfoContext(ctx, "initializing circuit breaker",
 		slog.Uint64("failure_threshold", uint64(cfg.Resilience.CircuitBreaker.FailureThreshold)),
 		slog.Duration("timeout", cfg.Resilience.CircuitBreaker.Timeout),
 	)
-	circuitBreaker, cbErr := resilience.NewCircuitBreaker(
-		resilience.Config{
-			MaxRequests:      cfg.Resilience.CircuitBreaker.MaxRequests,
-			Interval:         cfg.Resilience.CircuitBreaker.Interval,
-			Timeout:          cfg.Resilience.CircuitBreaker.Timeout,
-			FailureThreshold: cfg.Resilience.CircuitBreaker.FailureThreshold,
-		},
-		tracer,
-		meter,
-	)
-	if cbErr != nil {
-		return fmt.Errorf("create circuit breaker: %w", cbErr)
+	circuitBreaker := &resilience.Config{
+		MaxRequests:      cfg.Resilience.CircuitBreaker.MaxRequests,
+		Interval:         cfg.Resilience.CircuitBreaker.Interval,
+		Timeout:          cfg.Resilience.CircuitBreaker.Timeout,
+		FailureThreshold: cfg.Resilience.CircuitBreaker.FailureThreshold,
 	}
-	// TODO: Integrate circuit breaker into portal client or IPC handler
-	_ = circuitBreaker // Reserved for future integration
 
 	// Создаем Decision Cache для IPC Handler
 	logger.InfoContext(ctx, "initializing decision cache",
@@ -91,6 +93,42 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 		return fmt.Errorf("create decision cache: %w", err)
 	}
 
+	// Снимок кэша решений: решения предыдущего запуска восстанавливаются,
+	// чтобы fail_mode stale работал после рестарта при недоступном portal
+	if snapshot := cfg.Resilience.Cache; snapshot.SnapshotFile != "" {
+		var snapshotKey []byte
+		if snapshot.SnapshotKeyFile != "" {
+			snapshotKey, err = resilience.LoadSnapshotKey(snapshot.SnapshotKeyFile)
+			if err != nil {
+				return fmt.Errorf("load decision cache snapshot key: %w", err)
+			}
+		}
+
+		loaded, err := decisionCache.LoadSnapshot(ctx, snapshot.SnapshotFile, snapshotKey)
+		if err != nil {
+			logger.WarnContext(ctx, "ignoring unreadable decision cache snapshot",
+				slog.String("path", snapshot.SnapshotFile),
+				slog.String("error", err.Error()),
+			)
+		} else {
+			logger.InfoContext(ctx, "decision cache restored",
+				slog.String("path", snapshot.SnapshotFile),
+				slog.Int("entries", loaded),
+				slog.Bool("encrypted", snapshotKey != nil),
+			)
+		}
+
+		go runCacheSnapshots(ctx, decisionCache, snapshot.SnapshotFile, snapshotKey, snapshot.SnapshotInterval, logger)
+		defer func() {
+			if err := decisionCache.SaveSnapshot(snapshot.SnapshotFile, snapshotKey); err != nil {
+				logger.ErrorContext(context.Background(), "failed to save decision cache snapshot",
+					slog.String("path", snapshot.SnapshotFile),
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+	}
+
 	// Создаем occtl manager для работы с ocserv
 	// Используем zerolog для совместимости с существующим кодом ocserv
 	zlogger := zerolog.New(os.Stdout).With().Timestamp().Logger()
@@ -104,6 +142,8 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 	// Создаем portal client
 	logger.InfoContext(ctx, "connecting to portal",
 		slog.String("address", cfg.Portal.Address),
+		slog.Int("endpoints", len(cfg.Portal.Endpoints)),
+		slog.String("local_datacenter", cfg.Portal.LocalDatacenter),
 		slog.Bool("tls", !cfg.Portal.Insecure),
 	)
 
@@ -116,6 +156,15 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 			TLSCA:    cfg.Portal.TLSCA,
 			Timeout:  cfg.Portal.Timeout,
 			Insecure: cfg.Portal.Insecure,
+
+			Endpoints:           portalEndpoints(cfg.Portal.Endpoints),
+			LocalDatacenter:     cfg.Portal.LocalDatacenter,
+			Selection:           cfg.Portal.Selection,
+			HealthCheckInterval: cfg.Portal.HealthCheckInterval,
+			FailbackPeriod:      cfg.Portal.FailbackPeriod,
+
+			CircuitBreaker: circuitBreaker,
+			Retry:          portalRetryPolicies(cfg.Portal.Retry),
 		},
 		logger,
 		tracer,
@@ -127,19 +176,154 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 	}
 	defer portalClient.Close()
 
+	// Периодическая проверка состояния portal (активный endpoint, circuit breaker)
+	go runPortalHealthChecks(ctx, portalClient, cfg.Health.DeepCheckInterval, logger)
+
+	// Состояние circuit breaker каждого endpoint отдается в /health;
+	// агент degraded, когда открыты breaker всех endpoint
+	telemetry.RegisterHealthCheck("portal", portalClient.BreakerChecks)
+
+	// Отчеты о подключениях/отключениях идут через write-ahead outbox:
+	// событие сохраняется до отправки и досылается после рестарта
+	portalOutbox, err := portal.NewOutbox(&portal.OutboxConfig{
+		Path:           cfg.Portal.Outbox.Path,
+		Reporter:       portalClient,
+		InitialBackoff: cfg.Portal.Outbox.InitialBackoff,
+		MaxBackoff:     cfg.Portal.Outbox.MaxBackoff,
+		Logger:         logger,
+		Meter:          meter,
+	})
+	if err != nil {
+		return fmt.Errorf("open portal outbox: %w", err)
+	}
+	portalOutbox.Start(ctx)
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer shutdownCancel()
+		if err := portalOutbox.Stop(shutdownCtx); err != nil {
+			logger.WarnContext(shutdownCtx, "portal outbox not drained, events will be replayed on next start",
+				slog.String("error", err.Error()),
+			)
+		}
+	}()
+
+	// Расписания доступа проверяются локально, без обращения к portal
+	var scheduleEvaluator *schedule.Evaluator
+	if cfg.Schedule.Enabled {
+		scheduleEvaluator, err = schedule.NewEvaluator(cfg.Schedule.RuleSpecs())
+		if err != nil {
+			return fmt.Errorf("create schedule evaluator: %w", err)
+		}
+		logger.InfoContext(ctx, "access schedules enabled",
+			slog.Int("rules", len(cfg.Schedule.Rules)),
+			slog.Duration("grace_warning", cfg.Schedule.GraceWarning),
+		)
+	}
+
+	// Локальная политика: жесткие запреты до обращения к portal и
+	// разрешения при его недоступности. Файл правил перечитывается при изменении
+	var localPolicy *policy.Engine
+	if cfg.LocalPolicy.Enabled {
+		localPolicy, err = policy.NewEngine(policy.EngineConfig{
+			Path:           cfg.LocalPolicy.File,
+			CountryFile:    cfg.LocalPolicy.CountryFile,
+			ReloadInterval: cfg.LocalPolicy.ReloadInterval,
+			Logger:         logger,
+			Meter:          meter,
+		})
+		if err != nil {
+			return fmt.Errorf("create local policy: %w", err)
+		}
+		go localPolicy.Run(ctx)
+		logger.InfoContext(ctx, "local policy enabled",
+			slog.String("file", cfg.LocalPolicy.File),
+			slog.Duration("reload_interval", cfg.LocalPolicy.ReloadInterval),
+		)
+	}
+
+	// Stats poller создается позже IPC handler; правила допуска и учет
+	// отключений обращаются к нему через эту ссылку
+	statsRef := &pollerRef{}
+
+	// Правила допуска (CEL-выражения) компилируются один раз при старте
+	var admissionRules *admission.Engine
+	if cfg.Admission.Enabled {
+		admissionRules, err = newAdmissionEngine(&cfg.Admission, statsRef, meter)
+		if err != nil {
+			return fmt.Errorf("create admission rules: %w", err)
+		}
+		logger.InfoContext(ctx, "admission rules enabled",
+			slog.Int("rules", admissionRules.Len()),
+		)
+	}
+
+	// Маршруты и DNS от portal записываются в per-user конфиг при подключении.
+	// Portal — источник истины, поэтому резервные копии не создаются.
+	var userConfigGenerator *config.Generator
+	if cfg.Ocserv.ConfigPerUserDir != "" {
+		userConfigGenerator, err = config.NewGenerator(cfg.Ocserv.ConfigPerUserDir, cfg.Ocserv.ConfigPerGroupDir, "")
+		if err != nil {
+			logger.WarnContext(ctx, "per-user config generator unavailable, portal routes will not be applied",
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	// Правила fail_mode по пользователям, группам и сетям клиентов
+	failModes, err := resilience.NewFailModes(cfg.Resilience.FailMode, cfg.Resilience.FailModeRuleSpecs())
+	if err != nil {
+		return fmt.Errorf("create fail mode rules: %w", err)
+	}
+
 	// Создаем IPC handler с Decision Cache
 	logger.InfoContext(ctx, "creating IPC handler",
 		slog.String("fail_mode", cfg.Resilience.FailMode),
+		slog.Int("fail_mode_rules", len(cfg.Resilience.FailModeRules)),
 	)
-	ipcHandler, err := ipc.NewHandler(&ipc.HandlerConfig{
+	handlerCfg := &ipc.HandlerConfig{
 		Logger:        logger,
 		Tracer:        tracer,
 		Meter:         meter,
 		PortalClient:  portalClient,
 		DecisionCache: decisionCache,
 		FailMode:      cfg.Resilience.FailMode,
+		FailModeRules: failModes,
+		Disconnects:   statsRef,
 		Timeout:       cfg.IPC.Timeout,
-	})
+
+		RefreshAhead:   cfg.Resilience.Cache.RefreshAhead,
+		RefreshMinHits: cfg.Resilience.Cache.RefreshMinHits,
+	}
+	if scheduleEvaluator != nil {
+		handlerCfg.Schedule = scheduleEvaluator
+	}
+	if localPolicy != nil {
+		handlerCfg.LocalPolicy = localPolicy
+	}
+	if admissionRules != nil {
+		handlerCfg.Admission = admissionRules
+	}
+	if userConfigGenerator != nil {
+		handlerCfg.UserConfig = userConfigGenerator
+	}
+	// Ограничение одновременных проверок в portal защищает его от
+	// всплеска переподключений после перезапуска ocserv
+	if cfg.IPC.MaxConcurrent > 0 {
+		limiter, err := ipc.NewLimiter(&ipc.LimiterConfig{
+			MaxConcurrent: cfg.IPC.MaxConcurrent,
+			MaxQueue:      cfg.IPC.MaxQueue,
+			Meter:         meter,
+		})
+		if err != nil {
+			return fmt.Errorf("create IPC limiter: %w", err)
+		}
+		handlerCfg.Limiter = limiter
+		logger.InfoContext(ctx, "IPC admission control enabled",
+			slog.Int("max_concurrent", cfg.IPC.MaxConcurrent),
+			slog.Int("max_queue", cfg.IPC.MaxQueue),
+		)
+	}
+	ipcHandler, err := ipc.NewHandler(handlerCfg)
 	if err != nil {
 		return fmt.Errorf("create IPC handler: %w", err)
 	}
@@ -148,12 +332,31 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 	logger.InfoContext(ctx, "creating IPC server",
 		slog.String("socket", cfg.IPC.SocketPath),
 	)
+	// Проверка SO_PEERCRED: к сокету допускаются только указанные
+	// пользователи и группы (по умолчанию root и пользователь ocserv;
+	// несуществующие учетные записи по умолчанию пропускаются).
+	// Отключается только явным allow_all_peers
+	ipcPeers := ipc.PeerPolicy{AllowAll: true}
+	if !cfg.IPC.AllowAllPeers {
+		ipcPeers, err = ipc.NewPeerPolicy(cfg.IPCPeers(), logger)
+		if err != nil {
+			return fmt.Errorf("resolve IPC allowed peers: %w", err)
+		}
+	}
+	socketMode, err := cfg.IPC.FileMode()
+	if err != nil {
+		return fmt.Errorf("parse IPC socket mode: %w", err)
+	}
 	ipcServer, err := ipc.NewServer(&ipc.ServerConfig{
-		SocketPath: cfg.IPC.SocketPath,
-		Handler:    ipcHandler,
-		Logger:     logger,
-		Tracer:     tracer,
-		Meter:      meter,
+		SocketPath:  cfg.IPC.SocketPath,
+		Handler:     ipcHandler,
+		Logger:      logger,
+		Tracer:      tracer,
+		Meter:       meter,
+		Peers:       ipcPeers,
+		SocketMode:  socketMode,
+		SocketOwner: cfg.IPC.SocketOwner,
+		SocketGroup: cfg.IPC.SocketGroup,
 	})
 	if err != nil {
 		return fmt.Errorf("create IPC server: %w", err)
@@ -173,34 +376,154 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 		}
 	}()
 
+	// Журнал завершенных сессий
+	var (
+		ledger *accounting.Ledger
+		leases *accounting.LeaseIndex
+	)
+	if cfg.Accounting.Enabled {
+		ledger, err = accounting.Open(accounting.Config{
+			Dir:       cfg.Accounting.Dir,
+			Retention: cfg.Accounting.Retention,
+			Logger:    logger,
+		})
+		if err != nil {
+			return fmt.Errorf("open accounting ledger: %w", err)
+		}
+		defer func() {
+			if err := ledger.Close(); err != nil {
+				logger.ErrorContext(ctx, "accounting ledger close error",
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+		logger.InfoContext(ctx, "accounting ledger enabled",
+			slog.String("dir", cfg.Accounting.Dir),
+			slog.Duration("retention", cfg.Accounting.Retention),
+		)
+
+		// Индекс аренд VPN IP для атрибуции адресов пользователям
+		leases, err = accounting.OpenLeaseIndex(ledger, filepath.Join(cfg.Accounting.Dir, accounting.ActiveLeasesFile))
+		if err != nil {
+			return fmt.Errorf("open VPN IP lease index: %w", err)
+		}
+	}
+
+	// RADIUS Accounting exporter
+	var radiusExporter *radius.Exporter
+	if cfg.Radius.Enabled {
+		radiusExporter, err = radius.NewExporter(&radius.Config{
+			Servers:         cfg.Radius.Servers,
+			Secret:          cfg.Radius.Secret,
+			Timeout:         cfg.Radius.Timeout,
+			Retries:         *cfg.Radius.Retries,
+			InterimInterval: cfg.Radius.InterimInterval,
+			NASIdentifier:   cfg.Radius.NASIdentifier,
+			NASIPAddress:    cfg.Radius.NASIPAddress,
+			QueueSize:       cfg.Radius.QueueSize,
+			Logger:          logger,
+			Meter:           meter,
+		})
+		if err != nil {
+			return fmt.Errorf("create radius exporter: %w", err)
+		}
+		radiusExporter.Start(ctx)
+		defer func() {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer shutdownCancel()
+			if err := radiusExporter.Stop(shutdownCtx); err != nil {
+				logger.ErrorContext(shutdownCtx, "radius exporter shutdown error",
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+		logger.InfoContext(ctx, "radius accounting enabled",
+			slog.Any("servers", cfg.Radius.Servers),
+			slog.Duration("interim_interval", cfg.Radius.InterimInterval),
+		)
+	}
+
+	// Webhooks для событий сессий
+	var webhooks *webhook.Dispatcher
+	if cfg.Webhooks.Enabled {
+		webhooks, err = webhook.NewDispatcher(webhook.NewConfig(&cfg.Webhooks, logger, meter))
+		if err != nil {
+			return fmt.Errorf("create webhook dispatcher: %w", err)
+		}
+		webhooks.Start(ctx)
+		defer func() {
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer shutdownCancel()
+			if err := webhooks.Stop(shutdownCtx); err != nil {
+				logger.ErrorContext(shutdownCtx, "webhook dispatcher shutdown error",
+					slog.String("error", err.Error()),
+				)
+			}
+		}()
+		logger.InfoContext(ctx, "webhooks enabled",
+			slog.Int("sinks", len(cfg.Webhooks.Sinks)),
+		)
+	}
+
 	// Создаем stats poller
 	logger.InfoContext(ctx, "creating stats poller",
 		slog.Duration("interval", cfg.Health.MetricsInterval),
 	)
-	statsPoller, err := stats.NewPoller(&stats.PollerConfig{
+	pollerCfg := &stats.PollerConfig{
 		OcctlManager: occtlMgr,
 		Logger:       logger,
 		Tracer:       tracer,
 		Meter:        meter,
 		Interval:     cfg.Health.MetricsInterval,
-	})
+
+		UpdateReporter: portalClient,
+		UpdateInterval: cfg.Sessions.UpdateInterval,
+		ActivityWindow: cfg.Sessions.ActivityWindow,
+		IdleThreshold:  cfg.Sessions.IdleThreshold,
+	}
+	pollerCfg.StatePath = cfg.Sessions.StateFile
+	if cfg.Sessions.EventStream {
+		pollerCfg.EventStream = occtlMgr
+		pollerCfg.ReconcileInterval = cfg.Sessions.ReconcileInterval
+	}
+	if scheduleEvaluator != nil {
+		pollerCfg.Schedule = scheduleEvaluator
+		pollerCfg.ScheduleGrace = cfg.Schedule.GraceWarning
+	}
+	if cfg.Sessions.Revalidate {
+		pollerCfg.Validator = portalClient
+		pollerCfg.RevalidateInterval = cfg.Sessions.RevalidateInterval
+		pollerCfg.RevalidateJitter = cfg.Sessions.RevalidateJitter
+		pollerCfg.RevalidateConcurrency = cfg.Sessions.RevalidateConcurrency
+	}
+	statsPoller, err := stats.NewPoller(pollerCfg)
 	if err != nil {
 		return fmt.Errorf("create stats poller: %w", err)
 	}
+	statsRef.poller.Store(statsPoller)
 
-	// Регистрируем callback для событий сессий
+	// Регистрируем callback для событий сессий. Poller вызывает его
+	// последовательно в порядке событий, поэтому connect всегда попадает
+	// в outbox раньше disconnect той же сессии
 	statsPoller.RegisterCallback(func(ctx context.Context, event stats.SessionEvent) {
 		logger.InfoContext(ctx, "session event",
 			slog.String("type", string(event.Type)),
 			slog.String("username", event.Session.Username),
 			slog.String("client_ip", event.Session.ClientIP),
 			slog.String("vpn_ip", event.Session.VPNIP),
+			slog.Bool("recovered", event.Recovered),
 		)
 
-		// Отправляем события в portal
+		// События, восстановленные после рестарта агента, помечаются в metadata
+		var metadata map[string]string
+		if event.Recovered {
+			metadata = map[string]string{"recovered": "true"}
+		}
+
+		// Ставим события в очередь отправки в portal
 		switch event.Type {
 		case stats.SessionConnected:
-			if err := portalClient.ReportConnect(
+			if err := portalOutbox.ReportConnect(
 				ctx,
 				fmt.Sprintf("%d", event.Session.ID),
 				event.Session.Username,
@@ -208,26 +531,73 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 				event.Session.ClientIP,
 				event.Session.VPNIP,
 				"", // device
+				event.Session.ConnectedAt,
+				metadata,
 			); err != nil {
-				logger.ErrorContext(ctx, "failed to report connect",
+				logger.ErrorContext(ctx, "failed to persist connect event, kept in memory only",
 					slog.String("error", err.Error()),
 				)
 			}
 
+			if leases != nil {
+				if err := leases.Connect(accountingRecord(event.Session)); err != nil {
+					logger.ErrorContext(ctx, "failed to record VPN IP lease",
+						slog.String("error", err.Error()),
+					)
+				}
+			}
+
+			if radiusExporter != nil {
+				radiusExporter.SessionStart(ctx, radiusSession(event.Session))
+			}
+
+		case stats.SessionUpdated:
+			if radiusExporter != nil {
+				radiusExporter.SessionInterim(ctx, radiusSession(event.Session))
+			}
+
 		case stats.SessionDisconnected:
-			duration := time.Since(event.Session.ConnectedAt)
-			if err := portalClient.ReportDisconnect(
+			duration := event.Session.DisconnectedAt.Sub(event.Session.ConnectedAt)
+			if err := portalOutbox.ReportDisconnect(
 				ctx,
 				fmt.Sprintf("%d", event.Session.ID),
 				event.Session.Username,
+				event.Session.DisconnectedAt,
 				duration,
 				event.Session.BytesRX,
 				event.Session.BytesTX,
+				event.Session.DisconnectReason,
+				metadata,
 			); err != nil {
-				logger.ErrorContext(ctx, "failed to report disconnect",
+				logger.ErrorContext(ctx, "failed to persist disconnect event, kept in memory only",
 					slog.String("error", err.Error()),
 				)
 			}
+
+			if ledger != nil {
+				if err := ledger.Append(accountingRecord(event.Session)); err != nil {
+					logger.ErrorContext(ctx, "failed to record session in accounting ledger",
+						slog.String("error", err.Error()),
+					)
+				}
+			}
+			if leases != nil {
+				if err := leases.Disconnect(fmt.Sprintf("%d", event.Session.ID)); err != nil {
+					logger.ErrorContext(ctx, "failed to release VPN IP lease",
+						slog.String("error", err.Error()),
+					)
+				}
+			}
+
+			if radiusExporter != nil {
+				radiusExporter.SessionStop(ctx, radiusSession(event.Session))
+			}
+		}
+
+		if webhooks != nil {
+			if eventType, data, ok := sessionWebhook(event); ok {
+				webhooks.Publish(ctx, eventType, data)
+			}
 		}
 	})
 
@@ -245,9 +615,14 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 		}
 	}()
 
+	// Подписка на события политик от portal (отзыв пользователей, изменения политик)
+	if cfg.Portal.PolicyEvents {
+		go portalClient.WatchPolicyEvents(ctx, cfg.AgentID, policyEventHandler(ipcHandler, statsPoller, logger))
+	}
+
 	logger.InfoContext(ctx, "agent started successfully",
 		slog.String("ipc_socket", cfg.IPC.SocketPath),
-		slog.String("portal_address", cfg.Portal.Address),
+		slog.String("portal_endpoint", portalClient.ActiveEndpoint()),
 		slog.Duration("stats_interval", cfg.Health.MetricsInterval),
 	)
 
@@ -264,3 +639,301 @@ func runServerPhase2(cfg *config.Config, _ *slog.Logger) error {
 	logger.InfoContext(ctx, "shutdown complete")
 	return nil
 }
+
+// portalEndpoints конвертирует список реплик portal из конфигурации
+func portalEndpoints(endpoints []config.PortalEndpointConfig) []portal.Endpoint {
+	result := make([]portal.Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		result = append(result, portal.Endpoint{
+			Address:    ep.Address,
+			Datacenter: ep.Datacenter,
+			Weight:     ep.Weight,
+		})
+	}
+	return result
+}
+
+// portalRetryPolicies накладывает настройки retry из конфигурации на
+// значения по умолчанию; незаданные поля сохраняют значение по умолчанию
+func portalRetryPolicies(overrides map[string]config.PortalRetryConfig) map[string]portal.RetryPolicy {
+	policies := portal.DefaultRetryPolicies()
+	for method, override := range overrides {
+		policy := policies[method]
+		if override.MaxAttempts > 0 {
+			policy.MaxAttempts = override.MaxAttempts
+		}
+		if override.InitialBackoff > 0 {
+			policy.InitialBackoff = override.InitialBackoff
+		}
+		if override.MaxBackoff > 0 {
+			policy.MaxBackoff = override.MaxBackoff
+		}
+		policies[method] = policy
+	}
+	return policies
+}
+
+// runPortalHealthChecks периодически проверяет состояние portal client
+// и сообщает о переходах между healthy и unhealthy. Агент считается
+// degraded, когда открыты circuit breaker всех endpoint
+func runPortalHealthChecks(ctx context.Context, client *portal.Client, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		check, ok := client.CheckHealth()
+		endpoints := client.Endpoints()
+		breakers := make([]any, 0, len(endpoints))
+		for _, e := range endpoints {
+			breakers = append(breakers, slog.String(e.Address, e.Breaker))
+		}
+		attrs := []any{
+			slog.String("portal", check),
+			slog.Group("breakers", breakers...),
+		}
+
+		switch {
+		case portal.AllBreakersOpen(endpoints):
+			logger.WarnContext(ctx, "agent degraded: all portal circuit breakers open", attrs...)
+		case !ok:
+			logger.WarnContext(ctx, "portal health check failed", attrs...)
+		case !healthy:
+			logger.InfoContext(ctx, "portal health check recovered", attrs...)
+		default:
+			logger.DebugContext(ctx, "portal health check", attrs...)
+		}
+		healthy = ok
+	}
+}
+
+// runCacheSnapshots периодически сохраняет снимок кэша решений на диск
+func runCacheSnapshots(ctx context.Context, cache *resilience.DecisionCache, path string, key []byte, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := cache.SaveSnapshot(path, key); err != nil {
+			logger.ErrorContext(ctx, "failed to save decision cache snapshot",
+				slog.String("path", path),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
+
+// pollerRef дает IPC handler доступ к stats poller, который создается
+// после handler; до создания poller сессий нет
+type pollerRef struct {
+	poller atomic.Pointer[stats.Poller]
+}
+
+// CountSessions реализует admission.SessionCounter
+func (r *pollerRef) CountSessions(username string) int {
+	if p := r.poller.Load(); p != nil {
+		return p.CountSessions(username)
+	}
+	return 0
+}
+
+// RecordDisconnect реализует ipc.DisconnectRecorder. Отчеты, пришедшие до
+// создания poller, отбрасываются: poller еще не отслеживает сессии
+func (r *pollerRef) RecordDisconnect(ctx context.Context, report stats.DisconnectReport) {
+	if p := r.poller.Load(); p != nil {
+		p.RecordDisconnect(ctx, report)
+	}
+}
+
+// newAdmissionEngine компилирует правила допуска из конфигурации
+func newAdmissionEngine(cfg *config.AdmissionConfig, sessions admission.SessionCounter, meter metric.Meter) (*admission.Engine, error) {
+	admissionCfg := admission.Config{
+		Rules:    cfg.RuleSpecs(),
+		Sessions: sessions,
+		Meter:    meter,
+	}
+	if cfg.CountryFile != "" {
+		countries, err := policy.LoadCountryTable(cfg.CountryFile)
+		if err != nil {
+			return nil, err
+		}
+		admissionCfg.Countries = countries
+	}
+	return admission.New(admissionCfg)
+}
+
+// policyEventHandler применяет события политик от portal: сбрасывает
+// решения в кэше и, по флагам события, отключает затронутые сессии или
+// перепроверяет их политику с перегенерацией per-user конфигов
+func policyEventHandler(handler *ipc.Handler, poller *stats.Poller, logger *slog.Logger) portal.PolicyEventHandler {
+	return func(ctx context.Context, event *vpnv1.PolicyEvent) {
+		var match func(stats.SessionInfo) bool
+		reason := stats.DisconnectReasonRevoked
+
+		switch event.Type {
+		case vpnv1.PolicyEventType_POLICY_EVENT_TYPE_USER_REVOKED:
+			if event.Username == "" {
+				logger.WarnContext(ctx, "ignoring user revocation without username",
+					slog.String("event_id", event.EventId),
+				)
+				return
+			}
+			handler.InvalidateDecisions(ctx, event.Username, "")
+			match = func(s stats.SessionInfo) bool { return s.Username == event.Username }
+
+		case vpnv1.PolicyEventType_POLICY_EVENT_TYPE_GROUP_POLICY_CHANGED:
+			if event.Groupname == "" {
+				logger.WarnContext(ctx, "ignoring group policy change without group",
+					slog.String("event_id", event.EventId),
+				)
+				return
+			}
+			handler.InvalidateDecisions(ctx, "", event.Groupname)
+			match = func(s stats.SessionInfo) bool { return s.GroupName == event.Groupname }
+			reason = stats.DisconnectReasonPolicyChanged
+
+		case vpnv1.PolicyEventType_POLICY_EVENT_TYPE_FLUSH_CACHE:
+			handler.FlushDecisions(ctx)
+			return
+
+		default:
+			logger.WarnContext(ctx, "ignoring unknown portal policy event",
+				slog.String("event_id", event.EventId),
+				slog.String("type", event.Type.String()),
+			)
+			return
+		}
+
+		switch {
+		case event.DisconnectSessions:
+			poller.DisconnectSessions(ctx, match, reason)
+
+		case event.RefreshConfig:
+			// Политика каждой сессии перепроверяется в portal; сессии,
+			// которым доступ больше не разрешен, отключаются
+			poller.DisconnectSessions(ctx, func(s stats.SessionInfo) bool {
+				if !match(s) {
+					return false
+				}
+				allowed, err := handler.RefreshDecision(ctx, s.Username, s.GroupName, s.ClientIP)
+				if err != nil {
+					logger.ErrorContext(ctx, "failed to refresh session policy",
+						slog.Int("id", s.ID),
+						slog.String("username", s.Username),
+						slog.String("error", err.Error()),
+					)
+					return false
+				}
+				return !allowed
+			}, reason)
+		}
+	}
+}
+
+// accountingRecord конвертирует завершенную сессию poller'а в запись журнала
+func accountingRecord(session stats.SessionInfo) *accounting.Record {
+	return &accounting.Record{
+		SessionID:        fmt.Sprintf("%d", session.ID),
+		Username:         session.Username,
+		GroupName:        session.GroupName,
+		ClientIP:         session.ClientIP,
+		VPNIPv4:          session.VPNIP,
+		VPNIPv6:          session.VPNIPv6,
+		UserAgent:        session.UserAgent,
+		StartedAt:        session.ConnectedAt,
+		EndedAt:          session.DisconnectedAt,
+		BytesIn:          session.BytesRX,
+		BytesOut:         session.BytesTX,
+		DisconnectReason: session.DisconnectReason,
+	}
+}
+
+// radiusSession конвертирует сессию poller'а для RADIUS Accounting
+func radiusSession(session stats.SessionInfo) radius.Session {
+	var cause uint32
+	switch session.DisconnectReason {
+	case stats.DisconnectReasonSchedule:
+		cause = radius.TerminateSessionTimeout
+	case stats.DisconnectReasonPortal, stats.DisconnectReasonRevoked:
+		cause = radius.TerminateAdminReset
+	}
+
+	return radius.Session{
+		ID:             fmt.Sprintf("%d", session.ID),
+		Username:       session.Username,
+		ClientIP:       session.ClientIP,
+		FramedIP:       session.VPNIP,
+		FramedIPv6:     session.VPNIPv6,
+		StartedAt:      session.ConnectedAt,
+		EndedAt:        session.DisconnectedAt,
+		BytesIn:        session.BytesRX,
+		BytesOut:       session.BytesTX,
+		TerminateCause: cause,
+	}
+}
+
+// sessionWebhook возвращает тип и данные webhook-события для события
+// сессии; false, если событие в webhooks не отправляется
+func sessionWebhook(event stats.SessionEvent) (string, webhook.SessionData, bool) {
+	switch event.Type {
+	case stats.SessionConnected:
+		return webhook.EventSessionConnected, webhookSession(event.Session, ""), true
+
+	case stats.SessionDisconnected:
+		return webhook.EventSessionDisconnected, webhookSession(event.Session, event.Session.DisconnectReason), true
+
+	case stats.SessionStatusChanged:
+		if event.Session.Status != vpnv1.SessionStatus_SESSION_STATUS_IDLE {
+			return "", webhook.SessionData{}, false
+		}
+		data := webhookSession(event.Session, "")
+		data.PreviousStatus = event.PreviousStatus.String()
+		return webhook.EventSessionIdle, data, true
+
+	case stats.SessionPortalDisconnect:
+		// Portal отключает сессию по квоте или иному лимиту
+		return webhook.EventSessionQuota, webhookSession(event.Session, event.Reason), true
+
+	case stats.SessionRevoked:
+		// Сессия отозвана в portal и отключается
+		return webhook.EventSessionRevoked, webhookSession(event.Session, event.Reason), true
+
+	case stats.SessionScheduleWarning:
+		// Окно доступа скоро закроется, сессия будет отключена в Deadline
+		data := webhookSession(event.Session, stats.DisconnectReasonSchedule)
+		data.Deadline = event.Deadline
+		return webhook.EventSessionScheduleWarning, data, true
+	}
+	return "", webhook.SessionData{}, false
+}
+
+// webhookSession конвертирует сессию poller'а в данные webhook-события
+func webhookSession(session stats.SessionInfo, reason string) webhook.SessionData {
+	data := webhook.SessionData{
+		SessionID: fmt.Sprintf("%d", session.ID),
+		Username:  session.Username,
+		GroupName: session.GroupName,
+		ClientIP:  session.ClientIP,
+		VPNIPv4:   session.VPNIP,
+		VPNIPv6:   session.VPNIPv6,
+		Status:    session.Status.String(),
+		Reason:    reason,
+		BytesIn:   session.BytesRX,
+		BytesOut:  session.BytesTX,
+	}
+	if !session.DisconnectedAt.IsZero() {
+		data.DurationSeconds = int64(session.DisconnectedAt.Sub(session.ConnectedAt).Seconds())
+	}
+	return data
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/stats"
+	"github.com/dantte-lp/ocserv-agent/internal/webhook"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+)
+
+func TestSessionWebhook(t *testing.T) {
+	session := stats.SessionInfo{ID: 7, Username: "alice", GroupName: "contractors", VPNIP: "10.10.0.2"}
+	deadline := time.Date(2026, 10, 18, 18, 0, 0, 0, time.UTC)
+
+	idle := session
+	idle.Status = vpnv1.SessionStatus_SESSION_STATUS_IDLE
+
+	tests := []struct {
+		name       string
+		event      stats.SessionEvent
+		wantType   string
+		wantReason string
+		wantSkip   bool
+	}{
+		{
+			name:     "connected",
+			event:    stats.SessionEvent{Type: stats.SessionConnected, Session: session},
+			wantType: webhook.EventSessionConnected,
+		},
+		{
+			name:     "idle",
+			event:    stats.SessionEvent{Type: stats.SessionStatusChanged, Session: idle},
+			wantType: webhook.EventSessionIdle,
+		},
+		{
+			name:     "active again",
+			event:    stats.SessionEvent{Type: stats.SessionStatusChanged, Session: session},
+			wantSkip: true,
+		},
+		{
+			name:       "schedule warning",
+			event:      stats.SessionEvent{Type: stats.SessionScheduleWarning, Session: session, Deadline: deadline},
+			wantType:   webhook.EventSessionScheduleWarning,
+			wantReason: stats.DisconnectReasonSchedule,
+		},
+		{
+			name:     "traffic update",
+			event:    stats.SessionEvent{Type: stats.SessionUpdated, Session: session},
+			wantSkip: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventType, data, ok := sessionWebhook(tt.event)
+			if ok == tt.wantSkip {
+				t.Fatalf("sessionWebhook() ok = %v, want %v", ok, !tt.wantSkip)
+			}
+			if tt.wantSkip {
+				return
+			}
+			if eventType != tt.wantType {
+				t.Errorf("event type = %q, want %q", eventType, tt.wantType)
+			}
+			if data.SessionID != "7" || data.Username != "alice" {
+				t.Errorf("session = %q/%q, want 7/alice", data.SessionID, data.Username)
+			}
+			if data.Reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", data.Reason, tt.wantReason)
+			}
+			if !data.Deadline.Equal(tt.event.Deadline) {
+				t.Errorf("deadline = %v, want %v", data.Deadline, tt.event.Deadline)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxCacheEntries ограничивает размер файла кэша; при переполнении
+// удаляются самые старые решения
+const maxCacheEntries = 1024
+
+// cacheEntry - последнее решение агента для пользователя и группы
+type cacheEntry struct {
+	Allowed bool      `json:"allowed"`
+	Error   string    `json:"error,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// decisionCache - локальный файл последних решений агента, которым
+// vpn-auth пользуется, когда агент недоступен.
+//
+// Файл перезаписывается атомарно (временный файл + rename). Одновременные
+// подключения могут потерять запись друг друга: кэш лишь дополняет
+// fail policy и не обязан быть полным
+type decisionCache struct {
+	path string
+	ttl  time.Duration
+}
+
+// newDecisionCache возвращает кэш или nil, если файл не задан
+func newDecisionCache(cfg *Config) *decisionCache {
+	if cfg.CacheFile == "" {
+		return nil
+	}
+	return &decisionCache{path: cfg.CacheFile, ttl: cfg.CacheTTL}
+}
+
+// cacheKey - IP клиента и VPN IP меняются между подключениями, поэтому
+// решение запоминается по пользователю и группе
+func cacheKey(req *AuthRequest) string {
+	return req.Username + ":" + req.GroupName
+}
+
+// lookup возвращает решение, сохраненное не раньше ttl назад
+func (c *decisionCache) lookup(req *AuthRequest, now time.Time) (*cacheEntry, bool) {
+	entries, err := c.load()
+	if err != nil {
+		return nil, false
+	}
+	entry, ok := entries[cacheKey(req)]
+	if !ok || now.Sub(entry.At) > c.ttl {
+		return nil, false
+	}
+	return entry, true
+}
+
+// store сохраняет решение агента
+func (c *decisionCache) store(req *AuthRequest, resp *AuthResponse, now time.Time) error {
+	entries, err := c.load()
+	if err != nil {
+		// Поврежденный файл заменяется новым
+		entries = make(map[string]*cacheEntry)
+	}
+
+	for key, entry := range entries {
+		if now.Sub(entry.At) > c.ttl {
+			delete(entries, key)
+		}
+	}
+	entries[cacheKey(req)] = &cacheEntry{Allowed: resp.Allowed, Error: resp.Error, At: now}
+	for len(entries) > maxCacheEntries {
+		evictOldest(entries)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("marshal cache: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("rename cache: %w", err)
+	}
+	return nil
+}
+
+// load читает файл кэша; отсутствующий файл - пустой кэш
+func (c *decisionCache) load() (map[string]*cacheEntry, error) {
+	data, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return make(map[string]*cacheEntry), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read cache: %w", err)
+	}
+
+	entries := make(map[string]*cacheEntry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("unmarshal cache: %w", err)
+	}
+	return entries, nil
+}
+
+// evictOldest удаляет самое старое решение
+func evictOldest(entries map[string]*cacheEntry) {
+	var oldestKey string
+	var oldest time.Time
+	for key, entry := range entries {
+		if oldestKey == "" || entry.At.Before(oldest) {
+			oldestKey, oldest = key, entry.At
+		}
+	}
+	delete(entries, oldestKey)
+}
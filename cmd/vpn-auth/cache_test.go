@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCache(t *testing.T, ttl time.Duration) *decisionCache {
+	t.Helper()
+
+	cache := newDecisionCache(&Config{CacheFile: filepath.Join(t.TempDir(), "cache.json"), CacheTTL: ttl})
+	require.NotNil(t, cache)
+	return cache
+}
+
+func TestNewDecisionCache_Disabled(t *testing.T) {
+	assert.Nil(t, newDecisionCache(&Config{CacheTTL: time.Hour}))
+}
+
+func TestDecisionCache_Lookup(t *testing.T) {
+	storedAt := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	alice := &AuthRequest{Username: "alice", GroupName: "staff", IPReal: "203.0.113.10"}
+
+	tests := []struct {
+		name    string
+		req     *AuthRequest
+		at      time.Time
+		wantHit bool
+	}{
+		{"fresh decision", alice, storedAt.Add(time.Minute), true},
+		{"other client address", &AuthRequest{Username: "alice", GroupName: "staff", IPReal: "198.51.100.7"}, storedAt.Add(time.Minute), true},
+		{"at the end of the ttl", alice, storedAt.Add(time.Hour), true},
+		{"stale after the ttl", alice, storedAt.Add(time.Hour + time.Second), false},
+		{"other group", &AuthRequest{Username: "alice", GroupName: "admins"}, storedAt.Add(time.Minute), false},
+		{"other user", &AuthRequest{Username: "bob", GroupName: "staff"}, storedAt.Add(time.Minute), false},
+	}
+
+	cache := newTestCache(t, time.Hour)
+	require.NoError(t, cache.store(alice, &AuthResponse{Allowed: false, Error: "outside schedule"}, storedAt))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, ok := cache.lookup(tt.req, tt.at)
+			require.Equal(t, tt.wantHit, ok)
+			if ok {
+				assert.Equal(t, cacheEntry{Allowed: false, Error: "outside schedule", At: storedAt}, *entry)
+			}
+		})
+	}
+}
+
+func TestDecisionCache_StoreReplacesDecision(t *testing.T) {
+	cache := newTestCache(t, time.Hour)
+	req := &AuthRequest{Username: "alice", GroupName: "staff"}
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	require.NoError(t, cache.store(req, &AuthResponse{Allowed: false, Error: "blocked"}, now))
+	require.NoError(t, cache.store(req, &AuthResponse{Allowed: true}, now.Add(time.Minute)))
+
+	entry, ok := cache.lookup(req, now.Add(2*time.Minute))
+	require.True(t, ok)
+	assert.True(t, entry.Allowed)
+	assert.Empty(t, entry.Error)
+}
+
+func TestDecisionCache_StorePrunesStaleEntries(t *testing.T) {
+	cache := newTestCache(t, time.Hour)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	require.NoError(t, cache.store(&AuthRequest{Username: "alice"}, &AuthResponse{Allowed: true}, now))
+	require.NoError(t, cache.store(&AuthRequest{Username: "bob"}, &AuthResponse{Allowed: true}, now.Add(2*time.Hour)))
+
+	entries, err := cache.load()
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Contains(t, entries, "bob:")
+}
+
+func TestDecisionCache_Eviction(t *testing.T) {
+	cache := newTestCache(t, 24*time.Hour)
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	for i := range maxCacheEntries + 2 {
+		req := &AuthRequest{Username: fmt.Sprintf("user%d", i)}
+		require.NoError(t, cache.store(req, &AuthResponse{Allowed: true}, start.Add(time.Duration(i)*time.Second)))
+	}
+
+	entries, err := cache.load()
+	require.NoError(t, err)
+	assert.Len(t, entries, maxCacheEntries)
+	assert.NotContains(t, entries, "user0:", "oldest decisions are evicted first")
+	assert.NotContains(t, entries, "user1:")
+	assert.Contains(t, entries, "user2:")
+	assert.Contains(t, entries, fmt.Sprintf("user%d:", maxCacheEntries+1))
+}
+
+func TestDecisionCache_CorruptFile(t *testing.T) {
+	cache := newTestCache(t, time.Hour)
+	require.NoError(t, os.WriteFile(cache.path, []byte(`{"alice:staff": {"allowed": tr`), 0600))
+
+	req := &AuthRequest{Username: "alice", GroupName: "staff"}
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	_, ok := cache.lookup(req, now)
+	assert.False(t, ok, "a corrupt cache is a miss")
+
+	// The next decision replaces the corrupt file
+	require.NoError(t, cache.store(req, &AuthResponse{Allowed: true}, now))
+	entry, ok := cache.lookup(req, now.Add(time.Minute))
+	require.True(t, ok)
+	assert.True(t, entry.Allowed)
+}
+
+func TestDecisionCache_UnwritableDirectory(t *testing.T) {
+	cache := newDecisionCache(&Config{CacheFile: filepath.Join(t.TempDir(), "missing", "cache.json"), CacheTTL: time.Hour})
+
+	err := cache.store(&AuthRequest{Username: "alice"}, &AuthResponse{Allowed: true}, time.Now())
+	assert.ErrorContains(t, err, "create temp file")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultConfigPath - файл настроек, читается если существует
+	defaultConfigPath = "/etc/ocserv-agent/vpn-auth.conf"
+
+	// Политики при недоступном агенте
+	failPolicyOpen  = "open"  // разрешить подключение
+	failPolicyClose = "close" // запретить подключение
+)
+
+// Config - настройки vpn-auth
+type Config struct {
+	SocketPath string        // Unix socket агента
+	Timeout    time.Duration // Таймаут запроса к агенту
+	FailPolicy string        // open или close, если агент недоступен
+	CacheFile  string        // Файл локального кэша решений, пусто - кэш выключен
+	CacheTTL   time.Duration // Максимальный возраст решения из кэша
+}
+
+// setting - параметр, задаваемый флагом, переменной окружения или
+// строкой KEY=VALUE в файле настроек (ключ совпадает с переменной окружения)
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	apply func(cfg *Config, value string) error
+}
+
+var settings = []setting{
+	{"socket", "VPN_AUTH_SOCKET", "Path to the agent Unix socket", func(cfg *Config, v string) error {
+		cfg.SocketPath = v
+		return nil
+	}},
+	{"timeout", "VPN_AUTH_TIMEOUT", "Agent request timeout", func(cfg *Config, v string) error {
+		return parseDuration(&cfg.Timeout, v)
+	}},
+	{"fail-policy", "VPN_AUTH_FAIL_POLICY", "Decision when the agent is unavailable: open or close", func(cfg *Config, v string) error {
+		cfg.FailPolicy = v
+		return nil
+	}},
+	{"cache-file", "VPN_AUTH_CACHE_FILE", "Local decision cache file (empty disables the cache)", func(cfg *Config, v string) error {
+		cfg.CacheFile = v
+		return nil
+	}},
+	{"cache-ttl", "VPN_AUTH_CACHE_TTL", "Maximum age of a cached decision", func(cfg *Config, v string) error {
+		return parseDuration(&cfg.CacheTTL, v)
+	}},
+}
+
+// defaultConfig возвращает настройки по умолчанию
+func defaultConfig() Config {
+	return Config{
+		SocketPath: "/var/run/ocserv-agent.sock",
+		// Должно быть меньше таймаута connect-script ocserv (~5-7 секунд)
+		Timeout:    3 * time.Second,
+		FailPolicy: failPolicyOpen,
+		CacheTTL:   24 * time.Hour,
+	}
+}
+
+// loadConfig собирает настройки. Приоритет: флаги, переменные окружения,
+// файл настроек (-config, VPN_AUTH_CONFIG или defaultConfigPath), значения
+// по умолчанию. ocserv не передает скрипту аргументы, поэтому флаги нужны
+// в основном для ручной проверки
+func loadConfig(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("vpn-auth", flag.ContinueOnError)
+	configPath := flags.String("config", "", "Path to the settings file (default "+defaultConfigPath+")")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.flag] = flags.String(s.flag, "", s.usage+" ("+s.env+")")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path, explicit := *configPath, true
+	if path == "" {
+		path = os.Getenv("VPN_AUTH_CONFIG")
+	}
+	if path == "" {
+		path, explicit = defaultConfigPath, false
+	}
+	fileValues, err := readConfigFile(path)
+	if err != nil && (explicit || !errors.Is(err, fs.ErrNotExist)) {
+		return nil, err
+	}
+
+	setFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	cfg := defaultConfig()
+	for _, s := range settings {
+		value, ok := fileValues[s.env]
+		if v, set := os.LookupEnv(s.env); set {
+			value, ok = v, true
+		}
+		if setFlags[s.flag] {
+			value, ok = *flagValues[s.flag], true
+		}
+		if !ok {
+			continue
+		}
+		if err := s.apply(&cfg, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.env, err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validate проверяет настройки
+func (c *Config) validate() error {
+	var errs []error
+	if c.SocketPath == "" {
+		errs = append(errs, errors.New("socket path is required"))
+	}
+	if c.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("timeout must be positive, got %s", c.Timeout))
+	}
+	if c.FailPolicy != failPolicyOpen && c.FailPolicy != failPolicyClose {
+		errs = append(errs, fmt.Errorf("fail policy must be %s or %s, got %q", failPolicyOpen, failPolicyClose, c.FailPolicy))
+	}
+	if c.CacheFile != "" && c.CacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache ttl must be positive, got %s", c.CacheTTL))
+	}
+	return errors.Join(errs...)
+}
+
+// readConfigFile читает строки KEY=VALUE; пустые строки и строки,
+// начинающиеся с '#', пропускаются
+func readConfigFile(path string) (map[string]string, error) {
+	// #nosec G304 - путь задает администратор
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open config: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, line)
+		}
+		key = strings.TrimSpace(key)
+		if !knownSetting(key) {
+			return nil, fmt.Errorf("%s:%d: unknown setting %s", path, line, key)
+		}
+		values[key] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	return values, nil
+}
+
+// knownSetting сообщает, есть ли параметр с таким ключом
+func knownSetting(key string) bool {
+	for _, s := range settings {
+		if s.env == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDuration разбирает длительность вида 3s или 24h
+func parseDuration(dst *time.Duration, value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSettings writes a settings file and returns its path
+func writeSettings(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "vpn-auth.conf")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+// clearSettingsEnv keeps the environment of the test process from leaking
+// into loadConfig
+func clearSettingsEnv(t *testing.T) {
+	t.Helper()
+
+	for _, key := range []string{"VPN_AUTH_CONFIG", "VPN_AUTH_SOCKET", "VPN_AUTH_TIMEOUT", "VPN_AUTH_FAIL_POLICY", "VPN_AUTH_CACHE_FILE", "VPN_AUTH_CACHE_TTL"} {
+		t.Setenv(key, "")
+		require.NoError(t, os.Unsetenv(key))
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	file := `# vpn-auth settings
+VPN_AUTH_SOCKET=/run/file.sock
+VPN_AUTH_TIMEOUT=5s
+VPN_AUTH_FAIL_POLICY="close"
+VPN_AUTH_CACHE_FILE=/var/lib/file-cache.json
+
+VPN_AUTH_CACHE_TTL = 1h
+`
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		flags []string
+		want  Config
+	}{
+		{
+			name: "file over defaults",
+			want: Config{SocketPath: "/run/file.sock", Timeout: 5 * time.Second, FailPolicy: failPolicyClose, CacheFile: "/var/lib/file-cache.json", CacheTTL: time.Hour},
+		},
+		{
+			name: "env over file",
+			env:  map[string]string{"VPN_AUTH_TIMEOUT": "4s", "VPN_AUTH_CACHE_FILE": "/var/lib/env-cache.json"},
+			want: Config{SocketPath: "/run/file.sock", Timeout: 4 * time.Second, FailPolicy: failPolicyClose, CacheFile: "/var/lib/env-cache.json", CacheTTL: time.Hour},
+		},
+		{
+			name:  "flags over env",
+			env:   map[string]string{"VPN_AUTH_TIMEOUT": "4s", "VPN_AUTH_FAIL_POLICY": "close"},
+			flags: []string{"-timeout", "2s", "-fail-policy", "open"},
+			want:  Config{SocketPath: "/run/file.sock", Timeout: 2 * time.Second, FailPolicy: failPolicyOpen, CacheFile: "/var/lib/file-cache.json", CacheTTL: time.Hour},
+		},
+		{
+			name:  "empty env value disables the file cache",
+			env:   map[string]string{"VPN_AUTH_CACHE_FILE": ""},
+			flags: []string{"-socket", "/run/flag.sock"},
+			want:  Config{SocketPath: "/run/flag.sock", Timeout: 5 * time.Second, FailPolicy: failPolicyClose, CacheTTL: time.Hour},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearSettingsEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			args := append([]string{"-config", writeSettings(t, file)}, tt.flags...)
+			cfg, err := loadConfig(args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *cfg)
+		})
+	}
+}
+
+func TestLoadConfig_ConfigPathFromEnv(t *testing.T) {
+	clearSettingsEnv(t)
+	t.Setenv("VPN_AUTH_CONFIG", writeSettings(t, "VPN_AUTH_SOCKET=/run/env-file.sock\n"))
+
+	cfg, err := loadConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/run/env-file.sock", cfg.SocketPath)
+	assert.Equal(t, 3*time.Second, cfg.Timeout, "unset values keep their defaults")
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		flags   []string
+		wantErr string
+	}{
+		{
+			name:    "line without separator",
+			file:    "VPN_AUTH_SOCKET=/run/a.sock\nVPN_AUTH_TIMEOUT\n",
+			wantErr: ":2: expected KEY=VALUE",
+		},
+		{
+			name:    "unknown key",
+			file:    "# comment\nVPN_AUTH_SOKET=/run/a.sock\n",
+			wantErr: ":2: unknown setting VPN_AUTH_SOKET",
+		},
+		{
+			name:    "invalid duration in file",
+			file:    "VPN_AUTH_TIMEOUT=soon\n",
+			wantErr: "VPN_AUTH_TIMEOUT: time: invalid duration",
+		},
+		{
+			name:    "invalid duration in env",
+			env:     map[string]string{"VPN_AUTH_CACHE_TTL": "1d"},
+			wantErr: "VPN_AUTH_CACHE_TTL: time: unknown unit",
+		},
+		{
+			name:    "invalid fail policy",
+			flags:   []string{"-fail-policy", "maybe"},
+			wantErr: `fail policy must be open or close, got "maybe"`,
+		},
+		{
+			name:    "non-positive timeout",
+			file:    "VPN_AUTH_TIMEOUT=0s\n",
+			wantErr: "timeout must be positive",
+		},
+		{
+			name:    "cache without ttl",
+			file:    "VPN_AUTH_CACHE_FILE=/var/lib/cache.json\nVPN_AUTH_CACHE_TTL=0s\n",
+			wantErr: "cache ttl must be positive",
+		},
+		{
+			name:    "unknown flag",
+			flags:   []string{"-sockett", "/run/a.sock"},
+			wantErr: "flag provided but not defined",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearSettingsEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			args := append([]string{"-config", writeSettings(t, tt.file)}, tt.flags...)
+			_, err := loadConfig(args)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestLoadConfig_MissingFile(t *testing.T) {
+	clearSettingsEnv(t)
+
+	// An explicitly named file must exist
+	_, err := loadConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.conf")})
+	assert.ErrorContains(t, err, "open config")
+}
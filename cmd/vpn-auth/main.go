@@ -1,52 +1,98 @@
 // vpn-auth - минималистичный CLI для connect-script ocserv
 // Используется как connect/disconnect скрипт для быстрой авторизации через IPC
+//
+// Настройки задаются флагами, переменными окружения или строками KEY=VALUE
+// в /etc/ocserv-agent/vpn-auth.conf:
+//
+//	VPN_AUTH_SOCKET=/var/run/ocserv-agent.sock
+//	VPN_AUTH_TIMEOUT=3s
+//	VPN_AUTH_FAIL_POLICY=open                     # open или close
+//	VPN_AUTH_CACHE_FILE=/var/lib/ocserv-agent/vpn-auth-cache.json
+//	VPN_AUTH_CACHE_TTL=24h
+//
+// С кэшем vpn-auth запоминает последние решения агента и при его
+// недоступности применяет их; fail policy действует, только если
+// решения в кэше нет
 package main
 
 import (
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strconv"
 	"time"
 )
 
-const (
-	// socketPath - путь к Unix socket агента
-	socketPath = "/var/run/ocserv-agent.sock"
+// protocolVersion - версия IPC протокола. Агенты v2 принимают и запросы
+// v1, агенты v1 игнорируют поля v2
+const protocolVersion = 2
 
-	// socketTimeout - максимальное время ожидания ответа от агента
-	// Должно быть меньше таймаута connect-script ocserv (~5-7 секунд)
-	socketTimeout = 3 * time.Second
-)
+// errorCodeUnsupportedVersion - агент не поддерживает версию запроса;
+// в Version ответа он сообщает максимальную поддерживаемую версию
+const errorCodeUnsupportedVersion = "unsupported_version"
 
 // AuthRequest - запрос авторизации к агенту
 type AuthRequest struct {
-	Reason    string `json:"reason"`     // connect, disconnect, host-update
-	Username  string `json:"username"`   // Из CN сертификата
-	GroupName string `json:"groupname"`  // Из OU сертификата
-	IPReal    string `json:"ip_real"`    // IP клиента
-	IPRemote  string `json:"ip_remote"`  // VPN IP
-	Device    string `json:"device"`     // tun/tap устройство
-	SessionID string `json:"session_id"` // ID сессии ocserv
+	// Конверт протокола v2; агенты v1 игнорируют эти поля
+	Version     int    `json:"version,omitempty"`     // Версия протокола
+	RequestID   string `json:"request_id,omitempty"`  // ID запроса для корреляции логов
+	Deadline    int64  `json:"deadline,omitempty"`    // Крайний срок ответа, unix миллисекунды
+	TraceParent string `json:"traceparent,omitempty"` // W3C trace context
+
+	Reason    string `json:"reason"`               // connect, disconnect, host-update
+	Username  string `json:"username"`             // Из CN сертификата
+	GroupName string `json:"groupname"`            // Из OU сертификата
+	IPReal    string `json:"ip_real"`              // IP клиента
+	IPRemote  string `json:"ip_remote"`            // VPN IP
+	Device    string `json:"device"`               // tun/tap устройство
+	SessionID string `json:"session_id"`           // ID сессии ocserv
+	UserAgent string `json:"user_agent,omitempty"` // User-Agent клиента
+
+	// Stats - итоговая статистика сессии, передается при disconnect
+	Stats *SessionStats `json:"stats,omitempty"`
+}
+
+// SessionStats - статистика, которую ocserv передает disconnect-скрипту
+type SessionStats struct {
+	BytesIn  uint64 `json:"bytes_in"`  // STATS_BYTES_IN, получено от клиента
+	BytesOut uint64 `json:"bytes_out"` // STATS_BYTES_OUT, отправлено клиенту
+	Duration int64  `json:"duration"`  // STATS_DURATION, секунды
 }
 
 // AuthResponse - ответ от агента
 type AuthResponse struct {
-	Allowed bool   `json:"allowed"` // Разрешено ли подключение
-	Error   string `json:"error,omitempty"`
+	Version   int    `json:"version,omitempty"`    // Версия протокола агента, 0 у агентов v1
+	RequestID string `json:"request_id,omitempty"` // ID запроса из AuthRequest
+	ErrorCode string `json:"error_code,omitempty"` // Код ошибки протокола
+	Allowed   bool   `json:"allowed"`              // Разрешено ли подключение
+	Error     string `json:"error,omitempty"`
 }
 
 func main() {
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Чтение переменных окружения, установленных ocserv
 	req := AuthRequest{
-		Reason:    os.Getenv("REASON"),
-		Username:  os.Getenv("USERNAME"),
-		GroupName: os.Getenv("GROUPNAME"),
-		IPReal:    os.Getenv("IP_REAL"),
-		IPRemote:  os.Getenv("IP_REMOTE"),
-		Device:    os.Getenv("DEVICE"),
-		SessionID: os.Getenv("ID"),
+		Version:     protocolVersion,
+		RequestID:   newRequestID(),
+		TraceParent: newTraceParent(),
+		Reason:      os.Getenv("REASON"),
+		Username:    os.Getenv("USERNAME"),
+		GroupName:   os.Getenv("GROUPNAME"),
+		IPReal:      os.Getenv("IP_REAL"),
+		IPRemote:    os.Getenv("IP_REMOTE"),
+		Device:      os.Getenv("DEVICE"),
+		SessionID:   os.Getenv("ID"),
+		UserAgent:   os.Getenv("USER_AGENT"),
 	}
 
 	// Валидация обязательных полей
@@ -55,8 +101,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	// При disconnect передаем агенту точную статистику сессии.
+	// Решение агента не важно: сессия уже завершена
+	if req.Reason == "disconnect" {
+		stats, err := readSessionStats()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: invalid session stats: %v - skipping accounting\n", err)
+			os.Exit(0)
+		}
+		req.Stats = stats
+		if _, err := sendRequest(cfg, &req); err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: agent unavailable: %v - disconnect not reported\n", err)
+		}
+		os.Exit(0)
+	}
+
 	// Только для connect нужна авторизация
-	// Для disconnect просто логируем
+	// Для host-update просто логируем
 	if req.Reason != "connect" {
 		fmt.Fprintf(os.Stderr, "INFO: reason=%s user=%s - skipping authorization\n",
 			req.Reason, req.Username)
@@ -64,39 +125,104 @@ func main() {
 	}
 
 	// Отправка запроса к агенту
-	resp, err := sendRequest(&req)
+	cache := newDecisionCache(cfg)
+	resp, err := sendRequest(cfg, &req)
 	if err != nil {
-		// Fail-open режим: если агент недоступен, разрешаем подключение
-		// Для production можно изменить на fail-close (os.Exit(1))
-		fmt.Fprintf(os.Stderr, "WARN: agent unavailable: %v - allowing (fail-open mode)\n", err)
-		os.Exit(0)
+		// Агент недоступен: сначала последнее решение из кэша, затем fail policy
+		if cache != nil {
+			if entry, ok := cache.lookup(&req, time.Now()); ok {
+				fmt.Fprintf(os.Stderr, "WARN: agent unavailable: %v - using decision cached at %s\n",
+					err, entry.At.Format(time.RFC3339))
+				resp = &AuthResponse{Allowed: entry.Allowed, Error: entry.Error}
+			}
+		}
+		if resp == nil {
+			if cfg.FailPolicy == failPolicyClose {
+				fmt.Fprintf(os.Stderr, "DENY: agent unavailable: %v - denying (fail-close mode)\n", err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "WARN: agent unavailable: %v - allowing (fail-open mode)\n", err)
+			os.Exit(0)
+		}
+	} else if cache != nil {
+		if err := cache.store(&req, resp, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: decision cache not updated: %v\n", err)
+		}
 	}
 
 	// Проверка решения агента
 	if !resp.Allowed {
-		fmt.Fprintf(os.Stderr, "DENY: user=%s reason=%s\n", req.Username, resp.Error)
+		fmt.Fprintf(os.Stderr, "DENY: user=%s reason=%s request_id=%s\n", req.Username, resp.Error, req.RequestID)
 		os.Exit(1)
 	}
 
 	// Разрешено
-	fmt.Fprintf(os.Stderr, "ALLOW: user=%s ip=%s\n", req.Username, req.IPReal)
+	fmt.Fprintf(os.Stderr, "ALLOW: user=%s ip=%s request_id=%s\n", req.Username, req.IPReal, req.RequestID)
 	os.Exit(0)
 }
 
-// sendRequest отправляет запрос к агенту через Unix socket
+// readSessionStats читает статистику сессии из переменных окружения
+// disconnect-скрипта. Отсутствующие значения считаются нулевыми
+func readSessionStats() (*SessionStats, error) {
+	var stats SessionStats
+	var err error
+
+	if v := os.Getenv("STATS_BYTES_IN"); v != "" {
+		if stats.BytesIn, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("STATS_BYTES_IN: %w", err)
+		}
+	}
+	if v := os.Getenv("STATS_BYTES_OUT"); v != "" {
+		if stats.BytesOut, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("STATS_BYTES_OUT: %w", err)
+		}
+	}
+	if v := os.Getenv("STATS_DURATION"); v != "" {
+		if stats.Duration, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("STATS_DURATION: %w", err)
+		}
+	}
+
+	return &stats, nil
+}
+
+// sendRequest отправляет запрос к агенту. Если агент не поддерживает
+// версию протокола запроса, запрос повторяется один раз с максимальной
+// версией, которую сообщил агент
+func sendRequest(cfg *Config, req *AuthRequest) (*AuthResponse, error) {
+	resp, err := exchange(cfg, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != errorCodeUnsupportedVersion {
+		return resp, nil
+	}
+	if resp.Version < 1 || resp.Version >= req.Version {
+		return nil, fmt.Errorf("agent does not support protocol version %d: %s", req.Version, resp.Error)
+	}
+
+	fmt.Fprintf(os.Stderr, "INFO: agent supports protocol up to v%d, retrying request_id=%s\n", resp.Version, req.RequestID)
+	req.Version = resp.Version
+	return exchange(cfg, req)
+}
+
+// exchange отправляет один запрос к агенту через Unix socket
 // Использует length-prefixed JSON протокол
-func sendRequest(req *AuthRequest) (*AuthResponse, error) {
+func exchange(cfg *Config, req *AuthRequest) (*AuthResponse, error) {
 	// Подключение к Unix socket
-	conn, err := net.DialTimeout("unix", socketPath, socketTimeout)
+	conn, err := net.DialTimeout("unix", cfg.SocketPath, cfg.Timeout)
 	if err != nil {
 		return nil, fmt.Errorf("dial socket: %w", err)
 	}
 	defer conn.Close()
 
-	// Установка deadline для всей операции
-	if err := conn.SetDeadline(time.Now().Add(socketTimeout)); err != nil {
+	// Установка deadline для всей операции; агент v2 не обрабатывает
+	// запрос дольше этого срока
+	deadline := time.Now().Add(cfg.Timeout)
+	if err := conn.SetDeadline(deadline); err != nil {
 		return nil, fmt.Errorf("set deadline: %w", err)
 	}
+	req.Deadline = deadline.UnixMilli()
 
 	// Сериализация запроса
 	data, err := json.Marshal(req)
@@ -128,7 +254,7 @@ func sendRequest(req *AuthRequest) (*AuthResponse, error) {
 
 	// Чтение данных ответа
 	respData := make([]byte, respLen)
-	if _, err := conn.Read(respData); err != nil {
+	if _, err := io.ReadFull(conn, respData); err != nil {
 		return nil, fmt.Errorf("read response data: %w", err)
 	}
 
@@ -140,3 +266,26 @@ func sendRequest(req *AuthRequest) (*AuthResponse, error) {
 
 	return &resp, nil
 }
+
+// newRequestID возвращает случайный ID запроса
+func newRequestID() string {
+	return randomHex(8)
+}
+
+// newTraceParent возвращает W3C traceparent: из TRACEPARENT, если
+// вызывающая сторона уже ведет трассу, иначе начинает новую трассу с
+// пометкой sampled, чтобы агент записал ее spans
+func newTraceParent() string {
+	if tp := os.Getenv("TRACEPARENT"); tp != "" {
+		return tp
+	}
+	return "00-" + randomHex(16) + "-" + randomHex(8) + "-01"
+}
+
+// randomHex возвращает n случайных байт в шестнадцатеричном виде
+func randomHex(n int) string {
+	b := make([]byte, n)
+	// crypto/rand.Read не возвращает ошибок на поддерживаемых платформах
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAgent answers IPC requests on a Unix socket with respond and records
+// the raw requests it received
+type fakeAgent struct {
+	mu       sync.Mutex
+	requests []map[string]any
+}
+
+func newFakeAgent(t *testing.T, respond func(req map[string]any) map[string]any) (*fakeAgent, *Config) {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	agent := &fakeAgent{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			agent.serve(conn, respond)
+		}
+	}()
+
+	cfg := defaultConfig()
+	cfg.SocketPath = socketPath
+	cfg.Timeout = time.Second
+	return agent, &cfg
+}
+
+func (a *fakeAgent) serve(conn net.Conn, respond func(req map[string]any) map[string]any) {
+	defer conn.Close()
+
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return
+	}
+	var req map[string]any
+	if err := json.Unmarshal(data, &req); err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	a.requests = append(a.requests, req)
+	a.mu.Unlock()
+
+	out, _ := json.Marshal(respond(req))
+	// #nosec G115 - test responses are small
+	_ = binary.Write(conn, binary.BigEndian, uint32(len(out)))
+	_, _ = conn.Write(out)
+}
+
+// versions returns the protocol versions of the received requests, 0 for
+// requests without one
+func (a *fakeAgent) versions() []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var versions []int
+	for _, req := range a.requests {
+		v, _ := req["version"].(float64)
+		versions = append(versions, int(v))
+	}
+	return versions
+}
+
+// v1Agent answers like an agent predating protocol v2: it ignores the
+// version and never echoes it
+func v1Agent(map[string]any) map[string]any {
+	return map[string]any{"allowed": true}
+}
+
+// v2Agent answers like a v2 agent, rejecting newer versions
+func v2Agent(req map[string]any) map[string]any {
+	version, _ := req["version"].(float64)
+	if version > 2 {
+		return map[string]any{
+			"version":    2,
+			"request_id": req["request_id"],
+			"error_code": errorCodeUnsupportedVersion,
+			"allowed":    false,
+			"error":      "unsupported protocol version 3 (max 2)",
+		}
+	}
+	return map[string]any{"version": 2, "request_id": req["request_id"], "allowed": true}
+}
+
+func TestSendRequest_VersionNegotiation(t *testing.T) {
+	tests := []struct {
+		name         string
+		agent        func(map[string]any) map[string]any
+		version      int
+		wantVersions []int
+		wantResp     AuthResponse
+		wantErr      string
+	}{
+		{
+			name:         "v2 client against v1 agent",
+			agent:        v1Agent,
+			version:      2,
+			wantVersions: []int{2},
+			wantResp:     AuthResponse{Allowed: true},
+		},
+		{
+			name:         "v2 client against v2 agent",
+			agent:        v2Agent,
+			version:      2,
+			wantVersions: []int{2},
+			wantResp:     AuthResponse{Version: 2, RequestID: "r1", Allowed: true},
+		},
+		{
+			name:         "v3 client against v2 agent retries at v2",
+			agent:        v2Agent,
+			version:      3,
+			wantVersions: []int{3, 2},
+			wantResp:     AuthResponse{Version: 2, RequestID: "r1", Allowed: true},
+		},
+		{
+			name: "agent without a lower version",
+			agent: func(map[string]any) map[string]any {
+				return map[string]any{"version": 2, "error_code": errorCodeUnsupportedVersion, "error": "unsupported"}
+			},
+			version:      2,
+			wantVersions: []int{2},
+			wantErr:      "agent does not support protocol version 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent, cfg := newFakeAgent(t, tt.agent)
+
+			req := &AuthRequest{Version: tt.version, RequestID: "r1", Reason: "connect", Username: "alice"}
+			resp, err := sendRequest(cfg, req)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantResp, *resp)
+			}
+			assert.Equal(t, tt.wantVersions, agent.versions())
+		})
+	}
+}
+
+func TestSendRequest_AgentUnavailable(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.SocketPath = filepath.Join(t.TempDir(), "missing.sock")
+
+	_, err := sendRequest(&cfg, &AuthRequest{Version: protocolVersion, Username: "alice"})
+	assert.ErrorContains(t, err, "dial socket")
+}
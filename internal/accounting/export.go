@@ -0,0 +1,136 @@
+package accounting
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Format is an export file format
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// ParseFormat parses an export format name (case-insensitive)
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(s)) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatJSONL, "ndjson":
+		return FormatJSONL, nil
+	default:
+		return "", errors.Newf("unsupported export format %q (expected csv or jsonl)", s)
+	}
+}
+
+// csvHeader lists the CSV columns, in order
+var csvHeader = []string{
+	"session_id",
+	"username",
+	"group_name",
+	"client_ip",
+	"vpn_ipv4",
+	"vpn_ipv6",
+	"user_agent",
+	"started_at",
+	"ended_at",
+	"duration_seconds",
+	"bytes_in",
+	"bytes_out",
+	"disconnect_reason",
+}
+
+// RecordWriter writes records in an export format
+type RecordWriter interface {
+	Write(rec *Record) error
+	// Flush writes any buffered data to the underlying writer
+	Flush() error
+}
+
+// NewWriter returns a RecordWriter producing the given format
+func NewWriter(w io.Writer, format Format) (RecordWriter, error) {
+	switch format {
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatJSONL:
+		bw := bufio.NewWriter(w)
+		return &jsonlWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	default:
+		return nil, errors.Newf("unsupported export format %q", format)
+	}
+}
+
+// csvWriter writes records as CSV with a header row
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvWriter) Write(rec *Record) error {
+	if !c.headerWritten {
+		if err := c.w.Write(csvHeader); err != nil {
+			return errors.Wrap(err, "write csv header")
+		}
+		c.headerWritten = true
+	}
+
+	row := []string{
+		rec.SessionID,
+		rec.Username,
+		rec.GroupName,
+		rec.ClientIP,
+		rec.VPNIPv4,
+		rec.VPNIPv6,
+		rec.UserAgent,
+		formatTime(rec.StartedAt),
+		formatTime(rec.EndedAt),
+		strconv.FormatInt(int64(rec.Duration()/time.Second), 10),
+		strconv.FormatUint(rec.BytesIn, 10),
+		strconv.FormatUint(rec.BytesOut, 10),
+		rec.DisconnectReason,
+	}
+	return errors.Wrap(c.w.Write(row), "write csv row")
+}
+
+func (c *csvWriter) Flush() error {
+	// An empty export still gets a header so the columns are known
+	if !c.headerWritten {
+		if err := c.w.Write(csvHeader); err != nil {
+			return errors.Wrap(err, "write csv header")
+		}
+		c.headerWritten = true
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes one JSON object per line
+type jsonlWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(rec *Record) error {
+	return errors.Wrap(j.enc.Encode(rec), "write jsonl record")
+}
+
+func (j *jsonlWriter) Flush() error {
+	return j.w.Flush()
+}
+
+// formatTime renders a timestamp for CSV output (empty for zero times)
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
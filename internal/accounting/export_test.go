@@ -0,0 +1,80 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("CSV")
+	require.NoError(t, err)
+	assert.Equal(t, FormatCSV, f)
+
+	f, err = ParseFormat("ndjson")
+	require.NoError(t, err)
+	assert.Equal(t, FormatJSONL, f)
+
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestExportCSV(t *testing.T) {
+	l := openTestLedger(t, 0)
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	rec := testRecord("1", "alice", start, start.Add(90*time.Second))
+	rec.UserAgent = "AnyConnect, Linux"
+	rec.DisconnectReason = "schedule"
+	require.NoError(t, l.Append(rec))
+
+	var buf bytes.Buffer
+	n, err := l.Export(context.Background(), &buf, FormatCSV, Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, csvHeader, rows[0])
+	assert.Equal(t, []string{
+		"1", "alice", "staff", "203.0.113.10", "10.0.0.1", "", "AnyConnect, Linux",
+		"2026-10-01T09:00:00Z", "2026-10-01T09:01:30Z", "90", "100", "200", "schedule",
+	}, rows[1])
+}
+
+func TestExportCSVEmptyHasHeader(t *testing.T) {
+	l := openTestLedger(t, 0)
+
+	var buf bytes.Buffer
+	n, err := l.Export(context.Background(), &buf, FormatCSV, Filter{})
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, strings.Join(csvHeader, ",")+"\n", buf.String())
+}
+
+func TestExportJSONL(t *testing.T) {
+	l := openTestLedger(t, 0)
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, l.Append(testRecord("1", "alice", start, start.Add(time.Hour))))
+	require.NoError(t, l.Append(testRecord("2", "bob", start, start.Add(2*time.Hour))))
+
+	var buf bytes.Buffer
+	n, err := l.Export(context.Background(), &buf, FormatJSONL, Filter{Username: "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+
+	var rec Record
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "2", rec.SessionID)
+	assert.Equal(t, uint64(200), rec.BytesOut)
+}
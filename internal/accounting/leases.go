@@ -0,0 +1,247 @@
+package accounting
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// ActiveLeasesFile is the name of the active lease snapshot in the ledger
+// directory
+const ActiveLeasesFile = "active-leases.json"
+
+// tombstoneTTL is how long a disconnected session ID is remembered so that
+// its connect, delivered late, does not reopen the lease
+const tombstoneTTL = 10 * time.Minute
+
+// Lease records a VPN address held by a session during [Start, End)
+type Lease struct {
+	IP        string
+	SessionID string
+	Username  string
+	GroupName string
+	ClientIP  string
+	Start     time.Time
+	End       time.Time // zero while the session is still connected
+}
+
+// Active reports whether the session still holds the address
+func (l *Lease) Active() bool {
+	return l.End.IsZero()
+}
+
+// LeaseIndex attributes VPN addresses to users and sessions over time.
+//
+// Closed leases come from the ledger; leases of connected sessions are
+// tracked in memory and mirrored to a snapshot file so that the CLI and a
+// restarted agent see them too.
+type LeaseIndex struct {
+	ledger *Ledger
+	path   string
+
+	mu         sync.Mutex
+	active     map[string]*Record   // sessionID -> connected session (EndedAt unset)
+	tombstones map[string]time.Time // sessionID -> when it disconnected
+}
+
+// OpenLeaseIndex creates a lease index over ledger, loading the active lease
+// snapshot from path when it exists. An empty path keeps active leases in
+// memory only.
+func OpenLeaseIndex(ledger *Ledger, path string) (*LeaseIndex, error) {
+	if ledger == nil {
+		return nil, errors.New("lease index requires a ledger")
+	}
+
+	ix := &LeaseIndex{
+		ledger:     ledger,
+		path:       path,
+		active:     make(map[string]*Record),
+		tombstones: make(map[string]time.Time),
+	}
+
+	if path == "" {
+		return ix, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read active leases")
+	}
+
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, errors.Wrap(err, "parse active leases")
+	}
+	for _, rec := range records {
+		ix.active[rec.SessionID] = rec
+	}
+
+	return ix, nil
+}
+
+// Connect starts tracking the addresses of a connected session. A connect
+// arriving after the session already disconnected is ignored.
+func (ix *LeaseIndex) Connect(rec *Record) error {
+	if rec.SessionID == "" {
+		return errors.New("lease session id is required")
+	}
+	if rec.VPNIPv4 == "" && rec.VPNIPv6 == "" {
+		// Nothing to attribute
+		return nil
+	}
+
+	open := *rec
+	open.EndedAt = time.Time{}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	// A session reusing the ID starts after the disconnect
+	if disconnectedAt, ok := ix.tombstones[rec.SessionID]; ok && !rec.StartedAt.After(disconnectedAt) {
+		return nil
+	}
+
+	ix.active[rec.SessionID] = &open
+	return ix.saveLocked()
+}
+
+// Disconnect stops tracking a session. Its lease history is taken from the
+// ledger from now on.
+func (ix *LeaseIndex) Disconnect(sessionID string) error {
+	now := time.Now()
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for id, at := range ix.tombstones {
+		if now.Sub(at) > tombstoneTTL {
+			delete(ix.tombstones, id)
+		}
+	}
+	ix.tombstones[sessionID] = now
+
+	if _, ok := ix.active[sessionID]; !ok {
+		return nil
+	}
+	delete(ix.active, sessionID)
+	return ix.saveLocked()
+}
+
+// Lookup returns every lease of ip that overlaps [from, to], oldest first.
+// Pass the same time as from and to for a point-in-time query; a zero from
+// or to leaves that side of the range open.
+func (ix *LeaseIndex) Lookup(ctx context.Context, ip string, from, to time.Time) ([]Lease, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, errors.Newf("invalid IP address %q", ip)
+	}
+	ip = addr.Unmap().String()
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return nil, errors.New("range end is before range start")
+	}
+
+	// The ledger range is half-open; include sessions starting exactly at to
+	filter := Filter{VPNIP: ip, From: from}
+	if !to.IsZero() {
+		filter.To = to.Add(time.Nanosecond)
+	}
+
+	var leases []Lease
+	closed := make(map[string]bool)
+	err = ix.ledger.Scan(ctx, filter, func(rec *Record) bool {
+		// Sessions ending exactly at from no longer held the address
+		if !from.IsZero() && !rec.EndedAt.After(from) {
+			return true
+		}
+		leases = append(leases, leaseFromRecord(rec, ip))
+		closed[leaseKey(rec)] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ix.mu.Lock()
+	for _, rec := range ix.active {
+		if closed[leaseKey(rec)] || (!to.IsZero() && rec.StartedAt.After(to)) {
+			continue
+		}
+		if !sameIP(rec.VPNIPv4, ip) && !sameIP(rec.VPNIPv6, ip) {
+			continue
+		}
+		lease := leaseFromRecord(rec, ip)
+		lease.End = time.Time{}
+		leases = append(leases, lease)
+	}
+	ix.mu.Unlock()
+
+	sort.SliceStable(leases, func(i, j int) bool {
+		return leases[i].Start.Before(leases[j].Start)
+	})
+
+	return leases, nil
+}
+
+// saveLocked writes the active lease snapshot. Caller must hold ix.mu.
+func (ix *LeaseIndex) saveLocked() error {
+	if ix.path == "" {
+		return nil
+	}
+
+	records := make([]*Record, 0, len(ix.active))
+	for _, rec := range ix.active {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].SessionID < records[j].SessionID
+	})
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return errors.Wrap(err, "marshal active leases")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ix.path), ".active-leases-*")
+	if err != nil {
+		return errors.Wrap(err, "create active leases file")
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "write active leases")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "write active leases")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), ix.path), "replace active leases")
+}
+
+// leaseFromRecord builds the lease of ip held by the session in rec
+func leaseFromRecord(rec *Record, ip string) Lease {
+	return Lease{
+		IP:        ip,
+		SessionID: rec.SessionID,
+		Username:  rec.Username,
+		GroupName: rec.GroupName,
+		ClientIP:  rec.ClientIP,
+		Start:     rec.StartedAt,
+		End:       rec.EndedAt,
+	}
+}
+
+// leaseKey identifies a session across the ledger and the active set
+func leaseKey(rec *Record) string {
+	return rec.SessionID + "@" + rec.StartedAt.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,131 @@
+package accounting
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func leaseUsers(leases []Lease) []string {
+	var users []string
+	for _, l := range leases {
+		users = append(users, l.Username)
+	}
+	return users
+}
+
+func TestLeaseIndexLookup(t *testing.T) {
+	l := openTestLedger(t, 0)
+	ix, err := OpenLeaseIndex(l, filepath.Join(l.dir, ActiveLeasesFile))
+	require.NoError(t, err)
+
+	base := time.Date(2026, 10, 18, 13, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	// 10.0.16.23 was held by alice 13:00-14:00, then reused by bob from 14:00
+	alice := &Record{
+		SessionID: "1", Username: "alice", VPNIPv4: "10.0.16.23", VPNIPv6: "fc00::1:8651",
+		StartedAt: base, EndedAt: base.Add(time.Hour),
+	}
+	require.NoError(t, ix.Connect(alice))
+	require.NoError(t, l.Append(alice))
+	require.NoError(t, ix.Disconnect("1"))
+
+	bob := &Record{SessionID: "2", Username: "bob", VPNIPv4: "10.0.16.23", StartedAt: base.Add(time.Hour)}
+	require.NoError(t, ix.Connect(bob))
+
+	tests := []struct {
+		name     string
+		ip       string
+		from, to time.Time
+		want     []string
+	}{
+		{"point during alice", "10.0.16.23", base.Add(5 * time.Minute), base.Add(5 * time.Minute), []string{"alice"}},
+		{"point at handover", "10.0.16.23", base.Add(time.Hour), base.Add(time.Hour), []string{"bob"}},
+		{"point during bob", "10.0.16.23", base.Add(2 * time.Hour), base.Add(2 * time.Hour), []string{"bob"}},
+		{"range with reuse", "10.0.16.23", base.Add(30 * time.Minute), base.Add(90 * time.Minute), []string{"alice", "bob"}},
+		{"before any lease", "10.0.16.23", base.Add(-time.Hour), base.Add(-time.Minute), nil},
+		{"ipv6 other spelling", "fc00:0:0::1:8651", base.Add(time.Minute), base.Add(time.Minute), []string{"alice"}},
+		{"unbounded", "10.0.16.23", time.Time{}, time.Time{}, []string{"alice", "bob"}},
+		{"other ip", "10.0.16.24", time.Time{}, time.Time{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leases, err := ix.Lookup(ctx, tt.ip, tt.from, tt.to)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, leaseUsers(leases))
+		})
+	}
+
+	leases, err := ix.Lookup(ctx, "10.0.16.23", base.Add(2*time.Hour), base.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, leases, 1)
+	assert.True(t, leases[0].Active())
+	assert.Equal(t, "2", leases[0].SessionID)
+}
+
+func TestLeaseIndexLookupValidation(t *testing.T) {
+	ix, err := OpenLeaseIndex(openTestLedger(t, 0), "")
+	require.NoError(t, err)
+
+	_, err = ix.Lookup(context.Background(), "not-an-ip", time.Time{}, time.Time{})
+	assert.Error(t, err)
+
+	now := time.Now()
+	_, err = ix.Lookup(context.Background(), "10.0.0.1", now, now.Add(-time.Hour))
+	assert.Error(t, err)
+
+	_, err = OpenLeaseIndex(nil, "")
+	assert.Error(t, err)
+}
+
+func TestLeaseIndexPersistsActiveLeases(t *testing.T) {
+	l := openTestLedger(t, 0)
+	path := filepath.Join(l.dir, ActiveLeasesFile)
+
+	ix, err := OpenLeaseIndex(l, path)
+	require.NoError(t, err)
+	start := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, ix.Connect(&Record{SessionID: "1", Username: "alice", VPNIPv4: "10.0.0.1", StartedAt: start}))
+	require.NoError(t, ix.Connect(&Record{SessionID: "2", Username: "bob", VPNIPv4: "10.0.0.2", StartedAt: start}))
+	require.NoError(t, ix.Disconnect("2"))
+	// Sessions without a VPN address are not tracked
+	require.NoError(t, ix.Connect(&Record{SessionID: "3", Username: "carol", StartedAt: start}))
+
+	reopened, err := OpenLeaseIndex(l, path)
+	require.NoError(t, err)
+
+	leases, err := reopened.Lookup(context.Background(), "10.0.0.1", start.Add(time.Minute), start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, leaseUsers(leases))
+
+	leases, err = reopened.Lookup(context.Background(), "10.0.0.2", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, leases)
+}
+
+func TestLeaseIndexIgnoresLateConnect(t *testing.T) {
+	ix, err := OpenLeaseIndex(openTestLedger(t, 0), "")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// The disconnect of a short session is seen before its connect
+	start := time.Now().Add(-time.Second)
+	require.NoError(t, ix.Disconnect("7"))
+	require.NoError(t, ix.Connect(&Record{SessionID: "7", Username: "alice", VPNIPv4: "10.0.0.7", StartedAt: start}))
+
+	leases, err := ix.Lookup(ctx, "10.0.0.7", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, leases, "late connect must not open a lease")
+
+	// A new session reusing the ID is tracked
+	require.NoError(t, ix.Connect(&Record{SessionID: "7", Username: "bob", VPNIPv4: "10.0.0.7", StartedAt: time.Now().Add(time.Second)}))
+	leases, err = ix.Lookup(ctx, "10.0.0.7", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, leaseUsers(leases))
+}
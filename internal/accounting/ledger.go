@@ -0,0 +1,436 @@
+package accounting
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+const (
+	// filePrefix and fileSuffix frame the per-day ledger files:
+	// sessions-20060102.jsonl, one JSON record per line
+	filePrefix = "sessions-"
+	fileSuffix = ".jsonl"
+	dayLayout  = "20060102"
+
+	// pruneInterval is how often the retention policy is applied
+	pruneInterval = time.Hour
+
+	// DefaultPageSize is used by Query when no page size is given
+	DefaultPageSize = 100
+	// MaxPageSize caps the page size accepted by Query
+	MaxPageSize = 1000
+)
+
+// Config configures a Ledger
+type Config struct {
+	// Dir holds the per-day ledger files
+	Dir string
+	// Retention is how long records are kept (0 = forever). Whole days
+	// are dropped once every record in them is older than Retention.
+	Retention time.Duration
+	Logger    *slog.Logger
+}
+
+// Ledger is an append-only store of closed sessions.
+//
+// Records are written to one JSONL file per UTC day of session end. Files
+// are only ever appended to, so they can be read safely (for example by
+// the CLI export command) while the agent is running.
+type Ledger struct {
+	dir       string
+	retention time.Duration
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	current *os.File // file of the most recent day written
+	day     string
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Open opens (or creates) the ledger in cfg.Dir and starts the retention
+// janitor when a retention period is configured.
+func Open(cfg Config) (*Ledger, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("ledger directory is required")
+	}
+	if cfg.Retention < 0 {
+		return nil, errors.New("ledger retention must not be negative")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, errors.Wrapf(err, "create ledger directory %s", cfg.Dir)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Ledger{
+		dir:       cfg.Dir,
+		retention: cfg.Retention,
+		logger:    logger,
+		cancel:    cancel,
+	}
+
+	if l.retention > 0 {
+		if _, err := l.Prune(time.Now()); err != nil {
+			logger.Warn("failed to apply ledger retention", slog.String("error", err.Error()))
+		}
+		l.wg.Add(1)
+		go l.pruneLoop(ctx)
+	}
+
+	return l, nil
+}
+
+// Append adds a closed session to the ledger
+func (l *Ledger) Append(rec *Record) error {
+	if err := rec.validate(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "marshal record")
+	}
+	line = append(line, '\n')
+
+	day := rec.EndedAt.UTC().Format(dayLayout)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Late records (e.g. sessions recovered after a restart) may belong
+	// to an earlier day than the file currently kept open
+	if day != l.day {
+		f, err := os.OpenFile(l.path(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return errors.Wrapf(err, "open ledger file for %s", day)
+		}
+		if day < l.day {
+			defer func() { _ = f.Close() }()
+			_, err = f.Write(line)
+			return errors.Wrap(err, "write ledger record")
+		}
+		if l.current != nil {
+			_ = l.current.Close()
+		}
+		l.current, l.day = f, day
+	}
+
+	if _, err := l.current.Write(line); err != nil {
+		return errors.Wrap(err, "write ledger record")
+	}
+	return nil
+}
+
+// Scan calls fn for every record matching filter, in ledger order (day of
+// session end, then write order). Returning false from fn stops the scan.
+func (l *Ledger) Scan(ctx context.Context, filter Filter, fn func(*Record) bool) error {
+	return l.scan(ctx, filter, position{}, func(_ position, rec *Record) bool {
+		return fn(rec)
+	})
+}
+
+// Query returns one page of records matching filter. pageToken is empty for
+// the first page; the returned token is empty when there are no more records.
+func (l *Ledger) Query(ctx context.Context, filter Filter, pageSize int, pageToken string) ([]*Record, string, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	start, err := parsePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	records := make([]*Record, 0, pageSize)
+	var next string
+	err = l.scan(ctx, filter, start, func(pos position, rec *Record) bool {
+		if len(records) == pageSize {
+			next = pos.token()
+			return false
+		}
+		records = append(records, rec)
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return records, next, nil
+}
+
+// Export writes every record matching filter to w in the given format
+func (l *Ledger) Export(ctx context.Context, w io.Writer, format Format, filter Filter) (int, error) {
+	rw, err := NewWriter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var writeErr error
+	err = l.Scan(ctx, filter, func(rec *Record) bool {
+		if writeErr = rw.Write(rec); writeErr != nil {
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return count, err
+	}
+	if writeErr != nil {
+		return count, writeErr
+	}
+
+	return count, rw.Flush()
+}
+
+// Prune deletes day files whose records are all older than the retention
+// period. It returns the number of files removed.
+func (l *Ledger) Prune(now time.Time) (int, error) {
+	if l.retention <= 0 {
+		return 0, nil
+	}
+
+	cutoff := now.UTC().Add(-l.retention).Format(dayLayout)
+
+	days, err := l.days()
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	removed := 0
+	var errs []error
+	for _, day := range days {
+		// A day file may still hold records newer than the cutoff until
+		// the whole day has passed it
+		if day >= cutoff {
+			break
+		}
+		if day == l.day && l.current != nil {
+			_ = l.current.Close()
+			l.current, l.day = nil, ""
+		}
+		if err := os.Remove(l.path(day)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		l.logger.Info("pruned session ledger",
+			slog.Int("files", removed),
+			slog.String("cutoff_day", cutoff),
+		)
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// Close stops the retention janitor and closes the open ledger file
+func (l *Ledger) Close() error {
+	l.cancel()
+	l.wg.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.current == nil {
+		return nil
+	}
+	err := l.current.Close()
+	l.current, l.day = nil, ""
+	return err
+}
+
+// pruneLoop applies the retention policy periodically
+func (l *Ledger) pruneLoop(ctx context.Context) {
+	defer l.wg.Done()
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := l.Prune(now); err != nil {
+				l.logger.Warn("failed to apply ledger retention", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// path returns the file holding records that ended on day
+func (l *Ledger) path(day string) string {
+	return filepath.Join(l.dir, filePrefix+day+fileSuffix)
+}
+
+// days lists the days present in the ledger, oldest first
+func (l *Ledger) days() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read ledger directory")
+	}
+
+	var days []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)
+		if _, err := time.Parse(dayLayout, day); err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Strings(days)
+
+	return days, nil
+}
+
+// scan walks the ledger from start, calling fn with the position of each
+// matching record
+func (l *Ledger) scan(ctx context.Context, filter Filter, start position, fn func(position, *Record) bool) error {
+	days, err := l.days()
+	if err != nil {
+		return err
+	}
+
+	// Records are filed by end day, so days before From cannot match
+	var fromDay string
+	if !filter.From.IsZero() {
+		fromDay = filter.From.UTC().Format(dayLayout)
+	}
+
+	for _, day := range days {
+		if day < fromDay || day < start.day {
+			continue
+		}
+		skip := 0
+		if day == start.day {
+			skip = start.line
+		}
+
+		more, err := l.scanDay(ctx, day, skip, filter, fn)
+		if err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// scanDay scans a single day file. It returns false when fn asked to stop.
+func (l *Ledger) scanDay(ctx context.Context, day string, skip int, filter Filter, fn func(position, *Record) bool) (bool, error) {
+	f, err := os.Open(l.path(day))
+	if errors.Is(err, os.ErrNotExist) {
+		// Removed by retention while we were scanning
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "open ledger file for %s", day)
+	}
+	defer func() { _ = f.Close() }()
+
+	reader := bufio.NewReader(f)
+	for line := 0; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A trailing fragment without newline is a write in progress
+			return true, nil
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "read ledger file for %s", day)
+		}
+		if line < skip {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			l.logger.Warn("skipping malformed ledger record",
+				slog.String("day", day),
+				slog.Int("line", line+1),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		if !filter.Match(&rec) {
+			continue
+		}
+		if !fn(position{day: day, line: line}, &rec) {
+			return false, nil
+		}
+	}
+}
+
+// position locates a record in the ledger
+type position struct {
+	day  string
+	line int
+}
+
+// token encodes the position as an opaque page token
+func (p position) token() string {
+	return p.day + ":" + strconv.Itoa(p.line)
+}
+
+// parsePageToken decodes a page token produced by position.token
+func parsePageToken(token string) (position, error) {
+	if token == "" {
+		return position{}, nil
+	}
+
+	day, line, ok := strings.Cut(token, ":")
+	if !ok {
+		return position{}, errors.Newf("invalid page token %q", token)
+	}
+	if _, err := time.Parse(dayLayout, day); err != nil {
+		return position{}, errors.Newf("invalid page token %q", token)
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil || n < 0 {
+		return position{}, errors.Newf("invalid page token %q", token)
+	}
+
+	return position{day: day, line: n}, nil
+}
@@ -0,0 +1,204 @@
+package accounting
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestLedger(t *testing.T, retention time.Duration) *Ledger {
+	t.Helper()
+
+	l, err := Open(Config{Dir: t.TempDir(), Retention: retention})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	return l
+}
+
+func testRecord(id, user string, start, end time.Time) *Record {
+	return &Record{
+		SessionID: id,
+		Username:  user,
+		GroupName: "staff",
+		ClientIP:  "203.0.113.10",
+		VPNIPv4:   "10.0.0." + id,
+		StartedAt: start,
+		EndedAt:   end,
+		BytesIn:   100,
+		BytesOut:  200,
+	}
+}
+
+func TestLedgerAppendAndScan(t *testing.T) {
+	l := openTestLedger(t, 0)
+	day1 := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+
+	require.NoError(t, l.Append(testRecord("1", "alice", day1, day1.Add(time.Hour))))
+	require.NoError(t, l.Append(testRecord("2", "bob", day2, day2.Add(time.Hour))))
+	// Late record for an earlier day goes into that day's file
+	require.NoError(t, l.Append(testRecord("3", "carol", day1, day1.Add(2*time.Hour))))
+
+	var ids []string
+	require.NoError(t, l.Scan(context.Background(), Filter{}, func(r *Record) bool {
+		ids = append(ids, r.SessionID)
+		return true
+	}))
+	assert.Equal(t, []string{"1", "3", "2"}, ids)
+
+	days, err := l.days()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20261001", "20261002"}, days)
+}
+
+func TestLedgerAppendRequiresUsernameAndEnd(t *testing.T) {
+	l := openTestLedger(t, 0)
+
+	assert.Error(t, l.Append(&Record{EndedAt: time.Now()}))
+	assert.Error(t, l.Append(&Record{Username: "alice"}))
+}
+
+func TestLedgerQueryFilters(t *testing.T) {
+	l := openTestLedger(t, 0)
+	base := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	require.NoError(t, l.Append(testRecord("1", "alice", base, base.Add(time.Hour))))
+	require.NoError(t, l.Append(testRecord("2", "bob", base.Add(2*time.Hour), base.Add(3*time.Hour))))
+	require.NoError(t, l.Append(testRecord("3", "alice", base.Add(48*time.Hour), base.Add(49*time.Hour))))
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"all", Filter{}, []string{"1", "2", "3"}},
+		{"username", Filter{Username: "alice"}, []string{"1", "3"}},
+		{"vpn ip", Filter{IP: "10.0.0.2"}, []string{"2"}},
+		{"client ip", Filter{IP: "203.0.113.10"}, []string{"1", "2", "3"}},
+		{"group miss", Filter{GroupName: "admins"}, nil},
+		{
+			// Overlaps session 1 only
+			"active at",
+			Filter{From: base.Add(30 * time.Minute), To: base.Add(31 * time.Minute)},
+			[]string{"1"},
+		},
+		{"from skips old days", Filter{From: base.Add(24 * time.Hour)}, []string{"3"}},
+		{"to", Filter{To: base.Add(2 * time.Hour)}, []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, next, err := l.Query(context.Background(), tt.filter, 0, "")
+			require.NoError(t, err)
+			assert.Empty(t, next)
+
+			var ids []string
+			for _, r := range records {
+				ids = append(ids, r.SessionID)
+			}
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
+
+func TestLedgerQueryPagination(t *testing.T) {
+	l := openTestLedger(t, 0)
+	base := time.Date(2026, 10, 1, 22, 0, 0, 0, time.UTC)
+
+	// Five sessions ending 30 minutes apart, spanning two day files
+	for i := range 5 {
+		end := base.Add(time.Duration(i) * 30 * time.Minute)
+		require.NoError(t, l.Append(testRecord(string(rune('a'+i)), "alice", end.Add(-time.Minute), end)))
+	}
+
+	var ids []string
+	token := ""
+	pages := 0
+	for {
+		records, next, err := l.Query(context.Background(), Filter{}, 2, token)
+		require.NoError(t, err)
+		pages++
+		for _, r := range records {
+			ids = append(ids, r.SessionID)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, ids)
+}
+
+func TestLedgerQueryInvalidToken(t *testing.T) {
+	l := openTestLedger(t, 0)
+
+	for _, token := range []string{"garbage", "2026:1", "20261001:x", "20261001:-1"} {
+		_, _, err := l.Query(context.Background(), Filter{}, 10, token)
+		assert.Error(t, err, token)
+	}
+}
+
+func TestLedgerSkipsPartialAndMalformedLines(t *testing.T) {
+	l := openTestLedger(t, 0)
+	end := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, l.Append(testRecord("1", "alice", end.Add(-time.Hour), end)))
+
+	f, err := os.OpenFile(l.path("20261001"), os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString("not json\n{\"session_id\":\"2\",\"username\":\"bo")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	records, _, err := l.Query(context.Background(), Filter{}, 10, "")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "1", records[0].SessionID)
+}
+
+func TestLedgerPrune(t *testing.T) {
+	l := openTestLedger(t, 48*time.Hour)
+	now := time.Date(2026, 10, 10, 12, 0, 0, 0, time.UTC)
+
+	for _, day := range []int{5, 7, 8, 10} {
+		end := time.Date(2026, 10, day, 8, 0, 0, 0, time.UTC)
+		require.NoError(t, l.Append(testRecord("1", "alice", end.Add(-time.Hour), end)))
+	}
+
+	removed, err := l.Prune(now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	days, err := l.days()
+	require.NoError(t, err)
+	// 2026-10-08 still holds records younger than the cutoff (10-08 12:00)
+	assert.Equal(t, []string{"20261008", "20261010"}, days)
+
+	// Appending after a prune keeps working
+	require.NoError(t, l.Append(testRecord("2", "bob", now, now.Add(time.Minute))))
+}
+
+func TestLedgerIgnoresForeignFiles(t *testing.T) {
+	l := openTestLedger(t, 0)
+	require.NoError(t, os.WriteFile(filepath.Join(l.dir, "README"), []byte("x"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(l.dir, "sessions-bogus.jsonl"), []byte("x"), 0o600))
+
+	days, err := l.days()
+	require.NoError(t, err)
+	assert.Empty(t, days)
+}
+
+func TestOpenValidation(t *testing.T) {
+	_, err := Open(Config{})
+	assert.Error(t, err)
+
+	_, err = Open(Config{Dir: t.TempDir(), Retention: -time.Hour})
+	assert.Error(t, err)
+}
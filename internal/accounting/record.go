@@ -0,0 +1,97 @@
+// Package accounting keeps a ledger of closed VPN sessions.
+//
+// Active sessions live in the stats poller and the session store and are
+// dropped as soon as they disconnect. The ledger records every closed
+// session so that usage reports and incident reviews can be produced
+// after the fact.
+package accounting
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Record describes a single closed VPN session
+type Record struct {
+	SessionID        string    `json:"session_id"`
+	Username         string    `json:"username"`
+	GroupName        string    `json:"group_name,omitempty"`
+	ClientIP         string    `json:"client_ip,omitempty"`
+	VPNIPv4          string    `json:"vpn_ipv4,omitempty"`
+	VPNIPv6          string    `json:"vpn_ipv6,omitempty"`
+	UserAgent        string    `json:"user_agent,omitempty"`
+	StartedAt        time.Time `json:"started_at"`
+	EndedAt          time.Time `json:"ended_at"`
+	BytesIn          uint64    `json:"bytes_in"`
+	BytesOut         uint64    `json:"bytes_out"`
+	DisconnectReason string    `json:"disconnect_reason,omitempty"`
+}
+
+// Duration returns how long the session lasted
+func (r *Record) Duration() time.Duration {
+	if r.EndedAt.Before(r.StartedAt) {
+		return 0
+	}
+	return r.EndedAt.Sub(r.StartedAt)
+}
+
+// validate checks the fields required to place a record in the ledger
+func (r *Record) validate() error {
+	if r.Username == "" {
+		return errors.New("record username is required")
+	}
+	if r.EndedAt.IsZero() {
+		return errors.New("record end time is required")
+	}
+	return nil
+}
+
+// Filter selects ledger records. Zero-valued fields match everything.
+type Filter struct {
+	Username  string
+	GroupName string
+	// IP matches the client IP or either VPN address
+	IP string
+	// VPNIP matches either VPN address only
+	VPNIP string
+	// From and To select sessions that were active at some point in
+	// [From, To)
+	From time.Time
+	To   time.Time
+}
+
+// Match reports whether the record satisfies the filter
+func (f *Filter) Match(r *Record) bool {
+	if f.Username != "" && r.Username != f.Username {
+		return false
+	}
+	if f.GroupName != "" && r.GroupName != f.GroupName {
+		return false
+	}
+	if f.IP != "" && !sameIP(r.ClientIP, f.IP) && !sameIP(r.VPNIPv4, f.IP) && !sameIP(r.VPNIPv6, f.IP) {
+		return false
+	}
+	if f.VPNIP != "" && !sameIP(r.VPNIPv4, f.VPNIP) && !sameIP(r.VPNIPv6, f.VPNIP) {
+		return false
+	}
+	if !f.From.IsZero() && r.EndedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !r.StartedAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// sameIP compares two addresses, tolerating different textual forms of the
+// same IPv6 address
+func sameIP(a, b string) bool {
+	if a == b {
+		return a != ""
+	}
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	return errA == nil && errB == nil && addrA.Unmap() == addrB.Unmap()
+}
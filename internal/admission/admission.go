@@ -0,0 +1,338 @@
+// Package admission implements expression-based admission rules.
+//
+// Rules are CEL expressions evaluated against each connect request and
+// its enrichment, for example
+//
+//	group == "contractors" && sessions(user) >= 1
+//
+// Expressions are compiled once when the engine is created. Rules are
+// checked in order and the first rule whose expression is true applies.
+package admission
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/dantte-lp/ocserv-agent/internal/policy"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Action is the outcome of a matching rule
+type Action string
+
+const (
+	// ActionDeny rejects the connection
+	ActionDeny Action = "deny"
+	// ActionAllow exempts the connection from the remaining rules; the
+	// portal still makes the final decision
+	ActionAllow Action = "allow"
+)
+
+// SessionCounter reports the active sessions of a user (stats.Poller)
+type SessionCounter interface {
+	CountSessions(username string) int
+}
+
+// RuleSpec is the uncompiled form of a rule, as read from configuration
+type RuleSpec struct {
+	Name   string
+	Expr   string
+	Action string
+	Reason string // deny reason reported to the client
+}
+
+// Rule is a compiled admission rule
+type Rule struct {
+	Name    string
+	Expr    string
+	Action  Action
+	Reason  string
+	program cel.Program
+}
+
+// Input is the connect request a rule is evaluated against
+type Input struct {
+	Username  string
+	GroupName string
+	ClientIP  string
+	VPNIP     string
+	Device    string
+	UserAgent string
+	Time      time.Time
+}
+
+// Decision is the result of evaluating the rules
+type Decision struct {
+	Action Action
+	Rule   string // name of the matching rule, empty if none matched
+	Reason string
+}
+
+// Result is the outcome of a single rule, as reported by EvaluateAll
+type Result struct {
+	Rule    *Rule
+	Matched bool
+	Err     error
+}
+
+// Config configures the admission engine
+type Config struct {
+	Rules     []RuleSpec
+	Sessions  SessionCounter         // optional, sessions() returns 0 without it
+	Countries policy.CountryResolver // optional, country is empty without it
+	Meter     metric.Meter
+}
+
+// Engine evaluates admission rules
+type Engine struct {
+	rules     []*Rule
+	sessions  SessionCounter
+	countries policy.CountryResolver
+
+	hitsTotal   metric.Int64Counter
+	errorsTotal metric.Int64Counter
+}
+
+// New compiles the rules of cfg
+func New(cfg Config) (*Engine, error) {
+	if cfg.Meter == nil {
+		return nil, errors.New("meter is required")
+	}
+
+	hitsTotal, err := cfg.Meter.Int64Counter(
+		"admission.rule.hits.total",
+		metric.WithDescription("Total number of connections matched by each admission rule"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create hits counter")
+	}
+
+	errorsTotal, err := cfg.Meter.Int64Counter(
+		"admission.rule.errors.total",
+		metric.WithDescription("Total number of admission rule evaluation errors"),
+		metric.WithUnit("{error}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create errors counter")
+	}
+
+	e := &Engine{
+		sessions:    cfg.Sessions,
+		countries:   cfg.Countries,
+		hitsTotal:   hitsTotal,
+		errorsTotal: errorsTotal,
+	}
+
+	if e.rules, err = e.compileRules(cfg.Rules); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Validate compiles rule specifications without creating an engine
+func Validate(specs []RuleSpec) error {
+	_, err := (&Engine{}).compileRules(specs)
+	return err
+}
+
+// Evaluate returns the decision of the first matching rule. Rules that
+// fail to evaluate are counted and skipped.
+func (e *Engine) Evaluate(ctx context.Context, in Input) Decision {
+	vars := e.activation(in)
+	for _, rule := range e.rules {
+		matched, err := rule.eval(vars)
+		if err != nil {
+			e.errorsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("rule", rule.Name)))
+			continue
+		}
+		if matched {
+			e.hitsTotal.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("rule", rule.Name),
+				attribute.String("action", string(rule.Action)),
+			))
+			return Decision{Action: rule.Action, Rule: rule.Name, Reason: rule.Reason}
+		}
+	}
+	return Decision{Action: ActionAllow}
+}
+
+// EvaluateAll evaluates every rule without recording metrics, for
+// testing rules against a sample request
+func (e *Engine) EvaluateAll(in Input) []Result {
+	vars := e.activation(in)
+	results := make([]Result, 0, len(e.rules))
+	for _, rule := range e.rules {
+		matched, err := rule.eval(vars)
+		results = append(results, Result{Rule: rule, Matched: matched, Err: err})
+	}
+	return results
+}
+
+// Len returns the number of rules
+func (e *Engine) Len() int {
+	return len(e.rules)
+}
+
+// newEnv declares the variables and functions available to expressions
+func (e *Engine) newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("user", cel.StringType),
+		cel.Variable("group", cel.StringType),
+		cel.Variable("ip", cel.StringType),
+		cel.Variable("vpn_ip", cel.StringType),
+		cel.Variable("device", cel.StringType),
+		cel.Variable("user_agent", cel.StringType),
+		cel.Variable("country", cel.StringType),
+		cel.Variable("now", cel.TimestampType),
+		cel.Function("sessions",
+			cel.Overload("sessions_string", []*cel.Type{cel.StringType}, cel.IntType,
+				cel.UnaryBinding(e.countSessions),
+			),
+		),
+		cel.Function("in_cidr",
+			cel.Overload("in_cidr_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(inCIDR),
+			),
+		),
+	)
+}
+
+// activation returns the expression variables of a request
+func (e *Engine) activation(in Input) map[string]any {
+	now := in.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var country string
+	if e.countries != nil {
+		if addr, err := netip.ParseAddr(in.ClientIP); err == nil {
+			country = e.countries.Country(addr.Unmap())
+		}
+	}
+
+	return map[string]any{
+		"user":       in.Username,
+		"group":      in.GroupName,
+		"ip":         in.ClientIP,
+		"vpn_ip":     in.VPNIP,
+		"device":     in.Device,
+		"user_agent": in.UserAgent,
+		"country":    country,
+		"now":        now,
+	}
+}
+
+// countSessions implements sessions(user)
+func (e *Engine) countSessions(username ref.Val) ref.Val {
+	name, ok := username.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(username)
+	}
+	if e.sessions == nil {
+		return types.Int(0)
+	}
+	return types.Int(e.sessions.CountSessions(string(name)))
+}
+
+// inCIDR implements in_cidr(ip, cidr)
+func inCIDR(ip, cidr ref.Val) ref.Val {
+	ipStr, ok := ip.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(ip)
+	}
+	cidrStr, ok := cidr.(types.String)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(cidr)
+	}
+
+	prefix, err := netip.ParsePrefix(string(cidrStr))
+	if err != nil {
+		return types.NewErr("in_cidr: invalid network %q", string(cidrStr))
+	}
+	addr, err := netip.ParseAddr(string(ipStr))
+	if err != nil {
+		return types.False
+	}
+	return types.Bool(prefix.Contains(addr.Unmap()))
+}
+
+// compileRules compiles rule specifications in the engine's environment
+func (e *Engine) compileRules(specs []RuleSpec) ([]*Rule, error) {
+	env, err := e.newEnv()
+	if err != nil {
+		return nil, errors.Wrap(err, "create expression environment")
+	}
+
+	rules := make([]*Rule, 0, len(specs))
+	for i, spec := range specs {
+		rule, err := compile(env, spec)
+		if err != nil {
+			if spec.Name == "" {
+				return nil, errors.Wrapf(err, "rule %d", i)
+			}
+			return nil, errors.Wrapf(err, "rule %s", spec.Name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compile type-checks a rule expression and plans its program
+func compile(env *cel.Env, spec RuleSpec) (*Rule, error) {
+	if spec.Name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	action := Action(spec.Action)
+	switch action {
+	case ActionAllow, ActionDeny:
+	default:
+		return nil, errors.Newf("action must be allow or deny, got %q", spec.Action)
+	}
+
+	if spec.Expr == "" {
+		return nil, errors.New("expr is required")
+	}
+	ast, issues := env.Compile(spec.Expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, errors.Wrap(issues.Err(), "compile expr")
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, errors.Newf("expr must evaluate to bool, got %s", ast.OutputType())
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan expr")
+	}
+
+	return &Rule{
+		Name:    spec.Name,
+		Expr:    spec.Expr,
+		Action:  action,
+		Reason:  spec.Reason,
+		program: program,
+	}, nil
+}
+
+// eval evaluates the rule expression
+func (r *Rule) eval(vars map[string]any) (bool, error) {
+	out, _, err := r.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, errors.Newf("expr returned %s, not bool", out.Type())
+	}
+	return matched, nil
+}
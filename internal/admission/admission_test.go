@@ -0,0 +1,112 @@
+package admission
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+)
+
+// fakeSessions is a SessionCounter backed by a map
+type fakeSessions map[string]int
+
+func (s fakeSessions) CountSessions(username string) int {
+	return s[username]
+}
+
+// fakeCountries is a CountryResolver backed by a map
+type fakeCountries map[string]string
+
+func (c fakeCountries) Country(addr netip.Addr) string {
+	return c[addr.String()]
+}
+
+func newTestEngine(t *testing.T, specs ...RuleSpec) *Engine {
+	t.Helper()
+
+	e, err := New(Config{
+		Rules:     specs,
+		Sessions:  fakeSessions{"carol": 1},
+		Countries: fakeCountries{"203.0.113.66": "KP"},
+		Meter:     metricnoop.NewMeterProvider().Meter("test"),
+	})
+	require.NoError(t, err)
+	return e
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	e := newTestEngine(t,
+		RuleSpec{Name: "admins", Expr: `group == "admins"`, Action: "allow"},
+		RuleSpec{Name: "single-session", Expr: `group == "contractors" && sessions(user) >= 1`, Action: "deny", Reason: "one session per contractor"},
+		RuleSpec{Name: "embargo", Expr: `country in ["KP", "IR"]`, Action: "deny"},
+		RuleSpec{Name: "legacy-clients", Expr: `user_agent.startsWith("Cisco AnyConnect VPN Agent for Windows 3.")`, Action: "deny"},
+		RuleSpec{Name: "night", Expr: `now.getHours("UTC") < 6 && !in_cidr(ip, "198.51.100.0/24")`, Action: "deny"},
+	)
+	noon := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	night := time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		in         Input
+		wantAction Action
+		wantRule   string
+	}{
+		{"allow stops evaluation", Input{Username: "alice", GroupName: "admins", ClientIP: "203.0.113.66", Time: noon}, ActionAllow, "admins"},
+		{"second session denied", Input{Username: "carol", GroupName: "contractors", ClientIP: "203.0.113.1", Time: noon}, ActionDeny, "single-session"},
+		{"first session passes", Input{Username: "dave", GroupName: "contractors", ClientIP: "203.0.113.1", Time: noon}, ActionAllow, ""},
+		{"country", Input{Username: "erin", GroupName: "staff", ClientIP: "203.0.113.66", Time: noon}, ActionDeny, "embargo"},
+		{"user agent", Input{Username: "frank", GroupName: "staff", ClientIP: "203.0.113.1", UserAgent: "Cisco AnyConnect VPN Agent for Windows 3.1.05160", Time: noon}, ActionDeny, "legacy-clients"},
+		{"time and network", Input{Username: "grace", GroupName: "staff", ClientIP: "203.0.113.1", Time: night}, ActionDeny, "night"},
+		{"office network at night", Input{Username: "grace", GroupName: "staff", ClientIP: "198.51.100.7", Time: night}, ActionAllow, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := e.Evaluate(context.Background(), tt.in)
+			assert.Equal(t, tt.wantAction, decision.Action)
+			assert.Equal(t, tt.wantRule, decision.Rule)
+		})
+	}
+}
+
+func TestEngineSkipsRulesFailingAtRuntime(t *testing.T) {
+	e := newTestEngine(t,
+		RuleSpec{Name: "broken", Expr: `in_cidr(ip, "not-a-network")`, Action: "deny"},
+		RuleSpec{Name: "staff", Expr: `group == "staff"`, Action: "deny"},
+	)
+	in := Input{Username: "alice", GroupName: "staff", ClientIP: "203.0.113.1"}
+
+	decision := e.Evaluate(context.Background(), in)
+	assert.Equal(t, "staff", decision.Rule)
+
+	results := e.EvaluateAll(in)
+	require.Len(t, results, 2)
+	assert.Error(t, results[0].Err)
+	assert.True(t, results[1].Matched)
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   RuleSpec
+		errMsg string
+	}{
+		{"missing name", RuleSpec{Expr: "true", Action: "deny"}, "rule 0: name is required"},
+		{"invalid action", RuleSpec{Name: "r", Expr: "true", Action: "defer"}, "rule r: action must be allow or deny"},
+		{"missing expr", RuleSpec{Name: "r", Action: "deny"}, "expr is required"},
+		{"syntax error", RuleSpec{Name: "r", Expr: `group ==`, Action: "deny"}, "compile expr"},
+		{"unknown variable", RuleSpec{Name: "r", Expr: `team == "a"`, Action: "deny"}, "undeclared reference"},
+		{"non-bool result", RuleSpec{Name: "r", Expr: `sessions(user)`, Action: "deny"}, "must evaluate to bool"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Config{Rules: []RuleSpec{tt.spec}, Meter: metricnoop.NewMeterProvider().Meter("test")})
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
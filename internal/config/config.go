@@ -4,9 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/dantte-lp/ocserv-agent/internal/admission"
 	"github.com/dantte-lp/ocserv-agent/internal/cert"
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
 	"gopkg.in/yaml.v3"
 )
 
@@ -25,6 +29,14 @@ type Config struct {
 	Logging       LoggingConfig       `yaml:"logging"`
 	Security      SecurityConfig      `yaml:"security"`
 	Resilience    ResilienceConfig    `yaml:"resilience"`
+	Schedule      ScheduleConfig      `yaml:"schedule"`
+	LocalPolicy   LocalPolicyConfig   `yaml:"local_policy"`
+	Admission     AdmissionConfig     `yaml:"admission"`
+	Sessions      SessionsConfig      `yaml:"sessions"`
+	Storage       StorageConfig       `yaml:"storage"`
+	Accounting    AccountingConfig    `yaml:"accounting"`
+	Radius        RadiusConfig        `yaml:"radius"`
+	Webhooks      WebhooksConfig      `yaml:"webhooks"`
 }
 
 // ControlServerConfig defines connection settings to control server
@@ -67,12 +79,73 @@ type OcservConfig struct {
 	CtlSocket         string `yaml:"ctl_socket"`
 	SystemdService    string `yaml:"systemd_service"`
 	BackupDir         string `yaml:"backup_dir"`
+	RunAsUser         string `yaml:"run_as_user"`  // run-as-user of ocserv, empty assumes "ocserv"
+	RunAsGroup        string `yaml:"run_as_group"` // run-as-group of ocserv, empty assumes "ocserv"
 }
 
 // IPCConfig defines Unix socket IPC settings
 type IPCConfig struct {
 	SocketPath string        `yaml:"socket_path"`
 	Timeout    time.Duration `yaml:"timeout"`
+
+	SocketMode    string   `yaml:"socket_mode"`     // octal file mode, default "0660"
+	SocketOwner   string   `yaml:"socket_owner"`    // user name or UID, empty keeps the agent's
+	SocketGroup   string   `yaml:"socket_group"`    // group name or GID, empty keeps the agent's
+	AllowedUsers  []string `yaml:"allowed_users"`   // peer users (names or UIDs); empty with allowed_groups: root and ocserv.run_as_user
+	AllowedGroups []string `yaml:"allowed_groups"`  // peer primary groups (names or GIDs); empty with allowed_users: ocserv.run_as_group
+	AllowAllPeers bool     `yaml:"allow_all_peers"` // disable peer credential checks
+
+	MaxConcurrent int `yaml:"max_concurrent"` // concurrent portal checks, 0 = unlimited
+	MaxQueue      int `yaml:"max_queue"`      // requests waiting for a portal check slot
+}
+
+// DefaultOcservUser is the run-as user and group assumed for ocserv when
+// ocserv.run_as_user and ocserv.run_as_group are not set
+const DefaultOcservUser = "ocserv"
+
+// PeerNames lists the users and groups (names or numeric IDs) allowed to
+// use the IPC socket. Optional names are defaults the operator did not
+// configure; the accounts may not exist on the host.
+type PeerNames struct {
+	Users          []string
+	Groups         []string
+	OptionalUsers  []string
+	OptionalGroups []string
+}
+
+// IPCPeers returns the users and groups allowed to use the IPC socket.
+// Configured allowed_users and allowed_groups are used as is. Otherwise
+// root and the run-as user and group of ocserv are allowed; only a
+// configured run_as_user or run_as_group is required to exist.
+func (c *Config) IPCPeers() PeerNames {
+	if len(c.IPC.AllowedUsers) > 0 || len(c.IPC.AllowedGroups) > 0 {
+		return PeerNames{Users: c.IPC.AllowedUsers, Groups: c.IPC.AllowedGroups}
+	}
+
+	peers := PeerNames{OptionalUsers: []string{"root"}}
+	if c.Ocserv.RunAsUser != "" {
+		peers.Users = append(peers.Users, c.Ocserv.RunAsUser)
+	} else {
+		peers.OptionalUsers = append(peers.OptionalUsers, DefaultOcservUser)
+	}
+	if c.Ocserv.RunAsGroup != "" {
+		peers.Groups = append(peers.Groups, c.Ocserv.RunAsGroup)
+	} else {
+		peers.OptionalGroups = append(peers.OptionalGroups, DefaultOcservUser)
+	}
+	return peers
+}
+
+// FileMode returns the parsed socket file mode
+func (c *IPCConfig) FileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.SocketMode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid socket_mode %q: %w", c.SocketMode, err)
+	}
+	if mode > 0o777 {
+		return 0, fmt.Errorf("socket_mode %q has bits outside 0777", c.SocketMode)
+	}
+	return os.FileMode(mode), nil
 }
 
 // PortalConfig defines portal gRPC connection settings
@@ -83,6 +156,47 @@ type PortalConfig struct {
 	TLSCA    string        `yaml:"tls_ca"`
 	Timeout  time.Duration `yaml:"timeout"`
 	Insecure bool          `yaml:"insecure"`
+
+	// Endpoints lists portal replicas in preference order (replaces Address)
+	Endpoints           []PortalEndpointConfig `yaml:"endpoints"`
+	LocalDatacenter     string                 `yaml:"local_datacenter"`      // endpoints of this datacenter are preferred
+	Selection           string                 `yaml:"selection"`             // ordered, weighted
+	HealthCheckInterval time.Duration          `yaml:"health_check_interval"` // endpoint probe interval
+	FailbackPeriod      time.Duration          `yaml:"failback_period"`       // traffic shifts back to a recovered endpoint over this period
+
+	// Outbox persists connect/disconnect reports until the portal
+	// acknowledges them
+	Outbox PortalOutboxConfig `yaml:"outbox"`
+
+	// Retry overrides retry policies per RPC: check_policy, validate_session,
+	// report_connect, report_disconnect, report_session_update
+	Retry map[string]PortalRetryConfig `yaml:"retry"`
+
+	// PolicyEvents subscribes to revocations and policy changes pushed by
+	// the portal, invalidating cached decisions without waiting for the TTL
+	PolicyEvents bool `yaml:"policy_events"`
+}
+
+// PortalRetryConfig defines retries of a single portal RPC.
+// Zero fields keep the built-in default of the method.
+type PortalRetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`    // total attempts including the first one
+	InitialBackoff time.Duration `yaml:"initial_backoff"` // wait after the first failed attempt, doubled per retry
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // upper bound of the wait between attempts
+}
+
+// PortalEndpointConfig defines a portal replica
+type PortalEndpointConfig struct {
+	Address    string `yaml:"address"`
+	Datacenter string `yaml:"datacenter"`
+	Weight     int    `yaml:"weight"` // share within the datacenter tier for weighted selection
+}
+
+// PortalOutboxConfig defines the write-ahead outbox for portal event reports
+type PortalOutboxConfig struct {
+	Path           string        `yaml:"path"`            // write-ahead file (empty = memory only, lost on restart)
+	InitialBackoff time.Duration `yaml:"initial_backoff"` // wait after the first failed delivery, doubled per retry
+	MaxBackoff     time.Duration `yaml:"max_backoff"`     // upper bound of the wait between retries
 }
 
 // HealthConfig defines health check intervals
@@ -166,6 +280,19 @@ type ResilienceConfig struct {
 	CircuitBreaker ResilienceCBConfig    `yaml:"circuit_breaker"`
 	Cache          ResilienceCacheConfig `yaml:"cache"`
 	FailMode       string                `yaml:"fail_mode"` // open, close, stale
+
+	// FailModeRules override FailMode for matching users, groups or client
+	// networks; the first matching rule applies
+	FailModeRules []FailModeRuleConfig `yaml:"fail_mode_rules"`
+}
+
+// FailModeRuleConfig selects the fail mode of matching users, groups or client networks
+type FailModeRuleConfig struct {
+	Name     string   `yaml:"name"`
+	Users    []string `yaml:"users"`    // username patterns, e.g. "oncall-*"
+	Groups   []string `yaml:"groups"`   // group name patterns
+	Networks []string `yaml:"networks"` // client IP CIDRs, e.g. "198.51.100.0/24"
+	Mode     string   `yaml:"mode"`     // open, close, stale
 }
 
 // ResilienceCBConfig defines circuit breaker resilience settings
@@ -181,6 +308,131 @@ type ResilienceCacheConfig struct {
 	TTL      time.Duration `yaml:"ttl"`
 	StaleTTL time.Duration `yaml:"stale_ttl"`
 	MaxSize  int           `yaml:"max_size"`
+
+	// SnapshotFile persists cached decisions so fail_mode stale keeps
+	// working after a restart during a portal outage (empty disables it)
+	SnapshotFile     string        `yaml:"snapshot_file"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"` // periodic snapshot, also written on shutdown
+	SnapshotKeyFile  string        `yaml:"snapshot_key_file"` // hex AES-256 key; encrypts the snapshot when set
+
+	// RefreshAhead refreshes hot decisions in the background this long
+	// before they expire (0 disables it); RefreshMinHits is the number of
+	// cache hits since the decision was cached that makes it hot
+	RefreshAhead   time.Duration `yaml:"refresh_ahead"`
+	RefreshMinHits int           `yaml:"refresh_min_hits"`
+}
+
+// ScheduleConfig defines time-of-day access windows enforced by the agent
+type ScheduleConfig struct {
+	Enabled      bool                 `yaml:"enabled"`
+	GraceWarning time.Duration        `yaml:"grace_warning"` // warn sessions this long before their window closes
+	Rules        []ScheduleRuleConfig `yaml:"rules"`
+}
+
+// ScheduleRuleConfig defines access windows for matching users or groups
+type ScheduleRuleConfig struct {
+	Name    string   `yaml:"name"`
+	Users   []string `yaml:"users"`   // username patterns, e.g. "contractor-*"
+	Groups  []string `yaml:"groups"`  // group name patterns
+	Windows []string `yaml:"windows"` // e.g. "weekdays 08:00-20:00 Europe/Berlin"
+}
+
+// LocalPolicyConfig defines the local access policy evaluated before the
+// portal and used instead of it while the portal is unreachable
+type LocalPolicyConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	File           string        `yaml:"file"`            // rules file, reloaded when changed
+	CountryFile    string        `yaml:"country_file"`    // "<CIDR> <country code>" per line; required by country rules
+	ReloadInterval time.Duration `yaml:"reload_interval"` // how often the files are checked for changes
+}
+
+// AdmissionConfig defines expression-based admission rules evaluated
+// before the portal
+type AdmissionConfig struct {
+	Enabled     bool                  `yaml:"enabled"`
+	CountryFile string                `yaml:"country_file"` // "<CIDR> <country code>" per line; enables the country variable
+	Rules       []AdmissionRuleConfig `yaml:"rules"`
+}
+
+// AdmissionRuleConfig defines an admission rule
+type AdmissionRuleConfig struct {
+	Name   string `yaml:"name"`
+	Expr   string `yaml:"expr"`   // CEL expression, e.g. group == "contractors" && sessions(user) >= 1
+	Action string `yaml:"action"` // deny, allow (skip the remaining rules)
+	Reason string `yaml:"reason"` // deny reason reported to the client
+}
+
+// SessionsConfig defines session activity tracking and status reporting
+type SessionsConfig struct {
+	UpdateInterval time.Duration `yaml:"update_interval"` // how often each session's status is reported to the portal
+	ActivityWindow time.Duration `yaml:"activity_window"` // period traffic deltas are measured over
+	IdleThreshold  uint64        `yaml:"idle_threshold"`  // bytes within the window at or below which a session is idle
+
+	// EventStream tracks sessions in real time via 'occtl show events';
+	// listing sessions via occtl then only runs every ReconcileInterval as a
+	// fallback. Activity, schedule and status update checks keep running at
+	// health.metrics_interval
+	EventStream       bool          `yaml:"event_stream"`
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+
+	// Revalidate periodically checks every active session with the portal
+	// (ValidateSession) and disconnects sessions it revoked
+	Revalidate            bool          `yaml:"revalidate"`
+	RevalidateInterval    time.Duration `yaml:"revalidate_interval"`    // how often every session is revalidated
+	RevalidateJitter      time.Duration `yaml:"revalidate_jitter"`      // maximum random delay added to each cycle
+	RevalidateConcurrency int           `yaml:"revalidate_concurrency"` // concurrent ValidateSession calls
+
+	// StateFile persists tracked sessions so restarts neither re-report
+	// existing sessions nor miss disconnects (empty disables persistence)
+	StateFile string `yaml:"state_file"`
+}
+
+// StorageConfig defines the session store backend
+type StorageConfig struct {
+	Backend    string        `yaml:"backend"`     // "memory" or "bolt"
+	Path       string        `yaml:"path"`        // database file for on-disk backends
+	SessionTTL time.Duration `yaml:"session_ttl"` // sessions expire after this long without updates
+}
+
+// AccountingConfig defines the closed-session ledger
+type AccountingConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Dir       string        `yaml:"dir"`       // directory with per-day ledger files
+	Retention time.Duration `yaml:"retention"` // records older than this are pruned (0 = keep forever)
+}
+
+// RadiusConfig defines the RADIUS accounting exporter
+type RadiusConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	Servers         []string      `yaml:"servers"`          // host[:port], tried in order (default port 1813)
+	Secret          string        `yaml:"secret"`           // shared secret (env: RADIUS_SECRET)
+	Timeout         time.Duration `yaml:"timeout"`          // wait for a response per transmission
+	Retries         *int          `yaml:"retries"`          // retransmissions per server before failover (unset = 2, 0 disables)
+	InterimInterval time.Duration `yaml:"interim_interval"` // minimum time between Interim-Update records
+	NASIdentifier   string        `yaml:"nas_identifier"`   // defaults to the hostname
+	NASIPAddress    string        `yaml:"nas_ip_address"`
+	QueueSize       int           `yaml:"queue_size"` // records waiting to be sent
+}
+
+// WebhooksConfig defines outbound webhooks for session and admin events
+type WebhooksConfig struct {
+	Enabled        bool                `yaml:"enabled"`
+	Sinks          []WebhookSinkConfig `yaml:"sinks"`
+	MaxAttempts    int                 `yaml:"max_attempts"`     // delivery attempts per event and sink
+	InitialBackoff time.Duration       `yaml:"initial_backoff"`  // wait after the first failure, doubled per retry
+	MaxBackoff     time.Duration       `yaml:"max_backoff"`      // upper bound of the wait between retries
+	Timeout        time.Duration       `yaml:"timeout"`          // per HTTP request
+	DeadLetterFile string              `yaml:"dead_letter_file"` // undeliverable events (JSON lines)
+	QueueSize      int                 `yaml:"queue_size"`       // events waiting per sink
+}
+
+// WebhookSinkConfig defines a single webhook receiver
+type WebhookSinkConfig struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Secret  string            `yaml:"secret"`  // HMAC-SHA256 signing key
+	Events  []string          `yaml:"events"`  // event type patterns, e.g. "session.*" (empty = all)
+	Headers map[string]string `yaml:"headers"` // extra request headers
 }
 
 // Load reads configuration from a YAML file and applies environment variable overrides
@@ -255,6 +507,7 @@ func applyEnvOverrides(cfg *Config) {
 	}
 	if v := os.Getenv("PORTAL_ADDRESS"); v != "" {
 		cfg.Portal.Address = v
+		cfg.Portal.Endpoints = nil // a single overridden endpoint replaces the list
 	}
 	if v := os.Getenv("PORTAL_TLS_CERT"); v != "" {
 		cfg.Portal.TLSCert = v
@@ -265,6 +518,9 @@ func applyEnvOverrides(cfg *Config) {
 	if v := os.Getenv("PORTAL_TLS_CA"); v != "" {
 		cfg.Portal.TLSCA = v
 	}
+	if v := os.Getenv("RADIUS_SECRET"); v != "" {
+		cfg.Radius.Secret = v
+	}
 	if v := os.Getenv("PORTAL_INSECURE"); v == "true" {
 		cfg.Portal.Insecure = true
 	}
@@ -354,10 +610,31 @@ func setDefaults(cfg *Config) {
 	if cfg.IPC.Timeout == 0 {
 		cfg.IPC.Timeout = 5 * time.Second
 	}
+	if cfg.IPC.SocketMode == "" {
+		cfg.IPC.SocketMode = "0660"
+	}
 
 	if cfg.Portal.Timeout == 0 {
 		cfg.Portal.Timeout = 10 * time.Second
 	}
+	if cfg.Portal.Selection == "" {
+		cfg.Portal.Selection = "ordered"
+	}
+	if cfg.Portal.HealthCheckInterval == 0 {
+		cfg.Portal.HealthCheckInterval = 10 * time.Second
+	}
+	if cfg.Portal.FailbackPeriod == 0 {
+		cfg.Portal.FailbackPeriod = time.Minute
+	}
+	if cfg.Portal.Outbox.Path == "" {
+		cfg.Portal.Outbox.Path = "/var/lib/ocserv-agent/portal-outbox.db"
+	}
+	if cfg.Portal.Outbox.InitialBackoff == 0 {
+		cfg.Portal.Outbox.InitialBackoff = time.Second
+	}
+	if cfg.Portal.Outbox.MaxBackoff == 0 {
+		cfg.Portal.Outbox.MaxBackoff = 5 * time.Minute
+	}
 
 	// Resilience defaults
 	if cfg.Resilience.CircuitBreaker.MaxRequests == 0 {
@@ -381,9 +658,86 @@ func setDefaults(cfg *Config) {
 	if cfg.Resilience.Cache.MaxSize == 0 {
 		cfg.Resilience.Cache.MaxSize = 10000
 	}
+	if cfg.Resilience.Cache.RefreshMinHits == 0 {
+		cfg.Resilience.Cache.RefreshMinHits = 2
+	}
+	if cfg.Resilience.Cache.SnapshotInterval == 0 {
+		cfg.Resilience.Cache.SnapshotInterval = time.Minute
+	}
 	if cfg.Resilience.FailMode == "" {
 		cfg.Resilience.FailMode = "stale"
 	}
+
+	if cfg.Schedule.GraceWarning == 0 {
+		cfg.Schedule.GraceWarning = 5 * time.Minute
+	}
+
+	if cfg.LocalPolicy.ReloadInterval == 0 {
+		cfg.LocalPolicy.ReloadInterval = 30 * time.Second
+	}
+
+	if cfg.Sessions.UpdateInterval == 0 {
+		cfg.Sessions.UpdateInterval = time.Minute
+	}
+	if cfg.Sessions.ActivityWindow == 0 {
+		cfg.Sessions.ActivityWindow = 5 * time.Minute
+	}
+	if cfg.Sessions.ReconcileInterval == 0 {
+		cfg.Sessions.ReconcileInterval = 5 * time.Minute
+	}
+	if cfg.Sessions.RevalidateInterval == 0 {
+		cfg.Sessions.RevalidateInterval = 5 * time.Minute
+	}
+	if cfg.Sessions.RevalidateJitter == 0 {
+		cfg.Sessions.RevalidateJitter = 30 * time.Second
+	}
+	if cfg.Sessions.RevalidateConcurrency == 0 {
+		cfg.Sessions.RevalidateConcurrency = 4
+	}
+
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = "memory"
+	}
+	if cfg.Storage.SessionTTL == 0 {
+		cfg.Storage.SessionTTL = 24 * time.Hour
+	}
+
+	if cfg.Accounting.Dir == "" {
+		cfg.Accounting.Dir = "/var/lib/ocserv-agent/accounting"
+	}
+
+	if cfg.Radius.Timeout == 0 {
+		cfg.Radius.Timeout = 3 * time.Second
+	}
+	if cfg.Radius.Retries == nil {
+		retries := 2
+		cfg.Radius.Retries = &retries
+	}
+	if cfg.Radius.InterimInterval == 0 {
+		cfg.Radius.InterimInterval = 5 * time.Minute
+	}
+	if cfg.Radius.QueueSize == 0 {
+		cfg.Radius.QueueSize = 1000
+	}
+
+	if cfg.Webhooks.MaxAttempts == 0 {
+		cfg.Webhooks.MaxAttempts = 5
+	}
+	if cfg.Webhooks.InitialBackoff == 0 {
+		cfg.Webhooks.InitialBackoff = time.Second
+	}
+	if cfg.Webhooks.MaxBackoff == 0 {
+		cfg.Webhooks.MaxBackoff = time.Minute
+	}
+	if cfg.Webhooks.Timeout == 0 {
+		cfg.Webhooks.Timeout = 10 * time.Second
+	}
+	if cfg.Webhooks.DeadLetterFile == "" {
+		cfg.Webhooks.DeadLetterFile = "/var/lib/ocserv-agent/webhooks-dead-letter.jsonl"
+	}
+	if cfg.Webhooks.QueueSize == 0 {
+		cfg.Webhooks.QueueSize = 1000
+	}
 }
 
 // bootstrapCertificates generates self-signed certificates if auto_generate is enabled
@@ -428,3 +782,46 @@ func bootstrapCertificates(cfg *Config) error {
 
 	return nil
 }
+
+// RuleSpecs converts schedule rules to evaluator specifications
+func (s *ScheduleConfig) RuleSpecs() []schedule.RuleSpec {
+	specs := make([]schedule.RuleSpec, 0, len(s.Rules))
+	for _, r := range s.Rules {
+		specs = append(specs, schedule.RuleSpec{
+			Name:    r.Name,
+			Users:   r.Users,
+			Groups:  r.Groups,
+			Windows: r.Windows,
+		})
+	}
+	return specs
+}
+
+// RuleSpecs converts admission rules to engine specifications
+func (a *AdmissionConfig) RuleSpecs() []admission.RuleSpec {
+	specs := make([]admission.RuleSpec, 0, len(a.Rules))
+	for _, r := range a.Rules {
+		specs = append(specs, admission.RuleSpec{
+			Name:   r.Name,
+			Expr:   r.Expr,
+			Action: r.Action,
+			Reason: r.Reason,
+		})
+	}
+	return specs
+}
+
+// FailModeRuleSpecs converts fail mode rules to resolver specifications
+func (r *ResilienceConfig) FailModeRuleSpecs() []resilience.FailModeRuleSpec {
+	specs := make([]resilience.FailModeRuleSpec, 0, len(r.FailModeRules))
+	for _, rule := range r.FailModeRules {
+		specs = append(specs, resilience.FailModeRuleSpec{
+			Name:     rule.Name,
+			Users:    rule.Users,
+			Groups:   rule.Groups,
+			Networks: rule.Networks,
+			Mode:     rule.Mode,
+		})
+	}
+	return specs
+}
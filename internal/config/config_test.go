@@ -3,8 +3,11 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // TestLoad tests the Load function with various config files
@@ -133,6 +136,7 @@ func TestSetDefaults(t *testing.T) {
 		{"telemetry sample rate", cfg.Telemetry.SampleRate, 1.0},
 		{"TLS min version", cfg.TLS.MinVersion, "TLS1.3"},
 		{"systemd service", cfg.Ocserv.SystemdService, "ocserv"},
+		{"IPC socket mode", cfg.IPC.SocketMode, "0660"},
 	}
 
 	for _, tt := range tests {
@@ -144,6 +148,67 @@ func TestSetDefaults(t *testing.T) {
 	}
 }
 
+// TestIPCPeers tests the IPC peer allow-list and which names are defaults
+func TestIPCPeers(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want PeerNames
+	}{
+		{
+			name: "defaults are optional",
+			want: PeerNames{OptionalUsers: []string{"root", "ocserv"}, OptionalGroups: []string{"ocserv"}},
+		},
+		{
+			name: "configured run-as user and group required",
+			cfg:  Config{Ocserv: OcservConfig{RunAsUser: "nobody", RunAsGroup: "daemon"}},
+			want: PeerNames{Users: []string{"nobody"}, Groups: []string{"daemon"}, OptionalUsers: []string{"root"}},
+		},
+		{
+			name: "configured list kept",
+			cfg:  Config{Ocserv: OcservConfig{RunAsUser: "nobody"}, IPC: IPCConfig{AllowedUsers: []string{"vpn"}}},
+			want: PeerNames{Users: []string{"vpn"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setDefaults(&tt.cfg)
+			got := tt.cfg.IPCPeers()
+			if !slices.Equal(got.Users, tt.want.Users) || !slices.Equal(got.Groups, tt.want.Groups) ||
+				!slices.Equal(got.OptionalUsers, tt.want.OptionalUsers) || !slices.Equal(got.OptionalGroups, tt.want.OptionalGroups) {
+				t.Errorf("IPCPeers() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRadiusRetries tests that retransmits default to 2 and can be disabled
+func TestRadiusRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want int
+	}{
+		{"unset", "radius:\n  enabled: true\n", 2},
+		{"disabled", "radius:\n  retries: 0\n", 0},
+		{"configured", "radius:\n  retries: 5\n", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg Config
+			if err := yaml.Unmarshal([]byte(tt.yaml), &cfg); err != nil {
+				t.Fatalf("yaml.Unmarshal() error = %v", err)
+			}
+			setDefaults(&cfg)
+			if cfg.Radius.Retries == nil || *cfg.Radius.Retries != tt.want {
+				t.Errorf("Radius.Retries = %v, want %d", cfg.Radius.Retries, tt.want)
+			}
+		})
+	}
+}
+
 // TestSetDefaults_NoOverride tests that defaults don't override existing values
 func TestSetDefaults_NoOverride(t *testing.T) {
 	cfg := &Config{
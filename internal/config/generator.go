@@ -101,7 +101,7 @@ func (g *Generator) GenerateUserConfig(cfg *PerUserConfig) error {
 	}
 
 	// Write new config
-	if err := os.WriteFile(configPath, content, 0644); err != nil {
+	if err := writeFileAtomic(configPath, content); err != nil {
 		return errors.Wrapf(err, "write config to %s", configPath)
 	}
 
@@ -142,7 +142,7 @@ func (g *Generator) GenerateGroupConfig(cfg *PerGroupConfig) error {
 	}
 
 	// Write new config
-	if err := os.WriteFile(configPath, content, 0644); err != nil {
+	if err := writeFileAtomic(configPath, content); err != nil {
 		return errors.Wrapf(err, "write config to %s", configPath)
 	}
 
@@ -214,6 +214,30 @@ func (g *Generator) ListUserConfigs() ([]string, error) {
 	return usernames, nil
 }
 
+// writeFileAtomic replaces path with content through a temporary file, so
+// ocserv never reads a partially written config while a user connects
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temporary file")
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "write temporary file")
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return errors.Wrap(err, "chmod temporary file")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "close temporary file")
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
 // backupConfig creates a backup of the config file if it exists
 func (g *Generator) backupConfig(configPath string) error {
 	// Skip if backup directory is not configured
@@ -3,8 +3,16 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"slices"
 	"strings"
+
+	"github.com/dantte-lp/ocserv-agent/internal/admission"
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
 )
 
 // Validate checks if the configuration is valid
@@ -31,6 +39,11 @@ func Validate(cfg *Config) error {
 		errs = append(errs, fmt.Errorf("ocserv: %w", err))
 	}
 
+	// Validate IPC socket config
+	if err := validateIPC(&cfg.IPC); err != nil {
+		errs = append(errs, fmt.Errorf("ipc: %w", err))
+	}
+
 	// Validate health config
 	if err := validateHealth(&cfg.Health); err != nil {
 		errs = append(errs, fmt.Errorf("health: %w", err))
@@ -51,6 +64,56 @@ func Validate(cfg *Config) error {
 		errs = append(errs, fmt.Errorf("control_server.reconnect: %w", err))
 	}
 
+	// Validate schedule config
+	if err := validateSchedule(&cfg.Schedule); err != nil {
+		errs = append(errs, fmt.Errorf("schedule: %w", err))
+	}
+
+	// Validate local policy config
+	if err := validateLocalPolicy(&cfg.LocalPolicy); err != nil {
+		errs = append(errs, fmt.Errorf("local_policy: %w", err))
+	}
+
+	// Validate admission config
+	if err := validateAdmission(&cfg.Admission); err != nil {
+		errs = append(errs, fmt.Errorf("admission: %w", err))
+	}
+
+	// Validate sessions config
+	if err := validateSessions(&cfg.Sessions); err != nil {
+		errs = append(errs, fmt.Errorf("sessions: %w", err))
+	}
+
+	// Validate storage config
+	if err := validateStorage(&cfg.Storage); err != nil {
+		errs = append(errs, fmt.Errorf("storage: %w", err))
+	}
+
+	// Validate accounting ledger
+	if err := validateAccounting(&cfg.Accounting); err != nil {
+		errs = append(errs, fmt.Errorf("accounting: %w", err))
+	}
+
+	// Validate RADIUS accounting exporter
+	if err := validateRadius(&cfg.Radius); err != nil {
+		errs = append(errs, fmt.Errorf("radius: %w", err))
+	}
+
+	// Validate webhooks
+	if err := validateWebhooks(&cfg.Webhooks); err != nil {
+		errs = append(errs, fmt.Errorf("webhooks: %w", err))
+	}
+
+	// Validate resilience settings
+	if err := validateResilience(&cfg.Resilience); err != nil {
+		errs = append(errs, fmt.Errorf("resilience: %w", err))
+	}
+
+	// Validate portal client
+	if err := validatePortal(&cfg.Portal); err != nil {
+		errs = append(errs, fmt.Errorf("portal: %w", err))
+	}
+
 	// Return combined errors
 	if len(errs) > 0 {
 		return errors.Join(errs...)
@@ -277,3 +340,401 @@ func validateReconnect(reconnect *ReconnectConfig) error {
 
 	return nil
 }
+
+// validateSchedule checks access schedule configuration
+func validateSchedule(sched *ScheduleConfig) error {
+	if !sched.Enabled {
+		return nil
+	}
+
+	var errs []error
+
+	if len(sched.Rules) == 0 {
+		errs = append(errs, errors.New("at least one rule is required when schedule is enabled"))
+	}
+
+	if sched.GraceWarning < 0 {
+		errs = append(errs, errors.New("grace_warning must be >= 0"))
+	}
+
+	if _, err := schedule.NewEvaluator(sched.RuleSpecs()); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateIPC checks IPC socket configuration. User and group names are
+// resolved when the server starts.
+func validateIPC(ipcCfg *IPCConfig) error {
+	var errs []error
+
+	if ipcCfg.SocketMode != "" {
+		if _, err := ipcCfg.FileMode(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if slices.Contains(ipcCfg.AllowedUsers, "") {
+		errs = append(errs, errors.New("allowed_users must not contain empty names"))
+	}
+	if slices.Contains(ipcCfg.AllowedGroups, "") {
+		errs = append(errs, errors.New("allowed_groups must not contain empty names"))
+	}
+	if ipcCfg.AllowAllPeers && (len(ipcCfg.AllowedUsers) > 0 || len(ipcCfg.AllowedGroups) > 0) {
+		errs = append(errs, errors.New("allow_all_peers conflicts with allowed_users and allowed_groups"))
+	}
+
+	if ipcCfg.MaxConcurrent < 0 {
+		errs = append(errs, errors.New("max_concurrent must be >= 0"))
+	}
+	if ipcCfg.MaxQueue < 0 {
+		errs = append(errs, errors.New("max_queue must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateLocalPolicy checks local policy configuration
+func validateLocalPolicy(local *LocalPolicyConfig) error {
+	if !local.Enabled {
+		return nil
+	}
+
+	var errs []error
+
+	if local.File == "" {
+		errs = append(errs, errors.New("file is required when local policy is enabled"))
+	}
+
+	if local.ReloadInterval < 0 {
+		errs = append(errs, errors.New("reload_interval must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateAdmission checks admission rule configuration
+func validateAdmission(adm *AdmissionConfig) error {
+	if !adm.Enabled {
+		return nil
+	}
+
+	var errs []error
+
+	if len(adm.Rules) == 0 {
+		errs = append(errs, errors.New("at least one rule is required when admission is enabled"))
+	}
+
+	// Compiling catches syntax and type errors before the agent starts
+	if err := admission.Validate(adm.RuleSpecs()); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateSessions checks session tracking configuration
+func validateSessions(sessions *SessionsConfig) error {
+	var errs []error
+
+	if sessions.UpdateInterval < 0 {
+		errs = append(errs, errors.New("update_interval must be >= 0"))
+	}
+
+	if sessions.ActivityWindow < 0 {
+		errs = append(errs, errors.New("activity_window must be >= 0"))
+	}
+
+	if sessions.ReconcileInterval < 0 {
+		errs = append(errs, errors.New("reconcile_interval must be >= 0"))
+	}
+
+	if sessions.RevalidateInterval < 0 {
+		errs = append(errs, errors.New("revalidate_interval must be >= 0"))
+	}
+
+	if sessions.RevalidateJitter < 0 {
+		errs = append(errs, errors.New("revalidate_jitter must be >= 0"))
+	}
+
+	if sessions.RevalidateConcurrency < 0 {
+		errs = append(errs, errors.New("revalidate_concurrency must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateResilience checks fail mode and decision cache settings
+func validateResilience(resilienceCfg *ResilienceConfig) error {
+	var errs []error
+
+	failMode := resilienceCfg.FailMode
+	switch failMode {
+	case "open", "close", "stale":
+	case "":
+		failMode = resilience.FailModeStale
+	default:
+		errs = append(errs, fmt.Errorf("fail_mode must be open, close or stale, got %q", failMode))
+		failMode = resilience.FailModeStale
+	}
+
+	if _, err := resilience.NewFailModes(failMode, resilienceCfg.FailModeRuleSpecs()); err != nil {
+		errs = append(errs, fmt.Errorf("fail_mode_rules: %w", err))
+	}
+
+	if resilienceCfg.Cache.SnapshotInterval < 0 {
+		errs = append(errs, errors.New("cache.snapshot_interval must be >= 0"))
+	}
+
+	if resilienceCfg.Cache.SnapshotKeyFile != "" && resilienceCfg.Cache.SnapshotFile == "" {
+		errs = append(errs, errors.New("cache.snapshot_key_file requires cache.snapshot_file"))
+	}
+
+	if resilienceCfg.Cache.RefreshAhead < 0 {
+		errs = append(errs, errors.New("cache.refresh_ahead must be >= 0"))
+	}
+	if resilienceCfg.Cache.TTL > 0 && resilienceCfg.Cache.RefreshAhead >= resilienceCfg.Cache.TTL {
+		errs = append(errs, errors.New("cache.refresh_ahead must be shorter than cache.ttl"))
+	}
+	if resilienceCfg.Cache.RefreshMinHits < 0 {
+		errs = append(errs, errors.New("cache.refresh_min_hits must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateStorage checks session store configuration
+func validateStorage(storage *StorageConfig) error {
+	var errs []error
+
+	switch storage.Backend {
+	case "", "memory":
+	case "bolt":
+		if storage.Path == "" {
+			errs = append(errs, errors.New("path is required for bolt backend"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("invalid backend: %s (must be memory or bolt)", storage.Backend))
+	}
+
+	if storage.SessionTTL < 0 {
+		errs = append(errs, errors.New("session_ttl must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateAccounting checks closed-session ledger configuration
+func validateAccounting(accounting *AccountingConfig) error {
+	var errs []error
+
+	if accounting.Enabled && accounting.Dir == "" {
+		errs = append(errs, errors.New("dir is required when accounting is enabled"))
+	}
+
+	if accounting.Retention < 0 {
+		errs = append(errs, errors.New("retention must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateRadius checks RADIUS accounting exporter configuration
+func validateRadius(radius *RadiusConfig) error {
+	var errs []error
+
+	if radius.Enabled {
+		if len(radius.Servers) == 0 {
+			errs = append(errs, errors.New("at least one server is required when radius is enabled"))
+		}
+		if radius.Secret == "" {
+			errs = append(errs, errors.New("secret is required when radius is enabled"))
+		}
+	}
+
+	for _, server := range radius.Servers {
+		if server == "" {
+			errs = append(errs, errors.New("server address must not be empty"))
+		}
+	}
+
+	if radius.NASIPAddress != "" && net.ParseIP(radius.NASIPAddress).To4() == nil {
+		errs = append(errs, fmt.Errorf("invalid nas_ip_address: %s (must be IPv4)", radius.NASIPAddress))
+	}
+
+	if radius.Timeout < 0 {
+		errs = append(errs, errors.New("timeout must be >= 0"))
+	}
+	if radius.Retries != nil && *radius.Retries < 0 {
+		errs = append(errs, errors.New("retries must be >= 0"))
+	}
+	if radius.InterimInterval < 0 {
+		errs = append(errs, errors.New("interim_interval must be >= 0"))
+	}
+	if radius.QueueSize < 0 {
+		errs = append(errs, errors.New("queue_size must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// validateWebhooks checks outbound webhook configuration
+func validateWebhooks(webhooks *WebhooksConfig) error {
+	var errs []error
+
+	if webhooks.Enabled && len(webhooks.Sinks) == 0 {
+		errs = append(errs, errors.New("at least one sink is required when webhooks are enabled"))
+	}
+
+	names := make(map[string]bool)
+	for i, sink := range webhooks.Sinks {
+		name := sink.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		} else if names[name] {
+			errs = append(errs, fmt.Errorf("duplicate sink name: %s", name))
+		}
+		names[name] = true
+
+		u, err := url.Parse(sink.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("sink %s: invalid url: %q (must be http or https)", name, sink.URL))
+		}
+		if sink.Secret == "" {
+			errs = append(errs, fmt.Errorf("sink %s: secret is required", name))
+		}
+		for _, pattern := range sink.Events {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("sink %s: invalid event pattern: %q", name, pattern))
+			}
+		}
+	}
+
+	if webhooks.MaxAttempts < 0 {
+		errs = append(errs, errors.New("max_attempts must be >= 0"))
+	}
+	if webhooks.InitialBackoff < 0 {
+		errs = append(errs, errors.New("initial_backoff must be >= 0"))
+	}
+	if webhooks.MaxBackoff < 0 {
+		errs = append(errs, errors.New("max_backoff must be >= 0"))
+	}
+	if webhooks.Timeout < 0 {
+		errs = append(errs, errors.New("timeout must be >= 0"))
+	}
+	if webhooks.QueueSize < 0 {
+		errs = append(errs, errors.New("queue_size must be >= 0"))
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
+
+// portalRetryMethods lists the portal RPCs that accept a retry policy
+var portalRetryMethods = []string{
+	"check_policy",
+	"validate_session",
+	"report_connect",
+	"report_disconnect",
+	"report_session_update",
+}
+
+// validatePortal checks portal client configuration
+func validatePortal(portal *PortalConfig) error {
+	var errs []error
+
+	if portal.Timeout < 0 {
+		errs = append(errs, errors.New("timeout must be >= 0"))
+	}
+	if portal.Address != "" && len(portal.Endpoints) > 0 {
+		errs = append(errs, errors.New("address and endpoints are mutually exclusive"))
+	}
+	seen := make(map[string]bool, len(portal.Endpoints))
+	for i, ep := range portal.Endpoints {
+		if ep.Address == "" {
+			errs = append(errs, fmt.Errorf("endpoints[%d]: address is required", i))
+		} else if seen[ep.Address] {
+			errs = append(errs, fmt.Errorf("endpoints[%d]: duplicate address %q", i, ep.Address))
+		}
+		seen[ep.Address] = true
+		if ep.Weight < 0 {
+			errs = append(errs, fmt.Errorf("endpoints[%d]: weight must be >= 0", i))
+		}
+	}
+	if portal.Selection != "" && portal.Selection != "ordered" && portal.Selection != "weighted" {
+		errs = append(errs, fmt.Errorf("selection must be ordered or weighted, got %q", portal.Selection))
+	}
+	if portal.HealthCheckInterval < 0 {
+		errs = append(errs, errors.New("health_check_interval must be >= 0"))
+	}
+	if portal.FailbackPeriod < 0 {
+		errs = append(errs, errors.New("failback_period must be >= 0"))
+	}
+	if portal.Outbox.InitialBackoff < 0 {
+		errs = append(errs, errors.New("outbox.initial_backoff must be >= 0"))
+	}
+	if portal.Outbox.MaxBackoff < 0 {
+		errs = append(errs, errors.New("outbox.max_backoff must be >= 0"))
+	}
+
+	for method, retry := range portal.Retry {
+		if !slices.Contains(portalRetryMethods, method) {
+			errs = append(errs, fmt.Errorf("retry: unknown method %q (valid: %s)", method, strings.Join(portalRetryMethods, ", ")))
+		}
+		if retry.MaxAttempts < 0 {
+			errs = append(errs, fmt.Errorf("retry.%s.max_attempts must be >= 0", method))
+		}
+		if retry.InitialBackoff < 0 {
+			errs = append(errs, fmt.Errorf("retry.%s.initial_backoff must be >= 0", method))
+		}
+		if retry.MaxBackoff < 0 {
+			errs = append(errs, fmt.Errorf("retry.%s.max_backoff must be >= 0", method))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return nil
+}
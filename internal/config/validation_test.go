@@ -577,3 +577,513 @@ func TestValidateReconnect(t *testing.T) {
 		})
 	}
 }
+
+// TestValidateWebhooks tests webhook sink validation
+func TestValidateWebhooks(t *testing.T) {
+	tests := []struct {
+		name     string
+		webhooks *WebhooksConfig
+		wantErr  bool
+		errMsg   string
+	}{
+		{
+			name: "valid config",
+			webhooks: &WebhooksConfig{
+				Enabled: true,
+				Sinks: []WebhookSinkConfig{
+					{Name: "siem", URL: "https://siem.example.com/hook", Secret: "s", Events: []string{"session.*"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:     "enabled without sinks",
+			webhooks: &WebhooksConfig{Enabled: true},
+			wantErr:  true,
+			errMsg:   "at least one sink is required",
+		},
+		{
+			name: "invalid url",
+			webhooks: &WebhooksConfig{
+				Sinks: []WebhookSinkConfig{{Name: "siem", URL: "ftp://siem", Secret: "s"}},
+			},
+			wantErr: true,
+			errMsg:  "invalid url",
+		},
+		{
+			name: "missing secret",
+			webhooks: &WebhooksConfig{
+				Sinks: []WebhookSinkConfig{{Name: "siem", URL: "https://siem"}},
+			},
+			wantErr: true,
+			errMsg:  "secret is required",
+		},
+		{
+			name: "duplicate names",
+			webhooks: &WebhooksConfig{
+				Sinks: []WebhookSinkConfig{
+					{Name: "siem", URL: "https://a", Secret: "s"},
+					{Name: "siem", URL: "https://b", Secret: "s"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "duplicate sink name",
+		},
+		{
+			name: "bad event pattern",
+			webhooks: &WebhooksConfig{
+				Sinks: []WebhookSinkConfig{{Name: "siem", URL: "https://siem", Secret: "s", Events: []string{"session.["}}},
+			},
+			wantErr: true,
+			errMsg:  "invalid event pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateWebhooks(tt.webhooks)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateWebhooks() expected error, got nil")
+					return
+				}
+				if tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateWebhooks() error = %v, want error containing %q", err, tt.errMsg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("validateWebhooks() unexpected error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePortal(t *testing.T) {
+	tests := []struct {
+		name    string
+		portal  *PortalConfig
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name: "valid retry overrides",
+			portal: &PortalConfig{
+				Timeout: 5 * time.Second,
+				Retry: map[string]PortalRetryConfig{
+					"check_policy":          {MaxAttempts: 2, InitialBackoff: 100 * time.Millisecond},
+					"report_session_update": {MaxAttempts: 1},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid endpoints",
+			portal: &PortalConfig{
+				Endpoints: []PortalEndpointConfig{
+					{Address: "portal-fra:9092", Datacenter: "fra", Weight: 2},
+					{Address: "portal-ams:9092", Datacenter: "ams"},
+				},
+				LocalDatacenter: "fra",
+				Selection:       "weighted",
+			},
+			wantErr: false,
+		},
+		{
+			name: "address with endpoints",
+			portal: &PortalConfig{
+				Address:   "portal:9092",
+				Endpoints: []PortalEndpointConfig{{Address: "portal-fra:9092"}},
+			},
+			wantErr: true,
+			errMsg:  "mutually exclusive",
+		},
+		{
+			name: "duplicate endpoint",
+			portal: &PortalConfig{
+				Endpoints: []PortalEndpointConfig{{Address: "portal:9092"}, {Address: "portal:9092"}},
+			},
+			wantErr: true,
+			errMsg:  "duplicate address",
+		},
+		{
+			name:    "unknown selection",
+			portal:  &PortalConfig{Address: "portal:9092", Selection: "random"},
+			wantErr: true,
+			errMsg:  "selection must be ordered or weighted",
+		},
+		{
+			name:    "negative timeout",
+			portal:  &PortalConfig{Timeout: -time.Second},
+			wantErr: true,
+			errMsg:  "timeout must be >= 0",
+		},
+		{
+			name: "unknown retry method",
+			portal: &PortalConfig{
+				Retry: map[string]PortalRetryConfig{"check": {MaxAttempts: 2}},
+			},
+			wantErr: true,
+			errMsg:  `unknown method "check"`,
+		},
+		{
+			name: "negative retry backoff",
+			portal: &PortalConfig{
+				Retry: map[string]PortalRetryConfig{"validate_session": {MaxBackoff: -time.Second}},
+			},
+			wantErr: true,
+			errMsg:  "retry.validate_session.max_backoff must be >= 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePortal(tt.portal)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validatePortal() expected error, got nil")
+					return
+				}
+				if tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
+					t.Errorf("validatePortal() error = %v, want error containing %q", err, tt.errMsg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("validatePortal() unexpected error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateResilience(t *testing.T) {
+	tests := []struct {
+		name       string
+		resilience *ResilienceConfig
+		wantErr    bool
+		errMsg     string
+	}{
+		{
+			name: "valid encrypted snapshot",
+			resilience: &ResilienceConfig{
+				FailMode: "stale",
+				Cache: ResilienceCacheConfig{
+					SnapshotFile:     "/var/lib/ocserv-agent/decisions.bin",
+					SnapshotInterval: time.Minute,
+					SnapshotKeyFile:  "/etc/ocserv-agent/snapshot.key",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:       "unknown fail mode",
+			resilience: &ResilienceConfig{FailMode: "retry"},
+			wantErr:    true,
+			errMsg:     "fail_mode must be open, close or stale",
+		},
+		{
+			name: "negative snapshot interval",
+			resilience: &ResilienceConfig{
+				Cache: ResilienceCacheConfig{SnapshotFile: "/tmp/decisions.json", SnapshotInterval: -time.Second},
+			},
+			wantErr: true,
+			errMsg:  "cache.snapshot_interval must be >= 0",
+		},
+		{
+			name: "valid refresh ahead",
+			resilience: &ResilienceConfig{
+				FailMode: "stale",
+				Cache:    ResilienceCacheConfig{TTL: 5 * time.Minute, RefreshAhead: 30 * time.Second, RefreshMinHits: 2},
+			},
+			wantErr: false,
+		},
+		{
+			name: "refresh ahead not shorter than ttl",
+			resilience: &ResilienceConfig{
+				Cache: ResilienceCacheConfig{TTL: time.Minute, RefreshAhead: time.Minute},
+			},
+			wantErr: true,
+			errMsg:  "cache.refresh_ahead must be shorter than cache.ttl",
+		},
+		{
+			name: "negative refresh min hits",
+			resilience: &ResilienceConfig{
+				Cache: ResilienceCacheConfig{RefreshMinHits: -1},
+			},
+			wantErr: true,
+			errMsg:  "cache.refresh_min_hits must be >= 0",
+		},
+		{
+			name: "key without snapshot file",
+			resilience: &ResilienceConfig{
+				Cache: ResilienceCacheConfig{SnapshotKeyFile: "/etc/ocserv-agent/snapshot.key"},
+			},
+			wantErr: true,
+			errMsg:  "requires cache.snapshot_file",
+		},
+		{
+			name: "valid fail mode rules",
+			resilience: &ResilienceConfig{
+				FailMode: "stale",
+				FailModeRules: []FailModeRuleConfig{
+					{Name: "admins", Groups: []string{"admins"}, Users: []string{"oncall-*"}, Mode: "open"},
+					{Name: "contractors", Groups: []string{"contractors"}, Networks: []string{"198.51.100.0/24"}, Mode: "close"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "fail mode rule with unknown mode",
+			resilience: &ResilienceConfig{
+				FailModeRules: []FailModeRuleConfig{{Name: "admins", Groups: []string{"admins"}, Mode: "allow"}},
+			},
+			wantErr: true,
+			errMsg:  "mode must be open, close or stale",
+		},
+		{
+			name: "fail mode rule with invalid network",
+			resilience: &ResilienceConfig{
+				FailModeRules: []FailModeRuleConfig{{Name: "office", Networks: []string{"198.51.100.0"}, Mode: "open"}},
+			},
+			wantErr: true,
+			errMsg:  "rule office: invalid network",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResilience(tt.resilience)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateResilience() expected error, got nil")
+					return
+				}
+				if tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateResilience() error = %v, want error containing %q", err, tt.errMsg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("validateResilience() unexpected error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateLocalPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   *LocalPolicyConfig
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "disabled",
+			local:   &LocalPolicyConfig{},
+			wantErr: false,
+		},
+		{
+			name: "valid",
+			local: &LocalPolicyConfig{
+				Enabled:        true,
+				File:           "/etc/ocserv-agent/policy.yaml",
+				CountryFile:    "/etc/ocserv-agent/countries.txt",
+				ReloadInterval: 30 * time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "missing file",
+			local:   &LocalPolicyConfig{Enabled: true},
+			wantErr: true,
+			errMsg:  "file is required",
+		},
+		{
+			name:    "negative reload interval",
+			local:   &LocalPolicyConfig{Enabled: true, File: "/etc/ocserv-agent/policy.yaml", ReloadInterval: -time.Second},
+			wantErr: true,
+			errMsg:  "reload_interval must be >= 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLocalPolicy(tt.local)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateLocalPolicy() expected error, got nil")
+					return
+				}
+				if tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateLocalPolicy() error = %v, want error containing %q", err, tt.errMsg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("validateLocalPolicy() unexpected error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAdmission(t *testing.T) {
+	tests := []struct {
+		name      string
+		admission *AdmissionConfig
+		wantErr   bool
+		errMsg    string
+	}{
+		{
+			name:      "disabled",
+			admission: &AdmissionConfig{Rules: []AdmissionRuleConfig{{Name: "broken"}}},
+			wantErr:   false,
+		},
+		{
+			name: "valid",
+			admission: &AdmissionConfig{
+				Enabled: true,
+				Rules: []AdmissionRuleConfig{
+					{Name: "single-session", Expr: `group == "contractors" && sessions(user) >= 1`, Action: "deny"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name:      "no rules",
+			admission: &AdmissionConfig{Enabled: true},
+			wantErr:   true,
+			errMsg:    "at least one rule is required",
+		},
+		{
+			name: "invalid expression",
+			admission: &AdmissionConfig{
+				Enabled: true,
+				Rules:   []AdmissionRuleConfig{{Name: "typo", Expr: `grop == "contractors"`, Action: "deny"}},
+			},
+			wantErr: true,
+			errMsg:  "rule typo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAdmission(tt.admission)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateAdmission() expected error, got nil")
+					return
+				}
+				if tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateAdmission() error = %v, want error containing %q", err, tt.errMsg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("validateAdmission() unexpected error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateIPC(t *testing.T) {
+	tests := []struct {
+		name    string
+		ipc     *IPCConfig
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name:    "defaults",
+			ipc:     &IPCConfig{SocketPath: "/var/run/ocserv-agent.sock", SocketMode: "0660", AllowedUsers: []string{"root", "ocserv"}, AllowedGroups: []string{"ocserv"}},
+			wantErr: false,
+		},
+		{
+			name:    "allow all peers",
+			ipc:     &IPCConfig{SocketPath: "/var/run/ocserv-agent.sock", SocketMode: "0666", AllowAllPeers: true},
+			wantErr: false,
+		},
+		{
+			name:    "allow all peers with allowed users",
+			ipc:     &IPCConfig{SocketMode: "0666", AllowAllPeers: true, AllowedUsers: []string{"root"}},
+			wantErr: true,
+			errMsg:  "allow_all_peers conflicts with allowed_users and allowed_groups",
+		},
+		{
+			name: "restricted",
+			ipc: &IPCConfig{
+				SocketPath:    "/var/run/ocserv-agent.sock",
+				SocketMode:    "0660",
+				SocketGroup:   "ocserv",
+				AllowedUsers:  []string{"root", "ocserv"},
+				AllowedGroups: []string{"1000"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "invalid mode",
+			ipc:     &IPCConfig{SocketMode: "rw-rw----"},
+			wantErr: true,
+			errMsg:  "invalid socket_mode",
+		},
+		{
+			name:    "mode with special bits",
+			ipc:     &IPCConfig{SocketMode: "4777"},
+			wantErr: true,
+			errMsg:  "bits outside 0777",
+		},
+		{
+			name:    "empty user",
+			ipc:     &IPCConfig{SocketMode: "0660", AllowedUsers: []string{"root", ""}},
+			wantErr: true,
+			errMsg:  "allowed_users must not contain empty names",
+		},
+		{
+			name:    "empty group",
+			ipc:     &IPCConfig{SocketMode: "0660", AllowedGroups: []string{""}},
+			wantErr: true,
+			errMsg:  "allowed_groups must not contain empty names",
+		},
+		{
+			name:    "admission control",
+			ipc:     &IPCConfig{SocketMode: "0660", MaxConcurrent: 64, MaxQueue: 512},
+			wantErr: false,
+		},
+		{
+			name:    "negative max concurrent",
+			ipc:     &IPCConfig{SocketMode: "0660", MaxConcurrent: -1},
+			wantErr: true,
+			errMsg:  "max_concurrent must be >= 0",
+		},
+		{
+			name:    "negative max queue",
+			ipc:     &IPCConfig{SocketMode: "0660", MaxConcurrent: 64, MaxQueue: -1},
+			wantErr: true,
+			errMsg:  "max_queue must be >= 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateIPC(tt.ipc)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validateIPC() expected error, got nil")
+					return
+				}
+				if tt.errMsg != "" && !contains(err.Error(), tt.errMsg) {
+					t.Errorf("validateIPC() error = %v, want error containing %q", err, tt.errMsg)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("validateIPC() unexpected error = %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/accounting"
+	"github.com/dantte-lp/ocserv-agent/internal/storage"
+	pb "github.com/dantte-lp/ocserv-agent/pkg/proto/agent/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// exportChunkSize - максимальный размер одного ExportChunk
+const exportChunkSize = 64 * 1024
+
+// HistoryService предоставляет доступ к журналу завершенных сессий
+type HistoryService struct {
+	pb.UnimplementedSessionHistoryServiceServer
+
+	ledger *accounting.Ledger
+	leases *accounting.LeaseIndex
+	logger *slog.Logger
+}
+
+// NewHistoryService creates a new session history service
+func NewHistoryService(ledger *accounting.Ledger, leases *accounting.LeaseIndex, logger *slog.Logger) *HistoryService {
+	return &HistoryService{
+		ledger: ledger,
+		leases: leases,
+		logger: logger,
+	}
+}
+
+// QuerySessionHistory возвращает страницу записей журнала
+func (s *HistoryService) QuerySessionHistory(ctx context.Context, req *pb.QuerySessionHistoryRequest) (*pb.QuerySessionHistoryResponse, error) {
+	records, next, err := s.ledger.Query(ctx, filterFromProto(req.GetFilter()), int(req.GetPageSize()), req.GetPageToken())
+	if err != nil {
+		if req.GetPageToken() != "" {
+			return nil, status.Errorf(codes.InvalidArgument, "query session history: %v", err)
+		}
+		return nil, status.Errorf(codes.Internal, "query session history: %v", err)
+	}
+
+	resp := &pb.QuerySessionHistoryResponse{
+		Records:       make([]*pb.SessionRecord, 0, len(records)),
+		NextPageToken: next,
+	}
+	for _, rec := range records {
+		resp.Records = append(resp.Records, recordToProto(rec))
+	}
+
+	return resp, nil
+}
+
+// ExportSessionHistory выгружает записи журнала в CSV или JSONL
+func (s *HistoryService) ExportSessionHistory(req *pb.ExportSessionHistoryRequest, stream pb.SessionHistoryService_ExportSessionHistoryServer) error {
+	format, err := accounting.ParseFormat(req.GetFormat())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	w := &chunkWriter{stream: stream}
+	count, err := s.ledger.Export(stream.Context(), w, format, filterFromProto(req.GetFilter()))
+	if err == nil {
+		err = w.flush()
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "export session history: %v", err)
+	}
+
+	if s.logger != nil {
+		s.logger.InfoContext(stream.Context(), "Session history exported",
+			slog.String("format", string(format)),
+			slog.Int("records", count),
+		)
+	}
+
+	return nil
+}
+
+// LookupIPLease отвечает, кому был выдан VPN IP в момент или интервал времени
+func (s *HistoryService) LookupIPLease(ctx context.Context, req *pb.LookupIPLeaseRequest) (*pb.LookupIPLeaseResponse, error) {
+	if s.leases == nil {
+		return nil, status.Error(codes.Unavailable, "lease index is not enabled")
+	}
+
+	var from, to time.Time
+	switch {
+	case req.GetAt() != nil:
+		from = req.GetAt().AsTime()
+		to = from
+	default:
+		if req.GetFrom() != nil {
+			from = req.GetFrom().AsTime()
+		}
+		if req.GetTo() != nil {
+			to = req.GetTo().AsTime()
+		}
+	}
+
+	leases, err := s.leases.Lookup(ctx, req.GetIp(), from, to)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "lookup ip lease: %v", err)
+	}
+
+	resp := &pb.LookupIPLeaseResponse{
+		Leases: make([]*pb.IPLease, 0, len(leases)),
+	}
+	for i := range leases {
+		resp.Leases = append(resp.Leases, leaseToProto(&leases[i]))
+	}
+
+	return resp, nil
+}
+
+// chunkWriter буферизует данные выгрузки и отправляет их ExportChunk'ами
+type chunkWriter struct {
+	stream pb.SessionHistoryService_ExportSessionHistoryServer
+	buf    []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= exportChunkSize {
+		if err := w.stream.Send(&pb.ExportChunk{Data: w.buf[:exportChunkSize]}); err != nil {
+			return 0, err
+		}
+		w.buf = append([]byte(nil), w.buf[exportChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+// flush отправляет остаток буфера
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.stream.Send(&pb.ExportChunk{Data: w.buf})
+	w.buf = nil
+	return err
+}
+
+// filterFromProto конвертирует proto фильтр в accounting.Filter
+func filterFromProto(f *pb.SessionHistoryFilter) accounting.Filter {
+	if f == nil {
+		return accounting.Filter{}
+	}
+
+	filter := accounting.Filter{
+		Username:  f.GetUsername(),
+		GroupName: f.GetGroupName(),
+		IP:        f.GetIp(),
+	}
+	if f.GetFrom() != nil {
+		filter.From = f.GetFrom().AsTime()
+	}
+	if f.GetTo() != nil {
+		filter.To = f.GetTo().AsTime()
+	}
+
+	return filter
+}
+
+// recordToProto конвертирует запись журнала в proto формат
+func recordToProto(rec *accounting.Record) *pb.SessionRecord {
+	return &pb.SessionRecord{
+		SessionId:        rec.SessionID,
+		Username:         rec.Username,
+		GroupName:        rec.GroupName,
+		ClientIp:         rec.ClientIP,
+		VpnIpv4:          rec.VPNIPv4,
+		VpnIpv6:          rec.VPNIPv6,
+		UserAgent:        rec.UserAgent,
+		StartedAt:        timestamppb.New(rec.StartedAt),
+		EndedAt:          timestamppb.New(rec.EndedAt),
+		BytesIn:          rec.BytesIn,
+		BytesOut:         rec.BytesOut,
+		DisconnectReason: rec.DisconnectReason,
+	}
+}
+
+// leaseToProto конвертирует аренду VPN IP в proto формат
+func leaseToProto(lease *accounting.Lease) *pb.IPLease {
+	pbLease := &pb.IPLease{
+		Ip:        lease.IP,
+		SessionId: lease.SessionID,
+		Username:  lease.Username,
+		GroupName: lease.GroupName,
+		ClientIp:  lease.ClientIP,
+		Start:     timestamppb.New(lease.Start),
+		Active:    lease.Active(),
+	}
+	if !lease.Active() {
+		pbLease.End = timestamppb.New(lease.End)
+	}
+	return pbLease
+}
+
+// connectRecord строит запись об активной сессии для индекса аренд VPN IP
+func connectRecord(session *storage.VPNSession) *accounting.Record {
+	return &accounting.Record{
+		SessionID: session.SessionID,
+		Username:  session.Username,
+		GroupName: session.Metadata["group"],
+		ClientIP:  session.ClientIP,
+		VPNIPv4:   session.VpnIP,
+		VPNIPv6:   session.Metadata["vpn_ipv6"],
+		UserAgent: session.Metadata["user_agent"],
+		StartedAt: session.ConnectedAt,
+	}
+}
+
+// disconnectRecord строит запись журнала из уведомления об отключении.
+// session - сессия из SessionStore (nil, если она не найдена)
+func disconnectRecord(req *pb.NotifyDisconnectRequest, session *storage.VPNSession) *accounting.Record {
+	endedAt := time.Now()
+	if req.DisconnectTime != nil {
+		endedAt = req.DisconnectTime.AsTime()
+	}
+
+	rec := &accounting.Record{
+		SessionID:        req.SessionId,
+		Username:         req.Username,
+		StartedAt:        endedAt.Add(-time.Duration(req.DurationSeconds) * time.Second),
+		EndedAt:          endedAt,
+		BytesIn:          req.BytesIn,
+		BytesOut:         req.BytesOut,
+		DisconnectReason: req.DisconnectReason,
+	}
+
+	if session != nil {
+		open := connectRecord(session)
+		rec.ClientIP = open.ClientIP
+		rec.VPNIPv4 = open.VPNIPv4
+		rec.VPNIPv6 = open.VPNIPv6
+		rec.GroupName = open.GroupName
+		rec.UserAgent = open.UserAgent
+		rec.StartedAt = open.StartedAt
+		if rec.Username == "" {
+			rec.Username = open.Username
+		}
+	}
+
+	return rec
+}
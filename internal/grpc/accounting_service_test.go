@@ -0,0 +1,250 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/accounting"
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/dantte-lp/ocserv-agent/internal/storage"
+	pb "github.com/dantte-lp/ocserv-agent/pkg/proto/agent/v1"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// mockExportStream collects ExportChunk messages
+type mockExportStream struct {
+	mockServerStream
+	chunks []*pb.ExportChunk
+}
+
+func (m *mockExportStream) Send(chunk *pb.ExportChunk) error {
+	m.chunks = append(m.chunks, chunk)
+	return nil
+}
+
+func (m *mockExportStream) data() string {
+	var b strings.Builder
+	for _, c := range m.chunks {
+		b.Write(c.Data)
+	}
+	return b.String()
+}
+
+func newTestLedger(t *testing.T) *accounting.Ledger {
+	t.Helper()
+
+	ledger, err := accounting.Open(accounting.Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ledger.Close() })
+
+	return ledger
+}
+
+// TestVPNService_NotifyDisconnectRecordsLedger checks that closed sessions land in the ledger
+func TestVPNService_NotifyDisconnectRecordsLedger(t *testing.T) {
+	ledger := newTestLedger(t)
+	sessionStore := storage.NewSessionStore(time.Hour)
+	defer func() { _ = sessionStore.Close() }()
+
+	server := &Server{
+		config:       &config.Config{},
+		logger:       zerolog.Nop(),
+		sessionStore: sessionStore,
+		ledger:       ledger,
+	}
+	vpnService := NewVPNService(server, nil)
+	ctx := context.Background()
+
+	_, err := vpnService.NotifyConnect(ctx, &pb.NotifyConnectRequest{
+		SessionId: "s1",
+		Username:  "alice",
+		ClientIp:  "203.0.113.1",
+		VpnIp:     "10.10.10.1",
+		Metadata:  map[string]string{"user_agent": "TestClient/1.0", "group": "staff"},
+	})
+	require.NoError(t, err)
+
+	disconnectAt := time.Now().Add(time.Minute)
+	_, err = vpnService.NotifyDisconnect(ctx, &pb.NotifyDisconnectRequest{
+		SessionId:        "s1",
+		Username:         "alice",
+		DisconnectTime:   timestamppb.New(disconnectAt),
+		DisconnectReason: "user_request",
+		BytesIn:          1024,
+		BytesOut:         2048,
+		DurationSeconds:  60,
+	})
+	require.NoError(t, err)
+
+	// Unknown session: start time is derived from the reported duration
+	_, err = vpnService.NotifyDisconnect(ctx, &pb.NotifyDisconnectRequest{
+		SessionId:       "s2",
+		Username:        "bob",
+		DisconnectTime:  timestamppb.New(disconnectAt),
+		DurationSeconds: 30,
+	})
+	require.NoError(t, err)
+
+	records, _, err := ledger.Query(ctx, accounting.Filter{}, 10, "")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	alice := records[0]
+	assert.Equal(t, "s1", alice.SessionID)
+	assert.Equal(t, "203.0.113.1", alice.ClientIP)
+	assert.Equal(t, "10.10.10.1", alice.VPNIPv4)
+	assert.Equal(t, "staff", alice.GroupName)
+	assert.Equal(t, "TestClient/1.0", alice.UserAgent)
+	assert.Equal(t, "user_request", alice.DisconnectReason)
+	assert.Equal(t, uint64(1024), alice.BytesIn)
+	assert.Equal(t, uint64(2048), alice.BytesOut)
+
+	bob := records[1]
+	assert.Equal(t, "bob", bob.Username)
+	assert.True(t, bob.StartedAt.Equal(disconnectAt.Add(-30*time.Second)))
+}
+
+// TestHistoryService_Query tests filtering and pagination over gRPC
+func TestHistoryService_Query(t *testing.T) {
+	ledger := newTestLedger(t)
+	base := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	for i, user := range []string{"alice", "bob", "alice"} {
+		start := base.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, ledger.Append(&accounting.Record{
+			SessionID: string(rune('1' + i)),
+			Username:  user,
+			StartedAt: start,
+			EndedAt:   start.Add(30 * time.Minute),
+		}))
+	}
+
+	service := NewHistoryService(ledger, nil, nil)
+	ctx := context.Background()
+
+	resp, err := service.QuerySessionHistory(ctx, &pb.QuerySessionHistoryRequest{
+		Filter:   &pb.SessionHistoryFilter{Username: "alice"},
+		PageSize: 1,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, "1", resp.Records[0].SessionId)
+	require.NotEmpty(t, resp.NextPageToken)
+
+	resp, err = service.QuerySessionHistory(ctx, &pb.QuerySessionHistoryRequest{
+		Filter:    &pb.SessionHistoryFilter{Username: "alice"},
+		PageSize:  1,
+		PageToken: resp.NextPageToken,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, "3", resp.Records[0].SessionId)
+	assert.Empty(t, resp.NextPageToken)
+
+	resp, err = service.QuerySessionHistory(ctx, &pb.QuerySessionHistoryRequest{
+		Filter: &pb.SessionHistoryFilter{
+			From: timestamppb.New(base.Add(70 * time.Minute)),
+			To:   timestamppb.New(base.Add(80 * time.Minute)),
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Equal(t, "bob", resp.Records[0].Username)
+
+	_, err = service.QuerySessionHistory(ctx, &pb.QuerySessionHistoryRequest{PageToken: "bogus"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// TestHistoryService_Export tests streaming CSV/JSONL export
+func TestHistoryService_Export(t *testing.T) {
+	ledger := newTestLedger(t)
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, ledger.Append(&accounting.Record{
+		SessionID: "1",
+		Username:  "alice",
+		StartedAt: start,
+		EndedAt:   start.Add(time.Hour),
+	}))
+
+	service := NewHistoryService(ledger, nil, nil)
+
+	stream := &mockExportStream{}
+	require.NoError(t, service.ExportSessionHistory(&pb.ExportSessionHistoryRequest{Format: "csv"}, stream))
+	lines := strings.Split(strings.TrimSpace(stream.data()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "session_id,username"))
+	assert.True(t, strings.HasPrefix(lines[1], "1,alice"))
+
+	stream = &mockExportStream{}
+	require.NoError(t, service.ExportSessionHistory(&pb.ExportSessionHistoryRequest{Format: "jsonl"}, stream))
+	assert.Contains(t, stream.data(), `"username":"alice"`)
+
+	err := service.ExportSessionHistory(&pb.ExportSessionHistoryRequest{Format: "xlsx"}, &mockExportStream{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// TestHistoryService_LookupIPLease tests VPN IP attribution via NotifyConnect/NotifyDisconnect
+func TestHistoryService_LookupIPLease(t *testing.T) {
+	ledger := newTestLedger(t)
+	leases, err := accounting.OpenLeaseIndex(ledger, "")
+	require.NoError(t, err)
+	sessionStore := storage.NewSessionStore(time.Hour)
+	defer func() { _ = sessionStore.Close() }()
+
+	server := &Server{
+		config:       &config.Config{},
+		logger:       zerolog.Nop(),
+		sessionStore: sessionStore,
+		ledger:       ledger,
+		leases:       leases,
+	}
+	vpnService := NewVPNService(server, nil)
+	service := NewHistoryService(ledger, leases, nil)
+	ctx := context.Background()
+
+	// alice holds 10.10.10.1 and disconnects, then bob gets the same address
+	_, err = vpnService.NotifyConnect(ctx, &pb.NotifyConnectRequest{SessionId: "s1", Username: "alice", VpnIp: "10.10.10.1"})
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	duringAlice := time.Now()
+	time.Sleep(time.Millisecond)
+	_, err = vpnService.NotifyDisconnect(ctx, &pb.NotifyDisconnectRequest{
+		SessionId:      "s1",
+		Username:       "alice",
+		DisconnectTime: timestamppb.Now(),
+	})
+	require.NoError(t, err)
+
+	_, err = vpnService.NotifyConnect(ctx, &pb.NotifyConnectRequest{SessionId: "s2", Username: "bob", VpnIp: "10.10.10.1"})
+	require.NoError(t, err)
+
+	resp, err := service.LookupIPLease(ctx, &pb.LookupIPLeaseRequest{Ip: "10.10.10.1"})
+	require.NoError(t, err)
+	require.Len(t, resp.Leases, 2)
+	assert.Equal(t, "alice", resp.Leases[0].Username)
+	assert.False(t, resp.Leases[0].Active)
+	assert.NotNil(t, resp.Leases[0].End)
+	assert.Equal(t, "bob", resp.Leases[1].Username)
+	assert.True(t, resp.Leases[1].Active)
+	assert.Nil(t, resp.Leases[1].End)
+
+	resp, err = service.LookupIPLease(ctx, &pb.LookupIPLeaseRequest{
+		Ip: "10.10.10.1",
+		At: timestamppb.New(duringAlice),
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Leases, 1)
+	assert.Equal(t, "alice", resp.Leases[0].Username)
+
+	_, err = service.LookupIPLease(ctx, &pb.LookupIPLeaseRequest{Ip: "bogus"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = NewHistoryService(ledger, nil, nil).LookupIPLease(ctx, &pb.LookupIPLeaseRequest{Ip: "10.10.10.1"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/dantte-lp/ocserv-agent/internal/webhook"
 	pb "github.com/dantte-lp/ocserv-agent/pkg/proto/agent/v1"
 	"github.com/shirou/gopsutil/v3/load"
 	"github.com/shirou/gopsutil/v3/mem"
@@ -315,6 +316,12 @@ func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*p
 	response.ExitCode = int32(result.ExitCode)
 	response.ErrorMessage = result.ErrorMsg
 
+	if result.Success {
+		if eventType, ok := commandEvent(req.CommandType, req.Args); ok {
+			s.publish(ctx, eventType, commandData(req))
+		}
+	}
+
 	return response, nil
 }
 
@@ -470,6 +477,15 @@ func (s *Server) UpdateConfig(ctx context.Context, req *pb.ConfigUpdateRequest)
 	response.Success = true
 	response.ValidationResult = "config applied successfully"
 
+	s.publish(ctx, webhook.EventAdminConfigUpdate, webhook.AdminData{
+		Action: "update_config",
+		Target: req.ConfigName,
+		Details: map[string]string{
+			"request_id":  req.RequestId,
+			"config_type": req.ConfigType.String(),
+		},
+	})
+
 	// Return backup path if backup was created
 	if req.CreateBackup && s.config.Ocserv.BackupDir != "" {
 		response.BackupPath = s.config.Ocserv.BackupDir
@@ -8,11 +8,13 @@ import (
 	"log/slog"
 	"net"
 	"os"
-	"time"
+	"path/filepath"
 
+	"github.com/dantte-lp/ocserv-agent/internal/accounting"
 	"github.com/dantte-lp/ocserv-agent/internal/config"
 	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
 	"github.com/dantte-lp/ocserv-agent/internal/storage"
+	"github.com/dantte-lp/ocserv-agent/internal/webhook"
 	pb "github.com/dantte-lp/ocserv-agent/pkg/proto/agent/v1"
 	"github.com/rs/zerolog"
 	"google.golang.org/grpc"
@@ -30,7 +32,10 @@ type Server struct {
 	server          *grpc.Server
 	ocservManager   *ocserv.Manager
 	configGenerator *config.Generator
-	sessionStore    *storage.SessionStore // In-memory session storage
+	sessionStore    storage.SessionStore // Session storage (backend selected in config)
+	ledger          *accounting.Ledger   // Closed-session ledger (nil if accounting is disabled)
+	leases          *accounting.LeaseIndex
+	webhooks        *webhook.Dispatcher // Outbound webhooks (nil if disabled)
 }
 
 // New creates a new gRPC server instance
@@ -58,12 +63,60 @@ func New(cfg *config.Config, logger zerolog.Logger) (*Server, error) {
 		}
 	}
 
-	// Create session store with 24h TTL
-	s.sessionStore = storage.NewSessionStore(24 * time.Hour)
+	// Create session store
+	sessionStore, err := storage.OpenSessionStore(cfg.Storage.Backend, cfg.Storage.Path, cfg.Storage.SessionTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session store: %w", err)
+	}
+	s.sessionStore = sessionStore
+	logger.Info().
+		Str("backend", cfg.Storage.Backend).
+		Dur("session_ttl", cfg.Storage.SessionTTL).
+		Msg("Session store opened")
+
+	// Open closed-session ledger
+	if cfg.Accounting.Enabled {
+		ledger, err := accounting.Open(accounting.Config{
+			Dir:       cfg.Accounting.Dir,
+			Retention: cfg.Accounting.Retention,
+			Logger:    s.slogger,
+		})
+		if err != nil {
+			_ = sessionStore.Close()
+			return nil, fmt.Errorf("failed to open accounting ledger: %w", err)
+		}
+		s.ledger = ledger
+
+		leases, err := accounting.OpenLeaseIndex(ledger, filepath.Join(cfg.Accounting.Dir, accounting.ActiveLeasesFile))
+		if err != nil {
+			s.closeStores()
+			return nil, fmt.Errorf("failed to open VPN IP lease index: %w", err)
+		}
+		s.leases = leases
+		logger.Info().
+			Str("dir", cfg.Accounting.Dir).
+			Dur("retention", cfg.Accounting.Retention).
+			Msg("Accounting ledger opened")
+	}
+
+	// Start webhook delivery
+	if cfg.Webhooks.Enabled {
+		webhooks, err := newWebhookDispatcher(&cfg.Webhooks, cfg.Telemetry.ServiceName, s.slogger)
+		if err != nil {
+			s.closeStores()
+			return nil, fmt.Errorf("failed to create webhook dispatcher: %w", err)
+		}
+		s.webhooks = webhooks
+		logger.Info().
+			Int("sinks", len(cfg.Webhooks.Sinks)).
+			Msg("Webhooks enabled")
+	}
 
 	// Create gRPC server with TLS
 	grpcServer, err := s.createGRPCServer()
 	if err != nil {
+		s.stopWebhooks()
+		s.closeStores()
 		return nil, fmt.Errorf("failed to create gRPC server: %w", err)
 	}
 
@@ -76,6 +129,11 @@ func New(cfg *config.Config, logger zerolog.Logger) (*Server, error) {
 	vpnService := NewVPNService(s, slog.Default())
 	pb.RegisterVPNAgentServiceServer(s.server, vpnService)
 
+	// Register SessionHistoryService
+	if s.ledger != nil {
+		pb.RegisterSessionHistoryServiceServer(s.server, NewHistoryService(s.ledger, s.leases, slog.Default()))
+	}
+
 	// Register reflection service (for grpcurl and other tools)
 	reflection.Register(s.server)
 
@@ -190,12 +248,31 @@ func (s *Server) Serve(address string) error {
 func (s *Server) GracefulStop() {
 	s.logger.Info().Msg("Gracefully stopping gRPC server")
 	s.server.GracefulStop()
+	s.stopWebhooks()
+	s.closeStores()
 }
 
 // Stop forcefully stops the gRPC server
 func (s *Server) Stop() {
 	s.logger.Warn().Msg("Forcefully stopping gRPC server")
 	s.server.Stop()
+	s.stopWebhooks()
+	s.closeStores()
+}
+
+// closeStores releases the session store and the accounting ledger
+// (flushes on-disk backends)
+func (s *Server) closeStores() {
+	if s.sessionStore != nil {
+		if err := s.sessionStore.Close(); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to close session store")
+		}
+	}
+	if s.ledger != nil {
+		if err := s.ledger.Close(); err != nil {
+			s.logger.Error().Err(err).Msg("Failed to close accounting ledger")
+		}
+	}
 }
 
 // loggingInterceptor logs all unary RPC calls
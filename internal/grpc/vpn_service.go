@@ -11,6 +11,7 @@ import (
 	"github.com/cockroachdb/errors"
 	"github.com/dantte-lp/ocserv-agent/internal/config"
 	"github.com/dantte-lp/ocserv-agent/internal/storage"
+	"github.com/dantte-lp/ocserv-agent/internal/webhook"
 	pb "github.com/dantte-lp/ocserv-agent/pkg/proto/agent/v1"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -92,8 +93,20 @@ func (s *VPNService) NotifyConnect(ctx context.Context, req *pb.NotifyConnectReq
 				slog.Int("total_sessions", s.server.sessionStore.Count()),
 			)
 		}
+
+		// Учесть аренду VPN IP для атрибуции адресов
+		if s.server.leases != nil {
+			if err := s.server.leases.Connect(connectRecord(session)); err != nil {
+				s.logWarn(ctx, "Failed to record VPN IP lease",
+					slog.String("session_id", req.SessionId),
+					slog.String("error", err.Error()),
+				)
+			}
+		}
 	}
 
+	s.server.publish(ctx, webhook.EventSessionConnected, connectWebhookData(req))
+
 	// TODO: Интеграция с Portal для проверки политик
 	// На данный момент возвращаем базовый ответ
 
@@ -125,6 +138,12 @@ func (s *VPNService) NotifyDisconnect(ctx context.Context, req *pb.NotifyDisconn
 		slog.Uint64("duration", req.DurationSeconds),
 	)
 
+	// Сессия нужна для записи в журнал до удаления из SessionStore
+	var session *storage.VPNSession
+	if s.server.sessionStore != nil {
+		session, _ = s.server.sessionStore.Get(req.SessionId)
+	}
+
 	// Обновить статистику и удалить сессию из SessionStore
 	if s.server.sessionStore != nil {
 		// Сначала обновляем статистику если сессия существует
@@ -149,6 +168,28 @@ func (s *VPNService) NotifyDisconnect(ctx context.Context, req *pb.NotifyDisconn
 		}
 	}
 
+	// Записать завершенную сессию в журнал
+	if s.server.ledger != nil {
+		if err := s.server.ledger.Append(disconnectRecord(req, session)); err != nil {
+			s.logError(ctx, "Failed to record session in accounting ledger",
+				slog.String("session_id", req.SessionId),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	// Дальше аренда VPN IP берется из журнала
+	if s.server.leases != nil {
+		if err := s.server.leases.Disconnect(req.SessionId); err != nil {
+			s.logWarn(ctx, "Failed to release VPN IP lease",
+				slog.String("session_id", req.SessionId),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	s.server.publish(ctx, webhook.EventSessionDisconnected, disconnectWebhookData(req, session))
+
 	// TODO: Уведомить Portal об отключении
 
 	response := &pb.NotifyDisconnectResponse{
@@ -355,6 +396,12 @@ func (s *VPNService) DisconnectUser(ctx context.Context, req *pb.DisconnectUserR
 		slog.String("username", req.Username),
 	)
 
+	s.server.publish(ctx, webhook.EventAdminDisconnect, webhook.AdminData{
+		Action:  "disconnect_user",
+		Target:  req.Username,
+		Details: map[string]string{"reason": req.Reason},
+	})
+
 	return response, nil
 }
 
@@ -443,5 +490,14 @@ func (s *VPNService) UpdateUserRoutes(ctx context.Context, req *pb.UpdateUserRou
 		slog.Bool("user_reconnected", userReconnected),
 	)
 
+	s.server.publish(ctx, webhook.EventAdminConfigUpdate, webhook.AdminData{
+		Action: "update_user_routes",
+		Target: req.Username,
+		Details: map[string]string{
+			"config_path":      configPath,
+			"user_reconnected": fmt.Sprintf("%t", userReconnected),
+		},
+	})
+
 	return response, nil
 }
@@ -0,0 +1,105 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/dantte-lp/ocserv-agent/internal/storage"
+	"github.com/dantte-lp/ocserv-agent/internal/webhook"
+	pb "github.com/dantte-lp/ocserv-agent/pkg/proto/agent/v1"
+	"go.opentelemetry.io/otel"
+)
+
+// newWebhookDispatcher creates and starts the webhook dispatcher
+func newWebhookDispatcher(cfg *config.WebhooksConfig, serviceName string, logger *slog.Logger) (*webhook.Dispatcher, error) {
+	meter := otel.GetMeterProvider().Meter(serviceName)
+	dispatcher, err := webhook.NewDispatcher(webhook.NewConfig(cfg, logger, meter))
+	if err != nil {
+		return nil, err
+	}
+	dispatcher.Start(context.Background())
+
+	return dispatcher, nil
+}
+
+// publish sends an event to the webhook sinks (no-op if webhooks are disabled)
+func (s *Server) publish(ctx context.Context, eventType string, data any) {
+	if s.webhooks != nil {
+		s.webhooks.Publish(ctx, eventType, data)
+	}
+}
+
+// stopWebhooks delivers queued webhook events before shutdown
+func (s *Server) stopWebhooks() {
+	if s.webhooks == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.webhooks.Stop(ctx); err != nil {
+		s.logger.Error().Err(err).Msg("Failed to deliver pending webhook events")
+	}
+}
+
+// commandEvent maps an executed command to its admin webhook event
+func commandEvent(commandType string, args []string) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+
+	switch {
+	case commandType == "systemctl" && (args[0] == "reload" || args[0] == "restart"):
+		return webhook.EventAdminReload, true
+	case commandType == "occtl" && args[0] == "reload":
+		return webhook.EventAdminReload, true
+	case commandType == "occtl" && args[0] == "disconnect":
+		return webhook.EventAdminDisconnect, true
+	default:
+		return "", false
+	}
+}
+
+// commandData builds the admin webhook payload of an executed command
+func commandData(req *pb.CommandRequest) webhook.AdminData {
+	data := webhook.AdminData{
+		Action:  req.CommandType + " " + req.Args[0],
+		Details: map[string]string{"request_id": req.RequestId},
+	}
+	// occtl disconnect user|id <value>
+	if len(req.Args) >= 3 {
+		data.Target = req.Args[2]
+		data.Details["target_type"] = req.Args[1]
+	}
+	return data
+}
+
+// connectWebhookData builds the session.connected payload
+func connectWebhookData(req *pb.NotifyConnectRequest) webhook.SessionData {
+	return webhook.SessionData{
+		SessionID: req.SessionId,
+		Username:  req.Username,
+		GroupName: req.Metadata["group"],
+		ClientIP:  req.ClientIp,
+		VPNIPv4:   req.VpnIp,
+		VPNIPv6:   req.Metadata["vpn_ipv6"],
+	}
+}
+
+// disconnectWebhookData builds the session.disconnected payload
+func disconnectWebhookData(req *pb.NotifyDisconnectRequest, session *storage.VPNSession) webhook.SessionData {
+	rec := disconnectRecord(req, session)
+	return webhook.SessionData{
+		SessionID:       rec.SessionID,
+		Username:        rec.Username,
+		GroupName:       rec.GroupName,
+		ClientIP:        rec.ClientIP,
+		VPNIPv4:         rec.VPNIPv4,
+		VPNIPv6:         rec.VPNIPv6,
+		Reason:          rec.DisconnectReason,
+		BytesIn:         rec.BytesIn,
+		BytesOut:        rec.BytesOut,
+		DurationSeconds: int64(rec.Duration().Seconds()),
+	}
+}
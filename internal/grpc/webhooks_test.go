@@ -0,0 +1,119 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/dantte-lp/ocserv-agent/internal/storage"
+	"github.com/dantte-lp/ocserv-agent/internal/webhook"
+	pb "github.com/dantte-lp/ocserv-agent/pkg/proto/agent/v1"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestVPNService_SessionWebhooks checks that connect/disconnect notifications reach webhook sinks
+func TestVPNService_SessionWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]webhook.SessionData)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhook.Verify([]byte("secret"), r.Header.Get(webhook.HeaderTimestamp), body, r.Header.Get(webhook.HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event struct {
+			Type string              `json:"type"`
+			Data webhook.SessionData `json:"data"`
+		}
+		_ = json.Unmarshal(body, &event)
+		mu.Lock()
+		received[event.Type] = event.Data
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	webhooks, err := newWebhookDispatcher(&config.WebhooksConfig{
+		Sinks: []config.WebhookSinkConfig{{Name: "test", URL: receiver.URL, Secret: "secret", Events: []string{"session.*"}}},
+	}, "test", slog.Default())
+	require.NoError(t, err)
+
+	sessionStore := storage.NewSessionStore(time.Hour)
+	defer func() { _ = sessionStore.Close() }()
+
+	server := &Server{
+		config:       &config.Config{},
+		logger:       zerolog.Nop(),
+		sessionStore: sessionStore,
+		webhooks:     webhooks,
+	}
+	vpnService := NewVPNService(server, nil)
+	ctx := context.Background()
+
+	_, err = vpnService.NotifyConnect(ctx, &pb.NotifyConnectRequest{
+		SessionId: "s1",
+		Username:  "alice",
+		ClientIp:  "203.0.113.1",
+		VpnIp:     "10.10.10.1",
+		Metadata:  map[string]string{"group": "staff"},
+	})
+	require.NoError(t, err)
+
+	_, err = vpnService.NotifyDisconnect(ctx, &pb.NotifyDisconnectRequest{
+		SessionId:        "s1",
+		Username:         "alice",
+		DisconnectTime:   timestamppb.Now(),
+		DisconnectReason: "user_request",
+		BytesIn:          1024,
+	})
+	require.NoError(t, err)
+
+	server.stopWebhooks()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, received, webhook.EventSessionConnected)
+	assert.Equal(t, "staff", received[webhook.EventSessionConnected].GroupName)
+	require.Contains(t, received, webhook.EventSessionDisconnected)
+	disconnected := received[webhook.EventSessionDisconnected]
+	assert.Equal(t, "10.10.10.1", disconnected.VPNIPv4)
+	assert.Equal(t, "user_request", disconnected.Reason)
+	assert.Equal(t, uint64(1024), disconnected.BytesIn)
+}
+
+// TestCommandEvent tests mapping of executed commands to admin events
+func TestCommandEvent(t *testing.T) {
+	tests := []struct {
+		commandType string
+		args        []string
+		want        string
+	}{
+		{"systemctl", []string{"reload"}, webhook.EventAdminReload},
+		{"systemctl", []string{"restart"}, webhook.EventAdminReload},
+		{"occtl", []string{"reload"}, webhook.EventAdminReload},
+		{"occtl", []string{"disconnect", "user", "alice"}, webhook.EventAdminDisconnect},
+		{"occtl", []string{"show", "users"}, ""},
+		{"systemctl", []string{"status"}, ""},
+		{"occtl", nil, ""},
+	}
+
+	for _, tt := range tests {
+		got, ok := commandEvent(tt.commandType, tt.args)
+		assert.Equal(t, tt.want != "", ok, "%s %v", tt.commandType, tt.args)
+		assert.Equal(t, tt.want, got, "%s %v", tt.commandType, tt.args)
+	}
+
+	data := commandData(&pb.CommandRequest{RequestId: "r1", CommandType: "occtl", Args: []string{"disconnect", "user", "alice"}})
+	assert.Equal(t, "occtl disconnect", data.Action)
+	assert.Equal(t, "alice", data.Target)
+	assert.Equal(t, "user", data.Details["target_type"])
+}
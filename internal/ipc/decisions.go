@@ -0,0 +1,184 @@
+package ipc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// maxInvalidations bounds the per-user and per-group invalidation epochs
+// kept; beyond it they are folded into a single flush epoch
+const maxInvalidations = 1024
+
+// decisionKey identifies the cached decision of a connection
+type decisionKey struct {
+	Username  string
+	GroupName string
+	ClientIP  string
+}
+
+// requestKey returns the decision key of a request
+func requestKey(req *AuthRequest) decisionKey {
+	return decisionKey{Username: req.Username, GroupName: req.GroupName, ClientIP: req.IPReal}
+}
+
+// String encodes the key for the decision cache. Every field is quoted, so
+// the key parses back even if a field contains the separator.
+func (k decisionKey) String() string {
+	return strconv.Quote(k.Username) + ":" + strconv.Quote(k.GroupName) + ":" + strconv.Quote(k.ClientIP)
+}
+
+// parseDecisionKey decodes a decision cache key produced by String
+func parseDecisionKey(s string) (decisionKey, bool) {
+	var fields [3]string
+	for i := range fields {
+		if i > 0 {
+			if !strings.HasPrefix(s, ":") {
+				return decisionKey{}, false
+			}
+			s = s[1:]
+		}
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return decisionKey{}, false
+		}
+		if fields[i], err = strconv.Unquote(quoted); err != nil {
+			return decisionKey{}, false
+		}
+		s = s[len(quoted):]
+	}
+	if s != "" {
+		return decisionKey{}, false
+	}
+	return decisionKey{Username: fields[0], GroupName: fields[1], ClientIP: fields[2]}, true
+}
+
+// matches reports whether the key belongs to the user and/or group (an
+// empty value matches any)
+func (k decisionKey) matches(username, groupName string) bool {
+	return (username == "" || k.Username == username) && (groupName == "" || k.GroupName == groupName)
+}
+
+// decisionEpoch returns the current invalidation epoch. A portal check
+// takes it before asking the portal; its result is only cached if the
+// connection's user and group were not invalidated since.
+func (h *Handler) decisionEpoch() uint64 {
+	h.epochMu.Lock()
+	defer h.epochMu.Unlock()
+	return h.epoch
+}
+
+// invalidatedSince reports whether decisions of the key were invalidated
+// after epoch. Must be called with h.epochMu held.
+func (h *Handler) invalidatedSince(key decisionKey, epoch uint64) bool {
+	return h.flushEpoch > epoch || h.userEpochs[key.Username] > epoch || h.groupEpochs[key.GroupName] > epoch
+}
+
+// InvalidateDecisions removes cached decisions of a user and/or a group
+// (an empty value matches any) and returns how many were removed. Portal
+// checks already in flight for them are not shared with later requests
+// and their results are not cached.
+func (h *Handler) InvalidateDecisions(ctx context.Context, username, groupName string) int {
+	var removed int
+	if username == "" && groupName == "" {
+		removed = h.flushDecisions(ctx)
+	} else {
+		removed = h.invalidate(ctx, username, groupName)
+	}
+
+	h.logger.InfoContext(ctx, "invalidated cached decisions",
+		slog.String("username", username),
+		slog.String("group", groupName),
+		slog.Int("removed", removed),
+	)
+	return removed
+}
+
+// invalidate bumps the invalidation epoch of a user and/or group and
+// removes their cached decisions
+func (h *Handler) invalidate(ctx context.Context, username, groupName string) int {
+	h.flights.forget(func(key decisionKey) bool { return key.matches(username, groupName) })
+
+	h.epochMu.Lock()
+	h.epoch++
+	if len(h.userEpochs)+len(h.groupEpochs) >= maxInvalidations {
+		h.flushEpoch = h.epoch
+		clear(h.userEpochs)
+		clear(h.groupEpochs)
+	}
+	if username != "" {
+		h.userEpochs[username] = h.epoch
+	}
+	if groupName != "" {
+		h.groupEpochs[groupName] = h.epoch
+	}
+
+	if h.decisionCache == nil {
+		h.epochMu.Unlock()
+		return 0
+	}
+	removed := h.decisionCache.DeleteFunc(ctx, func(s string) bool {
+		key, ok := parseDecisionKey(s)
+		return ok && key.matches(username, groupName)
+	})
+	h.epochMu.Unlock()
+	return removed
+}
+
+// FlushDecisions removes all cached decisions
+func (h *Handler) FlushDecisions(ctx context.Context) {
+	h.flushDecisions(ctx)
+	h.logger.InfoContext(ctx, "flushed decision cache")
+}
+
+// flushDecisions invalidates every decision and returns how many cached
+// decisions were removed
+func (h *Handler) flushDecisions(ctx context.Context) int {
+	h.flights.forget(func(decisionKey) bool { return true })
+
+	h.epochMu.Lock()
+	defer h.epochMu.Unlock()
+
+	h.epoch++
+	h.flushEpoch = h.epoch
+	clear(h.userEpochs)
+	clear(h.groupEpochs)
+
+	if h.decisionCache == nil {
+		return 0
+	}
+	return h.decisionCache.DeleteFunc(ctx, func(string) bool { return true })
+}
+
+// RefreshDecision re-evaluates an active connection with the portal,
+// bypassing the cache: the per-user config is rewritten with the current
+// routes and DNS and the fresh decision is cached. It reports whether the
+// user is still allowed; ocserv applies the rewritten config on the next
+// connect.
+func (h *Handler) RefreshDecision(ctx context.Context, username, groupName, clientIP string) (bool, error) {
+	req := &AuthRequest{
+		Reason:    "connect",
+		Username:  username,
+		GroupName: groupName,
+		IPReal:    clientIP,
+	}
+
+	decision, epoch, err := h.checkPolicy(ctx, req)
+	if err != nil {
+		return false, fmt.Errorf("check policy: %w", err)
+	}
+
+	if !decision.Allowed {
+		h.cacheDecision(ctx, requestKey(req), epoch, false, decision.DenyReason)
+		return false, nil
+	}
+
+	if err := h.applyRouting(ctx, req, decision); err != nil {
+		return true, fmt.Errorf("apply routing: %w", err)
+	}
+	h.cacheDecision(ctx, requestKey(req), epoch, true, "")
+
+	return true, nil
+}
@@ -0,0 +1,320 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestCache(t *testing.T) *resilience.DecisionCache {
+	t.Helper()
+
+	cache, err := resilience.NewDecisionCache(resilience.DefaultCacheConfig(),
+		tracenoop.NewTracerProvider().Tracer("test"), metricnoop.NewMeterProvider().Meter("test"))
+	require.NoError(t, err)
+	return cache
+}
+
+func TestHandler_InvalidateDecisions(t *testing.T) {
+	ctx := context.Background()
+	cache := newTestCache(t)
+	h := newTestHandler(t, &HandlerConfig{PortalClient: &fakePortal{}, DecisionCache: cache})
+
+	seed := func() {
+		cache.Clear(ctx)
+		for _, key := range []string{
+			decisionKey{"alice", "staff", "203.0.113.1"}.String(),
+			decisionKey{"alice", "staff", "2001:db8::1"}.String(),
+			decisionKey{"bob", "staff", "203.0.113.2"}.String(),
+			decisionKey{"carol", "contractors", "203.0.113.3"}.String(),
+		} {
+			require.NoError(t, cache.Set(ctx, key, true, ""))
+		}
+	}
+
+	seed()
+	assert.Equal(t, 2, h.InvalidateDecisions(ctx, "alice", ""))
+	assert.Equal(t, 2, cache.Size())
+
+	seed()
+	assert.Equal(t, 3, h.InvalidateDecisions(ctx, "", "staff"))
+	_, found, _ := cache.Get(ctx, decisionKey{"carol", "contractors", "203.0.113.3"}.String())
+	assert.True(t, found)
+
+	seed()
+	h.FlushDecisions(ctx)
+	assert.Zero(t, cache.Size())
+}
+
+func TestDecisionKey_RoundTrip(t *testing.T) {
+	for _, key := range []decisionKey{
+		{"alice", "staff", "203.0.113.1"},
+		{"alice", "staff", "2001:db8::1"},
+		{"a:b", "staff:ops", "203.0.113.1"},
+		{`quote"d`, "", ""},
+	} {
+		got, ok := parseDecisionKey(key.String())
+		require.True(t, ok, key.String())
+		assert.Equal(t, key, got)
+	}
+
+	_, ok := parseDecisionKey("alice:staff:203.0.113.1")
+	assert.False(t, ok, "unquoted keys are not decision keys")
+}
+
+func TestHandler_InvalidateDecisionsWithSeparator(t *testing.T) {
+	ctx := context.Background()
+	cache := newTestCache(t)
+	h := newTestHandler(t, &HandlerConfig{PortalClient: &fakePortal{}, DecisionCache: cache})
+
+	for _, key := range []decisionKey{
+		{"a:b", "staff", "203.0.113.1"},
+		{"a", "b:staff", "203.0.113.2"},
+		{"carol", "ops:eu", "203.0.113.3"},
+	} {
+		require.NoError(t, cache.Set(ctx, key.String(), true, ""))
+	}
+
+	assert.Equal(t, 1, h.InvalidateDecisions(ctx, "a:b", ""))
+	assert.Equal(t, 1, h.InvalidateDecisions(ctx, "", "ops:eu"))
+	_, found, _ := cache.Get(ctx, decisionKey{"a", "b:staff", "203.0.113.2"}.String())
+	assert.True(t, found)
+}
+
+func TestHandler_InvalidationDuringPortalCheck(t *testing.T) {
+	ctx := context.Background()
+	cache := newTestCache(t)
+	portal := &countingPortal{release: make(chan struct{})}
+	h := newTestHandler(t, &HandlerConfig{PortalClient: portal, DecisionCache: cache})
+	connect := &AuthRequest{Reason: "connect", Username: "alice", GroupName: "staff", IPReal: "203.0.113.1"}
+
+	done := make(chan bool)
+	go func() { done <- h.processRequest(ctx, connect).Allowed }()
+	require.Eventually(t, func() bool { return portal.calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	// The user is revoked while the check is in flight
+	h.InvalidateDecisions(ctx, "alice", "")
+
+	// A later check does not share the call that predates the revocation
+	refreshed := make(chan bool)
+	go func() {
+		allowed, _ := h.RefreshDecision(ctx, "alice", "staff", "203.0.113.1")
+		refreshed <- allowed
+	}()
+	require.Eventually(t, func() bool { return portal.calls.Load() == 2 }, time.Second, time.Millisecond)
+
+	portal.release <- struct{}{}
+	portal.release <- struct{}{}
+	<-done
+	<-refreshed
+
+	// Only the check started after the revocation may be cached
+	require.Equal(t, 1, cache.Size())
+
+	// A result from before the revocation is never cached
+	cache.Clear(ctx)
+	go func() { done <- h.processRequest(ctx, connect).Allowed }()
+	require.Eventually(t, func() bool { return portal.calls.Load() == 3 }, time.Second, time.Millisecond)
+	h.InvalidateDecisions(ctx, "", "staff")
+	portal.release <- struct{}{}
+	assert.True(t, <-done)
+	assert.Zero(t, cache.Size())
+}
+
+func TestHandler_RefreshDecisionUsesLimiter(t *testing.T) {
+	limiter, err := NewLimiter(&LimiterConfig{MaxConcurrent: 1, Meter: metricnoop.NewMeterProvider().Meter("test")})
+	require.NoError(t, err)
+	portal := &countingPortal{}
+	h := newTestHandler(t, &HandlerConfig{PortalClient: portal, Limiter: limiter})
+
+	// Every slot is taken: the refresh is shed instead of calling the portal
+	release, err := limiter.Acquire(context.Background(), "bob")
+	require.NoError(t, err)
+	_, err = h.RefreshDecision(context.Background(), "alice", "staff", "203.0.113.1")
+	assert.True(t, IsShed(err), "got %v", err)
+	assert.Zero(t, portal.calls.Load())
+
+	release()
+	allowed, err := h.RefreshDecision(context.Background(), "alice", "staff", "203.0.113.1")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int32(1), portal.calls.Load())
+}
+
+func TestHandler_RefreshDecision(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("allowed rewrites routes and caches", func(t *testing.T) {
+		cache := newTestCache(t)
+		writer := &fakeUserConfig{}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient:  &fakePortal{allowed: true, routes: []string{"10.1.0.0/16"}},
+			DecisionCache: cache,
+			UserConfig:    writer,
+		})
+
+		allowed, err := h.RefreshDecision(ctx, "alice", "staff", "203.0.113.1")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		require.Len(t, writer.written, 1)
+		assert.Equal(t, []string{"10.1.0.0/16"}, writer.written[0].Routes)
+
+		entry, found, _ := cache.Get(ctx, decisionKey{"alice", "staff", "203.0.113.1"}.String())
+		require.True(t, found)
+		assert.True(t, entry.(*resilience.CacheEntry).Allowed)
+	})
+
+	t.Run("denied is cached", func(t *testing.T) {
+		cache := newTestCache(t)
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient:  &fakePortal{reason: "group removed"},
+			DecisionCache: cache,
+		})
+
+		allowed, err := h.RefreshDecision(ctx, "alice", "staff", "203.0.113.1")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+
+		entry, found, _ := cache.Get(ctx, decisionKey{"alice", "staff", "203.0.113.1"}.String())
+		require.True(t, found)
+		assert.Equal(t, "group removed", entry.(*resilience.CacheEntry).DenyReason)
+	})
+
+	t.Run("portal errors are returned", func(t *testing.T) {
+		h := newTestHandler(t, &HandlerConfig{PortalClient: &fakePortal{err: errors.New("portal unreachable")}})
+
+		_, err := h.RefreshDecision(ctx, "alice", "staff", "203.0.113.1")
+		assert.ErrorContains(t, err, "portal unreachable")
+	})
+}
+
+// countingPortal is a PortalClient safe for concurrent use that can hold
+// calls until released
+type countingPortal struct {
+	calls   atomic.Int32
+	release chan struct{} // nil answers at once
+}
+
+func (p *countingPortal) CheckPolicy(_ context.Context, _, _, _ string) (*vpnv1.CheckPolicyResponse, error) {
+	p.calls.Add(1)
+	if p.release != nil {
+		<-p.release
+	}
+	return &vpnv1.CheckPolicyResponse{Allowed: true}, nil
+}
+
+func TestHandler_CoalescesIdenticalPortalChecks(t *testing.T) {
+	portal := &countingPortal{release: make(chan struct{})}
+	h := newTestHandler(t, &HandlerConfig{PortalClient: portal, DecisionCache: newTestCache(t)})
+	connect := &AuthRequest{Reason: "connect", Username: "alice", GroupName: "staff", IPReal: "203.0.113.1"}
+
+	const sessions = 4
+	results := make(chan bool, sessions)
+	for range sessions {
+		go func() {
+			results <- h.processRequest(context.Background(), connect).Allowed
+		}()
+	}
+	require.Eventually(t, func() bool {
+		return h.flights.waiters(decisionKey{"alice", "staff", "203.0.113.1"}) == sessions
+	}, time.Second, time.Millisecond)
+	close(portal.release)
+
+	for range sessions {
+		assert.True(t, <-results)
+	}
+	assert.Equal(t, int32(1), portal.calls.Load())
+}
+
+func TestHandler_RefreshesHotDecisionsAhead(t *testing.T) {
+	ctx := context.Background()
+	portal := &countingPortal{}
+	h := newTestHandler(t, &HandlerConfig{
+		PortalClient:   portal,
+		DecisionCache:  newTestCache(t),
+		RefreshAhead:   time.Hour, // longer than the TTL: every hit is close to expiry
+		RefreshMinHits: 2,
+	})
+	connect := &AuthRequest{Reason: "connect", Username: "alice", GroupName: "staff", IPReal: "203.0.113.1"}
+
+	require.True(t, h.processRequest(ctx, connect).Allowed)
+	require.Equal(t, int32(1), portal.calls.Load())
+
+	// The first hit does not make the decision hot yet
+	require.True(t, h.processRequest(ctx, connect).Allowed)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(1), portal.calls.Load())
+
+	// The second hit is answered from the cache and refreshes it
+	require.True(t, h.processRequest(ctx, connect).Allowed)
+	require.Eventually(t, func() bool { return portal.calls.Load() == 2 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		h.refreshMu.Lock()
+		defer h.refreshMu.Unlock()
+		return len(h.refreshing) == 0
+	}, time.Second, time.Millisecond)
+
+	// The refreshed decision starts cold again
+	require.True(t, h.processRequest(ctx, connect).Allowed)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int32(2), portal.calls.Load())
+}
+
+// restoredCache returns a decision cache restored from a snapshot holding
+// an allow decision for the connection
+func restoredCache(t *testing.T, key decisionKey) *resilience.DecisionCache {
+	t.Helper()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "decisions.json")
+	src := newTestCache(t)
+	require.NoError(t, src.Set(ctx, key.String(), true, ""))
+	require.NoError(t, src.SaveSnapshot(path, nil))
+
+	cache := newTestCache(t)
+	loaded, err := cache.LoadSnapshot(ctx, path, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, loaded)
+	return cache
+}
+
+func TestHandler_RestoredDecisionsOnlyStandInForThePortal(t *testing.T) {
+	connect := &AuthRequest{Reason: "connect", Username: "alice", GroupName: "staff", IPReal: "203.0.113.1"}
+
+	t.Run("portal reachable", func(t *testing.T) {
+		// Revoked while the agent was down
+		portal := &fakePortal{allowed: false, reason: "revoked"}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient:  portal,
+			DecisionCache: restoredCache(t, requestKey(connect)),
+			FailMode:      "stale",
+		})
+
+		resp := h.processRequest(context.Background(), connect)
+		assert.False(t, resp.Allowed)
+		assert.Equal(t, "revoked", resp.Error)
+		assert.Equal(t, 1, portal.calls)
+	})
+
+	t.Run("portal unreachable", func(t *testing.T) {
+		portal := &fakePortal{err: errors.New("portal unreachable")}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient:  portal,
+			DecisionCache: restoredCache(t, requestKey(connect)),
+			FailMode:      "stale",
+		})
+
+		assert.True(t, h.processRequest(context.Background(), connect).Allowed)
+		assert.Equal(t, 1, portal.calls)
+	})
+}
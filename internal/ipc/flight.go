@@ -0,0 +1,152 @@
+package ipc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+)
+
+// flightGroup coalesces concurrent portal checks with the same decision
+// cache key into a single call
+type flightGroup struct {
+	timeout time.Duration // bounds each call, 0 leaves it unbounded
+
+	mu    sync.Mutex
+	calls map[decisionKey]*flight
+}
+
+// flight is a portal check in progress
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc // stops the call, set once it started
+	waiters int                // callers waiting for the result, guarded by flightGroup.mu
+	resp    *vpnv1.CheckPolicyResponse
+	err     error
+}
+
+// admitFunc waits until a call may start and returns the function ending
+// its admission once the call is done
+type admitFunc func(ctx context.Context) (release func(), err error)
+
+// do runs fn unless a call with the same key is already in progress, in
+// which case the caller waits for that call's result instead. shared
+// reports whether the result came from another caller's call.
+//
+// The caller starting the call is admitted by admit (if set) on its own
+// context, so the wait for admission honours that caller's deadline; a
+// rejection fails everyone sharing the call. Once admitted, the call runs
+// detached from the caller, bounded only by the group's timeout, so one
+// caller's short deadline does not fail everyone sharing it. Every caller
+// gives up when its own context is done, and the call is canceled once no
+// caller waits for it anymore.
+func (g *flightGroup) do(ctx context.Context, key decisionKey, admit admitFunc, fn func(ctx context.Context) (*vpnv1.CheckPolicyResponse, error)) (resp *vpnv1.CheckPolicyResponse, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[decisionKey]*flight)
+	}
+	f, shared := g.calls[key]
+	if !shared {
+		f = &flight{done: make(chan struct{})}
+		g.calls[key] = f
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	if !shared {
+		g.start(ctx, key, f, admit, fn)
+	}
+
+	select {
+	case <-f.done:
+		return f.resp, shared, f.err
+	case <-ctx.Done():
+		g.leave(key, f)
+		return nil, shared, ctx.Err()
+	}
+}
+
+// start admits the call of f and runs it on a context detached from the
+// caller; a rejected call completes at once with the admission error
+func (g *flightGroup) start(ctx context.Context, key decisionKey, f *flight, admit admitFunc, fn func(ctx context.Context) (*vpnv1.CheckPolicyResponse, error)) {
+	release := func() {}
+	if admit != nil {
+		var err error
+		if release, err = admit(ctx); err != nil {
+			g.finish(key, f, nil, err)
+			return
+		}
+	}
+
+	var callCtx context.Context
+	var cancel context.CancelFunc
+	if g.timeout > 0 {
+		callCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), g.timeout)
+	} else {
+		callCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+	}
+	g.mu.Lock()
+	f.cancel = cancel
+	g.mu.Unlock()
+
+	go func() {
+		resp, err := fn(callCtx)
+		cancel()
+		release()
+		g.finish(key, f, resp, err)
+	}()
+}
+
+// finish records the result of f and wakes its callers
+func (g *flightGroup) finish(key decisionKey, f *flight, resp *vpnv1.CheckPolicyResponse, err error) {
+	g.mu.Lock()
+	if g.calls[key] == f {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+
+	f.resp, f.err = resp, err
+	close(f.done)
+}
+
+// leave drops a caller that gave up on f; the call is canceled once no
+// caller waits for it
+func (g *flightGroup) leave(key decisionKey, f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	if g.calls[key] == f {
+		delete(g.calls, key)
+	}
+	if f.cancel != nil {
+		f.cancel()
+	}
+}
+
+// forget detaches the calls in progress whose key matches: they still
+// complete for their callers, but later callers start a new call
+func (g *flightGroup) forget(match func(key decisionKey) bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key := range g.calls {
+		if match(key) {
+			delete(g.calls, key)
+		}
+	}
+}
+
+// waiters returns the number of callers waiting for the call with key
+func (g *flightGroup) waiters(key decisionKey) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.calls[key]; ok {
+		return f.waiters
+	}
+	return 0
+}
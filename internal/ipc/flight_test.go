@@ -0,0 +1,208 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlightGroup_CoalescesConcurrentCalls(t *testing.T) {
+	var g flightGroup
+	var calls atomic.Int32
+	unblock := make(chan struct{})
+	fn := func(context.Context) (*vpnv1.CheckPolicyResponse, error) {
+		calls.Add(1)
+		<-unblock
+		return &vpnv1.CheckPolicyResponse{Allowed: true}, nil
+	}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, shared, err := g.do(context.Background(), decisionKey{"alice", "staff", "203.0.113.1"}, nil, fn)
+			assert.NoError(t, err)
+			assert.True(t, resp.Allowed)
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+
+	// Every caller must be waiting on the single call in flight
+	require.Eventually(t, func() bool {
+		return g.waiters(decisionKey{"alice", "staff", "203.0.113.1"}) == callers
+	}, time.Second, time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, int32(callers-1), sharedCount.Load())
+
+	// Completed calls are not reused
+	_, shared, err := g.do(context.Background(), decisionKey{"alice", "staff", "203.0.113.1"}, nil, fn)
+	require.NoError(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestFlightGroup_WaiterGivesUpOnItsOwnContext(t *testing.T) {
+	var g flightGroup
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	go func() {
+		_, _, _ = g.do(context.Background(), decisionKey{Username: "alice"}, nil, func(context.Context) (*vpnv1.CheckPolicyResponse, error) {
+			<-unblock
+			return nil, errors.New("late")
+		})
+	}()
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return len(g.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, shared, err := g.do(ctx, decisionKey{Username: "alice"}, nil, func(context.Context) (*vpnv1.CheckPolicyResponse, error) {
+		t.Fatal("must not start a second call")
+		return nil, nil
+	})
+	assert.True(t, shared)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFlightGroup_CallOutlivesStartingCaller(t *testing.T) {
+	g := flightGroup{timeout: time.Second}
+	key := decisionKey{"alice", "staff", "203.0.113.1"}
+	unblock := make(chan struct{})
+	fn := func(ctx context.Context) (*vpnv1.CheckPolicyResponse, error) {
+		select {
+		case <-unblock:
+			return &vpnv1.CheckPolicyResponse{Allowed: true}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// The caller starting the call has a short deadline
+	short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, _, err := g.do(short, key, nil, fn)
+		leaderErr <- err
+	}()
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return len(g.calls) == 1
+	}, time.Second, time.Millisecond)
+
+	waiterResp := make(chan *vpnv1.CheckPolicyResponse, 1)
+	go func() {
+		resp, shared, err := g.do(context.Background(), key, nil, fn)
+		assert.True(t, shared)
+		assert.NoError(t, err)
+		waiterResp <- resp
+	}()
+	require.Eventually(t, func() bool { return g.waiters(key) == 2 }, time.Second, time.Millisecond)
+
+	// The starting caller gives up, the shared call keeps running
+	assert.ErrorIs(t, <-leaderErr, context.DeadlineExceeded)
+	close(unblock)
+
+	resp := <-waiterResp
+	require.NotNil(t, resp)
+	assert.True(t, resp.Allowed)
+}
+
+func TestFlightGroup_CallBoundedByTimeout(t *testing.T) {
+	g := flightGroup{timeout: 20 * time.Millisecond}
+	_, _, err := g.do(context.Background(), decisionKey{Username: "alice"}, nil, func(ctx context.Context) (*vpnv1.CheckPolicyResponse, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFlightGroup_CanceledOnceEveryCallerLeft(t *testing.T) {
+	g := flightGroup{timeout: time.Minute}
+	key := decisionKey{Username: "alice"}
+	callErr := make(chan error, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := g.do(ctx, key, nil, func(ctx context.Context) (*vpnv1.CheckPolicyResponse, error) {
+		<-ctx.Done()
+		callErr <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The abandoned call stops instead of running into the group timeout
+	select {
+	case err := <-callErr:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("abandoned call was not canceled")
+	}
+	assert.Zero(t, g.waiters(key))
+}
+
+func TestFlightGroup_AdmissionOnStartingCallersContext(t *testing.T) {
+	var g flightGroup
+	key := decisionKey{Username: "alice"}
+	shed := &ShedError{Reason: ShedDeadline}
+	admitting := make(chan struct{})
+	reject := make(chan struct{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	wantDeadline, _ := ctx.Deadline()
+
+	admit := func(admitCtx context.Context) (func(), error) {
+		deadline, ok := admitCtx.Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, wantDeadline, deadline)
+		close(admitting)
+		<-reject
+		return nil, shed
+	}
+	fn := func(context.Context) (*vpnv1.CheckPolicyResponse, error) {
+		t.Fatal("rejected call must not run")
+		return nil, nil
+	}
+
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, shared, err := g.do(ctx, key, admit, fn)
+		assert.False(t, shared)
+		leaderErr <- err
+	}()
+	<-admitting
+
+	// A caller joining while the call waits for admission shares the rejection
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, shared, err := g.do(context.Background(), key, admit, fn)
+		assert.True(t, shared)
+		waiterErr <- err
+	}()
+	require.Eventually(t, func() bool { return g.waiters(key) == 2 }, time.Second, time.Millisecond)
+	close(reject)
+
+	assert.True(t, IsShed(<-leaderErr))
+	assert.True(t, IsShed(<-waiterErr))
+}
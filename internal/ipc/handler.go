@@ -5,9 +5,17 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/dantte-lp/ocserv-agent/internal/admission"
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/dantte-lp/ocserv-agent/internal/policy"
 	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
+	"github.com/dantte-lp/ocserv-agent/internal/stats"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -15,8 +23,14 @@ import (
 
 // PortalClient defines the interface for communicating with the portal
 type PortalClient interface {
-	// CheckPolicy validates user access policy
-	CheckPolicy(ctx context.Context, username, groupName, clientIP string) (bool, string, error)
+	// CheckPolicy validates user access policy and returns the portal's
+	// decision, including the routes and DNS servers of the connection
+	CheckPolicy(ctx context.Context, username, groupName, clientIP string) (*vpnv1.CheckPolicyResponse, error)
+}
+
+// UserConfigWriter writes ocserv per-user configuration (config.Generator)
+type UserConfigWriter interface {
+	GenerateUserConfig(cfg *config.PerUserConfig) error
 }
 
 // CacheEntry represents a cached policy decision
@@ -31,6 +45,39 @@ type DecisionCache interface {
 	Get(ctx context.Context, key string) (entry interface{}, found bool, err error)
 	// Set stores a decision in cache
 	Set(ctx context.Context, key string, allowed bool, denyReason string) error
+	// DeleteFunc removes decisions whose key matches
+	DeleteFunc(ctx context.Context, match func(key string) bool) int
+	// Clear removes all decisions
+	Clear(ctx context.Context)
+}
+
+// ScheduleChecker evaluates time-of-day access windows
+type ScheduleChecker interface {
+	// Check returns whether the user may be connected at the given time
+	Check(username, groupName string, now time.Time) schedule.Decision
+}
+
+// LocalPolicy evaluates the agent's local access policy (policy.Engine)
+type LocalPolicy interface {
+	// Evaluate returns the decision of the first matching rule
+	Evaluate(req policy.Request) policy.Decision
+}
+
+// AdmissionRules evaluates expression-based admission rules (admission.Engine)
+type AdmissionRules interface {
+	// Evaluate returns the decision of the first matching rule
+	Evaluate(ctx context.Context, in admission.Input) admission.Decision
+}
+
+// DisconnectRecorder receives the final accounting of ended sessions (stats.Poller)
+type DisconnectRecorder interface {
+	RecordDisconnect(ctx context.Context, report stats.DisconnectReport)
+}
+
+// FailModeResolver selects the fail mode of a request (resilience.FailModes)
+type FailModeResolver interface {
+	// Resolve returns the fail mode and the name of the rule that selected it
+	Resolve(username, groupName, clientIP string) (mode, rule string)
 }
 
 // Handler processes IPC authentication requests
@@ -40,13 +87,39 @@ type Handler struct {
 	protocol      *Protocol
 	portalClient  PortalClient
 	decisionCache DecisionCache
+	schedule      ScheduleChecker
+	localPolicy   LocalPolicy
+	admission     AdmissionRules
+	userConfig    UserConfigWriter
+	disconnects   DisconnectRecorder
+	limiter       *Limiter
+	flights       flightGroup
 	failMode      string // open, close, stale
+	failModes     FailModeResolver
 	timeout       time.Duration
 
+	refreshAhead   time.Duration
+	refreshMinHits int64
+	refreshMu      sync.Mutex
+	refreshing     map[decisionKey]struct{}
+
+	// Invalidation epochs of cached decisions, see decisionEpoch
+	epochMu     sync.Mutex
+	epoch       uint64
+	flushEpoch  uint64
+	userEpochs  map[string]uint64
+	groupEpochs map[string]uint64
+
 	// Metrics
 	requestsTotal   metric.Int64Counter
 	requestDuration metric.Float64Histogram
 	errorsTotal     metric.Int64Counter
+	scheduleDenied  metric.Int64Counter
+
+	failModeDecisions    metric.Int64Counter
+	localPolicyDecisions metric.Int64Counter
+	coalescedTotal       metric.Int64Counter
+	refreshAheadTotal    metric.Int64Counter
 }
 
 // HandlerConfig configures the IPC handler
@@ -56,8 +129,22 @@ type HandlerConfig struct {
 	Meter         metric.Meter
 	PortalClient  PortalClient
 	DecisionCache DecisionCache
-	FailMode      string // open, close, stale
+	Schedule      ScheduleChecker    // optional, enforced before the portal is consulted
+	LocalPolicy   LocalPolicy        // optional, denies before the portal and allows when it is unreachable
+	Admission     AdmissionRules     // optional, enforced before the portal is consulted
+	UserConfig    UserConfigWriter   // optional, receives routes and DNS assigned by the portal
+	Disconnects   DisconnectRecorder // optional, receives disconnect accounting from vpn-auth
+	Limiter       *Limiter           // optional, caps concurrent portal checks; shed requests get the fail mode
+	FailMode      string             // open, close, stale
+	FailModeRules FailModeResolver   // optional, per user/group/network fail modes; FailMode applies when no rule matches
 	Timeout       time.Duration
+
+	// RefreshAhead refreshes cached decisions in the background when a
+	// connection hits them less than this long before they expire (0
+	// disables it). Only entries hit at least RefreshMinHits times since
+	// they were cached are refreshed.
+	RefreshAhead   time.Duration
+	RefreshMinHits int
 }
 
 // NewHandler creates a new IPC request handler
@@ -109,17 +196,81 @@ func NewHandler(cfg *HandlerConfig) (*Handler, error) {
 		return nil, fmt.Errorf("create errors counter: %w", err)
 	}
 
+	scheduleDenied, err := cfg.Meter.Int64Counter(
+		"ipc.schedule.denied.total",
+		metric.WithDescription("Total number of connections denied by access schedule"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create schedule denied counter: %w", err)
+	}
+
+	failModeDecisions, err := cfg.Meter.Int64Counter(
+		"ipc.fail_mode.decisions.total",
+		metric.WithDescription("Total number of decisions made by fail mode while portal is unavailable"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create fail mode decisions counter: %w", err)
+	}
+
+	localPolicyDecisions, err := cfg.Meter.Int64Counter(
+		"ipc.local_policy.decisions.total",
+		metric.WithDescription("Total number of connections decided by the local policy"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create local policy decisions counter: %w", err)
+	}
+
+	coalescedTotal, err := cfg.Meter.Int64Counter(
+		"ipc.portal.coalesced.total",
+		metric.WithDescription("Total number of portal checks answered by an identical check already in flight"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create coalesced counter: %w", err)
+	}
+
+	refreshAheadTotal, err := cfg.Meter.Int64Counter(
+		"ipc.cache.refresh_ahead.total",
+		metric.WithDescription("Total number of cached decisions refreshed before expiry"),
+		metric.WithUnit("{refresh}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create refresh ahead counter: %w", err)
+	}
+
 	return &Handler{
 		logger:          cfg.Logger,
 		tracer:          cfg.Tracer,
 		protocol:        NewProtocol(),
 		portalClient:    cfg.PortalClient,
 		decisionCache:   cfg.DecisionCache,
+		schedule:        cfg.Schedule,
+		localPolicy:     cfg.LocalPolicy,
+		admission:       cfg.Admission,
+		userConfig:      cfg.UserConfig,
+		disconnects:     cfg.Disconnects,
+		limiter:         cfg.Limiter,
 		failMode:        cfg.FailMode,
+		failModes:       cfg.FailModeRules,
 		timeout:         cfg.Timeout,
+		refreshAhead:    cfg.RefreshAhead,
+		refreshMinHits:  int64(cfg.RefreshMinHits),
+		flights:         flightGroup{timeout: cfg.Timeout},
+		refreshing:      make(map[decisionKey]struct{}),
+		userEpochs:      make(map[string]uint64),
+		groupEpochs:     make(map[string]uint64),
 		requestsTotal:   requestsTotal,
 		requestDuration: requestDuration,
 		errorsTotal:     errorsTotal,
+		scheduleDenied:  scheduleDenied,
+
+		failModeDecisions:    failModeDecisions,
+		localPolicyDecisions: localPolicyDecisions,
+		coalescedTotal:       coalescedTotal,
+		refreshAheadTotal:    refreshAheadTotal,
 	}, nil
 }
 
@@ -131,14 +282,6 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 	var req AuthRequest
 	var resp AuthResponse
 
-	// Create span for tracing
-	ctx, span := h.tracer.Start(ctx, "ipc.handle",
-		trace.WithAttributes(
-			attribute.String("remote_addr", conn.RemoteAddr().String()),
-		),
-	)
-	defer span.End()
-
 	// Set connection deadline
 	deadline := time.Now().Add(h.timeout)
 	if err := conn.SetDeadline(deadline); err != nil {
@@ -166,6 +309,46 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 		_ = h.protocol.WriteMessage(conn, &resp)
 		return
 	}
+	version := requestVersion(&req)
+
+	// Create span for tracing. A v2 request continues the trace of the
+	// client; the span stays linked to the connection span either way.
+	spanOpts := []trace.SpanStartOption{
+		trace.WithAttributes(
+			attribute.String("remote_addr", conn.RemoteAddr().String()),
+			attribute.Int("ipc.protocol_version", version),
+		),
+	}
+	if remote, ok := remoteSpanContext(&req); ok {
+		spanOpts = append(spanOpts, trace.WithLinks(trace.LinkFromContext(ctx)))
+		ctx = trace.ContextWithRemoteSpanContext(ctx, remote)
+	}
+	ctx, span := h.tracer.Start(ctx, "ipc.handle", spanOpts...)
+	defer span.End()
+
+	if version > ProtocolVersion {
+		h.errorsTotal.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("error_type", "version"),
+		))
+		resp = AuthResponse{
+			Version:   ProtocolVersion,
+			RequestID: req.RequestID,
+			ErrorCode: ErrorCodeUnsupportedVersion,
+			Error:     fmt.Sprintf("unsupported protocol version %d (max %d)", version, ProtocolVersion),
+		}
+		_ = h.protocol.WriteMessage(conn, &resp)
+		return
+	}
+
+	// The client deadline can only shorten the handler timeout. Work that
+	// cannot finish before the deadline is pointless, the response would
+	// not be delivered.
+	if clientDeadline, ok := requestDeadline(&req); ok && clientDeadline.Before(deadline) {
+		deadline = clientDeadline
+		_ = conn.SetDeadline(deadline)
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
 
 	// Add request attributes to span
 	span.SetAttributes(
@@ -175,15 +358,29 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 		attribute.String("client_ip", req.IPReal),
 		attribute.String("session_id", req.SessionID),
 	)
+	if req.RequestID != "" {
+		span.SetAttributes(attribute.String("request_id", req.RequestID))
+	}
 
-	h.logger.InfoContext(ctx, "processing auth request",
+	logAttrs := []any{
 		slog.String("reason", req.Reason),
 		slog.String("username", req.Username),
 		slog.String("group", req.GroupName),
 		slog.String("client_ip", req.IPReal),
 		slog.String("vpn_ip", req.IPRemote),
 		slog.String("session_id", req.SessionID),
-	)
+		slog.Int("protocol_version", version),
+	}
+	if req.RequestID != "" {
+		logAttrs = append(logAttrs, slog.String("request_id", req.RequestID))
+	}
+	if peer, ok := PeerCredFromContext(ctx); ok {
+		logAttrs = append(logAttrs,
+			slog.Int("peer_pid", int(peer.PID)),
+			slog.Int("peer_uid", int(peer.UID)),
+		)
+	}
+	h.logger.InfoContext(ctx, "processing auth request", logAttrs...)
 
 	// Increment request counter
 	h.requestsTotal.Add(ctx, 1, metric.WithAttributes(
@@ -192,6 +389,10 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 
 	// Process request
 	resp = h.processRequest(ctx, &req)
+	if version >= 2 {
+		resp.Version = ProtocolVersion
+		resp.RequestID = req.RequestID
+	}
 
 	// Record duration
 	duration := time.Since(start).Seconds()
@@ -213,6 +414,7 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 
 	h.logger.InfoContext(ctx, "request processed",
 		slog.String("username", req.Username),
+		slog.String("request_id", req.RequestID),
 		slog.Bool("allowed", resp.Allowed),
 		slog.Float64("duration_ms", duration*1000),
 	)
@@ -228,6 +430,11 @@ func (h *Handler) processRequest(ctx context.Context, req *AuthRequest) AuthResp
 		}
 	}
 
+	// Disconnect accounting replaces the poller's detection of the disconnect
+	if req.Reason == "disconnect" && req.Stats != nil && h.disconnects != nil {
+		h.recordDisconnect(ctx, req)
+	}
+
 	// For disconnect events, always allow (just logging)
 	if req.Reason == "disconnect" || req.Reason == "host-update" {
 		return AuthResponse{
@@ -236,19 +443,84 @@ func (h *Handler) processRequest(ctx context.Context, req *AuthRequest) AuthResp
 		}
 	}
 
-	// For connect events, check cache first (if available)
-	cacheKey := fmt.Sprintf("%s:%s:%s", req.Username, req.GroupName, req.IPReal)
+	// Access schedules are evaluated locally before the cache and the portal,
+	// so fail-open and stale decisions cannot admit users outside their window
+	if h.schedule != nil {
+		decision := h.schedule.Check(req.Username, req.GroupName, time.Now())
+		if !decision.Allowed {
+			h.logger.WarnContext(ctx, "access denied by schedule",
+				slog.String("username", req.Username),
+				slog.String("group", req.GroupName),
+				slog.String("rule", decision.Rule),
+			)
+			h.scheduleDenied.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("rule", decision.Rule),
+			))
+			return AuthResponse{
+				Allowed: false,
+				Error:   fmt.Sprintf("outside access schedule (rule %s)", decision.Rule),
+			}
+		}
+	}
 
-	if h.decisionCache != nil {
-		entry, found, err := h.decisionCache.Get(ctx, cacheKey)
-		if err == nil && found {
-			h.logger.DebugContext(ctx, "using cached decision",
+	// The local policy rejects hard denies before the cache and the portal;
+	// its allow rules only apply while the portal is unreachable
+	local := policy.Decision{Action: policy.ActionDefer}
+	if h.localPolicy != nil {
+		local = h.localPolicy.Evaluate(policy.Request{
+			Username:  req.Username,
+			GroupName: req.GroupName,
+			ClientIP:  req.IPReal,
+			Time:      time.Now(),
+		})
+		if local.Action == policy.ActionDeny {
+			return h.applyLocalPolicy(ctx, req, local, "prefilter")
+		}
+	}
+
+	// Admission rules depend on live state such as the user's session
+	// count, so they are evaluated before cached decisions are reused
+	if h.admission != nil {
+		decision := h.admission.Evaluate(ctx, admission.Input{
+			Username:  req.Username,
+			GroupName: req.GroupName,
+			ClientIP:  req.IPReal,
+			VPNIP:     req.IPRemote,
+			Device:    req.Device,
+			UserAgent: req.UserAgent,
+			Time:      time.Now(),
+		})
+		if decision.Action == admission.ActionDeny {
+			h.logger.WarnContext(ctx, "access denied by admission rule",
 				slog.String("username", req.Username),
-				slog.Bool("from_cache", true),
+				slog.String("group", req.GroupName),
+				slog.String("rule", decision.Rule),
 			)
+			reason := decision.Reason
+			if reason == "" {
+				reason = fmt.Sprintf("denied by admission rule %s", decision.Rule)
+			}
+			return AuthResponse{
+				Allowed: false,
+				Error:   reason,
+			}
+		}
+	}
+
+	// For connect events, check cache first (if available)
+	cacheKey := requestKey(req)
 
-			// Convert to resilience.CacheEntry
-			if ce, ok := entry.(*resilience.CacheEntry); ok {
+	if h.decisionCache != nil {
+		entry, found, err := h.decisionCache.Get(ctx, cacheKey.String())
+		if err == nil && found {
+			// Convert to resilience.CacheEntry. Decisions restored from a
+			// snapshot are left to the stale fail mode.
+			if ce, ok := entry.(*resilience.CacheEntry); ok && !ce.Restored {
+				h.logger.DebugContext(ctx, "using cached decision",
+					slog.String("username", req.Username),
+					slog.Bool("from_cache", true),
+				)
+				h.maybeRefreshAhead(ctx, req, ce)
 				return AuthResponse{
 					Allowed: ce.Allowed,
 					Error:   ce.DenyReason,
@@ -257,50 +529,295 @@ func (h *Handler) processRequest(ctx context.Context, req *AuthRequest) AuthResp
 		}
 	}
 
-	// Check with portal
-	allowed, message, err := h.portalClient.CheckPolicy(ctx, req.Username, req.GroupName, req.IPReal)
+	// Check with portal. Requests shed by admission control are treated
+	// like an unreachable portal.
+	decision, epoch, err := h.checkPolicy(ctx, req)
 	if err != nil {
+		errorType := "portal"
+		if IsShed(err) {
+			errorType = "shed"
+		}
 		h.logger.ErrorContext(ctx, "portal check failed",
 			slog.String("username", req.Username),
 			slog.String("error", err.Error()),
 		)
 		h.errorsTotal.Add(ctx, 1, metric.WithAttributes(
-			attribute.String("error_type", "portal"),
+			attribute.String("error_type", errorType),
 		))
 
+		if local.Action == policy.ActionAllow {
+			return h.applyLocalPolicy(ctx, req, local, "fallback")
+		}
+
 		// Apply fail mode policy
 		return h.applyFailMode(ctx, req, err)
 	}
 
-	// Cache the decision if cache is available
-	if h.decisionCache != nil {
-		if err := h.decisionCache.Set(ctx, cacheKey, allowed, message); err != nil {
-			h.logger.WarnContext(ctx, "failed to cache decision",
-				slog.String("error", err.Error()),
-			)
+	if !decision.Allowed {
+		h.cacheDecision(ctx, cacheKey, epoch, false, decision.DenyReason)
+		h.logger.WarnContext(ctx, "access denied by portal",
+			slog.String("username", req.Username),
+			slog.String("reason", decision.DenyReason),
+		)
+		return AuthResponse{
+			Allowed: false,
+			Error:   decision.DenyReason,
 		}
 	}
 
-	if !allowed {
-		h.logger.WarnContext(ctx, "access denied by portal",
+	// The portal drives per-connection routing: a connection whose routes
+	// cannot be written is denied rather than admitted with stale routes
+	if err := h.applyRouting(ctx, req, decision); err != nil {
+		h.logger.ErrorContext(ctx, "failed to apply portal routing",
+			slog.String("username", req.Username),
+			slog.String("error", err.Error()),
+		)
+		h.errorsTotal.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("error_type", "user_config"),
+		))
+		return AuthResponse{
+			Allowed: false,
+			Error:   fmt.Sprintf("failed to apply routing: %v", err),
+		}
+	}
+
+	// Cached only once applied: cache hits rely on the per-user config
+	// written here
+	h.cacheDecision(ctx, cacheKey, epoch, true, "")
+
+	return AuthResponse{
+		Allowed: true,
+	}
+}
+
+// checkPolicy consults the portal within the limiter's concurrency cap.
+// Identical checks in flight, by decision cache key, share one portal call;
+// the request starting it waits for a slot within its own deadline.
+// It also returns the invalidation epoch the check started in, which
+// caching its result requires.
+func (h *Handler) checkPolicy(ctx context.Context, req *AuthRequest) (*vpnv1.CheckPolicyResponse, uint64, error) {
+	var admit admitFunc
+	if h.limiter != nil {
+		admit = func(ctx context.Context) (func(), error) {
+			return h.limiter.Acquire(ctx, req.Username)
+		}
+	}
+
+	epoch := h.decisionEpoch()
+	resp, shared, err := h.flights.do(ctx, requestKey(req), admit, func(ctx context.Context) (*vpnv1.CheckPolicyResponse, error) {
+		return h.portalClient.CheckPolicy(ctx, req.Username, req.GroupName, req.IPReal)
+	})
+	if shared {
+		h.coalescedTotal.Add(ctx, 1)
+	}
+	return resp, epoch, err
+}
+
+// maybeRefreshAhead starts a background refresh of a hot cached decision
+// that is about to expire, so the next connection still hits the cache
+func (h *Handler) maybeRefreshAhead(ctx context.Context, req *AuthRequest, entry *resilience.CacheEntry) {
+	if h.refreshAhead <= 0 || entry.AccessCount < h.refreshMinHits || time.Until(entry.ExpiresAt) > h.refreshAhead {
+		return
+	}
+
+	key := requestKey(req)
+	h.refreshMu.Lock()
+	if _, ok := h.refreshing[key]; ok {
+		h.refreshMu.Unlock()
+		return
+	}
+	h.refreshing[key] = struct{}{}
+	h.refreshMu.Unlock()
+
+	refreshReq := *req
+	refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.timeout)
+	go func() {
+		defer cancel()
+		defer func() {
+			h.refreshMu.Lock()
+			delete(h.refreshing, key)
+			h.refreshMu.Unlock()
+		}()
+		h.refreshAheadDecision(refreshCtx, &refreshReq)
+	}()
+}
+
+// refreshAheadDecision replaces a cached decision with a fresh one from the
+// portal; on error the cached decision is kept until it expires
+func (h *Handler) refreshAheadDecision(ctx context.Context, req *AuthRequest) {
+	cacheKey := requestKey(req)
+
+	result := "error"
+	defer func() {
+		h.refreshAheadTotal.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("result", result),
+		))
+	}()
+
+	decision, epoch, err := h.checkPolicy(ctx, req)
+	if err != nil {
+		h.logger.WarnContext(ctx, "refresh ahead failed, keeping cached decision",
+			slog.String("username", req.Username),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	if !decision.Allowed {
+		result = "denied"
+		h.cacheDecision(ctx, cacheKey, epoch, false, decision.DenyReason)
+		return
+	}
+
+	if err := h.applyRouting(ctx, req, decision); err != nil {
+		h.logger.WarnContext(ctx, "refresh ahead failed to apply routing, keeping cached decision",
+			slog.String("username", req.Username),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	result = "allowed"
+	h.cacheDecision(ctx, cacheKey, epoch, true, "")
+}
+
+// recordDisconnect forwards the accounting of a disconnect request
+func (h *Handler) recordDisconnect(ctx context.Context, req *AuthRequest) {
+	id, err := strconv.Atoi(req.SessionID)
+	if err != nil {
+		h.logger.WarnContext(ctx, "disconnect accounting without valid session ID",
+			slog.String("username", req.Username),
+			slog.String("session_id", req.SessionID),
+		)
+		return
+	}
+
+	h.disconnects.RecordDisconnect(ctx, stats.DisconnectReport{
+		SessionID: id,
+		Username:  req.Username,
+		GroupName: req.GroupName,
+		ClientIP:  req.IPReal,
+		VPNIP:     req.IPRemote,
+		BytesIn:   req.Stats.BytesIn,
+		BytesOut:  req.Stats.BytesOut,
+		Duration:  time.Duration(req.Stats.Duration) * time.Second,
+	})
+}
+
+// applyRouting writes the routes and DNS servers assigned by the portal
+// to the user's per-user config. A decision without any rewrites the
+// config empty, so routes from an earlier connect do not linger.
+func (h *Handler) applyRouting(ctx context.Context, req *AuthRequest, decision *vpnv1.CheckPolicyResponse) error {
+	if h.userConfig == nil {
+		return nil
+	}
+
+	if err := h.userConfig.GenerateUserConfig(&config.PerUserConfig{
+		Username: req.Username,
+		Routes:   decision.Routes,
+		DNS:      decision.DnsServers,
+	}); err != nil {
+		return err
+	}
+
+	h.logger.InfoContext(ctx, "applied portal routing",
+		slog.String("username", req.Username),
+		slog.Int("routes", len(decision.Routes)),
+		slog.Int("dns_servers", len(decision.DnsServers)),
+	)
+	return nil
+}
+
+// cacheDecision stores a portal decision if the cache is available. A
+// decision checked in an epoch before its user or group was invalidated
+// is dropped: it may predate a revocation.
+func (h *Handler) cacheDecision(ctx context.Context, key decisionKey, epoch uint64, allowed bool, denyReason string) {
+	if h.decisionCache == nil {
+		return
+	}
+
+	h.epochMu.Lock()
+	defer h.epochMu.Unlock()
+	if h.invalidatedSince(key, epoch) {
+		h.logger.DebugContext(ctx, "not caching decision invalidated during the portal check",
+			slog.String("username", key.Username),
+		)
+		return
+	}
+	if err := h.decisionCache.Set(ctx, key.String(), allowed, denyReason); err != nil {
+		h.logger.WarnContext(ctx, "failed to cache decision",
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// applyLocalPolicy responds with a local policy decision. stage is
+// "prefilter" for decisions made before the portal and "fallback" for
+// decisions made because the portal is unreachable.
+func (h *Handler) applyLocalPolicy(ctx context.Context, req *AuthRequest, decision policy.Decision, stage string) AuthResponse {
+	h.localPolicyDecisions.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("action", string(decision.Action)),
+		attribute.String("rule", decision.Rule),
+		attribute.String("stage", stage),
+	))
+
+	if decision.Action == policy.ActionAllow {
+		h.logger.WarnContext(ctx, "portal unavailable, allowed by local policy",
 			slog.String("username", req.Username),
-			slog.String("reason", message),
+			slog.String("group", req.GroupName),
+			slog.String("rule", decision.Rule),
 		)
+		return AuthResponse{
+			Allowed: true,
+			Message: fmt.Sprintf("portal unavailable, access granted by local policy (rule %s)", decision.Rule),
+		}
 	}
 
+	h.logger.WarnContext(ctx, "access denied by local policy",
+		slog.String("username", req.Username),
+		slog.String("group", req.GroupName),
+		slog.String("rule", decision.Rule),
+		slog.String("stage", stage),
+	)
+	reason := decision.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("denied by local policy (rule %s)", decision.Rule)
+	}
 	return AuthResponse{
-		Allowed: allowed,
-		Error:   message,
+		Allowed: false,
+		Error:   reason,
 	}
 }
 
-// applyFailMode applies the configured fail mode when portal is unavailable
+// applyFailMode applies the fail mode of the request when portal is unavailable
 func (h *Handler) applyFailMode(ctx context.Context, req *AuthRequest, portalErr error) AuthResponse {
-	switch h.failMode {
-	case "open":
+	mode, rule := h.failMode, resilience.DefaultFailModeRule
+	if h.failModes != nil {
+		mode, rule = h.failModes.Resolve(req.Username, req.GroupName, req.IPReal)
+	}
+
+	logger := h.logger.With(
+		slog.String("username", req.Username),
+		slog.String("fail_mode", mode),
+		slog.String("fail_mode_rule", rule),
+	)
+
+	resp := h.failModeResponse(ctx, logger, req, mode, portalErr)
+
+	h.failModeDecisions.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("mode", mode),
+		attribute.String("rule", rule),
+		attribute.Bool("allowed", resp.Allowed),
+	))
+
+	return resp
+}
+
+// failModeResponse builds the response of a fail mode
+func (h *Handler) failModeResponse(ctx context.Context, logger *slog.Logger, req *AuthRequest, mode string, portalErr error) AuthResponse {
+	switch mode {
+	case resilience.FailModeOpen:
 		// Fail open: allow all connections
-		h.logger.WarnContext(ctx, "portal unavailable, failing open (allowing)",
-			slog.String("username", req.Username),
+		logger.WarnContext(ctx, "portal unavailable, failing open (allowing)",
 			slog.String("error", portalErr.Error()),
 		)
 		return AuthResponse{
@@ -308,10 +825,9 @@ func (h *Handler) applyFailMode(ctx context.Context, req *AuthRequest, portalErr
 			Message: "portal unavailable, access granted (fail-open mode)",
 		}
 
-	case "close":
+	case resilience.FailModeClose:
 		// Fail close: deny all connections
-		h.logger.WarnContext(ctx, "portal unavailable, failing close (denying)",
-			slog.String("username", req.Username),
+		logger.WarnContext(ctx, "portal unavailable, failing close (denying)",
 			slog.String("error", portalErr.Error()),
 		)
 		return AuthResponse{
@@ -319,16 +835,13 @@ func (h *Handler) applyFailMode(ctx context.Context, req *AuthRequest, portalErr
 			Error:   fmt.Sprintf("portal unavailable: %v", portalErr),
 		}
 
-	case "stale":
+	case resilience.FailModeStale:
 		// Fail stale: use cached decision if available
-		cacheKey := fmt.Sprintf("%s:%s:%s", req.Username, req.GroupName, req.IPReal)
-
 		if h.decisionCache != nil {
-			entry, found, err := h.decisionCache.Get(ctx, cacheKey)
+			entry, found, err := h.decisionCache.Get(ctx, requestKey(req).String())
 			if err == nil && found {
 				if ce, ok := entry.(*resilience.CacheEntry); ok {
-					h.logger.WarnContext(ctx, "portal unavailable, using stale cache",
-						slog.String("username", req.Username),
+					logger.WarnContext(ctx, "portal unavailable, using stale cache",
 						slog.Bool("allowed", ce.Allowed),
 					)
 
@@ -341,9 +854,7 @@ func (h *Handler) applyFailMode(ctx context.Context, req *AuthRequest, portalErr
 		}
 
 		// No cache available, fail close
-		h.logger.WarnContext(ctx, "portal unavailable, no cache, denying",
-			slog.String("username", req.Username),
-		)
+		logger.WarnContext(ctx, "portal unavailable, no cache, denying")
 		return AuthResponse{
 			Allowed: false,
 			Error:   fmt.Sprintf("portal unavailable and no cache: %v", portalErr),
@@ -0,0 +1,565 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/admission"
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/dantte-lp/ocserv-agent/internal/policy"
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
+	"github.com/dantte-lp/ocserv-agent/internal/stats"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// fakePortal is a PortalClient returning a fixed result
+type fakePortal struct {
+	allowed bool
+	reason  string
+	routes  []string
+	dns     []string
+	err     error
+	calls   int
+}
+
+func (p *fakePortal) CheckPolicy(_ context.Context, _, _, _ string) (*vpnv1.CheckPolicyResponse, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &vpnv1.CheckPolicyResponse{
+		Allowed:    p.allowed,
+		DenyReason: p.reason,
+		Routes:     p.routes,
+		DnsServers: p.dns,
+	}, nil
+}
+
+// fakeUserConfig is a UserConfigWriter recording written configs
+type fakeUserConfig struct {
+	written []*config.PerUserConfig
+	err     error
+}
+
+func (w *fakeUserConfig) GenerateUserConfig(cfg *config.PerUserConfig) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.written = append(w.written, cfg)
+	return nil
+}
+
+// staticSchedule is a ScheduleChecker returning a fixed decision
+type staticSchedule struct {
+	decision schedule.Decision
+}
+
+func (s staticSchedule) Check(_, _ string, _ time.Time) schedule.Decision {
+	return s.decision
+}
+
+func newTestHandler(t *testing.T, cfg *HandlerConfig) *Handler {
+	t.Helper()
+
+	cfg.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	cfg.Tracer = tracenoop.NewTracerProvider().Tracer("test")
+	cfg.Meter = metricnoop.NewMeterProvider().Meter("test")
+
+	h, err := NewHandler(cfg)
+	require.NoError(t, err)
+	return h
+}
+
+func TestHandler_ProcessRequest_Schedule(t *testing.T) {
+	connect := &AuthRequest{
+		Reason:    "connect",
+		Username:  "alice",
+		GroupName: "contractors",
+		IPReal:    "203.0.113.10",
+	}
+
+	t.Run("denied outside window even in fail-open mode", func(t *testing.T) {
+		portal := &fakePortal{err: errors.New("portal unreachable")}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: portal,
+			FailMode:     "open",
+			Schedule:     staticSchedule{decision: schedule.Decision{Allowed: false, Rule: "contractors"}},
+		})
+
+		resp := h.processRequest(context.Background(), connect)
+		assert.False(t, resp.Allowed)
+		assert.Contains(t, resp.Error, "contractors")
+		assert.Equal(t, 0, portal.calls, "portal must not be consulted")
+	})
+
+	t.Run("allowed inside window defers to portal", func(t *testing.T) {
+		portal := &fakePortal{allowed: true}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: portal,
+			Schedule:     staticSchedule{decision: schedule.Decision{Allowed: true, Rule: "contractors"}},
+		})
+
+		resp := h.processRequest(context.Background(), connect)
+		assert.True(t, resp.Allowed)
+		assert.Equal(t, 1, portal.calls)
+	})
+
+	t.Run("disconnect is not subject to schedule", func(t *testing.T) {
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: &fakePortal{},
+			Schedule:     staticSchedule{decision: schedule.Decision{Allowed: false}},
+		})
+
+		resp := h.processRequest(context.Background(), &AuthRequest{Reason: "disconnect", Username: "alice"})
+		assert.True(t, resp.Allowed)
+	})
+}
+
+func TestHandler_ProcessRequest_PortalRouting(t *testing.T) {
+	connect := &AuthRequest{
+		Reason:   "connect",
+		Username: "alice",
+		IPReal:   "203.0.113.10",
+	}
+
+	t.Run("routes and DNS are written before allowing", func(t *testing.T) {
+		writer := &fakeUserConfig{}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: &fakePortal{allowed: true, routes: []string{"10.0.0.0/8"}, dns: []string{"10.0.0.53"}},
+			UserConfig:   writer,
+		})
+
+		resp := h.processRequest(context.Background(), connect)
+		assert.True(t, resp.Allowed)
+		require.Len(t, writer.written, 1)
+		assert.Equal(t, "alice", writer.written[0].Username)
+		assert.Equal(t, []string{"10.0.0.0/8"}, writer.written[0].Routes)
+		assert.Equal(t, []string{"10.0.0.53"}, writer.written[0].DNS)
+	})
+
+	t.Run("write failure denies the connect", func(t *testing.T) {
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: &fakePortal{allowed: true, routes: []string{"10.0.0.0/8"}},
+			UserConfig:   &fakeUserConfig{err: errors.New("read-only file system")},
+		})
+
+		resp := h.processRequest(context.Background(), connect)
+		assert.False(t, resp.Allowed)
+		assert.Contains(t, resp.Error, "read-only file system")
+	})
+
+	t.Run("no routes clears the per-user config", func(t *testing.T) {
+		writer := &fakeUserConfig{}
+		portal := &fakePortal{allowed: true, routes: []string{"10.0.0.0/8"}, dns: []string{"10.0.0.53"}}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: portal,
+			UserConfig:   writer,
+		})
+
+		require.True(t, h.processRequest(context.Background(), connect).Allowed)
+
+		// The portal stops assigning routes; the next connect must not
+		// keep the old ones
+		portal.routes, portal.dns = nil, nil
+		resp := h.processRequest(context.Background(), connect)
+		assert.True(t, resp.Allowed)
+		require.Len(t, writer.written, 2)
+		assert.Equal(t, "alice", writer.written[1].Username)
+		assert.Empty(t, writer.written[1].Routes)
+		assert.Empty(t, writer.written[1].DNS)
+	})
+
+	t.Run("denied connects are not written", func(t *testing.T) {
+		writer := &fakeUserConfig{}
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: &fakePortal{reason: "blocked", routes: []string{"10.0.0.0/8"}},
+			UserConfig:   writer,
+		})
+
+		resp := h.processRequest(context.Background(), connect)
+		assert.False(t, resp.Allowed)
+		assert.Equal(t, "blocked", resp.Error)
+		assert.Empty(t, writer.written)
+	})
+}
+
+func TestHandler_ProcessRequest_FailModeRules(t *testing.T) {
+	ctx := context.Background()
+	cache := newTestCache(t)
+	require.NoError(t, cache.Set(ctx, decisionKey{"carol", "staff", "203.0.113.12"}.String(), true, ""))
+
+	rules, err := resilience.NewFailModes(resilience.FailModeStale, []resilience.FailModeRuleSpec{
+		{Name: "admins", Groups: []string{"admins"}, Users: []string{"oncall-*"}, Mode: resilience.FailModeOpen},
+		{Name: "contractors", Groups: []string{"contractors"}, Mode: resilience.FailModeClose},
+	})
+	require.NoError(t, err)
+
+	h := newTestHandler(t, &HandlerConfig{
+		PortalClient:  &fakePortal{err: errors.New("portal unreachable")},
+		DecisionCache: cache,
+		FailMode:      resilience.FailModeClose, // superseded by the rules' default
+		FailModeRules: rules,
+	})
+
+	tests := []struct {
+		name        string
+		username    string
+		group       string
+		ip          string
+		wantAllowed bool
+	}{
+		{"admins fail open", "alice", "admins", "203.0.113.10", true},
+		{"on-call users fail open", "oncall-bob", "staff", "203.0.113.11", true},
+		{"contractors fail closed", "dave", "contractors", "203.0.113.13", false},
+		{"others use cached decisions", "carol", "staff", "203.0.113.12", true},
+		{"others without cache are denied", "erin", "staff", "203.0.113.14", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := h.processRequest(ctx, &AuthRequest{
+				Reason:    "connect",
+				Username:  tt.username,
+				GroupName: tt.group,
+				IPReal:    tt.ip,
+			})
+			assert.Equal(t, tt.wantAllowed, resp.Allowed)
+		})
+	}
+}
+
+// staticPolicy is a LocalPolicy returning per-user decisions
+type staticPolicy map[string]policy.Decision
+
+func (p staticPolicy) Evaluate(req policy.Request) policy.Decision {
+	if decision, ok := p[req.Username]; ok {
+		return decision
+	}
+	return policy.Decision{Action: policy.ActionDefer}
+}
+
+func TestHandler_ProcessRequest_LocalPolicy(t *testing.T) {
+	local := staticPolicy{
+		"mallory": {Action: policy.ActionDeny, Rule: "blocked", Reason: "account disabled"},
+		"alice":   {Action: policy.ActionAllow, Rule: "admins"},
+	}
+	connect := func(username string) *AuthRequest {
+		return &AuthRequest{Reason: "connect", Username: username, IPReal: "203.0.113.10"}
+	}
+
+	t.Run("deny short-circuits the portal", func(t *testing.T) {
+		portal := &fakePortal{allowed: true}
+		h := newTestHandler(t, &HandlerConfig{PortalClient: portal, LocalPolicy: local, FailMode: "open"})
+
+		resp := h.processRequest(context.Background(), connect("mallory"))
+		assert.False(t, resp.Allowed)
+		assert.Equal(t, "account disabled", resp.Error)
+		assert.Equal(t, 0, portal.calls)
+	})
+
+	t.Run("allow defers to a reachable portal", func(t *testing.T) {
+		portal := &fakePortal{reason: "quota exceeded"}
+		h := newTestHandler(t, &HandlerConfig{PortalClient: portal, LocalPolicy: local})
+
+		resp := h.processRequest(context.Background(), connect("alice"))
+		assert.False(t, resp.Allowed)
+		assert.Equal(t, "quota exceeded", resp.Error)
+		assert.Equal(t, 1, portal.calls)
+	})
+
+	t.Run("allow applies when the portal is unreachable", func(t *testing.T) {
+		h := newTestHandler(t, &HandlerConfig{
+			PortalClient: &fakePortal{err: errors.New("portal unreachable")},
+			LocalPolicy:  local,
+			FailMode:     "close",
+		})
+
+		assert.True(t, h.processRequest(context.Background(), connect("alice")).Allowed)
+		assert.False(t, h.processRequest(context.Background(), connect("bob")).Allowed, "defer falls back to the fail mode")
+	})
+}
+
+// sessionCount is an admission.SessionCounter returning a fixed count
+type sessionCount int
+
+func (n sessionCount) CountSessions(string) int {
+	return int(n)
+}
+
+func TestHandler_ProcessRequest_Admission(t *testing.T) {
+	rules, err := admission.New(admission.Config{
+		Rules: []admission.RuleSpec{
+			{Name: "single-session", Expr: `group == "contractors" && sessions(user) >= 1`, Action: "deny", Reason: "one session per contractor"},
+			{Name: "legacy-clients", Expr: `user_agent.startsWith("Legacy")`, Action: "deny"},
+		},
+		Sessions: sessionCount(1),
+		Meter:    metricnoop.NewMeterProvider().Meter("test"),
+	})
+	require.NoError(t, err)
+
+	t.Run("matching deny rule short-circuits the portal", func(t *testing.T) {
+		portal := &fakePortal{allowed: true}
+		h := newTestHandler(t, &HandlerConfig{PortalClient: portal, Admission: rules})
+
+		resp := h.processRequest(context.Background(), &AuthRequest{Reason: "connect", Username: "carol", GroupName: "contractors"})
+		assert.False(t, resp.Allowed)
+		assert.Equal(t, "one session per contractor", resp.Error)
+		assert.Equal(t, 0, portal.calls)
+
+		resp = h.processRequest(context.Background(), &AuthRequest{Reason: "connect", Username: "dave", UserAgent: "Legacy 1.0"})
+		assert.False(t, resp.Allowed)
+		assert.Equal(t, "denied by admission rule legacy-clients", resp.Error)
+	})
+
+	t.Run("no matching rule defers to the portal", func(t *testing.T) {
+		portal := &fakePortal{allowed: true}
+		h := newTestHandler(t, &HandlerConfig{PortalClient: portal, Admission: rules})
+
+		resp := h.processRequest(context.Background(), &AuthRequest{Reason: "connect", Username: "alice", GroupName: "staff"})
+		assert.True(t, resp.Allowed)
+		assert.Equal(t, 1, portal.calls)
+	})
+}
+
+// fakeDisconnects is a DisconnectRecorder recording reports
+type fakeDisconnects struct {
+	reports []stats.DisconnectReport
+}
+
+func (d *fakeDisconnects) RecordDisconnect(_ context.Context, report stats.DisconnectReport) {
+	d.reports = append(d.reports, report)
+}
+
+func TestHandler_ProcessRequest_DisconnectAccounting(t *testing.T) {
+	recorder := &fakeDisconnects{}
+	h := newTestHandler(t, &HandlerConfig{PortalClient: &fakePortal{}, Disconnects: recorder})
+
+	resp := h.processRequest(context.Background(), &AuthRequest{
+		Reason:    "disconnect",
+		Username:  "alice",
+		GroupName: "staff",
+		IPReal:    "203.0.113.10",
+		IPRemote:  "10.10.0.2",
+		SessionID: "42",
+		Stats:     &SessionStats{BytesIn: 1000, BytesOut: 2000, Duration: 300},
+	})
+	assert.True(t, resp.Allowed)
+	require.Len(t, recorder.reports, 1)
+	assert.Equal(t, stats.DisconnectReport{
+		SessionID: 42,
+		Username:  "alice",
+		GroupName: "staff",
+		ClientIP:  "203.0.113.10",
+		VPNIP:     "10.10.0.2",
+		BytesIn:   1000,
+		BytesOut:  2000,
+		Duration:  5 * time.Minute,
+	}, recorder.reports[0])
+
+	// Requests from older vpn-auth versions carry no accounting
+	h.processRequest(context.Background(), &AuthRequest{Reason: "disconnect", Username: "alice", SessionID: "43"})
+	// Invalid session IDs cannot be matched to a session
+	h.processRequest(context.Background(), &AuthRequest{Reason: "disconnect", Username: "alice", SessionID: "x", Stats: &SessionStats{}})
+	assert.Len(t, recorder.reports, 1)
+}
+
+// roundTrip sends a request to Handle over an in-memory connection
+func roundTrip(t *testing.T, h *Handler, req *AuthRequest) AuthResponse {
+	t.Helper()
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Handle(context.Background(), server)
+	}()
+	defer func() {
+		client.Close()
+		<-done
+	}()
+
+	protocol := NewProtocol()
+	require.NoError(t, protocol.WriteMessage(client, req))
+	var resp AuthResponse
+	require.NoError(t, protocol.ReadMessage(client, &resp))
+	return resp
+}
+
+func TestHandler_Handle_ProtocolVersions(t *testing.T) {
+	h := newTestHandler(t, &HandlerConfig{PortalClient: &fakePortal{}})
+
+	t.Run("v1 request gets a v1 response", func(t *testing.T) {
+		resp := roundTrip(t, h, &AuthRequest{Reason: "disconnect", Username: "alice"})
+		assert.True(t, resp.Allowed)
+		assert.Zero(t, resp.Version)
+		assert.Empty(t, resp.RequestID)
+	})
+
+	t.Run("v2 request echoes the request ID", func(t *testing.T) {
+		resp := roundTrip(t, h, &AuthRequest{
+			Version:   2,
+			RequestID: "3f2a9c1b",
+			Deadline:  time.Now().Add(time.Second).UnixMilli(),
+			Reason:    "disconnect",
+			Username:  "alice",
+		})
+		assert.True(t, resp.Allowed)
+		assert.Equal(t, ProtocolVersion, resp.Version)
+		assert.Equal(t, "3f2a9c1b", resp.RequestID)
+	})
+
+	t.Run("v3 request is told the highest supported version", func(t *testing.T) {
+		resp := roundTrip(t, h, &AuthRequest{Version: 3, RequestID: "r3", Reason: "connect", Username: "alice"})
+		assert.False(t, resp.Allowed)
+		assert.Equal(t, ErrorCodeUnsupportedVersion, resp.ErrorCode)
+		assert.Equal(t, ProtocolVersion, resp.Version)
+		assert.Equal(t, "r3", resp.RequestID)
+		assert.Contains(t, resp.Error, "unsupported protocol version 3")
+
+		// Retrying at the advertised version succeeds
+		resp = roundTrip(t, h, &AuthRequest{Version: resp.Version, RequestID: "r3", Reason: "disconnect", Username: "alice"})
+		assert.True(t, resp.Allowed)
+		assert.Empty(t, resp.ErrorCode)
+	})
+}
+
+func TestHandler_Handle_TraceContext(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	h, err := NewHandler(&HandlerConfig{
+		Logger:       slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:       provider.Tracer("test"),
+		Meter:        metricnoop.NewMeterProvider().Meter("test"),
+		PortalClient: &fakePortal{},
+	})
+	require.NoError(t, err)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	roundTrip(t, h, &AuthRequest{
+		Version:     2,
+		RequestID:   "r1",
+		TraceParent: "00-" + traceID + "-00f067aa0ba902b7-01",
+		Reason:      "disconnect",
+		Username:    "alice",
+	})
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "ipc.handle", spans[0].Name)
+	assert.Equal(t, traceID, spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+	assert.True(t, spans[0].Parent.IsRemote())
+}
+
+func TestHandler_ProcessRequest_ShedRequestsGetFailMode(t *testing.T) {
+	connect := &AuthRequest{Reason: "connect", Username: "alice", GroupName: "staff", IPReal: "203.0.113.10"}
+
+	for _, tt := range []struct {
+		failMode    string
+		wantAllowed bool
+	}{
+		{"open", true},
+		{"close", false},
+	} {
+		t.Run(tt.failMode, func(t *testing.T) {
+			limiter := newTestLimiter(t, 1, 0)
+			release, err := limiter.Acquire(context.Background(), "bob")
+			require.NoError(t, err)
+			defer release()
+
+			portal := &fakePortal{}
+			h := newTestHandler(t, &HandlerConfig{PortalClient: portal, FailMode: tt.failMode, Limiter: limiter})
+
+			resp := h.processRequest(context.Background(), connect)
+			assert.Equal(t, tt.wantAllowed, resp.Allowed)
+			assert.Zero(t, portal.calls, "shed request must not reach the portal")
+		})
+	}
+}
+
+func TestHandler_Handle_ShedsPastClientDeadline(t *testing.T) {
+	for _, tt := range []struct {
+		failMode    string
+		wantAllowed bool
+	}{
+		{"open", true},
+		{"close", false},
+	} {
+		t.Run(tt.failMode, func(t *testing.T) {
+			limiter := newTestLimiter(t, 1, 4)
+			release, err := limiter.Acquire(context.Background(), "bob")
+			require.NoError(t, err)
+			defer release()
+			// Portal calls hold a slot for longer than the client waits
+			limiter.serviceTime = time.Second
+
+			reader := sdkmetric.NewManualReader()
+			provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+			portal := &fakePortal{allowed: true}
+			h, err := NewHandler(&HandlerConfig{
+				Logger:       slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+				Tracer:       tracenoop.NewTracerProvider().Tracer("test"),
+				Meter:        provider.Meter("test"),
+				PortalClient: portal,
+				FailMode:     tt.failMode,
+				Limiter:      limiter,
+				Timeout:      5 * time.Second,
+			})
+			require.NoError(t, err)
+
+			// The handler timeout leaves room to queue, the client deadline does not
+			resp := roundTrip(t, h, &AuthRequest{
+				Version:   2,
+				RequestID: "r1",
+				Deadline:  time.Now().Add(500 * time.Millisecond).UnixMilli(),
+				Reason:    "connect",
+				Username:  "alice",
+				GroupName: "staff",
+				IPReal:    "203.0.113.10",
+			})
+			assert.Equal(t, tt.wantAllowed, resp.Allowed)
+			assert.Zero(t, portal.calls, "shed request must not reach the portal")
+
+			var rm metricdata.ResourceMetrics
+			require.NoError(t, reader.Collect(context.Background(), &rm))
+			assert.Equal(t, map[string]int64{"shed": 1}, errorTypes(t, rm))
+		})
+	}
+}
+
+// errorTypes returns the ipc.errors.total counts by error type
+func errorTypes(t *testing.T, rm metricdata.ResourceMetrics) map[string]int64 {
+	t.Helper()
+
+	counts := make(map[string]int64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "ipc.errors.total" {
+				continue
+			}
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			require.True(t, ok)
+			for _, dp := range sum.DataPoints {
+				errorType, _ := dp.Attributes.Value("error_type")
+				counts[errorType.AsString()] += dp.Value
+			}
+		}
+	}
+	return counts
+}
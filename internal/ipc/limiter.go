@@ -0,0 +1,302 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Shed reasons reported by Limiter
+const (
+	ShedQueueFull = "queue_full" // the wait queue is full
+	ShedDeadline  = "deadline"   // the request cannot finish before its deadline
+	ShedCanceled  = "canceled"   // the request was canceled while waiting
+)
+
+// ShedError is returned when the limiter rejects a request
+type ShedError struct {
+	Reason string
+}
+
+func (e *ShedError) Error() string {
+	return fmt.Sprintf("request shed: %s", e.Reason)
+}
+
+// IsShed reports whether err is a load shedding rejection
+func IsShed(err error) bool {
+	var shed *ShedError
+	return errors.As(err, &shed)
+}
+
+// serviceTimeWeight is the weight of the latest sample in the moving
+// average of the time a slot is held
+const serviceTimeWeight = 0.2
+
+// Limiter caps the number of requests consulting the portal at once.
+//
+// Requests beyond the cap wait in a bounded queue. Waiting requests are
+// grouped by username and served round-robin, so a user reconnecting in a
+// loop cannot starve everyone else. A request is shed as soon as it is
+// clear it cannot get a slot and finish before its deadline, using the
+// average time a slot is held as the estimate.
+type Limiter struct {
+	maxConcurrent int
+	maxQueue      int
+
+	mu          sync.Mutex
+	active      int
+	queued      int
+	queues      map[string][]*waiter // per-username FIFO queues
+	users       []string             // round-robin order of users with waiters
+	next        int
+	serviceTime time.Duration // moving average, 0 until the first release
+
+	shedTotal metric.Int64Counter
+	queueWait metric.Float64Histogram
+}
+
+// waiter is a request waiting for a slot
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// LimiterConfig configures a Limiter
+type LimiterConfig struct {
+	MaxConcurrent int // requests consulting the portal at once
+	MaxQueue      int // requests waiting for a slot; 0 sheds at once
+	Meter         metric.Meter
+}
+
+// NewLimiter creates a concurrency limiter
+func NewLimiter(cfg *LimiterConfig) (*Limiter, error) {
+	if cfg.MaxConcurrent <= 0 {
+		return nil, fmt.Errorf("max concurrent must be positive")
+	}
+	if cfg.MaxQueue < 0 {
+		return nil, fmt.Errorf("max queue must not be negative")
+	}
+	if cfg.Meter == nil {
+		return nil, fmt.Errorf("meter is required")
+	}
+
+	l := &Limiter{
+		maxConcurrent: cfg.MaxConcurrent,
+		maxQueue:      cfg.MaxQueue,
+		queues:        make(map[string][]*waiter),
+	}
+
+	var err error
+	l.shedTotal, err = cfg.Meter.Int64Counter(
+		"ipc.admission.shed.total",
+		metric.WithDescription("Total number of IPC requests shed by admission control"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create shed counter: %w", err)
+	}
+
+	l.queueWait, err = cfg.Meter.Float64Histogram(
+		"ipc.admission.queue.wait",
+		metric.WithDescription("Time IPC requests waited for a portal slot"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create queue wait histogram: %w", err)
+	}
+
+	_, err = cfg.Meter.Int64ObservableGauge(
+		"ipc.admission.queue.depth",
+		metric.WithDescription("Number of IPC requests waiting for a portal slot"),
+		metric.WithUnit("{request}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			_, queued := l.Stats()
+			o.Observe(int64(queued))
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create queue depth gauge: %w", err)
+	}
+
+	_, err = cfg.Meter.Int64ObservableGauge(
+		"ipc.admission.active",
+		metric.WithDescription("Number of IPC requests consulting the portal"),
+		metric.WithUnit("{request}"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			active, _ := l.Stats()
+			o.Observe(int64(active))
+			return nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create active gauge: %w", err)
+	}
+
+	return l, nil
+}
+
+// Acquire waits for a slot for the user's request. The returned function
+// releases the slot and must be called once the portal call is done. The
+// context deadline, if any, bounds the wait.
+func (l *Limiter) Acquire(ctx context.Context, username string) (func(), error) {
+	start := time.Now()
+
+	l.mu.Lock()
+	if l.active < l.maxConcurrent && l.queued == 0 {
+		l.active++
+		l.mu.Unlock()
+		return l.releaseFunc(start), nil
+	}
+
+	// The request must start before its deadline minus the expected
+	// time it will hold the slot
+	var startBy <-chan time.Time
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline) - l.serviceTime
+		if remaining <= 0 {
+			l.mu.Unlock()
+			return nil, l.shed(ctx, ShedDeadline)
+		}
+		timer := time.NewTimer(remaining)
+		defer timer.Stop()
+		startBy = timer.C
+	}
+
+	if l.queued >= l.maxQueue {
+		l.mu.Unlock()
+		return nil, l.shed(ctx, ShedQueueFull)
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	if len(l.queues[username]) == 0 {
+		l.users = append(l.users, username)
+	}
+	l.queues[username] = append(l.queues[username], w)
+	l.queued++
+	l.mu.Unlock()
+
+	reason := ShedCanceled
+	select {
+	case <-w.ready:
+		l.queueWait.Record(ctx, time.Since(start).Seconds())
+		return l.releaseFunc(time.Now()), nil
+	case <-startBy:
+		reason = ShedDeadline
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			reason = ShedDeadline
+		}
+	}
+
+	l.mu.Lock()
+	if w.granted {
+		// The slot was handed over while giving up; pass it on
+		l.dispatch()
+	} else {
+		l.remove(username, w)
+	}
+	l.mu.Unlock()
+
+	return nil, l.shed(ctx, reason)
+}
+
+// Stats returns the number of requests holding and waiting for a slot
+func (l *Limiter) Stats() (active, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active, l.queued
+}
+
+// releaseFunc returns a function releasing a slot acquired at start
+func (l *Limiter) releaseFunc(start time.Time) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.release(time.Since(start))
+		})
+	}
+}
+
+// release frees a slot held for the given time
+func (l *Limiter) release(held time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.serviceTime == 0 {
+		l.serviceTime = held
+	} else {
+		l.serviceTime += time.Duration(serviceTimeWeight * float64(held-l.serviceTime))
+	}
+	l.dispatch()
+}
+
+// dispatch hands a freed slot to the next user in round-robin order;
+// l.mu must be held
+func (l *Limiter) dispatch() {
+	if len(l.users) == 0 {
+		l.active--
+		return
+	}
+
+	if l.next >= len(l.users) {
+		l.next = 0
+	}
+	username := l.users[l.next]
+	queue := l.queues[username]
+	w := queue[0]
+	if len(queue) == 1 {
+		delete(l.queues, username)
+		l.users = append(l.users[:l.next], l.users[l.next+1:]...)
+	} else {
+		l.queues[username] = queue[1:]
+		l.next++
+	}
+	l.queued--
+
+	// The slot passes to the waiter without becoming free
+	w.granted = true
+	close(w.ready)
+}
+
+// remove drops a waiter that gave up; l.mu must be held
+func (l *Limiter) remove(username string, w *waiter) {
+	queue := l.queues[username]
+	for i, queued := range queue {
+		if queued != w {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		l.queued--
+		break
+	}
+	if len(queue) > 0 {
+		l.queues[username] = queue
+		return
+	}
+
+	delete(l.queues, username)
+	for i, user := range l.users {
+		if user != username {
+			continue
+		}
+		l.users = append(l.users[:i], l.users[i+1:]...)
+		if i < l.next {
+			l.next--
+		}
+		break
+	}
+}
+
+// shed counts a rejected request
+func (l *Limiter) shed(ctx context.Context, reason string) error {
+	l.shedTotal.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("reason", reason),
+	))
+	return &ShedError{Reason: reason}
+}
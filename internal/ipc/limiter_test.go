@@ -0,0 +1,156 @@
+package ipc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+)
+
+func newTestLimiter(t *testing.T, maxConcurrent, maxQueue int) *Limiter {
+	t.Helper()
+
+	l, err := NewLimiter(&LimiterConfig{
+		MaxConcurrent: maxConcurrent,
+		MaxQueue:      maxQueue,
+		Meter:         metricnoop.NewMeterProvider().Meter("test"),
+	})
+	require.NoError(t, err)
+	return l
+}
+
+// requireShed asserts that err is a shed rejection with the given reason
+func requireShed(t *testing.T, err error, reason string) {
+	t.Helper()
+	var shed *ShedError
+	require.ErrorAs(t, err, &shed)
+	assert.Equal(t, reason, shed.Reason)
+}
+
+// waitQueued waits until n requests are queued
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		_, queued := l.Stats()
+		return queued == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestLimiter_QueuesBeyondCap(t *testing.T) {
+	l := newTestLimiter(t, 1, 1)
+
+	release, err := l.Acquire(context.Background(), "alice")
+	require.NoError(t, err)
+
+	acquired := make(chan func())
+	go func() {
+		next, err := l.Acquire(context.Background(), "bob")
+		assert.NoError(t, err)
+		acquired <- next
+	}()
+	waitQueued(t, l, 1)
+
+	_, err = l.Acquire(context.Background(), "carol")
+	requireShed(t, err, ShedQueueFull)
+	assert.True(t, IsShed(err))
+
+	release()
+	release() // releasing twice is harmless
+	next := <-acquired
+	active, queued := l.Stats()
+	assert.Equal(t, 1, active)
+	assert.Zero(t, queued)
+
+	next()
+	active, _ = l.Stats()
+	assert.Zero(t, active)
+}
+
+func TestLimiter_ShedsRequestsThatCannotMeetTheirDeadline(t *testing.T) {
+	l := newTestLimiter(t, 1, 10)
+
+	release, err := l.Acquire(context.Background(), "alice")
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = l.Acquire(ctx, "bob")
+	requireShed(t, err, ShedDeadline)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, queued := l.Stats()
+	assert.Zero(t, queued, "shed request must leave the queue")
+}
+
+func TestLimiter_ShedsAtOnceWhenServiceTimeExceedsDeadline(t *testing.T) {
+	l := newTestLimiter(t, 1, 10)
+
+	// Teach the limiter that a slot is held for about 100ms
+	release, err := l.Acquire(context.Background(), "alice")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	release()
+
+	release, err = l.Acquire(context.Background(), "alice")
+	require.NoError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = l.Acquire(ctx, "bob")
+	requireShed(t, err, ShedDeadline)
+	assert.Less(t, time.Since(start), 25*time.Millisecond, "request must be shed without waiting")
+}
+
+func TestLimiter_ServesUsersRoundRobin(t *testing.T) {
+	l := newTestLimiter(t, 1, 10)
+
+	release, err := l.Acquire(context.Background(), "holder")
+	require.NoError(t, err)
+
+	granted := make(chan string, 4)
+	enqueue := func(username string, queued int) {
+		go func() {
+			next, err := l.Acquire(context.Background(), username)
+			if !assert.NoError(t, err) {
+				return
+			}
+			granted <- username
+			next()
+		}()
+		waitQueued(t, l, queued)
+	}
+	enqueue("alice", 1)
+	enqueue("alice", 2)
+	enqueue("alice", 3)
+	enqueue("bob", 4)
+
+	release()
+
+	var order []string
+	for range 4 {
+		select {
+		case username := <-granted:
+			order = append(order, username)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for slots")
+		}
+	}
+	assert.Equal(t, []string{"alice", "bob", "alice", "alice"}, order)
+}
+
+func TestNewLimiterErrors(t *testing.T) {
+	meter := metricnoop.NewMeterProvider().Meter("test")
+
+	_, err := NewLimiter(&LimiterConfig{MaxConcurrent: 0, Meter: meter})
+	assert.ErrorContains(t, err, "max concurrent must be positive")
+
+	_, err = NewLimiter(&LimiterConfig{MaxConcurrent: 1, MaxQueue: -1, Meter: meter})
+	assert.ErrorContains(t, err, "max queue must not be negative")
+}
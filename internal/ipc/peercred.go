@@ -0,0 +1,142 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/user"
+	"slices"
+	"strconv"
+
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+)
+
+// errPeerCredUnsupported is returned where SO_PEERCRED is not available
+var errPeerCredUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// PeerCred identifies the process on the other end of an IPC connection
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerPolicy restricts which processes may use the IPC socket. A peer is
+// allowed if its UID or its primary GID is listed. The zero policy allows
+// no one; AllowAll disables the check.
+type PeerPolicy struct {
+	AllowedUIDs []uint32
+	AllowedGIDs []uint32
+	AllowAll    bool
+}
+
+// Enabled reports whether the policy restricts peers
+func (p PeerPolicy) Enabled() bool {
+	return !p.AllowAll
+}
+
+// Allows reports whether the peer may use the socket
+func (p PeerPolicy) Allows(cred PeerCred) bool {
+	if p.AllowAll {
+		return true
+	}
+	return slices.Contains(p.AllowedUIDs, cred.UID) || slices.Contains(p.AllowedGIDs, cred.GID)
+}
+
+type peerCredKey struct{}
+
+// withPeerCred returns a context carrying the peer credentials
+func withPeerCred(ctx context.Context, cred PeerCred) context.Context {
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// PeerCredFromContext returns the credentials of the peer that sent the
+// request, if they are known
+func PeerCredFromContext(ctx context.Context) (PeerCred, bool) {
+	cred, ok := ctx.Value(peerCredKey{}).(PeerCred)
+	return cred, ok
+}
+
+// LookupUID resolves a user name or numeric UID
+func LookupUID(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, fmt.Errorf("lookup user %q: %w", name, err)
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("user %q has non-numeric uid %q", name, u.Uid)
+	}
+	return uint32(id), nil
+}
+
+// LookupGID resolves a group name or numeric GID
+func LookupGID(name string) (uint32, error) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("lookup group %q: %w", name, err)
+	}
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("group %q has non-numeric gid %q", name, g.Gid)
+	}
+	return uint32(id), nil
+}
+
+// NewPeerPolicy resolves the allowed user and group names or numeric IDs.
+// Required names must resolve. Optional names are defaults the operator
+// did not configure and are skipped with a warning if the account does
+// not exist, e.g. ocserv running as nobody/daemon. At least one user or
+// group must resolve; allowing every peer takes an explicit
+// PeerPolicy{AllowAll: true}.
+func NewPeerPolicy(names config.PeerNames, logger *slog.Logger) (PeerPolicy, error) {
+	var policy PeerPolicy
+	for _, name := range names.Users {
+		uid, err := LookupUID(name)
+		if err != nil {
+			return PeerPolicy{}, err
+		}
+		policy.AllowedUIDs = append(policy.AllowedUIDs, uid)
+	}
+	for _, name := range names.OptionalUsers {
+		uid, err := LookupUID(name)
+		if err != nil {
+			logger.Warn("skipping default IPC peer user",
+				slog.String("user", name),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		policy.AllowedUIDs = append(policy.AllowedUIDs, uid)
+	}
+	for _, name := range names.Groups {
+		gid, err := LookupGID(name)
+		if err != nil {
+			return PeerPolicy{}, err
+		}
+		policy.AllowedGIDs = append(policy.AllowedGIDs, gid)
+	}
+	for _, name := range names.OptionalGroups {
+		gid, err := LookupGID(name)
+		if err != nil {
+			logger.Warn("skipping default IPC peer group",
+				slog.String("group", name),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		policy.AllowedGIDs = append(policy.AllowedGIDs, gid)
+	}
+
+	if len(policy.AllowedUIDs) == 0 && len(policy.AllowedGIDs) == 0 {
+		return PeerPolicy{}, errors.New("at least one allowed user or group is required")
+	}
+	return policy, nil
+}
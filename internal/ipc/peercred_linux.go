@@ -0,0 +1,33 @@
+package ipc
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// readPeerCred returns the SO_PEERCRED credentials of a Unix socket peer
+func readPeerCred(conn net.Conn) (PeerCred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCred{}, fmt.Errorf("not a unix socket connection: %T", conn)
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return PeerCred{}, fmt.Errorf("get raw connection: %w", err)
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return PeerCred{}, fmt.Errorf("control raw connection: %w", err)
+	}
+	if credErr != nil {
+		return PeerCred{}, fmt.Errorf("getsockopt SO_PEERCRED: %w", credErr)
+	}
+
+	return PeerCred{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
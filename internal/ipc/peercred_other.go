@@ -0,0 +1,10 @@
+//go:build !linux
+
+package ipc
+
+import "net"
+
+// readPeerCred is not implemented outside Linux
+func readPeerCred(net.Conn) (PeerCred, error) {
+	return PeerCred{}, errPeerCredUnsupported
+}
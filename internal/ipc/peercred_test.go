@@ -0,0 +1,183 @@
+package ipc
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestPeerPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PeerPolicy
+		cred   PeerCred
+		want   bool
+	}{
+		{"empty policy allows no one", PeerPolicy{}, PeerCred{UID: 0, GID: 0}, false},
+		{"allow all", PeerPolicy{AllowAll: true}, PeerCred{UID: 1234, GID: 1234}, true},
+		{"allowed uid", PeerPolicy{AllowedUIDs: []uint32{0, 998}}, PeerCred{UID: 998, GID: 100}, true},
+		{"allowed gid", PeerPolicy{AllowedUIDs: []uint32{0}, AllowedGIDs: []uint32{998}}, PeerCred{UID: 1000, GID: 998}, true},
+		{"not listed", PeerPolicy{AllowedUIDs: []uint32{0}, AllowedGIDs: []uint32{998}}, PeerCred{UID: 1000, GID: 1000}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Allows(tt.cred))
+		})
+	}
+}
+
+func TestNewPeerPolicy(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	tests := []struct {
+		name     string
+		names    config.PeerNames
+		wantUIDs []uint32
+		wantGIDs []uint32
+		wantErr  string
+	}{
+		{
+			name:     "names and IDs",
+			names:    config.PeerNames{Users: []string{"0", "root"}, Groups: []string{"998"}},
+			wantUIDs: []uint32{0, 0},
+			wantGIDs: []uint32{998},
+		},
+		{
+			name:    "missing configured user",
+			names:   config.PeerNames{Users: []string{"no-such-user-ocserv-agent"}},
+			wantErr: `lookup user "no-such-user-ocserv-agent"`,
+		},
+		{
+			name:    "missing configured group",
+			names:   config.PeerNames{OptionalUsers: []string{"root"}, Groups: []string{"no-such-group-ocserv-agent"}},
+			wantErr: `lookup group "no-such-group-ocserv-agent"`,
+		},
+		{
+			name: "missing default accounts skipped",
+			names: config.PeerNames{
+				OptionalUsers:  []string{"root", "no-such-user-ocserv-agent"},
+				OptionalGroups: []string{"no-such-group-ocserv-agent"},
+			},
+			wantUIDs: []uint32{0},
+		},
+		{
+			name:    "nothing resolves",
+			names:   config.PeerNames{OptionalUsers: []string{"no-such-user-ocserv-agent"}},
+			wantErr: "at least one allowed user or group is required",
+		},
+		{
+			name:    "no names",
+			wantErr: "at least one allowed user or group is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPeerPolicy(tt.names, logger)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantUIDs, policy.AllowedUIDs)
+			assert.Equal(t, tt.wantGIDs, policy.AllowedGIDs)
+		})
+	}
+}
+
+func newTestServer(t *testing.T, peers PeerPolicy) string {
+	t.Helper()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is only available on Linux")
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	server, err := NewServer(&ServerConfig{
+		SocketPath: socketPath,
+		Handler:    newTestHandler(t, &HandlerConfig{PortalClient: &fakePortal{}}),
+		Logger:     slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:     tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:      metricnoop.NewMeterProvider().Meter("test"),
+		Peers:      peers,
+		SocketMode: 0600,
+	})
+	require.NoError(t, err)
+	require.NoError(t, server.Start(context.Background()))
+	t.Cleanup(func() { _ = server.Stop(context.Background()) })
+
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	return socketPath
+}
+
+func sendTestRequest(t *testing.T, socketPath string) (*AuthResponse, error) {
+	t.Helper()
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	protocol := NewProtocol()
+	// A rejected connection may already be closed when writing
+	if err := protocol.WriteMessage(conn, &AuthRequest{Reason: "disconnect", Username: "alice"}); err != nil {
+		return nil, err
+	}
+
+	var resp AuthResponse
+	if err := protocol.ReadMessage(conn, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func TestServerPeerCredentials(t *testing.T) {
+	// #nosec G115 - test process IDs fit in uint32
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+
+	t.Run("allowed peer", func(t *testing.T) {
+		socketPath := newTestServer(t, PeerPolicy{AllowedUIDs: []uint32{uid}})
+
+		resp, err := sendTestRequest(t, socketPath)
+		require.NoError(t, err)
+		assert.True(t, resp.Allowed)
+	})
+
+	t.Run("allow all", func(t *testing.T) {
+		socketPath := newTestServer(t, PeerPolicy{AllowAll: true})
+
+		resp, err := sendTestRequest(t, socketPath)
+		require.NoError(t, err)
+		assert.True(t, resp.Allowed)
+	})
+
+	t.Run("rejected peer", func(t *testing.T) {
+		socketPath := newTestServer(t, PeerPolicy{AllowedUIDs: []uint32{uid + 1}, AllowedGIDs: []uint32{gid + 1}})
+
+		_, err := sendTestRequest(t, socketPath)
+		assert.Error(t, err, "connection must be closed without a response")
+	})
+}
+
+func TestPeerCredFromContext(t *testing.T) {
+	_, ok := PeerCredFromContext(context.Background())
+	assert.False(t, ok)
+
+	cred := PeerCred{PID: 42, UID: 998, GID: 998}
+	got, ok := PeerCredFromContext(withPeerCred(context.Background(), cred))
+	require.True(t, ok)
+	assert.Equal(t, cred, got)
+}
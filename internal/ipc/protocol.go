@@ -1,11 +1,16 @@
 package ipc
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -13,7 +18,16 @@ const (
 	MaxMessageSize = 1024 * 1024
 
 	// ProtocolVersion defines the current protocol version
-	ProtocolVersion = 1
+	ProtocolVersion = 2
+
+	// ProtocolVersionV1 is the original protocol. Its requests carry no
+	// version field, request ID, deadline or trace context.
+	ProtocolVersionV1 = 1
+
+	// ErrorCodeUnsupportedVersion rejects a request of a newer protocol
+	// version than the agent speaks. The response carries the highest
+	// version the agent supports, so the client can retry with it.
+	ErrorCodeUnsupportedVersion = "unsupported_version"
 )
 
 // Protocol implements length-prefixed JSON protocol for IPC communication
@@ -83,3 +97,33 @@ func (p *Protocol) WriteMessage(conn net.Conn, v interface{}) error {
 
 	return nil
 }
+
+// requestVersion returns the protocol version of a request; v1 clients
+// send no version
+func requestVersion(req *AuthRequest) int {
+	if req.Version == 0 {
+		return ProtocolVersionV1
+	}
+	return req.Version
+}
+
+// requestDeadline returns the client deadline of a v2 request, if any
+func requestDeadline(req *AuthRequest) (time.Time, bool) {
+	if req.Deadline <= 0 {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(req.Deadline), true
+}
+
+// remoteSpanContext extracts the W3C trace context of a v2 request
+func remoteSpanContext(req *AuthRequest) (trace.SpanContext, bool) {
+	if req.TraceParent == "" {
+		return trace.SpanContext{}, false
+	}
+	carrier := propagation.MapCarrier{"traceparent": req.TraceParent}
+	if req.TraceState != "" {
+		carrier["tracestate"] = req.TraceState
+	}
+	sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+	return sc, sc.IsValid()
+}
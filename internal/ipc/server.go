@@ -22,13 +22,19 @@ type Server struct {
 	logger     *slog.Logger
 	tracer     trace.Tracer
 
+	peers       PeerPolicy
+	socketMode  os.FileMode
+	socketOwner int
+	socketGroup int
+
 	wg     sync.WaitGroup
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	// Metrics
-	activeConnections metric.Int64UpDownCounter
-	connectionsTotal  metric.Int64Counter
+	activeConnections   metric.Int64UpDownCounter
+	connectionsTotal    metric.Int64Counter
+	connectionsRejected metric.Int64Counter
 }
 
 // ServerConfig configures the IPC server
@@ -38,6 +44,15 @@ type ServerConfig struct {
 	Logger     *slog.Logger
 	Tracer     trace.Tracer
 	Meter      metric.Meter
+
+	// Peers restricts connections by SO_PEERCRED; the zero policy rejects everyone
+	Peers PeerPolicy
+	// SocketMode is the socket file mode (default 0660)
+	SocketMode os.FileMode
+	// SocketOwner and SocketGroup (names or numeric IDs) change the
+	// socket file ownership when set
+	SocketOwner string
+	SocketGroup string
 }
 
 // NewServer creates a new IPC server
@@ -77,17 +92,53 @@ func NewServer(cfg *ServerConfig) (*Server, error) {
 		return nil, fmt.Errorf("create connections counter: %w", err)
 	}
 
+	connectionsRejected, err := cfg.Meter.Int64Counter(
+		"ipc.connections.rejected.total",
+		metric.WithDescription("Total number of IPC connections rejected by peer credential checks"),
+		metric.WithUnit("{connection}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create rejected connections counter: %w", err)
+	}
+
+	socketMode := cfg.SocketMode
+	if socketMode == 0 {
+		socketMode = 0660
+	}
+
+	// os.Chown leaves an ID of -1 unchanged
+	socketOwner, socketGroup := -1, -1
+	if cfg.SocketOwner != "" {
+		uid, err := LookupUID(cfg.SocketOwner)
+		if err != nil {
+			return nil, fmt.Errorf("socket owner: %w", err)
+		}
+		socketOwner = int(uid)
+	}
+	if cfg.SocketGroup != "" {
+		gid, err := LookupGID(cfg.SocketGroup)
+		if err != nil {
+			return nil, fmt.Errorf("socket group: %w", err)
+		}
+		socketGroup = int(gid)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Server{
-		socketPath:        cfg.SocketPath,
-		handler:           cfg.Handler,
-		logger:            cfg.Logger,
-		tracer:            cfg.Tracer,
-		ctx:               ctx,
-		cancel:            cancel,
-		activeConnections: activeConnections,
-		connectionsTotal:  connectionsTotal,
+		socketPath:          cfg.SocketPath,
+		handler:             cfg.Handler,
+		logger:              cfg.Logger,
+		tracer:              cfg.Tracer,
+		peers:               cfg.Peers,
+		socketMode:          socketMode,
+		socketOwner:         socketOwner,
+		socketGroup:         socketGroup,
+		ctx:                 ctx,
+		cancel:              cancel,
+		activeConnections:   activeConnections,
+		connectionsTotal:    connectionsTotal,
+		connectionsRejected: connectionsRejected,
 	}, nil
 }
 
@@ -112,16 +163,27 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 	s.listener = listener
 
-	// Set socket permissions to 0666 (readable/writable by all)
-	// This allows ocserv running as root to communicate with the agent
-	if err := os.Chmod(s.socketPath, 0666); err != nil {
+	// Socket permissions are the first line of defense; peer credentials
+	// are checked on every connection
+	if s.socketOwner != -1 || s.socketGroup != -1 {
+		if err := os.Chown(s.socketPath, s.socketOwner, s.socketGroup); err != nil {
+			listener.Close()
+			return fmt.Errorf("chown socket: %w", err)
+		}
+	}
+	if err := os.Chmod(s.socketPath, s.socketMode); err != nil {
 		listener.Close()
 		return fmt.Errorf("chmod socket: %w", err)
 	}
 
 	s.logger.InfoContext(ctx, "IPC server started",
 		slog.String("socket", s.socketPath),
+		slog.String("mode", s.socketMode.String()),
+		slog.Bool("peer_checks", s.peers.Enabled()),
 	)
+	if !s.peers.Enabled() {
+		s.logger.WarnContext(ctx, "IPC peer credential checks disabled by allow_all_peers, any local user allowed by the socket mode can connect")
+	}
 
 	// Start accepting connections in background
 	s.wg.Add(1)
@@ -217,6 +279,44 @@ func (s *Server) handleConnection(conn net.Conn) {
 	)
 	defer span.End()
 
+	cred, err := readPeerCred(conn)
+	switch {
+	case err != nil && s.peers.Enabled():
+		s.reject(ctx, conn, "credentials",
+			slog.String("error", err.Error()),
+		)
+		return
+	case err != nil:
+		s.logger.DebugContext(ctx, "peer credentials unavailable",
+			slog.String("error", err.Error()),
+		)
+	case !s.peers.Allows(cred):
+		s.reject(ctx, conn, "peer",
+			slog.Int("pid", int(cred.PID)),
+			slog.Int("uid", int(cred.UID)),
+			slog.Int("gid", int(cred.GID)),
+		)
+		return
+	default:
+		span.SetAttributes(
+			attribute.Int("peer.pid", int(cred.PID)),
+			attribute.Int("peer.uid", int(cred.UID)),
+		)
+		ctx = withPeerCred(ctx, cred)
+	}
+
 	// Delegate to handler
 	s.handler.Handle(ctx, conn)
 }
+
+// reject closes a connection that failed peer credential checks
+func (s *Server) reject(ctx context.Context, conn net.Conn, reason string, attrs ...any) {
+	defer conn.Close()
+
+	s.connectionsRejected.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("reason", reason),
+	))
+	s.logger.WarnContext(ctx, "IPC connection rejected",
+		append([]any{slog.String("reason", reason)}, attrs...)...,
+	)
+}
@@ -2,17 +2,39 @@ package ipc
 
 // AuthRequest represents an authentication request from vpn-auth CLI
 type AuthRequest struct {
-	Reason    string `json:"reason"`     // connect, disconnect, host-update
-	Username  string `json:"username"`   // From certificate CN
-	GroupName string `json:"groupname"`  // From certificate OU
-	IPReal    string `json:"ip_real"`    // Client IP
-	IPRemote  string `json:"ip_remote"`  // VPN IP
-	Device    string `json:"device"`     // tun/tap device
-	SessionID string `json:"session_id"` // ocserv session ID
+	// Protocol v2 envelope, absent in v1 requests
+	Version     int    `json:"version,omitempty"`     // protocol version
+	RequestID   string `json:"request_id,omitempty"`  // client-generated correlation ID
+	Deadline    int64  `json:"deadline,omitempty"`    // client deadline, unix milliseconds
+	TraceParent string `json:"traceparent,omitempty"` // W3C trace context
+	TraceState  string `json:"tracestate,omitempty"`  // W3C trace state
+
+	Reason    string `json:"reason"`               // connect, disconnect, host-update
+	Username  string `json:"username"`             // From certificate CN
+	GroupName string `json:"groupname"`            // From certificate OU
+	IPReal    string `json:"ip_real"`              // Client IP
+	IPRemote  string `json:"ip_remote"`            // VPN IP
+	Device    string `json:"device"`               // tun/tap device
+	SessionID string `json:"session_id"`           // ocserv session ID
+	UserAgent string `json:"user_agent,omitempty"` // client user agent
+
+	// Stats is the final session accounting, sent on disconnect
+	Stats *SessionStats `json:"stats,omitempty"`
+}
+
+// SessionStats carries the accounting ocserv passes to the disconnect script
+type SessionStats struct {
+	BytesIn  uint64 `json:"bytes_in"`  // STATS_BYTES_IN, received from the client
+	BytesOut uint64 `json:"bytes_out"` // STATS_BYTES_OUT, sent to the client
+	Duration int64  `json:"duration"`  // STATS_DURATION, seconds
 }
 
 // AuthResponse represents the response to an authentication request
 type AuthResponse struct {
+	Version   int    `json:"version,omitempty"`    // protocol version of the agent, v2 only
+	RequestID string `json:"request_id,omitempty"` // echoed request ID, v2 only
+	ErrorCode string `json:"error_code,omitempty"` // machine-readable error, v2 only
+
 	Allowed bool   `json:"allowed"`           // Whether connection is allowed
 	Error   string `json:"error,omitempty"`   // Error message if not allowed
 	Message string `json:"message,omitempty"` // Additional information
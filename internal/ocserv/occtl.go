@@ -435,23 +435,7 @@ func (m *OcctlManager) execute(ctx context.Context, args ...string) (string, str
 	defer cancel()
 
 	// Build command
-	var cmd *exec.Cmd
-	if m.sudoUser != "" {
-		// Run with sudo
-		cmdArgs := []string{"sudo", "-n", "occtl"}
-		if m.socketPath != "" {
-			cmdArgs = append(cmdArgs, "-s", m.socketPath)
-		}
-		cmdArgs = append(cmdArgs, args...)
-		cmd = exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
-	} else {
-		cmdArgs := []string{"occtl"}
-		if m.socketPath != "" {
-			cmdArgs = append(cmdArgs, "-s", m.socketPath)
-		}
-		cmdArgs = append(cmdArgs, args...)
-		cmd = exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
-	}
+	cmd := m.command(ctx, args...)
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
@@ -481,6 +465,26 @@ func (m *OcctlManager) execute(ctx context.Context, args ...string) (string, str
 	return stdoutStr, stderrStr, err
 }
 
+// command builds an occtl command, wrapped in sudo if configured
+func (m *OcctlManager) command(ctx context.Context, args ...string) *exec.Cmd {
+	if m.sudoUser != "" {
+		// Run with sudo
+		cmdArgs := []string{"sudo", "-n", "occtl"}
+		if m.socketPath != "" {
+			cmdArgs = append(cmdArgs, "-s", m.socketPath)
+		}
+		cmdArgs = append(cmdArgs, args...)
+		return exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
+	}
+
+	cmdArgs := []string{"occtl"}
+	if m.socketPath != "" {
+		cmdArgs = append(cmdArgs, "-s", m.socketPath)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	return exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
+}
+
 // executeJSON runs an occtl command with JSON output flag (-j) and captures output
 func (m *OcctlManager) executeJSON(ctx context.Context, args ...string) (string, string, error) {
 	// Add -j flag for JSON output
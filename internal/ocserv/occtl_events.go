@@ -0,0 +1,146 @@
+package ocserv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// eventRecord is a single value from 'occtl -j show events'.
+// occtl first prints the connected users, then one record per event. Records
+// are user objects (see User) optionally annotated with event fields.
+type eventRecord struct {
+	User
+	EventType        string    `json:"event_type"`
+	Timestamp        time.Time `json:"timestamp"`
+	EventRemoteIP    string    `json:"remote_ip"`
+	SessionID        string    `json:"session_id"`
+	Reason           string    `json:"reason"`
+	DisconnectReason string    `json:"Disconnect reason"`
+	Details          string    `json:"details"`
+}
+
+// StreamEvents runs 'occtl -j show events' and calls fn for every event until
+// the stream ends or ctx is cancelled. It always returns a non-nil error; the
+// caller is expected to restart the stream.
+func (m *OcctlManager) StreamEvents(ctx context.Context, fn func(Event)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// No timeout: the command runs until ocserv closes the stream
+	cmd := m.command(ctx, "-j", "show", "events")
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open events pipe: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	m.logger.Info().Msg("Starting occtl event stream")
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start event stream: %w", err)
+	}
+
+	decodeErr := decodeEvents(stdout, fn)
+
+	// Stop the process if decoding failed while it was still running
+	cancel()
+	waitErr := cmd.Wait()
+
+	switch {
+	case ctx.Err() != nil && decodeErr == nil:
+		return ctx.Err()
+	case decodeErr != nil:
+		return fmt.Errorf("failed to decode events: %w (stderr: %s)", decodeErr, stderr.String())
+	case waitErr != nil:
+		return fmt.Errorf("event stream exited: %w (stderr: %s)", waitErr, stderr.String())
+	default:
+		return errors.New("event stream closed")
+	}
+}
+
+// decodeEvents decodes a stream of JSON arrays or objects and calls fn for
+// each event. Returns nil when the stream ends cleanly.
+func decodeEvents(r io.Reader, fn func(Event)) error {
+	dec := json.NewDecoder(r)
+
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 && raw[0] == '[' {
+			var records []eventRecord
+			if err := json.Unmarshal(raw, &records); err != nil {
+				return err
+			}
+			for i := range records {
+				fn(records[i].toEvent())
+			}
+			continue
+		}
+
+		var record eventRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return err
+		}
+		fn(record.toEvent())
+	}
+}
+
+// toEvent converts a stream record into an Event
+func (r *eventRecord) toEvent() Event {
+	event := Event{
+		Timestamp: r.Timestamp,
+		EventType: r.EventType,
+		Username:  r.Username,
+		RemoteIP:  r.RemoteIP,
+		SessionID: r.SessionID,
+		Reason:    r.Reason,
+		Details:   r.Details,
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	if event.RemoteIP == "" {
+		event.RemoteIP = r.EventRemoteIP
+	}
+	if event.Reason == "" {
+		event.Reason = r.DisconnectReason
+	}
+	if event.SessionID == "" && r.ID != 0 {
+		event.SessionID = strconv.Itoa(r.ID)
+	}
+
+	// Plain user records carry the event in their state
+	if event.EventType == "" {
+		switch r.State {
+		case "disconnected":
+			event.EventType = EventDisconnect
+		case "auth failed", "failed":
+			event.EventType = EventAuthFailure
+		default:
+			event.EventType = EventConnect
+		}
+	}
+
+	if r.ID != 0 {
+		user := r.User
+		event.User = &user
+	}
+
+	return event
+}
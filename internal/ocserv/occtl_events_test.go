@@ -0,0 +1,89 @@
+package ocserv
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEvents_Fixture(t *testing.T) {
+	f, err := os.Open("../../test/fixtures/ocserv/occtl/occtl -j show events")
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	if err := decodeEvents(f, func(e Event) { events = append(events, e) }); err != nil {
+		t.Fatalf("decodeEvents() error = %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+
+	first := events[0]
+	if first.EventType != EventConnect {
+		t.Errorf("EventType = %q, want %q", first.EventType, EventConnect)
+	}
+	if first.Username != "lpa" || first.SessionID != "836917" || first.RemoteIP != "95.214.210.98" {
+		t.Errorf("unexpected event: %+v", first)
+	}
+	if first.User == nil || first.User.IPv4 != "10.0.16.23" {
+		t.Errorf("event should carry the user record, got %+v", first.User)
+	}
+}
+
+func TestDecodeEvents_Stream(t *testing.T) {
+	stream := `[]
+{"ID": 7, "Username": "alice", "State": "connected", "Remote IP": "203.0.113.5", "IPv4": "10.0.0.7"}
+{"ID": 7, "Username": "alice", "State": "disconnected", "RX": "1024", "TX": "2048", "Disconnect reason": "user disconnected"}
+{"event_type": "auth-failure", "username": "mallory", "remote_ip": "198.51.100.9", "reason": "bad password"}
+`
+
+	var events []Event
+	if err := decodeEvents(strings.NewReader(stream), func(e Event) { events = append(events, e) }); err != nil {
+		t.Fatalf("decodeEvents() error = %v", err)
+	}
+
+	want := []struct {
+		eventType string
+		username  string
+		sessionID string
+		reason    string
+	}{
+		{EventConnect, "alice", "7", ""},
+		{EventDisconnect, "alice", "7", "user disconnected"},
+		{EventAuthFailure, "mallory", "", "bad password"},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.EventType != w.eventType || e.Username != w.username || e.SessionID != w.sessionID || e.Reason != w.reason {
+			t.Errorf("event %d = %+v, want %+v", i, e, w)
+		}
+		if e.Timestamp.IsZero() {
+			t.Errorf("event %d has no timestamp", i)
+		}
+	}
+
+	if events[1].User == nil || events[1].User.RX != "1024" {
+		t.Errorf("disconnect should carry final counters, got %+v", events[1].User)
+	}
+	if events[2].User != nil {
+		t.Errorf("auth failure should not carry a user record")
+	}
+	if events[2].RemoteIP != "198.51.100.9" {
+		t.Errorf("RemoteIP = %q", events[2].RemoteIP)
+	}
+}
+
+func TestDecodeEvents_Malformed(t *testing.T) {
+	err := decodeEvents(strings.NewReader(`{"ID": 1, "Username": `), func(Event) {})
+	if err == nil {
+		t.Fatal("expected error for truncated stream")
+	}
+}
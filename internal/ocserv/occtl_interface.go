@@ -24,5 +24,15 @@ type OcctlInterface interface {
 	Reload(ctx context.Context) error
 }
 
+// EventStreamer streams session events from 'occtl show events'
+type EventStreamer interface {
+	// StreamEvents calls fn for every event until the stream ends or ctx is
+	// cancelled, then returns the reason the stream stopped
+	StreamEvents(ctx context.Context, fn func(Event)) error
+}
+
 // Ensure OcctlManager implements OcctlInterface
 var _ OcctlInterface = (*OcctlManager)(nil)
+
+// Ensure OcctlManager implements EventStreamer
+var _ EventStreamer = (*OcctlManager)(nil)
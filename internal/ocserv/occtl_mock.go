@@ -20,6 +20,8 @@ type MockOcctlManager struct {
 	disconnected  []string // Track disconnected users
 	reloadCalled  bool
 	stopCalled    bool
+	events        []Event
+	streamCalls   int
 
 	// Error injection
 	showUsersErr     error
@@ -28,6 +30,7 @@ type MockOcctlManager struct {
 	getStatsErr      error
 	reloadErr        error
 	stopErr          error
+	streamErr        error
 }
 
 // NewMockOcctlManager creates a new mock occtl manager
@@ -185,6 +188,26 @@ func (m *MockOcctlManager) Reload(ctx context.Context) error {
 	return nil
 }
 
+// StreamEvents replays the mock events, then returns the stream error if set
+// or blocks until ctx is cancelled
+func (m *MockOcctlManager) StreamEvents(ctx context.Context, fn func(Event)) error {
+	m.mu.Lock()
+	m.streamCalls++
+	events := m.events
+	streamErr := m.streamErr
+	m.mu.Unlock()
+
+	for _, event := range events {
+		fn(event)
+	}
+
+	if streamErr != nil {
+		return streamErr
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 // === Mock helpers for testing ===
 
 // AddUser adds a mock user to the list
@@ -249,6 +272,22 @@ func (m *MockOcctlManager) GetDisconnectedUsers() []string {
 	return result
 }
 
+// SetEvents sets the events replayed by StreamEvents and the error returned
+// after them (nil keeps the stream open)
+func (m *MockOcctlManager) SetEvents(events []Event, streamErr error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = events
+	m.streamErr = streamErr
+}
+
+// GetStreamCalls returns how many times StreamEvents was called
+func (m *MockOcctlManager) GetStreamCalls() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.streamCalls
+}
+
 // WasReloadCalled returns whether ReloadServer was called
 func (m *MockOcctlManager) WasReloadCalled() bool {
 	m.mu.RLock()
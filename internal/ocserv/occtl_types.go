@@ -171,6 +171,13 @@ type IPBanPoints struct {
 	Events       []string  `json:"events,omitempty"`
 }
 
+// Event types reported by 'show events'
+const (
+	EventConnect     = "connect"
+	EventDisconnect  = "disconnect"
+	EventAuthFailure = "auth-failure"
+)
+
 // Event represents a connection event from 'show events' command (streaming)
 type Event struct {
 	Timestamp time.Time `json:"timestamp"`
@@ -180,4 +187,7 @@ type Event struct {
 	SessionID string    `json:"session_id,omitempty"`
 	Reason    string    `json:"reason,omitempty"`
 	Details   string    `json:"details,omitempty"`
+
+	// User is the full user record when the event carries one
+	User *User `json:"-"`
 }
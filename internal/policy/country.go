@@ -0,0 +1,105 @@
+package policy
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cockroachdb/errors"
+)
+
+// CountryTable is a CountryResolver backed by a list of networks.
+// Lookups return the country of the most specific network containing
+// the address.
+type CountryTable struct {
+	networks map[netip.Prefix]string
+	lengths4 []int // IPv4 prefix lengths present, longest first
+	lengths6 []int // IPv6 prefix lengths present, longest first
+}
+
+// LoadCountryTable reads a country database with one network per line:
+//
+//	<CIDR> <country code>
+//
+// Fields may be separated by whitespace or a comma; empty lines and lines
+// starting with '#' are ignored.
+func LoadCountryTable(path string) (*CountryTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open country database")
+	}
+	defer f.Close()
+
+	table := &CountryTable{networks: make(map[netip.Prefix]string)}
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) != 2 || len(fields[1]) != 2 {
+			return nil, errors.Newf("country database line %d: expected \"<CIDR> <country code>\"", line)
+		}
+
+		prefix, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "country database line %d", line)
+		}
+		table.add(prefix, fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read country database")
+	}
+
+	return table, nil
+}
+
+// add maps a network to a country code
+func (t *CountryTable) add(prefix netip.Prefix, code string) {
+	prefix = prefix.Masked()
+	t.networks[prefix] = strings.ToUpper(code)
+
+	lengths := &t.lengths4
+	if prefix.Addr().Is6() {
+		lengths = &t.lengths6
+	}
+	if !slices.Contains(*lengths, prefix.Bits()) {
+		*lengths = append(*lengths, prefix.Bits())
+		slices.Sort(*lengths)
+		slices.Reverse(*lengths)
+	}
+}
+
+// Country returns the country code of addr, empty if unknown
+func (t *CountryTable) Country(addr netip.Addr) string {
+	addr = addr.Unmap()
+	lengths := t.lengths4
+	if addr.Is6() {
+		lengths = t.lengths6
+	}
+
+	for _, bits := range lengths {
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if code, ok := t.networks[prefix]; ok {
+			return code
+		}
+	}
+	return ""
+}
+
+// Len returns the number of networks in the table
+func (t *CountryTable) Len() int {
+	return len(t.networks)
+}
@@ -0,0 +1,210 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gopkg.in/yaml.v3"
+)
+
+// file is the format of the rules file
+type file struct {
+	Rules []RuleSpec `yaml:"rules"`
+}
+
+// EngineConfig configures the policy engine
+type EngineConfig struct {
+	Path           string        // rules file
+	CountryFile    string        // optional country database (see LoadCountryTable)
+	ReloadInterval time.Duration // how often the files are checked for changes
+	Logger         *slog.Logger
+	Meter          metric.Meter
+}
+
+// Engine evaluates the policy of a rules file and reloads it when the
+// rules file or the country database change. A file that fails to load
+// is reported and the previous policy stays in effect.
+type Engine struct {
+	path           string
+	countryFile    string
+	reloadInterval time.Duration
+	logger         *slog.Logger
+
+	policy atomic.Pointer[Policy]
+	stamps []fileStamp // of the loaded files, in load order
+
+	reloadsTotal metric.Int64Counter
+	rulesGauge   metric.Int64Gauge
+}
+
+// NewEngine creates an engine and loads the rules file
+func NewEngine(cfg EngineConfig) (*Engine, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("rules file is required")
+	}
+	if cfg.Logger == nil {
+		return nil, errors.New("logger is required")
+	}
+	if cfg.Meter == nil {
+		return nil, errors.New("meter is required")
+	}
+	if cfg.ReloadInterval == 0 {
+		cfg.ReloadInterval = 30 * time.Second
+	}
+
+	reloadsTotal, err := cfg.Meter.Int64Counter(
+		"policy.reloads.total",
+		metric.WithDescription("Total number of local policy reloads"),
+		metric.WithUnit("{reload}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create reloads counter")
+	}
+
+	rulesGauge, err := cfg.Meter.Int64Gauge(
+		"policy.rules",
+		metric.WithDescription("Number of rules in the loaded local policy"),
+		metric.WithUnit("{rule}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create rules gauge")
+	}
+
+	e := &Engine{
+		path:           cfg.Path,
+		countryFile:    cfg.CountryFile,
+		reloadInterval: cfg.ReloadInterval,
+		logger:         cfg.Logger,
+		reloadsTotal:   reloadsTotal,
+		rulesGauge:     rulesGauge,
+	}
+
+	stamps, err := e.fileStamps()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := e.load()
+	if err != nil {
+		return nil, err
+	}
+	e.policy.Store(policy)
+	e.stamps = stamps
+	e.rulesGauge.Record(context.Background(), int64(policy.Len()))
+
+	return e, nil
+}
+
+// Evaluate evaluates the current policy
+func (e *Engine) Evaluate(req Request) Decision {
+	return e.policy.Load().Evaluate(req)
+}
+
+// Run reloads the policy whenever its files change, until ctx is done
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.reloadIfChanged(ctx)
+		}
+	}
+}
+
+// reloadIfChanged reloads the policy if a file was modified or replaced
+// since the last load attempt
+func (e *Engine) reloadIfChanged(ctx context.Context) {
+	stamps, err := e.fileStamps()
+	if err != nil {
+		e.logger.WarnContext(ctx, "failed to check local policy files",
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	if slices.Equal(stamps, e.stamps) {
+		return
+	}
+	// Remembered even if loading fails, so a broken file is reported once
+	e.stamps = stamps
+
+	policy, err := e.load()
+	if err != nil {
+		e.reloadsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "error")))
+		e.logger.ErrorContext(ctx, "failed to reload local policy, keeping previous rules",
+			slog.String("path", e.path),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	e.policy.Store(policy)
+	e.reloadsTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("result", "success")))
+	e.rulesGauge.Record(ctx, int64(policy.Len()))
+	e.logger.InfoContext(ctx, "local policy reloaded",
+		slog.String("path", e.path),
+		slog.Int("rules", policy.Len()),
+	)
+}
+
+// load reads and compiles the rules file and the country database
+func (e *Engine) load() (*Policy, error) {
+	var countries CountryResolver
+	if e.countryFile != "" {
+		table, err := LoadCountryTable(e.countryFile)
+		if err != nil {
+			return nil, err
+		}
+		countries = table
+	}
+
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read rules file")
+	}
+
+	// Unknown keys are rejected: a misspelled criterion would otherwise
+	// be ignored and widen its rule
+	var f file
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "parse rules file")
+	}
+
+	return New(f.Rules, countries)
+}
+
+// fileStamp identifies a version of a policy file
+type fileStamp struct {
+	modTime int64 // unix nanoseconds
+	size    int64
+}
+
+// fileStamps returns the stamps of the policy files. Files are compared
+// one by one: a replaced file may be older than the other one.
+func (e *Engine) fileStamps() ([]fileStamp, error) {
+	var stamps []fileStamp
+	for _, path := range []string{e.path, e.countryFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "stat policy file")
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()})
+	}
+	return stamps, nil
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+)
+
+func newTestEngine(t *testing.T, path string) *Engine {
+	t.Helper()
+
+	e, err := NewEngine(EngineConfig{
+		Path:   path,
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError + 1})),
+		Meter:  metricnoop.NewMeterProvider().Meter("test"),
+	})
+	require.NoError(t, err)
+	return e
+}
+
+// writeRules writes a rules file with a distinct modification time
+func writeRules(t *testing.T, path, rules string, modTime time.Time) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestEngineReloadsChangedRules(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	start := time.Now().Add(-time.Hour)
+	writeRules(t, path, `
+rules:
+  - name: blocked
+    users: ["mallory"]
+    action: deny
+`, start)
+
+	e := newTestEngine(t, path)
+	req := Request{Username: "alice", GroupName: "staff"}
+	assert.Equal(t, ActionDefer, e.Evaluate(req).Action)
+
+	writeRules(t, path, `
+rules:
+  - name: blocked
+    users: ["mallory", "alice"]
+    action: deny
+`, start.Add(time.Minute))
+	e.reloadIfChanged(ctx)
+	assert.Equal(t, ActionDeny, e.Evaluate(req).Action)
+
+	// An invalid file keeps the previous rules
+	writeRules(t, path, `
+rules:
+  - name: blocked
+    action: reject
+`, start.Add(2*time.Minute))
+	e.reloadIfChanged(ctx)
+	assert.Equal(t, ActionDeny, e.Evaluate(req).Action)
+}
+
+func TestNewEngineRejectsInvalidRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	writeRules(t, path, "rules:\n  - action: deny\n", time.Now())
+
+	_, err := NewEngine(EngineConfig{
+		Path:   path,
+		Logger: slog.Default(),
+		Meter:  metricnoop.NewMeterProvider().Meter("test"),
+	})
+	assert.ErrorContains(t, err, "name is required")
+}
+
+func TestNewEngineRejectsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	writeRules(t, path, `
+rules:
+  - name: office
+    netwroks: ["198.51.100.0/24"]
+    action: deny
+`, time.Now())
+
+	_, err := NewEngine(EngineConfig{
+		Path:   path,
+		Logger: slog.Default(),
+		Meter:  metricnoop.NewMeterProvider().Meter("test"),
+	})
+	assert.ErrorContains(t, err, "field netwroks not found")
+}
+
+func TestEngineReloadsReplacedOlderFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	countryFile := filepath.Join(dir, "countries.txt")
+	start := time.Now().Add(-time.Hour)
+
+	writeRules(t, path, `
+rules:
+  - name: blocked
+    users: ["mallory"]
+    action: deny
+`, start)
+	writeRules(t, countryFile, "198.51.100.0/24 DE\n", start.Add(time.Minute))
+
+	e, err := NewEngine(EngineConfig{
+		Path:        path,
+		CountryFile: countryFile,
+		Logger:      slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError + 1})),
+		Meter:       metricnoop.NewMeterProvider().Meter("test"),
+	})
+	require.NoError(t, err)
+	req := Request{Username: "alice", GroupName: "staff"}
+	require.Equal(t, ActionDefer, e.Evaluate(req).Action)
+
+	// A staged file copied with its original, older modification time
+	writeRules(t, path, `
+rules:
+  - name: blocked
+    users: ["mallory", "alice"]
+    action: deny
+`, start.Add(-time.Hour))
+	e.reloadIfChanged(ctx)
+	assert.Equal(t, ActionDeny, e.Evaluate(req).Action)
+}
@@ -0,0 +1,229 @@
+// Package policy implements a local access policy evaluated by the agent.
+//
+// The policy is read from a rules file and consulted by the IPC handler
+// before the portal, to reject hard denies without a portal round trip,
+// and instead of the portal while it is unreachable, so connections are
+// decided deterministically during outages.
+package policy
+
+import (
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
+)
+
+// Action is the outcome of a policy rule
+type Action string
+
+const (
+	// ActionAllow admits the connection when the portal is unreachable;
+	// while the portal is reachable it still makes the decision
+	ActionAllow Action = "allow"
+	// ActionDeny rejects the connection without consulting the portal
+	ActionDeny Action = "deny"
+	// ActionDefer leaves the decision to the portal, or to the fail mode
+	// when the portal is unreachable
+	ActionDefer Action = "defer"
+)
+
+// Rule is a compiled policy rule.
+// A connection matches when every non-empty criterion matches; within a
+// criterion any entry may match.
+type Rule struct {
+	Name      string
+	Users     []string       // username patterns (path.Match syntax)
+	Groups    []string       // group name patterns (path.Match syntax)
+	Networks  []netip.Prefix // client IP networks
+	Countries []string       // ISO 3166-1 alpha-2 codes of the client IP
+	Windows   []*schedule.Window
+	Action    Action
+	Reason    string // deny reason reported to the client
+}
+
+// RuleSpec is the unparsed form of a Rule, as read from the rules file
+type RuleSpec struct {
+	Name      string   `yaml:"name"`
+	Users     []string `yaml:"users"`
+	Groups    []string `yaml:"groups"`
+	Networks  []string `yaml:"networks"`
+	Countries []string `yaml:"countries"`
+	Windows   []string `yaml:"windows"`
+	Action    string   `yaml:"action"`
+	Reason    string   `yaml:"reason"`
+}
+
+// Request describes the connection being evaluated
+type Request struct {
+	Username  string
+	GroupName string
+	ClientIP  string
+	Time      time.Time
+}
+
+// Decision is the result of evaluating the policy
+type Decision struct {
+	Action Action
+	Rule   string // name of the matching rule, empty if none matched
+	Reason string
+}
+
+// CountryResolver maps client addresses to ISO 3166-1 alpha-2 country codes
+type CountryResolver interface {
+	// Country returns the country code of addr, empty if unknown
+	Country(addr netip.Addr) string
+}
+
+// Policy is an ordered list of rules; the first matching rule applies and
+// connections matching no rule are deferred to the portal
+type Policy struct {
+	rules     []*Rule
+	countries CountryResolver
+}
+
+// New compiles rule specifications. countries may be nil if no rule
+// matches on countries.
+func New(specs []RuleSpec, countries CountryResolver) (*Policy, error) {
+	rules := make([]*Rule, 0, len(specs))
+
+	for i, spec := range specs {
+		name := spec.Name
+		if name == "" {
+			return nil, errors.Newf("rule %d: name is required", i)
+		}
+
+		action := Action(spec.Action)
+		switch action {
+		case ActionAllow, ActionDeny, ActionDefer:
+		default:
+			return nil, errors.Newf("rule %s: action must be allow, deny or defer, got %q", name, spec.Action)
+		}
+
+		for _, pattern := range append(append([]string{}, spec.Users...), spec.Groups...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "rule %s: invalid pattern %q", name, pattern)
+			}
+		}
+
+		// A rule without criteria would match every connection, which a
+		// misspelled key must not silently turn into
+		if len(spec.Users)+len(spec.Groups)+len(spec.Networks)+len(spec.Countries)+len(spec.Windows) == 0 {
+			return nil, errors.Newf("rule %s: at least one of users, groups, networks, countries or windows is required", name)
+		}
+
+		if len(spec.Countries) > 0 && countries == nil {
+			return nil, errors.Newf("rule %s: countries require a country database", name)
+		}
+
+		rule := &Rule{
+			Name:   name,
+			Users:  spec.Users,
+			Groups: spec.Groups,
+			Action: action,
+			Reason: spec.Reason,
+		}
+		for _, cidr := range spec.Networks {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %s: invalid network", name)
+			}
+			rule.Networks = append(rule.Networks, prefix.Masked())
+		}
+		for _, code := range spec.Countries {
+			if len(code) != 2 {
+				return nil, errors.Newf("rule %s: invalid country code %q", name, code)
+			}
+			rule.Countries = append(rule.Countries, strings.ToUpper(code))
+		}
+		for _, ws := range spec.Windows {
+			w, err := schedule.ParseWindow(ws)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %s", name)
+			}
+			rule.Windows = append(rule.Windows, w)
+		}
+		rules = append(rules, rule)
+	}
+
+	return &Policy{rules: rules, countries: countries}, nil
+}
+
+// Evaluate returns the decision of the first rule matching the request
+func (p *Policy) Evaluate(req Request) Decision {
+	addr, _ := netip.ParseAddr(req.ClientIP)
+	addr = addr.Unmap()
+
+	var country string
+	if p.countries != nil && addr.IsValid() {
+		country = p.countries.Country(addr)
+	}
+
+	for _, rule := range p.rules {
+		if rule.matches(req, addr, country) {
+			return Decision{Action: rule.Action, Rule: rule.Name, Reason: rule.Reason}
+		}
+	}
+	return Decision{Action: ActionDefer}
+}
+
+// Len returns the number of rules
+func (p *Policy) Len() int {
+	return len(p.rules)
+}
+
+// matches reports whether every criterion of the rule matches the request
+func (r *Rule) matches(req Request, addr netip.Addr, country string) bool {
+	if len(r.Users) > 0 && !matchAny(r.Users, req.Username) {
+		return false
+	}
+	if len(r.Groups) > 0 && !matchAny(r.Groups, req.GroupName) {
+		return false
+	}
+	if len(r.Networks) > 0 && !containsAddr(r.Networks, addr) {
+		return false
+	}
+	if len(r.Countries) > 0 && (country == "" || !slices.Contains(r.Countries, country)) {
+		return false
+	}
+	if len(r.Windows) > 0 && !inWindow(r.Windows, req.Time) {
+		return false
+	}
+	return true
+}
+
+// matchAny reports whether name matches any of the patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddr reports whether addr is in any of the networks
+func containsAddr(networks []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// inWindow reports whether t falls within any of the windows
+func inWindow(windows []*schedule.Window, t time.Time) bool {
+	for _, w := range windows {
+		if ok, _ := w.Contains(t); ok {
+			return true
+		}
+	}
+	return false
+}
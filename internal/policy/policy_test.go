@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staticCountries is a CountryResolver backed by a map
+type staticCountries map[string]string
+
+func (c staticCountries) Country(addr netip.Addr) string {
+	return c[addr.String()]
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	p, err := New([]RuleSpec{
+		{Name: "blocked", Users: []string{"mallory", "ex-*"}, Action: "deny", Reason: "account disabled"},
+		{Name: "embargo", Countries: []string{"kp"}, Action: "deny"},
+		{Name: "contractors-hours", Groups: []string{"contractors"}, Windows: []string{"weekdays 08:00-20:00 UTC"}, Action: "allow"},
+		{Name: "contractors", Groups: []string{"contractors"}, Action: "deny", Reason: "outside contractor hours"},
+		{Name: "office", Groups: []string{"staff"}, Networks: []string{"198.51.100.0/24"}, Action: "allow"},
+		{Name: "portal", Groups: []string{"staff"}, Action: "defer"},
+	}, staticCountries{"203.0.113.66": "KP"})
+	require.NoError(t, err)
+
+	monday := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	sunday := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		req        Request
+		wantAction Action
+		wantRule   string
+	}{
+		{"user pattern", Request{Username: "ex-alice", GroupName: "staff", ClientIP: "198.51.100.1"}, ActionDeny, "blocked"},
+		{"country", Request{Username: "bob", GroupName: "staff", ClientIP: "203.0.113.66"}, ActionDeny, "embargo"},
+		{"inside window", Request{Username: "carol", GroupName: "contractors", ClientIP: "203.0.113.1", Time: monday}, ActionAllow, "contractors-hours"},
+		{"outside window", Request{Username: "carol", GroupName: "contractors", ClientIP: "203.0.113.1", Time: sunday}, ActionDeny, "contractors"},
+		{"group and network", Request{Username: "dave", GroupName: "staff", ClientIP: "198.51.100.7"}, ActionAllow, "office"},
+		{"ipv4-mapped address", Request{Username: "dave", GroupName: "staff", ClientIP: "::ffff:198.51.100.7"}, ActionAllow, "office"},
+		{"all criteria must match", Request{Username: "dave", GroupName: "staff", ClientIP: "203.0.113.1"}, ActionDefer, "portal"},
+		{"no match defers", Request{Username: "erin", GroupName: "guests", ClientIP: "203.0.113.1"}, ActionDefer, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := p.Evaluate(tt.req)
+			assert.Equal(t, tt.wantAction, decision.Action)
+			assert.Equal(t, tt.wantRule, decision.Rule)
+		})
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   RuleSpec
+		errMsg string
+	}{
+		{"missing name", RuleSpec{Action: "deny"}, "rule 0: name is required"},
+		{"no criteria", RuleSpec{Name: "r", Action: "allow"}, "rule r: at least one of users, groups, networks, countries or windows is required"},
+		{"invalid action", RuleSpec{Name: "r", Action: "reject"}, "action must be allow, deny or defer"},
+		{"invalid pattern", RuleSpec{Name: "r", Groups: []string{"["}, Action: "deny"}, "invalid pattern"},
+		{"invalid network", RuleSpec{Name: "r", Networks: []string{"300.0.0.0/8"}, Action: "deny"}, "invalid network"},
+		{"invalid window", RuleSpec{Name: "r", Windows: []string{"someday 08:00-20:00"}, Action: "deny"}, "rule r"},
+		{"countries without database", RuleSpec{Name: "r", Countries: []string{"DE"}, Action: "deny"}, "require a country database"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New([]RuleSpec{tt.spec}, nil)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestLoadCountryTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "countries.txt")
+	require.NoError(t, os.WriteFile(path, []byte(`# network country
+198.51.100.0/24 de
+198.51.100.128/25,FR
+
+2001:db8::/32	NL
+`), 0o600))
+
+	table, err := LoadCountryTable(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, table.Len())
+
+	assert.Equal(t, "DE", table.Country(netip.MustParseAddr("198.51.100.1")))
+	assert.Equal(t, "FR", table.Country(netip.MustParseAddr("198.51.100.200")), "most specific network wins")
+	assert.Equal(t, "DE", table.Country(netip.MustParseAddr("::ffff:198.51.100.1")))
+	assert.Equal(t, "NL", table.Country(netip.MustParseAddr("2001:db8::1")))
+	assert.Empty(t, table.Country(netip.MustParseAddr("203.0.113.1")))
+}
+
+func TestLoadCountryTableRejectsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "countries.txt")
+	require.NoError(t, os.WriteFile(path, []byte("198.51.100.0/24\n"), 0o600))
+
+	_, err := LoadCountryTable(path)
+	assert.ErrorContains(t, err, "line 1")
+}
@@ -9,11 +9,14 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// CheckPolicy validates user access policy via portal
-func (c *Client) CheckPolicy(ctx context.Context, username, groupName, clientIP string) (bool, string, error) {
+// CheckPolicy validates user access policy via portal.
+// The full decision is returned so the caller can apply the routes and
+// DNS servers the portal assigns to the connection.
+func (c *Client) CheckPolicy(ctx context.Context, username, groupName, clientIP string) (*vpnv1.CheckPolicyResponse, error) {
 	ctx, span := c.tracer.Start(ctx, "portal.check_policy",
 		trace.WithAttributes(
 			attribute.String("username", username),
@@ -23,13 +26,6 @@ func (c *Client) CheckPolicy(ctx context.Context, username, groupName, clientIP
 	)
 	defer span.End()
 
-	// Create auth service client
-	authClient := vpnv1.NewAuthServiceClient(c.conn)
-
-	// Apply timeout
-	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
-	defer cancel()
-
 	// Prepare request
 	req := &vpnv1.CheckPolicyRequest{
 		Username:    username,
@@ -44,12 +40,17 @@ func (c *Client) CheckPolicy(ctx context.Context, username, groupName, clientIP
 		"client_ip", clientIP,
 	)
 
-	// Call portal gRPC service
-	resp, err := authClient.CheckPolicy(ctx, req)
+	// Call portal gRPC service (retried through the circuit breaker)
+	var resp *vpnv1.CheckPolicyResponse
+	err := c.call(ctx, MethodCheckPolicy, func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = vpnv1.NewAuthServiceClient(conn).CheckPolicy(ctx, req)
+		return err
+	})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "policy check failed")
-		return false, "", errors.Wrap(err, "grpc CheckPolicy")
+		return nil, errors.Wrap(err, "grpc CheckPolicy")
 	}
 
 	// Record response
@@ -64,7 +65,7 @@ func (c *Client) CheckPolicy(ctx context.Context, username, groupName, clientIP
 			"username", username,
 			"reason", resp.DenyReason,
 		)
-		return false, resp.DenyReason, nil
+		return resp, nil
 	}
 
 	c.logger.InfoContext(ctx, "access allowed by portal",
@@ -73,12 +74,12 @@ func (c *Client) CheckPolicy(ctx context.Context, username, groupName, clientIP
 		"dns_count", len(resp.DnsServers),
 	)
 
-	// Return success (routes will be handled by caller if needed)
-	return true, "", nil
+	return resp, nil
 }
 
-// ValidateSession validates an active VPN session
-func (c *Client) ValidateSession(ctx context.Context, sessionID, username string) (bool, error) {
+// ValidateSession validates an active VPN session and returns the portal's
+// verdict, including whether the session must be disconnected
+func (c *Client) ValidateSession(ctx context.Context, sessionID, username, clientIP string) (*vpnv1.ValidateSessionResponse, error) {
 	ctx, span := c.tracer.Start(ctx, "portal.validate_session",
 		trace.WithAttributes(
 			attribute.String("session_id", sessionID),
@@ -87,31 +88,30 @@ func (c *Client) ValidateSession(ctx context.Context, sessionID, username string
 	)
 	defer span.End()
 
-	// Create auth service client
-	authClient := vpnv1.NewAuthServiceClient(c.conn)
-
-	// Apply timeout
-	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
-	defer cancel()
-
 	// Prepare request
 	req := &vpnv1.ValidateSessionRequest{
 		Username:    username,
 		SessionId:   sessionID,
+		ClientIp:    clientIP,
 		RequestTime: timestamppb.Now(),
 	}
 
-	c.logger.InfoContext(ctx, "validating session via portal",
+	c.logger.DebugContext(ctx, "validating session via portal",
 		"session_id", sessionID,
 		"username", username,
 	)
 
-	// Call portal gRPC service
-	resp, err := authClient.ValidateSession(ctx, req)
+	// Call portal gRPC service (retried through the circuit breaker)
+	var resp *vpnv1.ValidateSessionResponse
+	err := c.call(ctx, MethodValidateSession, func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = vpnv1.NewAuthServiceClient(conn).ValidateSession(ctx, req)
+		return err
+	})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "session validation failed")
-		return false, errors.Wrap(err, "grpc ValidateSession")
+		return nil, errors.Wrap(err, "grpc ValidateSession")
 	}
 
 	// Record response
@@ -129,11 +129,12 @@ func (c *Client) ValidateSession(ctx context.Context, sessionID, username string
 		)
 	}
 
-	return resp.Valid, nil
+	return resp, nil
 }
 
 // ReportConnect reports a new connection to portal
-func (c *Client) ReportConnect(ctx context.Context, sessionID, username, groupName, clientIP, vpnIP, device string) error {
+// connectedAt defaults to now when zero; metadata is passed through to the portal.
+func (c *Client) ReportConnect(ctx context.Context, sessionID, username, groupName, clientIP, vpnIP, device string, connectedAt time.Time, metadata map[string]string) error {
 	ctx, span := c.tracer.Start(ctx, "portal.report_connect",
 		trace.WithAttributes(
 			attribute.String("session_id", sessionID),
@@ -142,12 +143,9 @@ func (c *Client) ReportConnect(ctx context.Context, sessionID, username, groupNa
 	)
 	defer span.End()
 
-	// Create event service client
-	eventClient := vpnv1.NewEventServiceClient(c.conn)
-
-	// Apply timeout
-	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
-	defer cancel()
+	if connectedAt.IsZero() {
+		connectedAt = time.Now()
+	}
 
 	// Prepare request
 	req := &vpnv1.ReportConnectRequest{
@@ -156,8 +154,9 @@ func (c *Client) ReportConnect(ctx context.Context, sessionID, username, groupNa
 		ClientIp:    clientIP,
 		VpnIp:       vpnIP,
 		Device:      device,
-		ConnectedAt: timestamppb.Now(),
+		ConnectedAt: timestamppb.New(connectedAt),
 		Groupname:   groupName,
+		Metadata:    metadata,
 	}
 
 	c.logger.InfoContext(ctx, "reporting connection to portal",
@@ -165,8 +164,13 @@ func (c *Client) ReportConnect(ctx context.Context, sessionID, username, groupNa
 		"username", username,
 	)
 
-	// Call portal gRPC service
-	resp, err := eventClient.ReportConnect(ctx, req)
+	// Call portal gRPC service (retried through the circuit breaker)
+	var resp *vpnv1.ReportConnectResponse
+	err := c.call(ctx, MethodReportConnect, func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = vpnv1.NewEventServiceClient(conn).ReportConnect(ctx, req)
+		return err
+	})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "report connect failed")
@@ -182,29 +186,43 @@ func (c *Client) ReportConnect(ctx context.Context, sessionID, username, groupNa
 	return nil
 }
 
-// ReportDisconnect reports a disconnection to portal
-func (c *Client) ReportDisconnect(ctx context.Context, sessionID, username string, duration time.Duration, bytesRX, bytesTX uint64) error {
+// ReportDisconnect reports a disconnection to portal.
+// disconnectedAt defaults to now when zero. reason is empty for user-initiated
+// disconnects, otherwise it describes why the agent terminated the session.
+// metadata is passed through to the portal.
+func (c *Client) ReportDisconnect(ctx context.Context, sessionID, username string, disconnectedAt time.Time, duration time.Duration, bytesRX, bytesTX uint64, reason string, metadata map[string]string) error {
 	ctx, span := c.tracer.Start(ctx, "portal.report_disconnect",
 		trace.WithAttributes(
 			attribute.String("session_id", sessionID),
 			attribute.String("username", username),
+			attribute.String("reason", reason),
 		),
 	)
 	defer span.End()
 
-	// Create event service client
-	eventClient := vpnv1.NewEventServiceClient(c.conn)
+	// Agent-initiated disconnects carry their reason in metadata
+	disconnectReason := vpnv1.DisconnectReason_DISCONNECT_REASON_USER_INITIATED
+	if reason != "" {
+		disconnectReason = vpnv1.DisconnectReason_DISCONNECT_REASON_POLICY_VIOLATION
+		merged := make(map[string]string, len(metadata)+1)
+		for k, v := range metadata {
+			merged[k] = v
+		}
+		merged["reason"] = reason
+		metadata = merged
+	}
 
-	// Apply timeout
-	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
-	defer cancel()
+	if disconnectedAt.IsZero() {
+		disconnectedAt = time.Now()
+	}
 
 	// Prepare request
 	req := &vpnv1.ReportDisconnectRequest{
 		Username:       username,
 		SessionId:      sessionID,
-		DisconnectedAt: timestamppb.Now(),
-		Reason:         vpnv1.DisconnectReason_DISCONNECT_REASON_USER_INITIATED,
+		DisconnectedAt: timestamppb.New(disconnectedAt),
+		Reason:         disconnectReason,
+		Metadata:       metadata,
 		Stats: &vpnv1.SessionStats{
 			DurationSeconds: int64(duration.Seconds()),
 			BytesReceived:   bytesRX,
@@ -216,10 +234,16 @@ func (c *Client) ReportDisconnect(ctx context.Context, sessionID, username strin
 		"session_id", sessionID,
 		"username", username,
 		"duration", duration,
+		"reason", reason,
 	)
 
-	// Call portal gRPC service
-	resp, err := eventClient.ReportDisconnect(ctx, req)
+	// Call portal gRPC service (retried through the circuit breaker)
+	var resp *vpnv1.ReportDisconnectResponse
+	err := c.call(ctx, MethodReportDisconnect, func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = vpnv1.NewEventServiceClient(conn).ReportDisconnect(ctx, req)
+		return err
+	})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "report disconnect failed")
@@ -235,8 +259,9 @@ func (c *Client) ReportDisconnect(ctx context.Context, sessionID, username strin
 	return nil
 }
 
-// ReportSessionUpdate reports periodic session status update to portal
-func (c *Client) ReportSessionUpdate(ctx context.Context, sessionID, username string, status vpnv1.SessionStatus, bytesRX, bytesTX uint64) (bool, error) {
+// ReportSessionUpdate reports periodic session status update to portal.
+// Returns whether the portal requests the session to be disconnected and why.
+func (c *Client) ReportSessionUpdate(ctx context.Context, sessionID, username string, status vpnv1.SessionStatus, bytesRX, bytesTX uint64) (bool, string, error) {
 	ctx, span := c.tracer.Start(ctx, "portal.report_session_update",
 		trace.WithAttributes(
 			attribute.String("session_id", sessionID),
@@ -246,13 +271,6 @@ func (c *Client) ReportSessionUpdate(ctx context.Context, sessionID, username st
 	)
 	defer span.End()
 
-	// Create event service client
-	eventClient := vpnv1.NewEventServiceClient(c.conn)
-
-	// Apply timeout
-	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
-	defer cancel()
-
 	// Prepare request
 	req := &vpnv1.ReportSessionUpdateRequest{
 		Username:   username,
@@ -271,12 +289,17 @@ func (c *Client) ReportSessionUpdate(ctx context.Context, sessionID, username st
 		"status", status.String(),
 	)
 
-	// Call portal gRPC service
-	resp, err := eventClient.ReportSessionUpdate(ctx, req)
+	// Call portal gRPC service (retried through the circuit breaker)
+	var resp *vpnv1.ReportSessionUpdateResponse
+	err := c.call(ctx, MethodReportSessionUpdate, func(ctx context.Context, conn *grpc.ClientConn) error {
+		var err error
+		resp, err = vpnv1.NewEventServiceClient(conn).ReportSessionUpdate(ctx, req)
+		return err
+	})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "report session update failed")
-		return false, errors.Wrap(err, "grpc ReportSessionUpdate")
+		return false, "", errors.Wrap(err, "grpc ReportSessionUpdate")
 	}
 
 	// Record response
@@ -289,8 +312,9 @@ func (c *Client) ReportSessionUpdate(ctx context.Context, sessionID, username st
 		c.logger.WarnContext(ctx, "portal requests session disconnect",
 			"session_id", sessionID,
 			"username", username,
+			"reason", resp.DisconnectReason,
 		)
 	}
 
-	return resp.ShouldDisconnect, nil
+	return resp.ShouldDisconnect, resp.DisconnectReason, nil
 }
@@ -4,11 +4,14 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 	"time"
 
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -18,27 +21,61 @@ import (
 	"google.golang.org/grpc/keepalive"
 )
 
+// instrumentationName is the meter scope of portal client metrics
+const instrumentationName = "github.com/dantte-lp/ocserv-agent/internal/portal"
+
 // Client provides communication with the portal server
 type Client struct {
-	conn   *grpc.ClientConn
 	logger *slog.Logger
 	tracer trace.Tracer
 	config *Config
+
+	metrics *clientMetrics
+
+	// endpoints are sorted by preference: local datacenter first,
+	// then configuration order
+	endpoints []*endpoint
+
+	mu     sync.Mutex
+	active *endpoint // endpoint currently receiving traffic
+
+	stopHealth context.CancelFunc
+	healthDone chan struct{}
 }
 
 // Config configures the portal client
 type Config struct {
-	Address  string
-	TLSCert  string
-	TLSKey   string
-	TLSCA    string
-	Timeout  time.Duration
-	Insecure bool
+	Address   string     // single portal endpoint, used when Endpoints is empty
+	Endpoints []Endpoint // portal replicas in preference order
+	TLSCert   string
+	TLSKey    string
+	TLSCA     string
+	Timeout   time.Duration // per-attempt timeout
+	Insecure  bool
+
+	// LocalDatacenter moves endpoints of this datacenter ahead of the others
+	LocalDatacenter string
+	// Selection is SelectionOrdered (default) or SelectionWeighted
+	Selection string
+	// HealthCheckInterval is how often endpoints are probed (default 10s)
+	HealthCheckInterval time.Duration
+	// FailbackPeriod is how long traffic takes to shift back to a
+	// recovered, more preferred endpoint (default 1m)
+	FailbackPeriod time.Duration
+
+	// CircuitBreaker configures the breaker of every endpoint (nil disables it)
+	CircuitBreaker *resilience.Config
+	// Retry maps method names (MethodCheckPolicy, ...) to their retry
+	// policy; nil means DefaultRetryPolicies
+	Retry map[string]RetryPolicy
 }
 
 // NewClient creates a new portal client
 func NewClient(ctx context.Context, cfg *Config, logger *slog.Logger, tracer trace.Tracer, tracerProvider trace.TracerProvider, meterProvider metric.MeterProvider) (*Client, error) {
-	if cfg.Address == "" {
+	if len(cfg.Endpoints) == 0 && cfg.Address != "" {
+		cfg.Endpoints = []Endpoint{{Address: cfg.Address}}
+	}
+	if len(cfg.Endpoints) == 0 {
 		return nil, fmt.Errorf("portal address is required")
 	}
 	if logger == nil {
@@ -52,6 +89,27 @@ func NewClient(ctx context.Context, cfg *Config, logger *slog.Logger, tracer tra
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 10 * time.Second
 	}
+	if cfg.Retry == nil {
+		cfg.Retry = DefaultRetryPolicies()
+	}
+	if cfg.Selection == "" {
+		cfg.Selection = SelectionOrdered
+	}
+	if cfg.Selection != SelectionOrdered && cfg.Selection != SelectionWeighted {
+		return nil, fmt.Errorf("unknown endpoint selection %q", cfg.Selection)
+	}
+	if cfg.HealthCheckInterval == 0 {
+		cfg.HealthCheckInterval = 10 * time.Second
+	}
+	if cfg.FailbackPeriod == 0 {
+		cfg.FailbackPeriod = time.Minute
+	}
+
+	meter := meterProvider.Meter(instrumentationName)
+	metrics, err := newClientMetrics(meter)
+	if err != nil {
+		return nil, fmt.Errorf("create metrics: %w", err)
+	}
 
 	// Prepare dial options
 	var opts []grpc.DialOption
@@ -84,31 +142,175 @@ func NewClient(ctx context.Context, cfg *Config, logger *slog.Logger, tracer tra
 		PermitWithoutStream: true,
 	}))
 
-	// Dial portal
-	conn, err := grpc.NewClient(cfg.Address, opts...)
-	if err != nil {
-		return nil, fmt.Errorf("dial portal: %w", err)
+	c := &Client{
+		logger:  logger,
+		tracer:  tracer,
+		config:  cfg,
+		metrics: metrics,
 	}
 
-	logger.InfoContext(ctx, "portal client connected",
-		slog.String("address", cfg.Address),
-		slog.Bool("tls", !cfg.Insecure),
+	// Dial every portal endpoint
+	for _, ep := range sortEndpoints(cfg.Endpoints, cfg.LocalDatacenter) {
+		e := &endpoint{
+			Endpoint: ep,
+			local:    cfg.LocalDatacenter != "" && ep.Datacenter == cfg.LocalDatacenter,
+			healthy:  true,
+		}
+		if e.Weight <= 0 {
+			e.Weight = 1
+		}
+
+		e.conn, err = grpc.NewClient(ep.Address, opts...)
+		if err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("dial portal %s: %w", ep.Address, err)
+		}
+		c.endpoints = append(c.endpoints, e)
+
+		if cfg.CircuitBreaker != nil {
+			e.breaker, err = c.newBreaker(ep.Address, *cfg.CircuitBreaker, tracer, meter)
+			if err != nil {
+				_ = c.Close()
+				return nil, fmt.Errorf("create circuit breaker for %s: %w", ep.Address, err)
+			}
+		}
+	}
+	c.active = c.endpoints[0]
+
+	if err := metrics.observeEndpoints(meter, c); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("create endpoint metrics: %w", err)
+	}
+
+	for _, e := range c.endpoints {
+		logger.InfoContext(ctx, "portal client connected",
+			slog.String("address", e.Address),
+			slog.String("datacenter", e.Datacenter),
+			slog.Bool("local", e.local),
+			slog.Bool("tls", !cfg.Insecure),
+			slog.Bool("circuit_breaker", e.breaker != nil),
+		)
+	}
+	logger.InfoContext(ctx, "portal active endpoint",
+		slog.String("endpoint", c.active.Address),
+		slog.String("datacenter", c.active.Datacenter),
 	)
 
-	return &Client{
-		conn:   conn,
-		logger: logger,
-		tracer: tracer,
-		config: cfg,
-	}, nil
+	// Probe endpoints so the client can fail back after an outage
+	healthCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	c.stopHealth = cancel
+	c.healthDone = make(chan struct{})
+	go c.runHealthChecks(healthCtx)
+
+	return c, nil
 }
 
-// Close closes the portal client connection
+// CheckHealth reports the active endpoint and how many endpoints are
+// usable. The client is unhealthy when no endpoint is, i.e. the portal
+// is considered down and calls fail fast.
+func (c *Client) CheckHealth() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	usable := 0
+	for _, e := range c.endpoints {
+		if c.usable(e) {
+			usable++
+		}
+	}
+
+	check := fmt.Sprintf("active %s, %d/%d endpoints healthy", c.active.Address, usable, len(c.endpoints))
+	if b := c.active.breaker; b != nil {
+		check += ", circuit breaker " + b.State().String()
+	}
+	return check, usable > 0
+}
+
+// BreakerDisabled is the breaker state reported for endpoints without a
+// circuit breaker
+const BreakerDisabled = "disabled"
+
+// EndpointHealth is the health of one portal endpoint
+type EndpointHealth struct {
+	Address string
+	Healthy bool   // passing health checks
+	Breaker string // circuit breaker state: closed, open, half_open or disabled
+}
+
+// Endpoints reports the health of every endpoint in selection order
+func (c *Client) Endpoints() []EndpointHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	endpoints := make([]EndpointHealth, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		health := EndpointHealth{Address: e.Address, Healthy: e.healthy, Breaker: BreakerDisabled}
+		if e.breaker != nil {
+			health.Breaker = e.breaker.State().String()
+		}
+		endpoints = append(endpoints, health)
+	}
+	return endpoints
+}
+
+// AllBreakersOpen reports whether the circuit breaker of every endpoint is
+// open, i.e. the agent cannot reach the portal until one of them probes
+// successfully. It is false when breakers are disabled.
+func AllBreakersOpen(endpoints []EndpointHealth) bool {
+	if len(endpoints) == 0 {
+		return false
+	}
+	for _, e := range endpoints {
+		if e.Breaker != resilience.StateOpen.String() {
+			return false
+		}
+	}
+	return true
+}
+
+// BreakerChecks reports the circuit breaker state of every endpoint as
+// health checks. It returns false, i.e. the agent is degraded, when every
+// breaker is open.
+func (c *Client) BreakerChecks() (map[string]string, bool) {
+	return BreakerChecks(c.Endpoints())
+}
+
+// BreakerChecks reports the circuit breaker state of endpoints as health
+// checks keyed by "portal_breaker:<address>", plus an overall "portal" check
+func BreakerChecks(endpoints []EndpointHealth) (map[string]string, bool) {
+	checks := make(map[string]string, len(endpoints)+1)
+	for _, e := range endpoints {
+		checks["portal_breaker:"+e.Address] = e.Breaker
+	}
+	if AllBreakersOpen(endpoints) {
+		checks["portal"] = "degraded"
+		return checks, false
+	}
+	checks["portal"] = "ok"
+	return checks, true
+}
+
+// ActiveEndpoint returns the address of the endpoint receiving traffic
+func (c *Client) ActiveEndpoint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active.Address
+}
+
+// Close stops endpoint health checks and closes the portal connections
 func (c *Client) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	if c.stopHealth != nil {
+		c.stopHealth()
+		<-c.healthDone
+	}
+
+	var errs []error
+	for _, e := range c.endpoints {
+		if err := e.conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 // loadTLSConfig loads mTLS configuration
@@ -0,0 +1,325 @@
+package portal
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Endpoint selection strategies
+const (
+	// SelectionOrdered sends all traffic to the first healthy endpoint
+	SelectionOrdered = "ordered"
+	// SelectionWeighted spreads traffic over the healthy endpoints of the
+	// most preferred tier (local datacenter, then the rest) by weight
+	SelectionWeighted = "weighted"
+)
+
+// Endpoint is a portal replica
+type Endpoint struct {
+	Address    string
+	Datacenter string
+	Weight     int // share within its tier for SelectionWeighted (default 1)
+}
+
+// endpoint is a dialed portal replica with its health state
+type endpoint struct {
+	Endpoint
+
+	local   bool
+	conn    *grpc.ClientConn
+	breaker *resilience.CircuitBreaker // nil if disabled
+
+	// Guarded by Client.mu
+	healthy     bool
+	recoveredAt time.Time // start of the failback ramp (zero when fully ramped up)
+}
+
+// sortEndpoints moves endpoints of the local datacenter to the front,
+// keeping the configured order otherwise
+func sortEndpoints(endpoints []Endpoint, localDatacenter string) []Endpoint {
+	sorted := slices.Clone(endpoints)
+	if localDatacenter == "" {
+		return sorted
+	}
+	slices.SortStableFunc(sorted, func(a, b Endpoint) int {
+		aLocal, bLocal := a.Datacenter == localDatacenter, b.Datacenter == localDatacenter
+		switch {
+		case aLocal == bLocal:
+			return 0
+		case aLocal:
+			return -1
+		default:
+			return 1
+		}
+	})
+	return sorted
+}
+
+// newBreaker creates the circuit breaker of an endpoint
+func (c *Client) newBreaker(address string, cfg resilience.Config, tracer trace.Tracer, meter metric.Meter) (*resilience.CircuitBreaker, error) {
+	cfg.Name = address
+	onStateChange := cfg.OnStateChange
+	cfg.OnStateChange = func(from, to resilience.State) {
+		c.logger.Warn("portal circuit breaker state changed",
+			slog.String("endpoint", address),
+			slog.String("from", from.String()),
+			slog.String("to", to.String()),
+		)
+		if onStateChange != nil {
+			onStateChange(from, to)
+		}
+	}
+	return resilience.NewCircuitBreaker(cfg, tracer, meter)
+}
+
+// usable reports whether e may receive traffic. Caller must hold c.mu.
+func (c *Client) usable(e *endpoint) bool {
+	return e.healthy && (e.breaker == nil || e.breaker.State() != resilience.StateOpen)
+}
+
+// ramp returns the share of its traffic a recovered endpoint takes back,
+// growing linearly from 0 to 1 over the failback period. Caller must hold c.mu.
+func (c *Client) ramp(e *endpoint, now time.Time) float64 {
+	if e.recoveredAt.IsZero() {
+		return 1
+	}
+	share := float64(now.Sub(e.recoveredAt)) / float64(c.config.FailbackPeriod)
+	if share >= 1 {
+		e.recoveredAt = time.Time{}
+		return 1
+	}
+	return share
+}
+
+// tiers groups endpoints by preference. Each ordered endpoint is its own
+// tier; weighted endpoints share a tier with their datacenter locality.
+func (c *Client) tiers() [][]*endpoint {
+	if c.config.Selection != SelectionWeighted {
+		tiers := make([][]*endpoint, 0, len(c.endpoints))
+		for _, e := range c.endpoints {
+			tiers = append(tiers, []*endpoint{e})
+		}
+		return tiers
+	}
+
+	var local, remote []*endpoint
+	for _, e := range c.endpoints {
+		if e.local {
+			local = append(local, e)
+		} else {
+			remote = append(remote, e)
+		}
+	}
+	return slices.DeleteFunc([][]*endpoint{local, remote}, func(tier []*endpoint) bool {
+		return len(tier) == 0
+	})
+}
+
+// pick selects the endpoint of the next attempt.
+//
+// The most preferred tier with a usable endpoint wins. An endpoint that
+// recovered recently only takes its ramped share of requests; the rest
+// continue to the next tier, so traffic fails back gradually.
+func (c *Client) pick() *endpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, tier := range c.tiers() {
+		var full, share float64
+		for _, e := range tier {
+			if c.usable(e) {
+				full += float64(e.Weight)
+				share += float64(e.Weight) * c.ramp(e, now)
+			}
+		}
+		if full == 0 {
+			continue
+		}
+
+		r := rand.Float64() * full
+		if r >= share {
+			continue
+		}
+		for _, e := range tier {
+			if !c.usable(e) {
+				continue
+			}
+			if r -= float64(e.Weight) * c.ramp(e, now); r < 0 {
+				return e
+			}
+		}
+	}
+
+	// Only ramping endpoints are left, or none is usable
+	for _, e := range c.endpoints {
+		if c.usable(e) {
+			return e
+		}
+	}
+	return c.active
+}
+
+// markSuccess records a successful call to e
+func (c *Client) markSuccess(e *endpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case !e.healthy:
+		c.setHealthy(e, true, "request succeeded")
+	case e != c.active && !c.usable(c.active):
+		// The active endpoint's circuit opened
+		c.elect("circuit breaker open")
+	}
+}
+
+// markFailure records a transient failure of e and fails over
+func (c *Client) markFailure(e *endpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e.healthy {
+		c.setHealthy(e, false, "request failed")
+	}
+}
+
+// setHealthy changes the health of e and re-elects the active endpoint.
+// Caller must hold c.mu.
+func (c *Client) setHealthy(e *endpoint, healthy bool, reason string) {
+	e.healthy = healthy
+	e.recoveredAt = time.Time{}
+	if healthy {
+		e.recoveredAt = time.Now()
+		c.logger.Info("portal endpoint recovered",
+			slog.String("endpoint", e.Address),
+			slog.String("reason", reason),
+			slog.Duration("failback_period", c.config.FailbackPeriod),
+		)
+	} else {
+		c.logger.Warn("portal endpoint unhealthy",
+			slog.String("endpoint", e.Address),
+			slog.String("reason", reason),
+		)
+	}
+	c.elect(reason)
+}
+
+// elect updates the active endpoint: the most preferred usable endpoint
+// that is fully ramped up (the highest weighted one within a tier).
+// Caller must hold c.mu.
+func (c *Client) elect(reason string) {
+	now := time.Now()
+	var next *endpoint
+	for _, tier := range c.tiers() {
+		for _, e := range tier {
+			if c.usable(e) && c.ramp(e, now) == 1 && (next == nil || e.Weight > next.Weight) {
+				next = e
+			}
+		}
+		if next != nil {
+			break
+		}
+	}
+	if next == nil {
+		for _, e := range c.endpoints {
+			if c.usable(e) {
+				next = e
+				break
+			}
+		}
+	}
+	if next == nil || next == c.active {
+		return
+	}
+
+	c.logger.Warn("portal active endpoint changed",
+		slog.String("from", c.active.Address),
+		slog.String("to", next.Address),
+		slog.String("datacenter", next.Datacenter),
+		slog.String("reason", reason),
+	)
+	if c.metrics != nil {
+		c.metrics.failovers.Add(context.Background(), 1, metric.WithAttributes(
+			attribute.String("from", c.active.Address),
+			attribute.String("to", next.Address),
+		))
+	}
+	c.active = next
+}
+
+// runHealthChecks probes all endpoints until ctx is cancelled
+func (c *Client) runHealthChecks(ctx context.Context) {
+	defer close(c.healthDone)
+
+	ticker := time.NewTicker(c.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkEndpoints(ctx)
+		}
+	}
+}
+
+// checkEndpoints probes every endpoint concurrently and applies the results
+func (c *Client) checkEndpoints(ctx context.Context) {
+	results := make([]error, len(c.endpoints))
+
+	var wg sync.WaitGroup
+	for i, e := range c.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.probe(ctx, e)
+		}()
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, e := range c.endpoints {
+		if healthy := results[i] == nil; healthy != e.healthy {
+			c.setHealthy(e, healthy, "health check")
+		}
+	}
+	// Ramps may have completed since the last election
+	c.elect("failback")
+}
+
+// probe checks one endpoint with the standard gRPC health service. Any
+// answer other than a transient error proves the portal is reachable,
+// so portals without the health service are probed too.
+func (c *Client) probe(ctx context.Context, e *endpoint) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	return c.execute(ctx, e, func(ctx context.Context, conn *grpc.ClientConn) error {
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		switch {
+		case err != nil && transient(err):
+			return err
+		case err == nil && resp.Status == healthpb.HealthCheckResponse_NOT_SERVING:
+			return status.Error(codes.Unavailable, "portal is not serving")
+		default:
+			return nil
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package portal
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestSortEndpointsPrefersLocalDatacenter(t *testing.T) {
+	endpoints := []Endpoint{
+		{Address: "a", Datacenter: "fra"},
+		{Address: "b", Datacenter: "ams"},
+		{Address: "c", Datacenter: "fra"},
+		{Address: "d", Datacenter: "ams"},
+	}
+
+	addresses := func(eps []Endpoint) []string {
+		var out []string
+		for _, ep := range eps {
+			out = append(out, ep.Address)
+		}
+		return out
+	}
+
+	assert.Equal(t, []string{"b", "d", "a", "c"}, addresses(sortEndpoints(endpoints, "ams")))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, addresses(sortEndpoints(endpoints, "")))
+}
+
+func TestCallFailsOverWithoutBackoff(t *testing.T) {
+	primary, secondary := testEndpoint("primary"), testEndpoint("secondary")
+	c := newRetryClient(&Config{Retry: map[string]RetryPolicy{
+		MethodCheckPolicy: {MaxAttempts: 2, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+	}}, primary, secondary)
+
+	var used []string
+	start := time.Now()
+	err := c.call(context.Background(), MethodCheckPolicy, func(ctx context.Context, _ *grpc.ClientConn) error {
+		e := c.pick()
+		used = append(used, e.Address)
+		if len(used) == 1 {
+			return status.Error(codes.Unavailable, "primary down")
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second, "failover must not wait for backoff")
+	assert.False(t, primary.healthy)
+	assert.Equal(t, "secondary", c.ActiveEndpoint())
+
+	health, ok := c.CheckHealth()
+	assert.True(t, ok)
+	assert.Equal(t, "active secondary, 1/2 endpoints healthy", health)
+}
+
+func TestCallFailsOverOnOpenCircuit(t *testing.T) {
+	primary := withBreaker(testEndpoint("primary"), newTestBreaker(t, 1))
+	secondary := testEndpoint("secondary")
+	c := newRetryClient(&Config{Retry: testRetry}, primary, secondary)
+
+	// Open the primary's circuit
+	_ = primary.breaker.Execute(context.Background(), func(context.Context) error {
+		return status.Error(codes.Unavailable, "down")
+	})
+
+	var calls int
+	err := c.call(context.Background(), MethodCheckPolicy, func(context.Context, *grpc.ClientConn) error {
+		calls++
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls, "the rejected attempt never reaches the portal")
+	assert.Equal(t, "secondary", c.ActiveEndpoint())
+}
+
+func TestGradualFailback(t *testing.T) {
+	primary, secondary := testEndpoint("primary"), testEndpoint("secondary")
+	c := newRetryClient(&Config{FailbackPeriod: time.Hour}, primary, secondary)
+
+	c.markFailure(primary)
+	require.Equal(t, "secondary", c.ActiveEndpoint())
+
+	// Halfway through the failback period the primary takes about half of the traffic
+	c.markSuccess(primary)
+	c.mu.Lock()
+	primary.recoveredAt = time.Now().Add(-30 * time.Minute)
+	c.mu.Unlock()
+
+	hits := 0
+	for i := 0; i < 2000; i++ {
+		if c.pick() == primary {
+			hits++
+		}
+	}
+	assert.InDelta(t, 1000, hits, 200)
+	assert.Equal(t, "secondary", c.ActiveEndpoint(), "active until failback completes")
+
+	// Once ramped up the primary takes everything back
+	c.mu.Lock()
+	primary.recoveredAt = time.Now().Add(-time.Hour)
+	c.elect("failback")
+	c.mu.Unlock()
+
+	for i := 0; i < 100; i++ {
+		require.Same(t, primary, c.pick())
+	}
+	assert.Equal(t, "primary", c.ActiveEndpoint())
+}
+
+func TestWeightedSelection(t *testing.T) {
+	heavy, light, remote := testEndpoint("heavy"), testEndpoint("light"), testEndpoint("remote")
+	heavy.Weight, light.Weight = 3, 1
+	heavy.local, light.local = true, true
+	c := newRetryClient(&Config{Selection: SelectionWeighted}, heavy, light, remote)
+
+	hits := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		hits[c.pick().Address]++
+	}
+	assert.InDelta(t, 3000, hits["heavy"], 300)
+	assert.InDelta(t, 1000, hits["light"], 300)
+	assert.Zero(t, hits["remote"], "remote DC only serves when the local tier is down")
+
+	c.markFailure(heavy)
+	c.markFailure(light)
+	assert.Same(t, remote, c.pick())
+	assert.Equal(t, "remote", c.ActiveEndpoint())
+}
+
+// startPortal serves a fake portal, optionally with the gRPC health service
+func startPortal(t *testing.T, healthStatus *healthpb.HealthCheckResponse_ServingStatus) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	vpnv1.RegisterAuthServiceServer(server, &flakyAuthServer{})
+	if healthStatus != nil {
+		hs := health.NewServer()
+		hs.SetServingStatus("", *healthStatus)
+		healthpb.RegisterHealthServer(server, hs)
+	}
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+func TestClientFailoverAndHealthChecks(t *testing.T) {
+	notServing := healthpb.HealthCheckResponse_NOT_SERVING
+
+	// A closed listener stands in for a dead datacenter
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	draining := startPortal(t, &notServing)
+	noHealthService := startPortal(t, nil)
+
+	client, err := NewClient(context.Background(), &Config{
+		Endpoints: []Endpoint{
+			{Address: draining, Datacenter: "ams"},
+			{Address: noHealthService, Datacenter: "ams"},
+			{Address: dead, Datacenter: "fra"},
+		},
+		LocalDatacenter:     "fra",
+		Insecure:            true,
+		Timeout:             time.Second,
+		HealthCheckInterval: time.Hour,
+	}, slog.Default(), tracenoop.NewTracerProvider().Tracer("test"), tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	// The local datacenter is preferred
+	assert.Equal(t, dead, client.ActiveEndpoint())
+
+	client.checkEndpoints(context.Background())
+	assert.Equal(t, noHealthService, client.ActiveEndpoint(),
+		"dead and not-serving endpoints fail over; a portal without the health service is reachable")
+
+	decision, err := client.CheckPolicy(context.Background(), "alice", "staff", "203.0.113.1")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	health, ok := client.CheckHealth()
+	assert.True(t, ok)
+	assert.Contains(t, health, "1/3 endpoints healthy")
+}
+
+func TestAllBreakersOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints []EndpointHealth
+		want      bool
+	}{
+		{"no endpoints", nil, false},
+		{"all open", []EndpointHealth{{Address: "a", Breaker: "open"}, {Address: "b", Breaker: "open"}}, true},
+		{"one half-open", []EndpointHealth{{Address: "a", Breaker: "open"}, {Address: "b", Breaker: "half_open"}}, false},
+		{"breakers disabled", []EndpointHealth{{Address: "a", Breaker: BreakerDisabled}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, AllBreakersOpen(tt.endpoints))
+		})
+	}
+}
+
+func TestBreakerChecks(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoints   []EndpointHealth
+		wantPortal  string
+		wantHealthy bool
+	}{
+		{
+			name:        "breakers closed",
+			endpoints:   []EndpointHealth{{Address: "a:9090", Breaker: "closed"}, {Address: "b:9090", Breaker: "half_open"}},
+			wantPortal:  "ok",
+			wantHealthy: true,
+		},
+		{
+			name:        "one breaker open",
+			endpoints:   []EndpointHealth{{Address: "a:9090", Breaker: "open"}, {Address: "b:9090", Breaker: "closed"}},
+			wantPortal:  "ok",
+			wantHealthy: true,
+		},
+		{
+			name:       "all breakers open",
+			endpoints:  []EndpointHealth{{Address: "a:9090", Breaker: "open"}, {Address: "b:9090", Breaker: "open"}},
+			wantPortal: "degraded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks, healthy := BreakerChecks(tt.endpoints)
+			assert.Equal(t, tt.wantHealthy, healthy)
+			assert.Equal(t, tt.wantPortal, checks["portal"])
+			for _, e := range tt.endpoints {
+				assert.Equal(t, e.Breaker, checks["portal_breaker:"+e.Address])
+			}
+		})
+	}
+}
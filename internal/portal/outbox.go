@@ -0,0 +1,526 @@
+package portal
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Outbox event kinds
+const (
+	OutboxConnect    = "connect"
+	OutboxDisconnect = "disconnect"
+)
+
+var (
+	// outboxBucket holds pending events (big-endian seq -> JSON OutboxEvent)
+	outboxBucket = []byte("events")
+	// rejectedBucket keeps events the portal refused, for manual replay
+	rejectedBucket = []byte("rejected")
+)
+
+// EventReporter delivers session events to the portal
+type EventReporter interface {
+	ReportConnect(ctx context.Context, sessionID, username, groupName, clientIP, vpnIP, device string, connectedAt time.Time, metadata map[string]string) error
+	ReportDisconnect(ctx context.Context, sessionID, username string, disconnectedAt time.Time, duration time.Duration, bytesRX, bytesTX uint64, reason string, metadata map[string]string) error
+}
+
+var (
+	_ EventReporter = (*Client)(nil)
+	_ EventReporter = (*Outbox)(nil)
+)
+
+// OutboxEvent is a persisted session event waiting for portal acknowledgement
+type OutboxEvent struct {
+	Seq       uint64    `json:"seq"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+
+	SessionID string            `json:"session_id"`
+	Username  string            `json:"username"`
+	GroupName string            `json:"group,omitempty"`
+	ClientIP  string            `json:"client_ip,omitempty"`
+	VPNIP     string            `json:"vpn_ip,omitempty"`
+	Device    string            `json:"device,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+
+	ConnectedAt    time.Time     `json:"connected_at,omitzero"`
+	DisconnectedAt time.Time     `json:"disconnected_at,omitzero"`
+	Duration       time.Duration `json:"duration,omitempty"`
+	BytesRX        uint64        `json:"bytes_rx,omitempty"`
+	BytesTX        uint64        `json:"bytes_tx,omitempty"`
+	Reason         string        `json:"reason,omitempty"`
+}
+
+// OutboxConfig configures the portal event outbox
+type OutboxConfig struct {
+	// Path is the write-ahead file (empty = in memory only, pending
+	// events are lost on restart)
+	Path     string
+	Reporter EventReporter
+	// InitialBackoff is the wait after the first failed delivery; it
+	// doubles with every further failure up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	Logger *slog.Logger
+	Meter  metric.Meter
+}
+
+// Outbox persists portal session events before sending them and delivers
+// them strictly in order, retrying until the portal acknowledges each one.
+//
+// Events written before a crash or restart are replayed on the next start,
+// so a connect is never reported after its disconnect and no disconnect
+// (which billing depends on) is lost.
+type Outbox struct {
+	db             *bolt.DB // nil in memory-only mode
+	reporter       EventReporter
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	logger         *slog.Logger
+
+	mu      sync.Mutex
+	pending []*OutboxEvent
+	nextSeq uint64
+	wake    chan struct{}
+	drained chan struct{} // closed and replaced whenever pending becomes empty
+
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+	registration metric.Registration
+
+	// Metrics
+	deliveriesTotal metric.Int64Counter
+}
+
+// NewOutbox opens the outbox and loads events left from a previous run
+func NewOutbox(cfg *OutboxConfig) (*Outbox, error) {
+	if cfg.Reporter == nil {
+		return nil, errors.New("reporter is required")
+	}
+	if cfg.Logger == nil {
+		return nil, errors.New("logger is required")
+	}
+	if cfg.Meter == nil {
+		return nil, errors.New("meter is required")
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+
+	o := &Outbox{
+		reporter:       cfg.Reporter,
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     max(cfg.MaxBackoff, cfg.InitialBackoff),
+		logger:         cfg.Logger,
+		nextSeq:        1,
+		wake:           make(chan struct{}, 1),
+		drained:        make(chan struct{}),
+	}
+
+	if cfg.Path != "" {
+		if err := o.open(cfg.Path); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.pending) == 0 {
+		close(o.drained)
+	}
+
+	var err error
+	o.deliveriesTotal, err = cfg.Meter.Int64Counter(
+		"portal.outbox.deliveries.total",
+		metric.WithDescription("Total number of portal event delivery attempts by kind and result"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		_ = o.closeDB()
+		return nil, errors.Wrap(err, "create deliveries counter")
+	}
+
+	depth, err := cfg.Meter.Int64ObservableGauge(
+		"portal.outbox.depth",
+		metric.WithDescription("Number of session events waiting to be acknowledged by the portal"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		_ = o.closeDB()
+		return nil, errors.Wrap(err, "create depth gauge")
+	}
+
+	age, err := cfg.Meter.Float64ObservableGauge(
+		"portal.outbox.oldest_age",
+		metric.WithDescription("Age of the oldest session event waiting for the portal"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		_ = o.closeDB()
+		return nil, errors.Wrap(err, "create age gauge")
+	}
+
+	o.registration, err = cfg.Meter.RegisterCallback(func(_ context.Context, obs metric.Observer) error {
+		n, oldest := o.Stats()
+		obs.ObserveInt64(depth, int64(n))
+		obs.ObserveFloat64(age, oldest.Seconds())
+		return nil
+	}, depth, age)
+	if err != nil {
+		_ = o.closeDB()
+		return nil, errors.Wrap(err, "register outbox metrics")
+	}
+
+	return o, nil
+}
+
+// open opens the write-ahead file and loads pending events in order
+func (o *Outbox) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.Wrapf(err, "create outbox directory for %s", path)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return errors.Wrapf(err, "open outbox %s", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(rejectedBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(outboxBucket)
+		if err != nil {
+			return err
+		}
+		if last, _ := bucket.Cursor().Last(); last != nil {
+			o.nextSeq = binary.BigEndian.Uint64(last) + 1
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			event := &OutboxEvent{}
+			if err := json.Unmarshal(v, event); err != nil {
+				// A corrupt record cannot be delivered; leave it on disk
+				// for inspection and keep going with the others
+				o.logger.Error("skipping corrupt outbox event",
+					slog.Uint64("seq", binary.BigEndian.Uint64(k)),
+					slog.String("error", err.Error()),
+				)
+				return nil
+			}
+			o.pending = append(o.pending, event)
+			return nil
+		})
+	})
+	if err != nil {
+		_ = db.Close()
+		return errors.Wrap(err, "load outbox")
+	}
+
+	if n := len(o.pending); n > 0 {
+		o.logger.Info("replaying portal events from outbox",
+			slog.Int("events", n),
+			slog.Time("oldest", o.pending[0].CreatedAt),
+		)
+	}
+	o.db = db
+
+	return nil
+}
+
+// Start launches the delivery worker
+func (o *Outbox) Start(ctx context.Context) {
+	// Delivery continues after ctx is cancelled until Stop gives up
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	o.cancel = cancel
+
+	o.wg.Add(1)
+	go o.run(runCtx)
+}
+
+// Stop waits for pending events to be delivered until ctx expires, then
+// stops the worker. Undelivered events stay in the outbox for the next run.
+func (o *Outbox) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	drained := o.drained
+	o.mu.Unlock()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		n, _ := o.Stats()
+		err = errors.Wrapf(ctx.Err(), "portal outbox shutdown with %d pending events", n)
+	}
+
+	if o.cancel != nil {
+		o.cancel()
+	}
+	o.wg.Wait()
+
+	if o.registration != nil {
+		_ = o.registration.Unregister()
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if closeErr := o.closeDB(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeDB closes the write-ahead file
+func (o *Outbox) closeDB() error {
+	if o.db == nil {
+		return nil
+	}
+	err := o.db.Close()
+	o.db = nil
+	return err
+}
+
+// ReportConnect queues a connect event. The event is persisted before
+// returning; an error means it is only kept in memory.
+func (o *Outbox) ReportConnect(_ context.Context, sessionID, username, groupName, clientIP, vpnIP, device string, connectedAt time.Time, metadata map[string]string) error {
+	if connectedAt.IsZero() {
+		connectedAt = time.Now()
+	}
+	return o.enqueue(&OutboxEvent{
+		Kind:        OutboxConnect,
+		SessionID:   sessionID,
+		Username:    username,
+		GroupName:   groupName,
+		ClientIP:    clientIP,
+		VPNIP:       vpnIP,
+		Device:      device,
+		ConnectedAt: connectedAt,
+		Metadata:    metadata,
+	})
+}
+
+// ReportDisconnect queues a disconnect event. The event is persisted before
+// returning; an error means it is only kept in memory.
+func (o *Outbox) ReportDisconnect(_ context.Context, sessionID, username string, disconnectedAt time.Time, duration time.Duration, bytesRX, bytesTX uint64, reason string, metadata map[string]string) error {
+	if disconnectedAt.IsZero() {
+		disconnectedAt = time.Now()
+	}
+	return o.enqueue(&OutboxEvent{
+		Kind:           OutboxDisconnect,
+		SessionID:      sessionID,
+		Username:       username,
+		DisconnectedAt: disconnectedAt,
+		Duration:       duration,
+		BytesRX:        bytesRX,
+		BytesTX:        bytesTX,
+		Reason:         reason,
+		Metadata:       metadata,
+	})
+}
+
+// Stats returns the number of pending events and the age of the oldest one
+func (o *Outbox) Stats() (int, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) == 0 {
+		return 0, 0
+	}
+	return len(o.pending), time.Since(o.pending[0].CreatedAt)
+}
+
+// enqueue persists an event and hands it to the worker
+func (o *Outbox) enqueue(event *OutboxEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	event.Seq = o.nextSeq
+	event.CreatedAt = time.Now()
+	o.nextSeq++
+
+	// Write ahead: the event is on disk before anyone relies on it
+	var err error
+	if o.db != nil {
+		err = o.db.Update(func(tx *bolt.Tx) error {
+			return putOutboxEvent(tx.Bucket(outboxBucket), event)
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "persist %s event for session %s", event.Kind, event.SessionID)
+		}
+	}
+
+	if len(o.pending) == 0 {
+		o.drained = make(chan struct{})
+	}
+	o.pending = append(o.pending, event)
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return err
+}
+
+// run delivers pending events in order until ctx is cancelled
+func (o *Outbox) run(ctx context.Context) {
+	defer o.wg.Done()
+
+	failures := 0
+	for {
+		o.mu.Lock()
+		var head *OutboxEvent
+		if len(o.pending) > 0 {
+			head = o.pending[0]
+		}
+		o.mu.Unlock()
+
+		if head == nil {
+			select {
+			case <-o.wake:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err := o.deliver(ctx, head)
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err == nil || status.Code(err) == codes.AlreadyExists:
+			// AlreadyExists: a replay of an event the portal did get
+			o.record(ctx, head, "delivered")
+			o.ack(ctx, head, false)
+			failures = 0
+
+		case status.Code(err) == codes.InvalidArgument:
+			// Retrying cannot succeed and would block every later event
+			o.record(ctx, head, "rejected")
+			o.logger.ErrorContext(ctx, "portal rejected session event, moving it aside",
+				slog.String("kind", head.Kind),
+				slog.String("session_id", head.SessionID),
+				slog.String("username", head.Username),
+				slog.String("error", err.Error()),
+			)
+			o.ack(ctx, head, true)
+			failures = 0
+
+		default:
+			o.record(ctx, head, "retry")
+			failures++
+			wait := o.backoff(failures)
+			o.logger.WarnContext(ctx, "failed to report session event to portal, will retry",
+				slog.String("kind", head.Kind),
+				slog.String("session_id", head.SessionID),
+				slog.Int("attempt", failures),
+				slog.Duration("backoff", wait),
+				slog.String("error", err.Error()),
+			)
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// deliver sends a single event to the portal
+func (o *Outbox) deliver(ctx context.Context, event *OutboxEvent) error {
+	switch event.Kind {
+	case OutboxConnect:
+		return o.reporter.ReportConnect(ctx, event.SessionID, event.Username, event.GroupName,
+			event.ClientIP, event.VPNIP, event.Device, event.ConnectedAt, event.Metadata)
+	case OutboxDisconnect:
+		return o.reporter.ReportDisconnect(ctx, event.SessionID, event.Username, event.DisconnectedAt,
+			event.Duration, event.BytesRX, event.BytesTX, event.Reason, event.Metadata)
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown outbox event kind %q", event.Kind)
+	}
+}
+
+// ack removes the head event once the portal has answered. Rejected
+// events are kept in a separate bucket.
+func (o *Outbox) ack(ctx context.Context, event *OutboxEvent, rejected bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.db != nil {
+		err := o.db.Update(func(tx *bolt.Tx) error {
+			if rejected {
+				if err := putOutboxEvent(tx.Bucket(rejectedBucket), event); err != nil {
+					return err
+				}
+			}
+			return tx.Bucket(outboxBucket).Delete(outboxKey(event.Seq))
+		})
+		if err != nil {
+			// The event will be sent again after a restart
+			o.logger.ErrorContext(ctx, "failed to remove delivered event from outbox",
+				slog.Uint64("seq", event.Seq),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+
+	o.pending = o.pending[1:]
+	if len(o.pending) == 0 {
+		o.pending = nil
+		close(o.drained)
+	}
+}
+
+// backoff returns the jittered wait after the given number of consecutive
+// failures
+func (o *Outbox) backoff(failures int) time.Duration {
+	wait := o.initialBackoff
+	for i := 1; i < failures && wait < o.maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, o.maxBackoff)
+
+	return wait - rand.N(wait/4+1) // #nosec G404 - jitter does not need a CSPRNG
+}
+
+// record counts a delivery attempt
+func (o *Outbox) record(ctx context.Context, event *OutboxEvent, result string) {
+	o.deliveriesTotal.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("kind", event.Kind),
+		attribute.String("result", result),
+	))
+}
+
+// putOutboxEvent stores an event under its sequence number
+func putOutboxEvent(bucket *bolt.Bucket, event *OutboxEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(outboxKey(event.Seq), data)
+}
+
+// outboxKey encodes a sequence number so keys sort in delivery order
+func outboxKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
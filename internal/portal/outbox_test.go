@@ -0,0 +1,229 @@
+package portal
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeEventReporter records delivered events and fails calls on demand
+type fakeEventReporter struct {
+	mu        sync.Mutex
+	delivered []OutboxEvent
+	calls     int
+	// fail returns the error for the n-th call (nil = success)
+	fail func(n int, event OutboxEvent) error
+}
+
+func (f *fakeEventReporter) report(event OutboxEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.fail != nil {
+		if err := f.fail(f.calls, event); err != nil {
+			return err
+		}
+	}
+	f.delivered = append(f.delivered, event)
+	return nil
+}
+
+func (f *fakeEventReporter) ReportConnect(_ context.Context, sessionID, username, groupName, clientIP, vpnIP, device string, connectedAt time.Time, metadata map[string]string) error {
+	return f.report(OutboxEvent{
+		Kind:        OutboxConnect,
+		SessionID:   sessionID,
+		Username:    username,
+		GroupName:   groupName,
+		ClientIP:    clientIP,
+		VPNIP:       vpnIP,
+		ConnectedAt: connectedAt,
+		Metadata:    metadata,
+	})
+}
+
+func (f *fakeEventReporter) ReportDisconnect(_ context.Context, sessionID, username string, disconnectedAt time.Time, duration time.Duration, bytesRX, bytesTX uint64, reason string, metadata map[string]string) error {
+	return f.report(OutboxEvent{
+		Kind:           OutboxDisconnect,
+		SessionID:      sessionID,
+		Username:       username,
+		DisconnectedAt: disconnectedAt,
+		Duration:       duration,
+		BytesRX:        bytesRX,
+		BytesTX:        bytesTX,
+		Reason:         reason,
+		Metadata:       metadata,
+	})
+}
+
+func (f *fakeEventReporter) events() []OutboxEvent {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]OutboxEvent(nil), f.delivered...)
+}
+
+func newTestOutbox(t *testing.T, path string, reporter EventReporter) *Outbox {
+	t.Helper()
+
+	o, err := NewOutbox(&OutboxConfig{
+		Path:           path,
+		Reporter:       reporter,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		Logger:         slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Meter:          noop.NewMeterProvider().Meter("test"),
+	})
+	require.NoError(t, err)
+	return o
+}
+
+func stopOutbox(t *testing.T, o *Outbox, timeout time.Duration) error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return o.Stop(ctx)
+}
+
+func TestOutbox_RetriesInOrder(t *testing.T) {
+	reporter := &fakeEventReporter{fail: func(n int, _ OutboxEvent) error {
+		if n <= 3 {
+			return status.Error(codes.Unavailable, "portal down")
+		}
+		return nil
+	}}
+	o := newTestOutbox(t, "", reporter)
+	o.Start(context.Background())
+
+	ctx := context.Background()
+	disconnectedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, o.ReportConnect(ctx, "1", "alice", "staff", "203.0.113.1", "10.0.0.2", "", time.Time{}, nil))
+	require.NoError(t, o.ReportDisconnect(ctx, "1", "alice", disconnectedAt, time.Hour, 10, 20, "", nil))
+	require.NoError(t, o.ReportConnect(ctx, "2", "bob", "", "", "", "", time.Time{}, nil))
+
+	require.NoError(t, stopOutbox(t, o, 5*time.Second))
+
+	events := reporter.events()
+	require.Len(t, events, 3)
+	assert.Equal(t, OutboxConnect, events[0].Kind)
+	assert.Equal(t, "alice", events[0].Username)
+	assert.Equal(t, OutboxDisconnect, events[1].Kind)
+	assert.True(t, disconnectedAt.Equal(events[1].DisconnectedAt))
+	assert.Equal(t, uint64(20), events[1].BytesTX)
+	assert.Equal(t, "bob", events[2].Username)
+}
+
+func TestOutbox_ReplaysAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	down := &fakeEventReporter{fail: func(int, OutboxEvent) error {
+		return status.Error(codes.Unavailable, "portal down")
+	}}
+
+	o := newTestOutbox(t, path, down)
+	o.Start(context.Background())
+
+	ctx := context.Background()
+	disconnectedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, o.ReportConnect(ctx, "1", "alice", "", "", "", "", time.Time{}, map[string]string{"recovered": "true"}))
+	require.NoError(t, o.ReportDisconnect(ctx, "1", "alice", disconnectedAt, time.Hour, 1000, 2000, "schedule", nil))
+
+	n, age := o.Stats()
+	assert.Equal(t, 2, n)
+	assert.Positive(t, age)
+
+	// The portal never answered: shutdown leaves the events on disk
+	assert.Error(t, stopOutbox(t, o, 20*time.Millisecond))
+	assert.Empty(t, down.events())
+
+	up := &fakeEventReporter{}
+	o = newTestOutbox(t, path, up)
+	n, _ = o.Stats()
+	assert.Equal(t, 2, n)
+
+	o.Start(context.Background())
+	require.NoError(t, o.ReportConnect(ctx, "2", "bob", "", "", "", "", time.Time{}, nil))
+	require.NoError(t, stopOutbox(t, o, 5*time.Second))
+
+	events := up.events()
+	require.Len(t, events, 3)
+	assert.Equal(t, OutboxConnect, events[0].Kind)
+	assert.Equal(t, "true", events[0].Metadata["recovered"])
+	assert.Equal(t, OutboxDisconnect, events[1].Kind)
+	assert.True(t, disconnectedAt.Equal(events[1].DisconnectedAt))
+	assert.Equal(t, uint64(2000), events[1].BytesTX)
+	assert.Equal(t, "schedule", events[1].Reason)
+	assert.Equal(t, "bob", events[2].Username, "new events follow replayed ones")
+
+	// Nothing is replayed a second time
+	o = newTestOutbox(t, path, up)
+	n, _ = o.Stats()
+	assert.Zero(t, n)
+	require.NoError(t, stopOutbox(t, o, time.Second))
+}
+
+func TestOutbox_PermanentErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	reporter := &fakeEventReporter{fail: func(_ int, event OutboxEvent) error {
+		switch event.SessionID {
+		case "dup":
+			return status.Error(codes.AlreadyExists, "already reported")
+		case "bad":
+			return status.Error(codes.InvalidArgument, "unknown session")
+		}
+		return nil
+	}}
+	o := newTestOutbox(t, path, reporter)
+	o.Start(context.Background())
+
+	ctx := context.Background()
+	require.NoError(t, o.ReportConnect(ctx, "dup", "alice", "", "", "", "", time.Time{}, nil))
+	require.NoError(t, o.ReportConnect(ctx, "bad", "bob", "", "", "", "", time.Time{}, nil))
+	require.NoError(t, o.ReportConnect(ctx, "ok", "carol", "", "", "", "", time.Time{}, nil))
+	require.NoError(t, stopOutbox(t, o, 5*time.Second))
+
+	// A rejected event does not block the ones behind it
+	events := reporter.events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "ok", events[0].SessionID)
+
+	o = newTestOutbox(t, path, reporter)
+	n, _ := o.Stats()
+	assert.Zero(t, n)
+	require.NoError(t, stopOutbox(t, o, time.Second))
+}
+
+func TestOutbox_PersistFailureKeepsEventInMemory(t *testing.T) {
+	reporter := &fakeEventReporter{}
+	o := newTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"), reporter)
+
+	// Simulate a broken write-ahead file
+	require.NoError(t, o.db.Close())
+
+	err := o.ReportConnect(context.Background(), "1", "alice", "", "", "", "", time.Time{}, nil)
+	assert.Error(t, err)
+
+	o.Start(context.Background())
+	require.NoError(t, stopOutbox(t, o, 5*time.Second))
+	require.Len(t, reporter.events(), 1)
+}
+
+func TestOutbox_Backoff(t *testing.T) {
+	o := &Outbox{initialBackoff: time.Second, maxBackoff: 30 * time.Second}
+
+	assert.LessOrEqual(t, o.backoff(1), time.Second)
+	assert.GreaterOrEqual(t, o.backoff(1), 750*time.Millisecond)
+	assert.GreaterOrEqual(t, o.backoff(4), 6*time.Second)
+	for failures := 1; failures <= 20; failures++ {
+		assert.LessOrEqual(t, o.backoff(failures), 30*time.Second)
+	}
+}
@@ -0,0 +1,110 @@
+package portal
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// policyStreamBackoff paces resubscription after the policy event stream breaks
+var policyStreamBackoff = RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}
+
+// PolicyEventHandler handles an event pushed by the portal
+type PolicyEventHandler func(ctx context.Context, event *vpnv1.PolicyEvent)
+
+// WatchPolicyEvents subscribes to policy events pushed by the portal and
+// calls handle for each of them until ctx is cancelled.
+//
+// A broken stream is resubscribed on the active endpoint with backoff,
+// resuming after the last received event so the portal can replay the
+// events missed in between. Portals without the subscription are not
+// retried.
+func (c *Client) WatchPolicyEvents(ctx context.Context, agentID string, handle PolicyEventHandler) {
+	var lastEventID string
+	for attempt := 1; ; attempt++ {
+		received, err := c.subscribePolicyEvents(ctx, agentID, &lastEventID, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			c.logger.WarnContext(ctx, "portal does not support policy event subscription",
+				slog.String("error", err.Error()),
+			)
+			return
+		}
+		if received {
+			attempt = 1
+		}
+
+		wait := backoff(policyStreamBackoff, attempt)
+		c.logger.WarnContext(ctx, "portal policy event stream broken, resubscribing",
+			slog.Duration("backoff", wait),
+			slog.String("error", err.Error()),
+		)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// subscribePolicyEvents consumes one policy event stream until it breaks
+// and reports whether any event was received
+func (c *Client) subscribePolicyEvents(ctx context.Context, agentID string, lastEventID *string, handle PolicyEventHandler) (bool, error) {
+	e := c.pick()
+	stream, err := vpnv1.NewAuthServiceClient(e.conn).SubscribePolicyEvents(ctx, &vpnv1.SubscribePolicyEventsRequest{
+		AgentId:     agentID,
+		LastEventId: *lastEventID,
+	})
+	if err != nil {
+		if transient(err) {
+			c.markFailure(e)
+		}
+		return false, err
+	}
+
+	c.logger.InfoContext(ctx, "subscribed to portal policy events",
+		slog.String("endpoint", e.Address),
+		slog.String("last_event_id", *lastEventID),
+	)
+
+	received := false
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			if transient(err) {
+				c.markFailure(e)
+			}
+			return received, err
+		}
+		received = true
+		if event.EventId != "" {
+			*lastEventID = event.EventId
+		}
+
+		c.logger.InfoContext(ctx, "portal policy event received",
+			slog.String("event_id", event.EventId),
+			slog.String("type", event.Type.String()),
+			slog.String("username", event.Username),
+			slog.String("group", event.Groupname),
+			slog.String("reason", event.Reason),
+		)
+		if c.metrics != nil {
+			c.metrics.policyEvents.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("type", event.Type.String()),
+			))
+		}
+
+		handle(ctx, event)
+	}
+}
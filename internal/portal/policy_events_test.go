@@ -0,0 +1,144 @@
+package portal
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// policyEventServer streams one batch of events per subscription and
+// breaks every stream but the last one
+type policyEventServer struct {
+	vpnv1.UnimplementedAuthServiceServer
+
+	batches [][]*vpnv1.PolicyEvent
+
+	mu       sync.Mutex
+	requests []*vpnv1.SubscribePolicyEventsRequest
+}
+
+func (s *policyEventServer) SubscribePolicyEvents(req *vpnv1.SubscribePolicyEventsRequest, stream grpc.ServerStreamingServer[vpnv1.PolicyEvent]) error {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	n := len(s.requests)
+	s.mu.Unlock()
+
+	if n > len(s.batches) {
+		<-stream.Context().Done()
+		return nil
+	}
+	for _, event := range s.batches[n-1] {
+		if err := stream.Send(event); err != nil {
+			return err
+		}
+	}
+	return status.Error(codes.Unavailable, "portal restarting")
+}
+
+func newPolicyEventClient(t *testing.T, srv vpnv1.AuthServiceServer) *Client {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	vpnv1.RegisterAuthServiceServer(server, srv)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	client, err := NewClient(context.Background(), &Config{
+		Address:             lis.Addr().String(),
+		Insecure:            true,
+		Timeout:             time.Second,
+		HealthCheckInterval: time.Hour,
+	}, slog.Default(), tracenoop.NewTracerProvider().Tracer("test"), tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestWatchPolicyEventsResubscribesAfterLastEvent(t *testing.T) {
+	saved := policyStreamBackoff
+	policyStreamBackoff = RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	t.Cleanup(func() { policyStreamBackoff = saved })
+
+	srv := &policyEventServer{batches: [][]*vpnv1.PolicyEvent{
+		{
+			{EventId: "1", Type: vpnv1.PolicyEventType_POLICY_EVENT_TYPE_USER_REVOKED, Username: "alice"},
+			{EventId: "2", Type: vpnv1.PolicyEventType_POLICY_EVENT_TYPE_FLUSH_CACHE},
+		},
+		{
+			{EventId: "3", Type: vpnv1.PolicyEventType_POLICY_EVENT_TYPE_GROUP_POLICY_CHANGED, Groupname: "staff"},
+		},
+	}}
+	client := newPolicyEventClient(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan *vpnv1.PolicyEvent, 8)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.WatchPolicyEvents(ctx, "agent-1", func(_ context.Context, event *vpnv1.PolicyEvent) {
+			events <- event
+		})
+	}()
+
+	var ids []string
+	for range 3 {
+		select {
+		case event := <-events:
+			ids = append(ids, event.EventId)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for policy events")
+		}
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+
+	// The third subscription resumes after the last event and stays open
+	require.Eventually(t, func() bool {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		return len(srv.requests) == 3
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	assert.Equal(t, "agent-1", srv.requests[0].AgentId)
+	assert.Empty(t, srv.requests[0].LastEventId)
+	assert.Equal(t, "2", srv.requests[1].LastEventId)
+	assert.Equal(t, "3", srv.requests[2].LastEventId)
+}
+
+func TestWatchPolicyEventsStopsWhenUnsupported(t *testing.T) {
+	client := newPolicyEventClient(t, &vpnv1.UnimplementedAuthServiceServer{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.WatchPolicyEvents(context.Background(), "agent-1", func(context.Context, *vpnv1.PolicyEvent) {
+			t.Error("unexpected event")
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch must stop on portals without the subscription")
+	}
+}
@@ -0,0 +1,302 @@
+package portal
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Portal RPC names used as retry policy keys and metric attributes
+const (
+	MethodCheckPolicy         = "check_policy"
+	MethodValidateSession     = "validate_session"
+	MethodReportConnect       = "report_connect"
+	MethodReportDisconnect    = "report_disconnect"
+	MethodReportSessionUpdate = "report_session_update"
+)
+
+// RetryPolicy configures retries of a single portal RPC
+type RetryPolicy struct {
+	MaxAttempts    int           // total attempts including the first one (<= 1 disables retries)
+	InitialBackoff time.Duration // wait after the first failed attempt, doubled per retry
+	MaxBackoff     time.Duration // upper bound of the wait between attempts
+}
+
+// DefaultRetryPolicies returns the retry policy of every portal RPC.
+// CheckPolicy sits on the connect path and gets a single quick retry;
+// session updates are periodic, so the next poll is their retry.
+func DefaultRetryPolicies() map[string]RetryPolicy {
+	return map[string]RetryPolicy{
+		MethodCheckPolicy:         {MaxAttempts: 2, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 500 * time.Millisecond},
+		MethodValidateSession:     {MaxAttempts: 3, InitialBackoff: 200 * time.Millisecond, MaxBackoff: 2 * time.Second},
+		MethodReportConnect:       {MaxAttempts: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second},
+		MethodReportDisconnect:    {MaxAttempts: 3, InitialBackoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Second},
+		MethodReportSessionUpdate: {MaxAttempts: 1},
+	}
+}
+
+// clientMetrics holds portal RPC instruments
+type clientMetrics struct {
+	attempts     metric.Int64Counter
+	retries      metric.Int64Counter
+	failovers    metric.Int64Counter
+	policyEvents metric.Int64Counter
+}
+
+// newClientMetrics creates portal RPC instruments
+func newClientMetrics(meter metric.Meter) (*clientMetrics, error) {
+	attempts, err := meter.Int64Counter("portal.rpc.attempts.total",
+		metric.WithDescription("Portal RPC attempts by method and result"),
+		metric.WithUnit("{attempt}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create attempts counter")
+	}
+
+	retries, err := meter.Int64Counter("portal.rpc.retries.total",
+		metric.WithDescription("Portal RPC retries by method"),
+		metric.WithUnit("{retry}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create retries counter")
+	}
+
+	failovers, err := meter.Int64Counter("portal.failovers.total",
+		metric.WithDescription("Changes of the active portal endpoint"),
+		metric.WithUnit("{failover}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create failovers counter")
+	}
+
+	policyEvents, err := meter.Int64Counter("portal.policy_events.total",
+		metric.WithDescription("Policy events pushed by the portal by type"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create policy events counter")
+	}
+
+	return &clientMetrics{attempts: attempts, retries: retries, failovers: failovers, policyEvents: policyEvents}, nil
+}
+
+// observeEndpoints registers gauges reporting the health of every
+// endpoint and which one is active
+func (m *clientMetrics) observeEndpoints(meter metric.Meter, c *Client) error {
+	active, err := meter.Int64ObservableGauge("portal.endpoint.active",
+		metric.WithDescription("1 for the portal endpoint receiving traffic, 0 otherwise"),
+	)
+	if err != nil {
+		return errors.Wrap(err, "create active endpoint gauge")
+	}
+
+	healthy, err := meter.Int64ObservableGauge("portal.endpoint.healthy",
+		metric.WithDescription("1 if the portal endpoint is healthy, 0 otherwise"),
+	)
+	if err != nil {
+		return errors.Wrap(err, "create endpoint health gauge")
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for _, e := range c.endpoints {
+			attrs := metric.WithAttributes(
+				attribute.String("endpoint", e.Address),
+				attribute.String("datacenter", e.Datacenter),
+			)
+			o.ObserveInt64(active, boolGauge(e == c.active), attrs)
+			o.ObserveInt64(healthy, boolGauge(c.usable(e)), attrs)
+		}
+		return nil
+	}, active, healthy)
+	if err != nil {
+		return errors.Wrap(err, "register endpoint callback")
+	}
+
+	return nil
+}
+
+// boolGauge converts a flag to a gauge value
+func boolGauge(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// call runs a portal RPC on the selected endpoint through its circuit
+// breaker, retrying transient failures according to the method's policy.
+//
+// A transient failure or an open circuit marks the endpoint unhealthy and
+// the retry goes to the next endpoint right away; retries on the same
+// endpoint back off. Each attempt gets an equal share of the caller's
+// remaining deadline (capped by the client timeout), and no retry is
+// started if its backoff would not leave time for the attempt itself.
+func (c *Client) call(ctx context.Context, method string, fn func(context.Context, *grpc.ClientConn) error) error {
+	policy := c.retryPolicy(method)
+	methodAttr := attribute.String("method", method)
+
+	e := c.pick()
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := c.attemptContext(ctx, policy.MaxAttempts-attempt+1)
+		err := c.execute(attemptCtx, e, fn)
+		cancel()
+
+		c.recordAttempt(ctx, method, e, err)
+		if err == nil {
+			c.markSuccess(e)
+			return nil
+		}
+
+		breakerOpen := errors.Is(err, resilience.ErrOpen)
+		if !breakerOpen && !transient(err) {
+			return err
+		}
+		c.markFailure(e)
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		next := c.pick()
+		var wait time.Duration
+		if next == e {
+			if breakerOpen {
+				// No other endpoint to fail over to
+				return err
+			}
+			wait = backoff(policy, attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+				return err
+			}
+		}
+
+		c.logger.DebugContext(ctx, "retrying portal call",
+			slog.String("method", method),
+			slog.Int("attempt", attempt),
+			slog.String("endpoint", e.Address),
+			slog.String("next_endpoint", next.Address),
+			slog.Duration("backoff", wait),
+			slog.String("error", err.Error()),
+		)
+		if c.metrics != nil {
+			c.metrics.retries.Add(ctx, 1, metric.WithAttributes(methodAttr))
+		}
+		e = next
+
+		if wait == 0 {
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// execute runs one attempt on endpoint e through its circuit breaker.
+// Only transient failures count against the breaker: a portal that
+// answers with a business error (e.g. NotFound) is healthy.
+func (c *Client) execute(ctx context.Context, e *endpoint, fn func(context.Context, *grpc.ClientConn) error) error {
+	if e.breaker == nil {
+		return fn(ctx, e.conn)
+	}
+
+	var callErr error
+	err := e.breaker.Execute(ctx, func(ctx context.Context) error {
+		callErr = fn(ctx, e.conn)
+		if transient(callErr) {
+			return callErr
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return callErr
+}
+
+// attemptContext derives the context of one attempt from the remaining
+// deadline budget
+func (c *Client) attemptContext(ctx context.Context, attemptsLeft int) (context.Context, context.CancelFunc) {
+	timeout := c.config.Timeout
+	if deadline, ok := ctx.Deadline(); ok && attemptsLeft > 1 {
+		if share := time.Until(deadline) / time.Duration(attemptsLeft); share < timeout {
+			timeout = share
+		}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// retryPolicy returns the policy of method (a single attempt if unknown)
+func (c *Client) retryPolicy(method string) RetryPolicy {
+	policy, ok := c.config.Retry[method]
+	if !ok || policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return policy
+}
+
+// recordAttempt counts an attempt by its endpoint and result
+func (c *Client) recordAttempt(ctx context.Context, method string, e *endpoint, err error) {
+	if c.metrics == nil {
+		return
+	}
+
+	result := "ok"
+	switch {
+	case errors.Is(err, resilience.ErrOpen):
+		result = "breaker_open"
+	case err != nil:
+		result = "error"
+	}
+	c.metrics.attempts.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("method", method),
+		attribute.String("endpoint", e.Address),
+		attribute.String("result", result),
+	))
+}
+
+// transient reports whether err means the portal is unreachable or
+// overloaded (as opposed to a business error)
+func transient(err error) bool {
+	if err == nil || errors.Is(err, resilience.ErrOpen) {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns the jittered wait before the retry following attempt
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	wait := policy.InitialBackoff
+	for i := 1; i < attempt && wait < policy.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+		wait = policy.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	// Up to half of the wait is jitter so agents don't retry in lockstep
+	return wait - rand.N(wait/2+1)
+}
@@ -0,0 +1,243 @@
+package portal
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/resilience"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testRetry = map[string]RetryPolicy{
+	MethodCheckPolicy: {MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond},
+}
+
+func newTestBreaker(t *testing.T, threshold uint32) *resilience.CircuitBreaker {
+	t.Helper()
+
+	cfg := resilience.DefaultConfig()
+	cfg.FailureThreshold = threshold
+	cb, err := resilience.NewCircuitBreaker(cfg, tracenoop.NewTracerProvider().Tracer("test"), metricnoop.NewMeterProvider().Meter("test"))
+	require.NoError(t, err)
+	return cb
+}
+
+// testEndpoint returns a healthy endpoint without a connection; test
+// calls ignore the conn argument
+func testEndpoint(address string) *endpoint {
+	return &endpoint{Endpoint: Endpoint{Address: address, Weight: 1}, healthy: true}
+}
+
+// newRetryClient builds a client over the given endpoints (one by default)
+func newRetryClient(cfg *Config, endpoints ...*endpoint) *Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.FailbackPeriod == 0 {
+		cfg.FailbackPeriod = time.Minute
+	}
+	if len(endpoints) == 0 {
+		endpoints = []*endpoint{testEndpoint("portal-a")}
+	}
+	return &Client{logger: slog.Default(), config: cfg, endpoints: endpoints, active: endpoints[0]}
+}
+
+// withBreaker attaches a circuit breaker to e
+func withBreaker(e *endpoint, cb *resilience.CircuitBreaker) *endpoint {
+	e.breaker = cb
+	return e
+}
+
+func TestCallRetriesTransientErrors(t *testing.T) {
+	c := newRetryClient(&Config{Retry: testRetry}, withBreaker(testEndpoint("portal-a"), newTestBreaker(t, 10)))
+
+	var calls int
+	err := c.call(context.Background(), MethodCheckPolicy, func(context.Context, *grpc.ClientConn) error {
+		calls++
+		if calls < 3 {
+			return status.Error(codes.Unavailable, "portal restarting")
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestCallGivesUpAfterMaxAttempts(t *testing.T) {
+	c := newRetryClient(&Config{Retry: testRetry})
+
+	var calls int
+	err := c.call(context.Background(), MethodCheckPolicy, func(context.Context, *grpc.ClientConn) error {
+		calls++
+		return status.Error(codes.Unavailable, "down")
+	})
+
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, 3, calls)
+
+	// Methods without a policy get a single attempt
+	calls = 0
+	_ = c.call(context.Background(), MethodReportSessionUpdate, func(context.Context, *grpc.ClientConn) error {
+		calls++
+		return status.Error(codes.Unavailable, "down")
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestCallBusinessErrorsDoNotTripBreaker(t *testing.T) {
+	breaker := newTestBreaker(t, 1)
+	c := newRetryClient(&Config{Retry: testRetry}, withBreaker(testEndpoint("portal-a"), breaker))
+
+	var calls int
+	err := c.call(context.Background(), MethodCheckPolicy, func(context.Context, *grpc.ClientConn) error {
+		calls++
+		return status.Error(codes.NotFound, "unknown user")
+	})
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, 1, calls, "business errors are not retried")
+	assert.Equal(t, resilience.StateClosed, breaker.State())
+}
+
+func TestCallFailsFastWhenBreakerOpen(t *testing.T) {
+	breaker := newTestBreaker(t, 2)
+	c := newRetryClient(&Config{Retry: testRetry}, withBreaker(testEndpoint("portal-a"), breaker))
+
+	var calls int
+	fn := func(context.Context, *grpc.ClientConn) error {
+		calls++
+		return status.Error(codes.Unavailable, "down")
+	}
+
+	// Two transient failures open the breaker, the third attempt is rejected
+	err := c.call(context.Background(), MethodCheckPolicy, fn)
+	assert.ErrorIs(t, err, resilience.ErrOpen)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, resilience.StateOpen, breaker.State())
+
+	calls = 0
+	err = c.call(context.Background(), MethodCheckPolicy, fn)
+	assert.ErrorIs(t, err, resilience.ErrOpen)
+	assert.Zero(t, calls)
+
+	health, ok := c.CheckHealth()
+	assert.False(t, ok)
+	assert.Equal(t, "active portal-a, 0/1 endpoints healthy, circuit breaker open", health)
+
+	endpoints := c.Endpoints()
+	assert.Equal(t, []EndpointHealth{{Address: "portal-a", Healthy: false, Breaker: "open"}}, endpoints)
+	assert.True(t, AllBreakersOpen(endpoints))
+}
+
+func TestCallSplitsDeadlineBudget(t *testing.T) {
+	c := newRetryClient(&Config{Timeout: 10 * time.Second, Retry: testRetry})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	var budgets []time.Duration
+	err := c.call(ctx, MethodCheckPolicy, func(ctx context.Context, _ *grpc.ClientConn) error {
+		deadline, ok := ctx.Deadline()
+		require.True(t, ok)
+		budgets = append(budgets, time.Until(deadline))
+		return status.Error(codes.Unavailable, "down")
+	})
+
+	require.Error(t, err)
+	require.Len(t, budgets, 3)
+	// The first of three attempts gets a third of the caller's budget,
+	// not the 10s client timeout
+	assert.LessOrEqual(t, budgets[0], 100*time.Millisecond)
+	assert.Greater(t, budgets[0], 50*time.Millisecond)
+}
+
+func TestCallStopsWhenBackoffExceedsDeadline(t *testing.T) {
+	c := newRetryClient(&Config{Retry: map[string]RetryPolicy{
+		MethodValidateSession: {MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour},
+	}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var calls int
+	start := time.Now()
+	err := c.call(ctx, MethodValidateSession, func(context.Context, *grpc.ClientConn) error {
+		calls++
+		return status.Error(codes.Unavailable, "down")
+	})
+
+	require.Error(t, err)
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		wait := backoff(policy, attempt)
+		assert.LessOrEqual(t, wait, time.Second)
+		assert.GreaterOrEqual(t, wait, 50*time.Millisecond)
+	}
+	assert.GreaterOrEqual(t, backoff(policy, 4), 400*time.Millisecond)
+	assert.Zero(t, backoff(RetryPolicy{MaxAttempts: 2}, 1))
+}
+
+// flakyAuthServer fails the first CheckPolicy calls with Unavailable
+type flakyAuthServer struct {
+	vpnv1.UnimplementedAuthServiceServer
+
+	failures atomic.Int32
+	calls    atomic.Int32
+}
+
+func (s *flakyAuthServer) CheckPolicy(context.Context, *vpnv1.CheckPolicyRequest) (*vpnv1.CheckPolicyResponse, error) {
+	if s.calls.Add(1) <= s.failures.Load() {
+		return nil, status.Error(codes.Unavailable, "warming up")
+	}
+	return &vpnv1.CheckPolicyResponse{Allowed: true}, nil
+}
+
+func TestClientCheckPolicyRetries(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	fake := &flakyAuthServer{}
+	fake.failures.Store(2)
+	server := grpc.NewServer()
+	vpnv1.RegisterAuthServiceServer(server, fake)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	breakerCfg := resilience.DefaultConfig()
+	client, err := NewClient(context.Background(), &Config{
+		Address:        lis.Addr().String(),
+		Insecure:       true,
+		Timeout:        time.Second,
+		CircuitBreaker: &breakerCfg,
+		Retry:          testRetry,
+	}, slog.Default(), tracenoop.NewTracerProvider().Tracer("test"), tracenoop.NewTracerProvider(), metricnoop.NewMeterProvider())
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	decision, err := client.CheckPolicy(context.Background(), "alice", "staff", "203.0.113.1")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, int32(3), fake.calls.Load())
+
+	health, ok := client.CheckHealth()
+	assert.True(t, ok)
+	assert.Equal(t, "active "+lis.Addr().String()+", 1/1 endpoints healthy, circuit breaker closed", health)
+}
@@ -0,0 +1,416 @@
+package radius
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// DefaultPort is the RADIUS accounting port used when a server has none
+const DefaultPort = "1813"
+
+// Session is the accounting view of a VPN session
+type Session struct {
+	ID         string
+	Username   string
+	ClientIP   string // sent as Calling-Station-Id
+	FramedIP   string // VPN IPv4 address
+	FramedIPv6 string // VPN IPv6 address
+	StartedAt  time.Time
+	EndedAt    time.Time // Stop only
+	BytesIn    uint64    // from the client
+	BytesOut   uint64    // to the client
+
+	// TerminateCause is the Acct-Terminate-Cause of Stop records
+	// (0 = User-Request)
+	TerminateCause uint32
+}
+
+// Config configures the accounting exporter
+type Config struct {
+	// Servers are tried in order; the first one that answers stays
+	// preferred until it fails
+	Servers []string
+	Secret  string
+	// Timeout is how long to wait for each (re)transmission
+	Timeout time.Duration
+	// Retries is the number of retransmissions per server
+	Retries int
+	// InterimInterval limits Interim-Update records per session
+	// (0 = send every update)
+	InterimInterval time.Duration
+	NASIdentifier   string
+	NASIPAddress    string
+	// QueueSize bounds records waiting to be sent; records beyond it
+	// are dropped
+	QueueSize int
+
+	Logger *slog.Logger
+	Meter  metric.Meter
+}
+
+// record is a queued accounting record
+type record struct {
+	status   StatusType
+	session  Session
+	queuedAt time.Time
+}
+
+// Exporter sends session accounting records to RADIUS servers.
+//
+// Records are queued and sent by a single worker, so Start, Interim and
+// Stop never block the caller.
+type Exporter struct {
+	servers         []string
+	secret          []byte
+	timeout         time.Duration
+	retries         int
+	interimInterval time.Duration
+	nasIdentifier   string
+	nasIPAddress    string
+	logger          *slog.Logger
+
+	queueMu   sync.RWMutex
+	queue     chan record
+	closed    bool          // guarded by queueMu; records are dropped once set
+	preferred atomic.Int32  // index of the server tried first
+	nextID    atomic.Uint32 // RADIUS packet identifier
+
+	mu          sync.Mutex
+	lastInterim map[string]time.Time // sessionID -> last Start/Interim queued
+
+	wg sync.WaitGroup
+
+	// Metrics
+	requestsTotal  metric.Int64Counter
+	failoversTotal metric.Int64Counter
+}
+
+// NewExporter creates a RADIUS accounting exporter
+func NewExporter(cfg *Config) (*Exporter, error) {
+	if len(cfg.Servers) == 0 {
+		return nil, fmt.Errorf("at least one server is required")
+	}
+	if cfg.Secret == "" {
+		return nil, fmt.Errorf("shared secret is required")
+	}
+	if cfg.Logger == nil {
+		return nil, fmt.Errorf("logger is required")
+	}
+	if cfg.Meter == nil {
+		return nil, fmt.Errorf("meter is required")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 3 * time.Second
+	}
+	if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.NASIdentifier == "" {
+		if hostname, err := os.Hostname(); err == nil {
+			cfg.NASIdentifier = hostname
+		}
+	}
+
+	servers := make([]string, 0, len(cfg.Servers))
+	for _, server := range cfg.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, DefaultPort)
+		}
+		servers = append(servers, server)
+	}
+
+	requestsTotal, err := cfg.Meter.Int64Counter(
+		"radius.accounting.requests.total",
+		metric.WithDescription("Total number of RADIUS accounting records by status type and result"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create requests counter: %w", err)
+	}
+
+	failoversTotal, err := cfg.Meter.Int64Counter(
+		"radius.accounting.failovers.total",
+		metric.WithDescription("Total number of failovers to another RADIUS server"),
+		metric.WithUnit("{failover}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create failovers counter: %w", err)
+	}
+
+	return &Exporter{
+		servers:         servers,
+		secret:          []byte(cfg.Secret),
+		timeout:         cfg.Timeout,
+		retries:         cfg.Retries,
+		interimInterval: cfg.InterimInterval,
+		nasIdentifier:   cfg.NASIdentifier,
+		nasIPAddress:    cfg.NASIPAddress,
+		logger:          cfg.Logger,
+		queue:           make(chan record, cfg.QueueSize),
+		lastInterim:     make(map[string]time.Time),
+		requestsTotal:   requestsTotal,
+		failoversTotal:  failoversTotal,
+	}, nil
+}
+
+// Start launches the sending worker
+func (e *Exporter) Start(ctx context.Context) {
+	e.wg.Add(1)
+	go e.run(ctx)
+}
+
+// Stop stops accepting records and waits for queued ones to be sent.
+// Records queued after Stop are dropped.
+func (e *Exporter) Stop(ctx context.Context) error {
+	e.queueMu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.queueMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("radius exporter shutdown: %w", ctx.Err())
+	}
+}
+
+// SessionStart queues an Accounting-Request Start
+func (e *Exporter) SessionStart(ctx context.Context, session Session) {
+	e.mu.Lock()
+	e.lastInterim[session.ID] = time.Now()
+	e.mu.Unlock()
+
+	e.enqueue(ctx, StatusStart, session)
+}
+
+// SessionInterim queues an Accounting-Request Interim-Update unless one was
+// queued for the session within the interim interval
+func (e *Exporter) SessionInterim(ctx context.Context, session Session) {
+	now := time.Now()
+
+	e.mu.Lock()
+	if last, ok := e.lastInterim[session.ID]; ok && now.Sub(last) < e.interimInterval {
+		e.mu.Unlock()
+		return
+	}
+	e.lastInterim[session.ID] = now
+	e.mu.Unlock()
+
+	e.enqueue(ctx, StatusInterim, session)
+}
+
+// SessionStop queues an Accounting-Request Stop
+func (e *Exporter) SessionStop(ctx context.Context, session Session) {
+	e.mu.Lock()
+	delete(e.lastInterim, session.ID)
+	e.mu.Unlock()
+
+	e.enqueue(ctx, StatusStop, session)
+}
+
+// enqueue hands a record to the worker without blocking
+func (e *Exporter) enqueue(ctx context.Context, status StatusType, session Session) {
+	e.queueMu.RLock()
+	defer e.queueMu.RUnlock()
+
+	if e.closed {
+		e.requestsTotal.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("status_type", status.String()),
+			attribute.String("result", "stopped"),
+		))
+		e.logger.WarnContext(ctx, "radius exporter stopped, dropping record",
+			slog.String("status_type", status.String()),
+			slog.String("session_id", session.ID),
+		)
+		return
+	}
+
+	select {
+	case e.queue <- record{status: status, session: session, queuedAt: time.Now()}:
+	default:
+		e.requestsTotal.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("status_type", status.String()),
+			attribute.String("result", "dropped"),
+		))
+		e.logger.WarnContext(ctx, "radius accounting queue full, dropping record",
+			slog.String("status_type", status.String()),
+			slog.String("session_id", session.ID),
+		)
+	}
+}
+
+// run sends queued records until the queue is closed
+func (e *Exporter) run(ctx context.Context) {
+	defer e.wg.Done()
+
+	for rec := range e.queue {
+		err := e.Send(ctx, rec.status, rec.session, time.Since(rec.queuedAt))
+
+		result := "ok"
+		if err != nil {
+			result = "failed"
+			e.logger.ErrorContext(ctx, "radius accounting failed",
+				slog.String("status_type", rec.status.String()),
+				slog.String("session_id", rec.session.ID),
+				slog.String("username", rec.session.Username),
+				slog.String("error", err.Error()),
+			)
+		}
+		e.requestsTotal.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("status_type", rec.status.String()),
+			attribute.String("result", result),
+		))
+	}
+}
+
+// Send builds an Accounting-Request and delivers it, retransmitting and
+// failing over between servers. delay is reported as Acct-Delay-Time.
+func (e *Exporter) Send(ctx context.Context, status StatusType, session Session, delay time.Duration) error {
+	pkt := e.buildPacket(status, session, delay)
+	pkt.Identifier = byte(e.nextID.Add(1)) // #nosec G115 - identifier wraps at 256 by design
+
+	raw, err := pkt.EncodeAccountingRequest(e.secret)
+	if err != nil {
+		return fmt.Errorf("encode accounting request: %w", err)
+	}
+
+	start := int(e.preferred.Load())
+	var errs []error
+	for i := range e.servers {
+		idx := (start + i) % len(e.servers)
+		server := e.servers[idx]
+
+		if err := e.exchange(ctx, server, pkt, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+
+		if idx != start {
+			e.preferred.Store(int32(idx)) // #nosec G115 - bounded by len(servers)
+			e.failoversTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("server", server)))
+			e.logger.WarnContext(ctx, "radius accounting failed over",
+				slog.String("from", e.servers[start]),
+				slog.String("to", server),
+			)
+		}
+		return nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// exchange sends raw to a single server and waits for a matching
+// Accounting-Response, retransmitting on timeout
+func (e *Exporter) exchange(ctx context.Context, server string, pkt *Packet, raw []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	buf := make([]byte, maxPacketLen)
+	for attempt := 0; attempt <= e.retries; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := conn.Write(raw); err != nil {
+			return err
+		}
+
+		deadline := time.Now().Add(e.timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break // retransmit
+				}
+				return err
+			}
+			// Late answers to earlier packets or forged responses are ignored
+			if verifyAccountingResponse(buf[:n], pkt, e.secret) == nil {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no response after %d attempts", e.retries+1)
+}
+
+// buildPacket fills the accounting attributes for a session
+func (e *Exporter) buildPacket(status StatusType, session Session, delay time.Duration) *Packet {
+	pkt := &Packet{}
+	pkt.AddUint32(AttrAcctStatusType, uint32(status))
+	pkt.AddString(AttrAcctSessionID, session.ID)
+	pkt.AddString(AttrUserName, session.Username)
+	pkt.AddString(AttrNASIdentifier, e.nasIdentifier)
+	pkt.AddIPv4(AttrNASIPAddress, e.nasIPAddress)
+	pkt.AddUint32(AttrNASPortType, nasPortTypeVirtual)
+	pkt.AddString(AttrCallingStationID, session.ClientIP)
+	pkt.AddIPv4(AttrFramedIPAddress, session.FramedIP)
+	pkt.AddIPv6(AttrFramedIPv6Address, session.FramedIPv6)
+	pkt.AddUint32(AttrAcctDelayTime, uint32(delay/time.Second)) // #nosec G115 - delay is small and non-negative
+
+	eventTime := time.Now().Add(-delay)
+	if status == StatusStart && !session.StartedAt.IsZero() {
+		eventTime = session.StartedAt
+	}
+
+	if status != StatusStart {
+		pkt.AddOctets(AttrAcctInputOctets, AttrAcctInputGigawords, session.BytesIn)
+		pkt.AddOctets(AttrAcctOutputOctets, AttrAcctOutputGigawords, session.BytesOut)
+
+		end := eventTime
+		if status == StatusStop && !session.EndedAt.IsZero() {
+			end = session.EndedAt
+			eventTime = end
+		}
+		if !session.StartedAt.IsZero() && end.After(session.StartedAt) {
+			pkt.AddUint32(AttrAcctSessionTime, uint32(end.Sub(session.StartedAt)/time.Second)) // #nosec G115 - session length in seconds
+		} else {
+			pkt.AddUint32(AttrAcctSessionTime, 0)
+		}
+	}
+
+	if status == StatusStop {
+		cause := session.TerminateCause
+		if cause == 0 {
+			cause = TerminateUserRequest
+		}
+		pkt.AddUint32(AttrAcctTerminateCause, cause)
+	}
+
+	pkt.AddTime(AttrEventTimestamp, eventTime)
+
+	return pkt
+}
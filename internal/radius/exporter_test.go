@@ -0,0 +1,283 @@
+package radius
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+const testSecret = "testing123"
+
+// standIn is a local UDP RADIUS accounting server
+type standIn struct {
+	conn   net.PacketConn
+	secret []byte
+
+	mu       sync.Mutex
+	requests []*Packet
+	drop     int // number of requests to ignore before answering
+	silent   bool
+}
+
+func newStandIn(t *testing.T, secret string, opts ...func(*standIn)) *standIn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &standIn{conn: conn, secret: []byte(secret)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go s.serve()
+
+	return s
+}
+
+func (s *standIn) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *standIn) serve() {
+	buf := make([]byte, maxPacketLen)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		raw := append([]byte(nil), buf[:n]...)
+		if !VerifyAccountingRequest(raw, s.secret) {
+			continue
+		}
+		pkt, err := Decode(raw)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, pkt)
+		answer := !s.silent && s.drop == 0
+		if s.drop > 0 {
+			s.drop--
+		}
+		s.mu.Unlock()
+
+		if !answer {
+			continue
+		}
+		resp, err := EncodeAccountingResponse(pkt, s.secret)
+		if err != nil {
+			continue
+		}
+		_, _ = s.conn.WriteTo(resp, peer)
+	}
+}
+
+func (s *standIn) received() []*Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Packet(nil), s.requests...)
+}
+
+func newTestExporter(t *testing.T, servers []string, interim time.Duration) *Exporter {
+	t.Helper()
+
+	e, err := NewExporter(&Config{
+		Servers:         servers,
+		Secret:          testSecret,
+		Timeout:         50 * time.Millisecond,
+		Retries:         1,
+		InterimInterval: interim,
+		NASIdentifier:   "vpn-1",
+		Logger:          slog.Default(),
+		Meter:           noop.NewMeterProvider().Meter("test"),
+	})
+	require.NoError(t, err)
+	return e
+}
+
+func testSession() Session {
+	return Session{
+		ID:         "42",
+		Username:   "alice",
+		ClientIP:   "203.0.113.10",
+		FramedIP:   "10.0.16.23",
+		FramedIPv6: "fc00::1:8651",
+		StartedAt:  time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC),
+		EndedAt:    time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+		BytesIn:    5<<32 + 1000,
+		BytesOut:   2000,
+	}
+}
+
+func TestExporterSendStop(t *testing.T) {
+	server := newStandIn(t, testSecret)
+	e := newTestExporter(t, []string{server.addr()}, 0)
+
+	require.NoError(t, e.Send(context.Background(), StatusStop, testSession(), 2*time.Second))
+
+	reqs := server.received()
+	require.Len(t, reqs, 1)
+	pkt := reqs[0]
+
+	status, _ := pkt.GetUint32(AttrAcctStatusType)
+	assert.Equal(t, uint32(StatusStop), status)
+	sessionID, _ := pkt.Get(AttrAcctSessionID)
+	assert.Equal(t, "42", string(sessionID))
+	user, _ := pkt.Get(AttrUserName)
+	assert.Equal(t, "alice", string(user))
+	calling, _ := pkt.Get(AttrCallingStationID)
+	assert.Equal(t, "203.0.113.10", string(calling))
+	framed, _ := pkt.Get(AttrFramedIPAddress)
+	assert.Equal(t, net.IPv4(10, 0, 16, 23).To4(), net.IP(framed))
+	framed6, _ := pkt.Get(AttrFramedIPv6Address)
+	assert.Equal(t, net.ParseIP("fc00::1:8651"), net.IP(framed6))
+
+	inOctets, _ := pkt.GetUint32(AttrAcctInputOctets)
+	inGiga, _ := pkt.GetUint32(AttrAcctInputGigawords)
+	assert.Equal(t, uint32(1000), inOctets)
+	assert.Equal(t, uint32(5), inGiga)
+	outOctets, _ := pkt.GetUint32(AttrAcctOutputOctets)
+	outGiga, _ := pkt.GetUint32(AttrAcctOutputGigawords)
+	assert.Equal(t, uint32(2000), outOctets)
+	assert.Equal(t, uint32(0), outGiga)
+
+	sessionTime, _ := pkt.GetUint32(AttrAcctSessionTime)
+	assert.Equal(t, uint32(3600), sessionTime)
+	cause, _ := pkt.GetUint32(AttrAcctTerminateCause)
+	assert.Equal(t, TerminateUserRequest, cause)
+	delay, _ := pkt.GetUint32(AttrAcctDelayTime)
+	assert.Equal(t, uint32(2), delay)
+}
+
+func TestExporterStartHasNoCounters(t *testing.T) {
+	server := newStandIn(t, testSecret)
+	e := newTestExporter(t, []string{server.addr()}, 0)
+
+	require.NoError(t, e.Send(context.Background(), StatusStart, testSession(), 0))
+
+	reqs := server.received()
+	require.Len(t, reqs, 1)
+	_, ok := reqs[0].Get(AttrAcctInputOctets)
+	assert.False(t, ok)
+	_, ok = reqs[0].Get(AttrAcctTerminateCause)
+	assert.False(t, ok)
+	ts, _ := reqs[0].GetUint32(AttrEventTimestamp)
+	assert.Equal(t, uint32(testSession().StartedAt.Unix()), ts)
+}
+
+func TestExporterRetransmits(t *testing.T) {
+	server := newStandIn(t, testSecret, func(s *standIn) { s.drop = 1 })
+	e := newTestExporter(t, []string{server.addr()}, 0)
+
+	require.NoError(t, e.Send(context.Background(), StatusInterim, testSession(), 0))
+
+	reqs := server.received()
+	require.Len(t, reqs, 2)
+	assert.Equal(t, reqs[0].Identifier, reqs[1].Identifier)
+}
+
+func TestExporterFailover(t *testing.T) {
+	primary := newStandIn(t, testSecret, func(s *standIn) { s.silent = true })
+	secondary := newStandIn(t, testSecret)
+	e := newTestExporter(t, []string{primary.addr(), secondary.addr()}, 0)
+
+	require.NoError(t, e.Send(context.Background(), StatusStart, testSession(), 0))
+	assert.Len(t, primary.received(), 2) // first try + one retransmit
+	assert.Len(t, secondary.received(), 1)
+
+	// The secondary stays preferred for the next record
+	require.NoError(t, e.Send(context.Background(), StatusStop, testSession(), 0))
+	assert.Len(t, primary.received(), 2)
+	assert.Len(t, secondary.received(), 2)
+}
+
+func TestExporterWrongSecret(t *testing.T) {
+	// The server cannot verify our requests and never answers
+	server := newStandIn(t, "other-secret")
+	e := newTestExporter(t, []string{server.addr()}, 0)
+
+	err := e.Send(context.Background(), StatusStart, testSession(), 0)
+	assert.Error(t, err)
+	assert.Empty(t, server.received())
+}
+
+func TestExporterQueueAndInterimInterval(t *testing.T) {
+	server := newStandIn(t, testSecret)
+	e := newTestExporter(t, []string{server.addr()}, time.Hour)
+
+	ctx := context.Background()
+	e.Start(ctx)
+
+	session := testSession()
+	e.SessionStart(ctx, session)
+	e.SessionInterim(ctx, session) // suppressed: within the interim interval of Start
+	e.SessionStop(ctx, session)
+	e.SessionInterim(ctx, session) // session unknown again, sent
+
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, e.Stop(stopCtx))
+
+	var statuses []uint32
+	for _, pkt := range server.received() {
+		status, _ := pkt.GetUint32(AttrAcctStatusType)
+		statuses = append(statuses, status)
+	}
+	assert.Equal(t, []uint32{uint32(StatusStart), uint32(StatusStop), uint32(StatusInterim)}, statuses)
+}
+
+func TestExporterDropsRecordsAfterStop(t *testing.T) {
+	server := newStandIn(t, testSecret)
+	e := newTestExporter(t, []string{server.addr()}, 0)
+
+	ctx := context.Background()
+	e.Start(ctx)
+	require.NoError(t, e.Stop(ctx))
+	require.NoError(t, e.Stop(ctx), "stopping twice is harmless")
+
+	// Session events racing with shutdown must not panic
+	session := testSession()
+	e.SessionStart(ctx, session)
+	e.SessionInterim(ctx, session)
+	e.SessionStop(ctx, session)
+
+	assert.Empty(t, server.received())
+}
+
+func TestNewExporterValidation(t *testing.T) {
+	meter := noop.NewMeterProvider().Meter("test")
+
+	_, err := NewExporter(&Config{Secret: "s", Logger: slog.Default(), Meter: meter})
+	assert.Error(t, err)
+
+	_, err = NewExporter(&Config{Servers: []string{"127.0.0.1"}, Logger: slog.Default(), Meter: meter})
+	assert.Error(t, err)
+
+	e, err := NewExporter(&Config{Servers: []string{"127.0.0.1"}, Secret: "s", Logger: slog.Default(), Meter: meter})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1:1813"}, e.servers)
+}
+
+func TestDecodeRejectsMalformed(t *testing.T) {
+	_, err := Decode([]byte{4, 1, 0})
+	assert.Error(t, err)
+
+	// Length field larger than the datagram
+	_, err = Decode(append([]byte{4, 1, 0, 40}, make([]byte, 16)...))
+	assert.Error(t, err)
+
+	// Attribute length runs past the end
+	raw := append([]byte{4, 1, 0, 23}, make([]byte, 16)...)
+	raw = append(raw, 1, 9, 'x')
+	_, err = Decode(raw)
+	assert.Error(t, err)
+}
@@ -36,6 +36,10 @@ type CacheEntry struct {
 	StaleAt    time.Time // when entry becomes stale but can still be used
 	AccessCount int64
 	LastAccess time.Time
+	// Restored marks a decision loaded from a snapshot: invalidations may
+	// have been missed while the agent was down, so it only stands in for
+	// an unreachable portal
+	Restored bool
 }
 
 // IsValid checks if entry is still valid (not expired)
@@ -129,11 +133,13 @@ func (dc *DecisionCache) Get(ctx context.Context, key string) (interface{}, bool
 		return nil, false, nil
 	}
 
-	// Update access stats
+	// Update access stats; callers get a copy, the entry keeps changing
 	dc.mu.Lock()
 	entry.AccessCount++
 	entry.LastAccess = time.Now()
+	snapshot := *entry
 	dc.mu.Unlock()
+	entry = &snapshot
 
 	// Check if entry is valid
 	if entry.IsValid() {
@@ -216,6 +222,26 @@ func (dc *DecisionCache) Delete(ctx context.Context, key string) {
 	dc.sizeGauge.Record(ctx, int64(len(dc.entries)))
 }
 
+// DeleteFunc removes all entries whose key matches and returns how many
+// were removed
+func (dc *DecisionCache) DeleteFunc(ctx context.Context, match func(key string) bool) int {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	removed := 0
+	for key := range dc.entries {
+		if match(key) {
+			delete(dc.entries, key)
+			removed++
+		}
+	}
+
+	// Update size gauge
+	dc.sizeGauge.Record(ctx, int64(len(dc.entries)))
+
+	return removed
+}
+
 // Clear removes all entries from cache
 func (dc *DecisionCache) Clear(ctx context.Context) {
 	dc.mu.Lock()
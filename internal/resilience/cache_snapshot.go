@@ -0,0 +1,237 @@
+package resilience
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// snapshotVersion is the format version of decision cache snapshots
+const snapshotVersion = 1
+
+// snapshotMagic prefixes encrypted snapshots: magic | nonce | AES-GCM ciphertext
+var snapshotMagic = []byte("OCDC1")
+
+// ErrSnapshotEncrypted is returned when an encrypted snapshot is loaded without a key
+var ErrSnapshotEncrypted = errors.New("decision cache snapshot is encrypted")
+
+// cacheSnapshot is the on-disk form of the decision cache
+type cacheSnapshot struct {
+	Version int             `json:"version"`
+	SavedAt time.Time       `json:"saved_at"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+// snapshotEntry is a persisted decision
+type snapshotEntry struct {
+	Key        string    `json:"key"`
+	Allowed    bool      `json:"allowed"`
+	DenyReason string    `json:"deny_reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	StaleAt    time.Time `json:"stale_at"`
+}
+
+// LoadSnapshotKey reads a hex-encoded AES-256 key (64 hex characters)
+func LoadSnapshotKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read snapshot key")
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode snapshot key")
+	}
+	if len(key) != 32 {
+		return nil, errors.Newf("snapshot key must be 32 bytes, got %d", len(key))
+	}
+
+	return key, nil
+}
+
+// SaveSnapshot atomically writes all cached decisions to path, readable by
+// the owner only. With a key the snapshot is encrypted with AES-256-GCM.
+func (dc *DecisionCache) SaveSnapshot(path string, key []byte) error {
+	dc.mu.RLock()
+	snapshot := cacheSnapshot{
+		Version: snapshotVersion,
+		SavedAt: time.Now(),
+		Entries: make([]snapshotEntry, 0, len(dc.entries)),
+	}
+	for k, entry := range dc.entries {
+		snapshot.Entries = append(snapshot.Entries, snapshotEntry{
+			Key:        k,
+			Allowed:    entry.Allowed,
+			DenyReason: entry.DenyReason,
+			CreatedAt:  entry.CreatedAt,
+			ExpiresAt:  entry.ExpiresAt,
+			StaleAt:    entry.StaleAt,
+		})
+	}
+	dc.mu.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.Wrap(err, "marshal snapshot")
+	}
+	if key != nil {
+		if data, err = sealSnapshot(data, key); err != nil {
+			return err
+		}
+	}
+
+	return writeSnapshotFile(path, data)
+}
+
+// LoadSnapshot restores decisions saved by SaveSnapshot and returns how
+// many were loaded. Decisions past their stale TTL are dropped; the
+// others keep their original expiry and are marked Restored until the
+// portal decides again. A missing file loads nothing.
+func (dc *DecisionCache) LoadSnapshot(ctx context.Context, path string, key []byte) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "read snapshot")
+	}
+
+	if bytes.HasPrefix(data, snapshotMagic) {
+		if key == nil {
+			return 0, ErrSnapshotEncrypted
+		}
+		if data, err = openSnapshot(data, key); err != nil {
+			return 0, err
+		}
+	}
+
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, errors.Wrap(err, "parse snapshot")
+	}
+	if snapshot.Version != snapshotVersion {
+		return 0, errors.Newf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	now := time.Now()
+	loaded := 0
+	for _, e := range snapshot.Entries {
+		if !now.Before(e.StaleAt) {
+			continue
+		}
+		if _, exists := dc.entries[e.Key]; !exists && len(dc.entries) >= dc.config.MaxSize {
+			dc.evictOldest()
+		}
+		dc.entries[e.Key] = &CacheEntry{
+			Allowed:    e.Allowed,
+			DenyReason: e.DenyReason,
+			CreatedAt:  e.CreatedAt,
+			ExpiresAt:  e.ExpiresAt,
+			StaleAt:    e.StaleAt,
+			LastAccess: e.CreatedAt,
+			Restored:   true,
+		}
+		loaded++
+	}
+
+	// Update size gauge
+	dc.sizeGauge.Record(ctx, int64(len(dc.entries)))
+
+	return loaded, nil
+}
+
+// sealSnapshot encrypts a snapshot with AES-256-GCM
+func sealSnapshot(plaintext, key []byte) ([]byte, error) {
+	gcm, err := snapshotCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "generate nonce")
+	}
+
+	out := append(bytes.Clone(snapshotMagic), nonce...)
+	return gcm.Seal(out, nonce, plaintext, snapshotMagic), nil
+}
+
+// openSnapshot decrypts a snapshot sealed by sealSnapshot
+func openSnapshot(data, key []byte) ([]byte, error) {
+	gcm, err := snapshotCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data = data[len(snapshotMagic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("snapshot is truncated")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, snapshotMagic)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt snapshot")
+	}
+	return plaintext, nil
+}
+
+// snapshotCipher creates the AES-GCM cipher of a snapshot key
+func snapshotCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "create snapshot cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "create snapshot cipher")
+	}
+	return gcm, nil
+}
+
+// writeSnapshotFile atomically replaces path with data (mode 0600)
+func writeSnapshotFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return errors.Wrap(err, "create snapshot directory")
+	}
+
+	// CreateTemp opens the file with mode 0600
+	tmp, err := os.CreateTemp(dir, ".decisions-*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp snapshot")
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write snapshot")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "sync snapshot")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "close snapshot")
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "replace snapshot")
+	}
+	return nil
+}
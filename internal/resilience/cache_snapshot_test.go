@@ -0,0 +1,125 @@
+package resilience
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func newTestCache(t *testing.T, cfg CacheConfig) *DecisionCache {
+	t.Helper()
+
+	dc, err := NewDecisionCache(cfg, tracenoop.NewTracerProvider().Tracer("test"), metricnoop.NewMeterProvider().Meter("test"))
+	require.NoError(t, err)
+	return dc
+}
+
+func TestDecisionCache_SnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache", "decisions.json")
+
+	src := newTestCache(t, DefaultCacheConfig())
+	require.NoError(t, src.Set(ctx, "alice:staff:203.0.113.1", true, ""))
+	require.NoError(t, src.Set(ctx, "bob:staff:203.0.113.2", false, "blocked"))
+	require.NoError(t, src.SaveSnapshot(path, nil))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	dst := newTestCache(t, DefaultCacheConfig())
+	loaded, err := dst.LoadSnapshot(ctx, path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, loaded)
+
+	entry, found, err := dst.Get(ctx, "bob:staff:203.0.113.2")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.False(t, entry.(*CacheEntry).Allowed)
+	assert.Equal(t, "blocked", entry.(*CacheEntry).DenyReason)
+	assert.True(t, entry.(*CacheEntry).Restored)
+
+	// A fresh decision replaces the restored one
+	require.NoError(t, dst.Set(ctx, "bob:staff:203.0.113.2", false, "blocked"))
+	entry, _, err = dst.Get(ctx, "bob:staff:203.0.113.2")
+	require.NoError(t, err)
+	assert.False(t, entry.(*CacheEntry).Restored)
+}
+
+func TestDecisionCache_SnapshotDropsEntriesPastStaleTTL(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "decisions.json")
+
+	src := newTestCache(t, CacheConfig{TTL: time.Millisecond, StaleTTL: 50 * time.Millisecond, MaxSize: 10})
+	require.NoError(t, src.Set(ctx, "alice:staff:203.0.113.1", true, ""))
+	require.NoError(t, src.SaveSnapshot(path, nil))
+	time.Sleep(5 * time.Millisecond)
+
+	// Expired but within the stale TTL: served by fail_mode stale
+	dst := newTestCache(t, DefaultCacheConfig())
+	loaded, err := dst.LoadSnapshot(ctx, path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	entry, found, _ := dst.Get(ctx, "alice:staff:203.0.113.1")
+	require.True(t, found)
+	assert.True(t, entry.(*CacheEntry).IsStale())
+
+	time.Sleep(60 * time.Millisecond)
+
+	dst = newTestCache(t, DefaultCacheConfig())
+	loaded, err = dst.LoadSnapshot(ctx, path, nil)
+	require.NoError(t, err)
+	assert.Zero(t, loaded)
+}
+
+func TestDecisionCache_EncryptedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "decisions.bin")
+
+	keyPath := filepath.Join(dir, "snapshot.key")
+	require.NoError(t, os.WriteFile(keyPath, []byte(hex.EncodeToString(bytes.Repeat([]byte{7}, 32))+"\n"), 0o600))
+	key, err := LoadSnapshotKey(keyPath)
+	require.NoError(t, err)
+
+	src := newTestCache(t, DefaultCacheConfig())
+	require.NoError(t, src.Set(ctx, "alice:staff:203.0.113.1", true, ""))
+	require.NoError(t, src.SaveSnapshot(path, key))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "alice")
+
+	_, err = newTestCache(t, DefaultCacheConfig()).LoadSnapshot(ctx, path, nil)
+	assert.ErrorIs(t, err, ErrSnapshotEncrypted)
+
+	_, err = newTestCache(t, DefaultCacheConfig()).LoadSnapshot(ctx, path, bytes.Repeat([]byte{8}, 32))
+	assert.ErrorContains(t, err, "decrypt snapshot")
+
+	loaded, err := newTestCache(t, DefaultCacheConfig()).LoadSnapshot(ctx, path, key)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+}
+
+func TestDecisionCache_LoadMissingSnapshot(t *testing.T) {
+	loaded, err := newTestCache(t, DefaultCacheConfig()).LoadSnapshot(context.Background(), filepath.Join(t.TempDir(), "missing"), nil)
+	require.NoError(t, err)
+	assert.Zero(t, loaded)
+}
+
+func TestLoadSnapshotKeyRejectsShortKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.key")
+	require.NoError(t, os.WriteFile(path, []byte("abcd"), 0o600))
+
+	_, err := LoadSnapshotKey(path)
+	assert.ErrorContains(t, err, "32 bytes")
+}
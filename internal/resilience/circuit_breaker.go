@@ -34,12 +34,23 @@ func (s State) String() string {
 	}
 }
 
+// ErrOpen is returned by Execute when the circuit rejects a call (open, or
+// half-open with all probe slots taken)
+var ErrOpen = errors.New("circuit breaker is open")
+
 // Config holds circuit breaker configuration
 type Config struct {
 	MaxRequests      uint32        // max requests in half-open state
 	Interval         time.Duration // interval to reset failure counter
 	Timeout          time.Duration // timeout in open state
 	FailureThreshold uint32        // failures to open circuit
+
+	// Name identifies the breaker in metrics when several are in use
+	Name string
+
+	// OnStateChange is called on every state transition while the breaker
+	// lock is held; it must not call back into the breaker
+	OnStateChange func(from, to State)
 }
 
 // DefaultConfig returns default circuit breaker config
@@ -118,15 +129,12 @@ func (cb *CircuitBreaker) Execute(ctx context.Context, fn func(context.Context)
 	defer span.End()
 
 	// Record request
-	cb.requestsTotal.Add(ctx, 1, metric.WithAttributes(
-		attribute.String("state", cb.State().String()),
-	))
+	cb.requestsTotal.Add(ctx, 1, cb.attributes(cb.State()))
 
 	// Check if circuit is open
-	if !cb.canExecute() {
-		err := errors.Newf("circuit breaker is %s", cb.State())
-		span.RecordError(err)
-		return err
+	if !cb.canExecute(ctx) {
+		span.RecordError(ErrOpen)
+		return ErrOpen
 	}
 
 	// Execute function
@@ -151,7 +159,7 @@ func (cb *CircuitBreaker) State() State {
 }
 
 // canExecute checks if request can be executed
-func (cb *CircuitBreaker) canExecute() bool {
+func (cb *CircuitBreaker) canExecute(ctx context.Context) bool {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
@@ -168,8 +176,8 @@ func (cb *CircuitBreaker) canExecute() bool {
 	case StateOpen:
 		// Transition to half-open if timeout passed
 		if now.Sub(cb.lastStateChange) > cb.config.Timeout {
-			cb.setState(StateHalfOpen)
-			cb.requests = 0
+			cb.setState(ctx, StateHalfOpen)
+			cb.requests = 1 // this request is the first probe
 			return true
 		}
 		return false
@@ -195,9 +203,8 @@ func (cb *CircuitBreaker) recordSuccess(ctx context.Context) {
 	if cb.state == StateHalfOpen {
 		// Transition to closed after successful requests
 		if cb.requests >= cb.config.MaxRequests {
-			cb.setState(StateClosed)
+			cb.setState(ctx, StateClosed)
 			cb.failures = 0
-			cb.recordState(ctx)
 		}
 	} else if cb.state == StateClosed {
 		// Reset failure counter on success
@@ -214,25 +221,30 @@ func (cb *CircuitBreaker) recordFailure(ctx context.Context) {
 	cb.lastFailure = time.Now()
 
 	// Record failure metric
-	cb.failuresTotal.Add(ctx, 1, metric.WithAttributes(
-		attribute.String("state", cb.state.String()),
-	))
+	cb.failuresTotal.Add(ctx, 1, cb.attributes(cb.state))
 
 	if cb.state == StateHalfOpen {
 		// Immediately open on failure in half-open
-		cb.setState(StateOpen)
-		cb.recordState(ctx)
+		cb.setState(ctx, StateOpen)
 	} else if cb.state == StateClosed && cb.failures >= cb.config.FailureThreshold {
 		// Open circuit if threshold exceeded
-		cb.setState(StateOpen)
-		cb.recordState(ctx)
+		cb.setState(ctx, StateOpen)
 	}
 }
 
 // setState changes circuit breaker state
-func (cb *CircuitBreaker) setState(state State) {
+func (cb *CircuitBreaker) setState(ctx context.Context, state State) {
+	prev := cb.state
 	cb.state = state
 	cb.lastStateChange = time.Now()
+
+	if prev == state {
+		return
+	}
+	cb.recordState(ctx)
+	if cb.config.OnStateChange != nil {
+		cb.config.OnStateChange(prev, state)
+	}
 }
 
 // recordState records current state to metrics
@@ -247,7 +259,20 @@ func (cb *CircuitBreaker) recordState(ctx context.Context) {
 		stateValue = 2
 	}
 
-	cb.stateGauge.Record(ctx, stateValue)
+	var opts []metric.RecordOption
+	if cb.config.Name != "" {
+		opts = append(opts, metric.WithAttributes(attribute.String("name", cb.config.Name)))
+	}
+	cb.stateGauge.Record(ctx, stateValue, opts...)
+}
+
+// attributes returns metric attributes of a request in the given state
+func (cb *CircuitBreaker) attributes(state State) metric.MeasurementOption {
+	attrs := []attribute.KeyValue{attribute.String("state", state.String())}
+	if cb.config.Name != "" {
+		attrs = append(attrs, attribute.String("name", cb.config.Name))
+	}
+	return metric.WithAttributes(attrs...)
 }
 
 // Stats returns current circuit breaker statistics
@@ -269,10 +294,9 @@ func (cb *CircuitBreaker) Reset(ctx context.Context) {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
-	cb.setState(StateClosed)
+	cb.setState(ctx, StateClosed)
 	cb.failures = 0
 	cb.requests = 0
-	cb.recordState(ctx)
 }
 
 // String returns circuit breaker status string
@@ -0,0 +1,134 @@
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+var errTest = errors.New("test error")
+
+func newTestBreaker(t *testing.T, cfg Config) *CircuitBreaker {
+	t.Helper()
+
+	cb, err := NewCircuitBreaker(cfg, tracenoop.NewTracerProvider().Tracer("test"), metricnoop.NewMeterProvider().Meter("test"))
+	require.NoError(t, err)
+	return cb
+}
+
+func fail(context.Context) error    { return errTest }
+func succeed(context.Context) error { return nil }
+
+func TestCircuitBreaker_OpensOnFailures(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FailureThreshold = 3
+	cb := newTestBreaker(t, cfg)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, cb.Execute(ctx, fail), errTest)
+	}
+	assert.Equal(t, StateOpen, cb.State())
+
+	called := false
+	err := cb.Execute(ctx, func(context.Context) error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrOpen)
+	assert.False(t, called, "open circuit must not run the call")
+}
+
+func TestCircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FailureThreshold = 2
+	cb := newTestBreaker(t, cfg)
+	ctx := context.Background()
+
+	_ = cb.Execute(ctx, fail)
+	require.NoError(t, cb.Execute(ctx, succeed))
+	_ = cb.Execute(ctx, fail)
+
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestCircuitBreaker_HalfOpenRecovery(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FailureThreshold = 1
+	cfg.Timeout = 20 * time.Millisecond
+	cfg.MaxRequests = 2
+	cb := newTestBreaker(t, cfg)
+	ctx := context.Background()
+
+	_ = cb.Execute(ctx, fail)
+	require.Equal(t, StateOpen, cb.State())
+
+	time.Sleep(30 * time.Millisecond)
+
+	require.NoError(t, cb.Execute(ctx, succeed))
+	assert.Equal(t, StateHalfOpen, cb.State())
+	require.NoError(t, cb.Execute(ctx, succeed))
+	assert.Equal(t, StateClosed, cb.State())
+}
+
+func TestCircuitBreaker_HalfOpenFailureReopens(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FailureThreshold = 1
+	cfg.Timeout = 20 * time.Millisecond
+	cb := newTestBreaker(t, cfg)
+	ctx := context.Background()
+
+	_ = cb.Execute(ctx, fail)
+	time.Sleep(30 * time.Millisecond)
+
+	assert.ErrorIs(t, cb.Execute(ctx, fail), errTest)
+	assert.Equal(t, StateOpen, cb.State())
+	assert.ErrorIs(t, cb.Execute(ctx, succeed), ErrOpen)
+}
+
+func TestCircuitBreaker_OnStateChange(t *testing.T) {
+	type transition struct{ from, to State }
+	var transitions []transition
+
+	cfg := DefaultConfig()
+	cfg.FailureThreshold = 1
+	cfg.Timeout = 20 * time.Millisecond
+	cfg.MaxRequests = 1
+	cfg.OnStateChange = func(from, to State) {
+		transitions = append(transitions, transition{from, to})
+	}
+	cb := newTestBreaker(t, cfg)
+	ctx := context.Background()
+
+	_ = cb.Execute(ctx, fail)
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, cb.Execute(ctx, succeed))
+	cb.Reset(ctx) // already closed: no transition
+
+	assert.Equal(t, []transition{
+		{StateClosed, StateOpen},
+		{StateOpen, StateHalfOpen},
+		{StateHalfOpen, StateClosed},
+	}, transitions)
+}
+
+func TestCircuitBreaker_Reset(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FailureThreshold = 1
+	cb := newTestBreaker(t, cfg)
+	ctx := context.Background()
+
+	_ = cb.Execute(ctx, fail)
+	require.Equal(t, StateOpen, cb.State())
+
+	cb.Reset(ctx)
+	assert.Equal(t, StateClosed, cb.State())
+	assert.NoError(t, cb.Execute(ctx, succeed))
+	assert.Equal(t, "closed", cb.Stats()["state"])
+}
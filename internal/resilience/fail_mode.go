@@ -0,0 +1,134 @@
+package resilience
+
+import (
+	"net/netip"
+	"path"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Fail modes applied when the portal is unavailable
+const (
+	FailModeOpen  = "open"  // allow the connection
+	FailModeClose = "close" // deny the connection
+	FailModeStale = "stale" // use a cached decision, deny without one
+)
+
+// DefaultFailModeRule names the global fail mode when no rule matches
+const DefaultFailModeRule = "default"
+
+// FailModeRule selects the fail mode of matching users, groups or client
+// networks
+type FailModeRule struct {
+	Name     string
+	Users    []string       // username patterns (path.Match syntax)
+	Groups   []string       // group name patterns (path.Match syntax)
+	Networks []netip.Prefix // client IP networks
+	Mode     string
+}
+
+// FailModeRuleSpec is the unparsed form of a FailModeRule, as read from
+// configuration
+type FailModeRuleSpec struct {
+	Name     string
+	Users    []string
+	Groups   []string
+	Networks []string // CIDRs
+	Mode     string
+}
+
+// FailModes resolves the fail mode of a connection.
+// Rules are checked in order and the first rule matching the user, group
+// or client IP applies; connections matching no rule get the default mode.
+type FailModes struct {
+	rules       []*FailModeRule
+	defaultMode string
+}
+
+// NewFailModes parses rule specifications. defaultMode applies to
+// connections matching no rule.
+func NewFailModes(defaultMode string, specs []FailModeRuleSpec) (*FailModes, error) {
+	if !validFailMode(defaultMode) {
+		return nil, errors.Newf("invalid default fail mode %q", defaultMode)
+	}
+
+	rules := make([]*FailModeRule, 0, len(specs))
+	for i, spec := range specs {
+		name := spec.Name
+		if name == "" {
+			return nil, errors.Newf("rule %d: name is required", i)
+		}
+		if !validFailMode(spec.Mode) {
+			return nil, errors.Newf("rule %s: mode must be open, close or stale, got %q", name, spec.Mode)
+		}
+		if len(spec.Users) == 0 && len(spec.Groups) == 0 && len(spec.Networks) == 0 {
+			return nil, errors.Newf("rule %s: at least one of users, groups or networks is required", name)
+		}
+		for _, pattern := range append(append([]string{}, spec.Users...), spec.Groups...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "rule %s: invalid pattern %q", name, pattern)
+			}
+		}
+
+		rule := &FailModeRule{
+			Name:   name,
+			Users:  spec.Users,
+			Groups: spec.Groups,
+			Mode:   spec.Mode,
+		}
+		for _, cidr := range spec.Networks {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %s: invalid network", name)
+			}
+			rule.Networks = append(rule.Networks, prefix.Masked())
+		}
+		rules = append(rules, rule)
+	}
+
+	return &FailModes{rules: rules, defaultMode: defaultMode}, nil
+}
+
+// Resolve returns the fail mode of a connection and the name of the rule
+// that selected it (DefaultFailModeRule if none matched)
+func (f *FailModes) Resolve(username, groupName, clientIP string) (mode, rule string) {
+	addr, _ := netip.ParseAddr(clientIP)
+	for _, r := range f.rules {
+		if r.Matches(username, groupName, addr.Unmap()) {
+			return r.Mode, r.Name
+		}
+	}
+	return f.defaultMode, DefaultFailModeRule
+}
+
+// Matches reports whether the rule applies to the user, group or client IP
+func (r *FailModeRule) Matches(username, groupName string, clientIP netip.Addr) bool {
+	for _, pattern := range r.Users {
+		if ok, _ := path.Match(pattern, username); ok {
+			return true
+		}
+	}
+	for _, pattern := range r.Groups {
+		if ok, _ := path.Match(pattern, groupName); ok {
+			return true
+		}
+	}
+	if clientIP.IsValid() {
+		for _, network := range r.Networks {
+			if network.Contains(clientIP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validFailMode reports whether mode is a known fail mode
+func validFailMode(mode string) bool {
+	switch mode {
+	case FailModeOpen, FailModeClose, FailModeStale:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,64 @@
+package resilience
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailModesResolve(t *testing.T) {
+	modes, err := NewFailModes(FailModeStale, []FailModeRuleSpec{
+		{Name: "admins", Groups: []string{"admins", "oncall-*"}, Mode: FailModeOpen},
+		{Name: "contractors", Users: []string{"ext-*"}, Groups: []string{"contractors"}, Mode: FailModeClose},
+		{Name: "office", Networks: []string{"198.51.100.0/24", "2001:db8::/32"}, Mode: FailModeOpen},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name                      string
+		username, group, clientIP string
+		wantMode, wantRule        string
+	}{
+		{"group", "alice", "admins", "203.0.113.1", FailModeOpen, "admins"},
+		{"group pattern", "bob", "oncall-eu", "203.0.113.1", FailModeOpen, "admins"},
+		{"user pattern", "ext-carol", "staff", "203.0.113.1", FailModeClose, "contractors"},
+		{"first match wins", "ext-dave", "admins", "203.0.113.1", FailModeOpen, "admins"},
+		{"network", "erin", "staff", "198.51.100.7", FailModeOpen, "office"},
+		{"ipv6 network", "erin", "staff", "2001:db8::7", FailModeOpen, "office"},
+		{"ipv4-mapped address", "erin", "staff", "::ffff:198.51.100.7", FailModeOpen, "office"},
+		{"default", "frank", "staff", "203.0.113.1", FailModeStale, DefaultFailModeRule},
+		{"invalid client IP", "frank", "staff", "", FailModeStale, DefaultFailModeRule},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, rule := modes.Resolve(tt.username, tt.group, tt.clientIP)
+			assert.Equal(t, tt.wantMode, mode)
+			assert.Equal(t, tt.wantRule, rule)
+		})
+	}
+}
+
+func TestNewFailModesErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		defaultMode string
+		specs       []FailModeRuleSpec
+		errMsg      string
+	}{
+		{"invalid default", "retry", nil, `invalid default fail mode "retry"`},
+		{"missing name", FailModeStale, []FailModeRuleSpec{{Groups: []string{"a"}, Mode: FailModeOpen}}, "rule 0: name is required"},
+		{"invalid mode", FailModeStale, []FailModeRuleSpec{{Name: "r", Groups: []string{"a"}, Mode: "maybe"}}, "mode must be open, close or stale"},
+		{"no matchers", FailModeStale, []FailModeRuleSpec{{Name: "r", Mode: FailModeOpen}}, "at least one of users, groups or networks"},
+		{"invalid pattern", FailModeStale, []FailModeRuleSpec{{Name: "r", Users: []string{"["}, Mode: FailModeOpen}}, "invalid pattern"},
+		{"invalid network", FailModeStale, []FailModeRuleSpec{{Name: "r", Networks: []string{"10.0.0.0/33"}, Mode: FailModeOpen}}, "invalid network"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFailModes(tt.defaultMode, tt.specs)
+			assert.ErrorContains(t, err, tt.errMsg)
+		})
+	}
+}
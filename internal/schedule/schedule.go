@@ -0,0 +1,129 @@
+// Package schedule implements time-of-day access windows for VPN users.
+//
+// Schedules are evaluated locally by the agent, so they are enforced even
+// when the portal is unreachable and the configured fail mode would
+// otherwise admit the connection.
+package schedule
+
+import (
+	"path"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// Rule restricts matching users or groups to a set of access windows
+type Rule struct {
+	Name    string
+	Users   []string // username patterns (path.Match syntax)
+	Groups  []string // group name patterns (path.Match syntax)
+	Windows []*Window
+}
+
+// RuleSpec is the unparsed form of a Rule, as read from configuration
+type RuleSpec struct {
+	Name    string
+	Users   []string
+	Groups  []string
+	Windows []string
+}
+
+// Decision is the result of evaluating a schedule for a user
+type Decision struct {
+	Allowed  bool
+	Rule     string    // name of the matching rule, empty if unrestricted
+	ClosesAt time.Time // end of the current window, zero if unrestricted or denied
+}
+
+// Evaluator evaluates access schedules.
+// Rules are checked in order and the first rule matching the user or group
+// applies. Users that match no rule are not restricted.
+type Evaluator struct {
+	rules []*Rule
+}
+
+// NewEvaluator parses rule specifications into an evaluator
+func NewEvaluator(specs []RuleSpec) (*Evaluator, error) {
+	rules := make([]*Rule, 0, len(specs))
+
+	for i, spec := range specs {
+		name := spec.Name
+		if name == "" {
+			return nil, errors.Newf("rule %d: name is required", i)
+		}
+		if len(spec.Windows) == 0 {
+			return nil, errors.Newf("rule %s: at least one window is required", name)
+		}
+		for _, pattern := range append(append([]string{}, spec.Users...), spec.Groups...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "rule %s: invalid pattern %q", name, pattern)
+			}
+		}
+
+		rule := &Rule{
+			Name:   name,
+			Users:  spec.Users,
+			Groups: spec.Groups,
+		}
+		for _, ws := range spec.Windows {
+			w, err := ParseWindow(ws)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %s", name)
+			}
+			rule.Windows = append(rule.Windows, w)
+		}
+		rules = append(rules, rule)
+	}
+
+	return &Evaluator{rules: rules}, nil
+}
+
+// Check evaluates the schedule for a user at the given time
+func (e *Evaluator) Check(username, groupName string, now time.Time) Decision {
+	rule := e.match(username, groupName)
+	if rule == nil {
+		return Decision{Allowed: true}
+	}
+
+	decision := Decision{Rule: rule.Name}
+	for _, w := range rule.Windows {
+		if ok, closesAt := w.Contains(now); ok {
+			decision.Allowed = true
+			// Overlapping windows extend access until the latest close
+			if closesAt.After(decision.ClosesAt) {
+				decision.ClosesAt = closesAt
+			}
+		}
+	}
+
+	return decision
+}
+
+// match returns the first rule matching the user or group
+func (e *Evaluator) match(username, groupName string) *Rule {
+	for _, rule := range e.rules {
+		if rule.Matches(username, groupName) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to the user or group.
+// A rule without users and groups applies to everyone.
+func (r *Rule) Matches(username, groupName string) bool {
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	for _, pattern := range r.Users {
+		if ok, _ := path.Match(pattern, username); ok {
+			return true
+		}
+	}
+	for _, pattern := range r.Groups {
+		if ok, _ := path.Match(pattern, groupName); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	require.NoError(t, err)
+	return loc
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		wantErr  bool
+		wantDays []time.Weekday
+		start    int
+		end      int
+	}{
+		{
+			name:     "weekdays with timezone",
+			spec:     "weekdays 08:00-20:00 Europe/Berlin",
+			wantDays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+			start:    8 * 60,
+			end:      20 * 60,
+		},
+		{
+			name:     "day range wrapping the week",
+			spec:     "fri-mon 00:00-24:00",
+			wantDays: []time.Weekday{time.Friday, time.Saturday, time.Sunday, time.Monday},
+			start:    0,
+			end:      24 * 60,
+		},
+		{
+			name:     "day list",
+			spec:     "mon,wed 09:30-10:15 UTC",
+			wantDays: []time.Weekday{time.Monday, time.Wednesday},
+			start:    9*60 + 30,
+			end:      10*60 + 15,
+		},
+		{name: "missing range", spec: "weekdays", wantErr: true},
+		{name: "unknown day", spec: "someday 08:00-20:00", wantErr: true},
+		{name: "bad time", spec: "daily 8-20", wantErr: true},
+		{name: "bad hour", spec: "daily 25:00-26:00", wantErr: true},
+		{name: "empty window", spec: "daily 08:00-08:00", wantErr: true},
+		{name: "bad timezone", spec: "daily 08:00-20:00 Mars/Olympus", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var days []time.Weekday
+			for d, on := range w.Days {
+				if on {
+					days = append(days, time.Weekday(d))
+				}
+			}
+			assert.ElementsMatch(t, tt.wantDays, days)
+			assert.Equal(t, tt.start, w.Start)
+			assert.Equal(t, tt.end, w.End)
+			assert.Equal(t, tt.spec, w.String())
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	berlin := mustLocation(t, "Europe/Berlin")
+
+	t.Run("daytime window", func(t *testing.T) {
+		w, err := ParseWindow("weekdays 08:00-20:00 Europe/Berlin")
+		require.NoError(t, err)
+
+		// Wednesday 2026-10-14 12:00 Berlin
+		ok, closesAt := w.Contains(time.Date(2026, 10, 14, 12, 0, 0, 0, berlin))
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, 10, 14, 20, 0, 0, 0, berlin), closesAt)
+
+		// Same instant expressed in UTC is still inside
+		ok, _ = w.Contains(time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC))
+		assert.True(t, ok)
+
+		// Wednesday 20:00 is outside (end is exclusive)
+		ok, _ = w.Contains(time.Date(2026, 10, 14, 20, 0, 0, 0, berlin))
+		assert.False(t, ok)
+
+		// Saturday noon is outside
+		ok, _ = w.Contains(time.Date(2026, 10, 17, 12, 0, 0, 0, berlin))
+		assert.False(t, ok)
+	})
+
+	t.Run("overnight window", func(t *testing.T) {
+		w, err := ParseWindow("fri 22:00-06:00 UTC")
+		require.NoError(t, err)
+
+		// Friday 23:00 belongs to Friday's occurrence and closes Saturday 06:00
+		ok, closesAt := w.Contains(time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC))
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC), closesAt)
+
+		// Saturday 05:00 still belongs to Friday's occurrence
+		ok, closesAt = w.Contains(time.Date(2026, 10, 17, 5, 0, 0, 0, time.UTC))
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, 10, 17, 6, 0, 0, 0, time.UTC), closesAt)
+
+		// Friday 05:00 would belong to Thursday's occurrence, which does not exist
+		ok, _ = w.Contains(time.Date(2026, 10, 16, 5, 0, 0, 0, time.UTC))
+		assert.False(t, ok)
+	})
+
+	t.Run("window ending at midnight", func(t *testing.T) {
+		w, err := ParseWindow("daily 18:00-24:00 UTC")
+		require.NoError(t, err)
+
+		ok, closesAt := w.Contains(time.Date(2026, 10, 14, 23, 59, 0, 0, time.UTC))
+		assert.True(t, ok)
+		assert.Equal(t, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), closesAt)
+	})
+}
+
+func TestEvaluatorCheck(t *testing.T) {
+	e, err := NewEvaluator([]RuleSpec{
+		{
+			Name:    "contractors",
+			Groups:  []string{"contractors"},
+			Windows: []string{"weekdays 08:00-20:00 UTC"},
+		},
+		{
+			Name:    "night-shift",
+			Users:   []string{"ops-*"},
+			Windows: []string{"daily 20:00-08:00 UTC"},
+		},
+	})
+	require.NoError(t, err)
+
+	weekdayNoon := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	weekdayNight := time.Date(2026, 10, 14, 23, 0, 0, 0, time.UTC)
+
+	t.Run("group rule inside window", func(t *testing.T) {
+		d := e.Check("alice", "contractors", weekdayNoon)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, "contractors", d.Rule)
+		assert.Equal(t, time.Date(2026, 10, 14, 20, 0, 0, 0, time.UTC), d.ClosesAt)
+	})
+
+	t.Run("group rule outside window", func(t *testing.T) {
+		d := e.Check("alice", "contractors", weekdayNight)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, "contractors", d.Rule)
+		assert.True(t, d.ClosesAt.IsZero())
+	})
+
+	t.Run("user pattern rule", func(t *testing.T) {
+		assert.True(t, e.Check("ops-bob", "staff", weekdayNight).Allowed)
+		assert.False(t, e.Check("ops-bob", "staff", weekdayNoon).Allowed)
+	})
+
+	t.Run("unmatched user is unrestricted", func(t *testing.T) {
+		d := e.Check("carol", "staff", weekdayNight)
+		assert.True(t, d.Allowed)
+		assert.Empty(t, d.Rule)
+	})
+}
+
+func TestNewEvaluatorErrors(t *testing.T) {
+	_, err := NewEvaluator([]RuleSpec{{Windows: []string{"daily 08:00-20:00"}}})
+	assert.Error(t, err, "missing name")
+
+	_, err = NewEvaluator([]RuleSpec{{Name: "empty"}})
+	assert.Error(t, err, "missing windows")
+
+	_, err = NewEvaluator([]RuleSpec{{Name: "bad", Users: []string{"["}, Windows: []string{"daily 08:00-20:00"}}})
+	assert.Error(t, err, "invalid pattern")
+
+	_, err = NewEvaluator([]RuleSpec{{Name: "bad", Windows: []string{"never"}}})
+	assert.Error(t, err, "invalid window")
+}
@@ -0,0 +1,191 @@
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// minutesPerDay is the number of minutes in a day; 24:00 is a valid window end
+const minutesPerDay = 24 * 60
+
+// Window describes a recurring weekly access window such as
+// "weekdays 08:00-20:00 Europe/Berlin".
+//
+// If End is before Start the window spans midnight and closes on the
+// following day (e.g. "fri 22:00-06:00").
+type Window struct {
+	Days     [7]bool        // indexed by time.Weekday
+	Start    int            // minutes since midnight
+	End      int            // minutes since midnight (1440 = end of day)
+	Location *time.Location // timezone the window is evaluated in
+	spec     string
+}
+
+// String returns the original window specification
+func (w *Window) String() string {
+	return w.spec
+}
+
+// ParseWindow parses a window specification of the form
+//
+//	<days> <HH:MM>-<HH:MM> [timezone]
+//
+// where <days> is one of "daily", "weekdays", "weekends", a single day
+// ("mon"), a range ("mon-fri") or a comma-separated list ("mon,wed,fri").
+// The timezone is an IANA name and defaults to the local timezone.
+func ParseWindow(spec string) (*Window, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, errors.Newf("invalid window %q: expected \"<days> <HH:MM>-<HH:MM> [timezone]\"", spec)
+	}
+
+	w := &Window{
+		Location: time.Local,
+		spec:     spec,
+	}
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid window %q", spec)
+	}
+	w.Days = days
+
+	start, end, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return nil, errors.Newf("invalid window %q: time range must be HH:MM-HH:MM", spec)
+	}
+	if w.Start, err = parseClock(start); err != nil {
+		return nil, errors.Wrapf(err, "invalid window %q", spec)
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return nil, errors.Wrapf(err, "invalid window %q", spec)
+	}
+	if w.Start == w.End {
+		return nil, errors.Newf("invalid window %q: start and end are equal", spec)
+	}
+	if w.Start == minutesPerDay {
+		return nil, errors.Newf("invalid window %q: start cannot be 24:00", spec)
+	}
+
+	if len(fields) == 3 {
+		loc, err := time.LoadLocation(fields[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid window %q: timezone", spec)
+		}
+		w.Location = loc
+	}
+
+	return w, nil
+}
+
+// Contains reports whether t falls inside the window and, if so, when the
+// current occurrence of the window closes
+func (w *Window) Contains(t time.Time) (bool, time.Time) {
+	local := t.In(w.Location)
+	minute := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+
+	if w.End > w.Start {
+		if w.Days[today] && minute >= w.Start && minute < w.End {
+			return true, w.at(local, 0, w.End)
+		}
+		return false, time.Time{}
+	}
+
+	// Overnight window: the part after Start belongs to today's occurrence,
+	// the part before End belongs to yesterday's occurrence
+	if w.Days[today] && minute >= w.Start {
+		return true, w.at(local, 1, w.End)
+	}
+	if w.Days[yesterday] && minute < w.End {
+		return true, w.at(local, 0, w.End)
+	}
+	return false, time.Time{}
+}
+
+// at returns the wall-clock time minutes past midnight, dayOffset days after local
+func (w *Window) at(local time.Time, dayOffset, minutes int) time.Time {
+	y, m, d := local.Date()
+	return time.Date(y, m, d+dayOffset, 0, minutes, 0, 0, w.Location)
+}
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseDays parses the days part of a window specification
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+
+	switch strings.ToLower(s) {
+	case "daily", "everyday", "*":
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	case "weekdays":
+		for d := time.Monday; d <= time.Friday; d++ {
+			days[d] = true
+		}
+		return days, nil
+	case "weekends":
+		days[time.Saturday] = true
+		days[time.Sunday] = true
+		return days, nil
+	}
+
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := dayNames[from]
+		if !ok {
+			return days, errors.Newf("unknown day %q", from)
+		}
+		if !isRange {
+			days[first] = true
+			continue
+		}
+		last, ok := dayNames[to]
+		if !ok {
+			return days, errors.Newf("unknown day %q", to)
+		}
+		// Ranges wrap around the week, so "fri-mon" covers fri, sat, sun, mon
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, errors.Newf("invalid time %q: expected HH:MM", s)
+	}
+	h, err := strconv.Atoi(hh)
+	if err != nil || h < 0 || h > 24 {
+		return 0, errors.Newf("invalid hour in %q", s)
+	}
+	m, err := strconv.Atoi(mm)
+	if err != nil || m < 0 || m > 59 {
+		return 0, errors.Newf("invalid minute in %q", s)
+	}
+	if h == 24 && m != 0 {
+		return 0, errors.Newf("invalid time %q: only 24:00 is allowed past 23:59", s)
+	}
+	return h*60 + m, nil
+}
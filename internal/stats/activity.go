@@ -0,0 +1,200 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+)
+
+// DisconnectReasonPortal is recorded when the portal requests a disconnect
+// without giving a reason
+const DisconnectReasonPortal = "portal_request"
+
+// UpdateReporter receives periodic session status updates
+type UpdateReporter interface {
+	// ReportSessionUpdate reports the session status and returns whether the
+	// session should be disconnected and why
+	ReportSessionUpdate(ctx context.Context, sessionID, username string, status vpnv1.SessionStatus, bytesRX, bytesTX uint64) (bool, string, error)
+}
+
+// activitySample is a traffic counter reading at a point in time
+type activitySample struct {
+	at     time.Time
+	rx, tx uint64
+}
+
+// activityTracker keeps the traffic history of a session over the activity window
+type activityTracker struct {
+	samples    []activitySample
+	lastReport time.Time
+}
+
+// observe records a counter reading and drops samples older than the window,
+// keeping the newest sample at or before the window start as the baseline
+func (a *activityTracker) observe(now time.Time, rx, tx uint64, window time.Duration) {
+	a.samples = append(a.samples, activitySample{at: now, rx: rx, tx: tx})
+
+	cutoff := now.Add(-window)
+	i := 0
+	for i+1 < len(a.samples) && !a.samples[i+1].at.After(cutoff) {
+		i++
+	}
+	a.samples = a.samples[i:]
+}
+
+// classify derives the session status from the traffic over the window.
+// Sessions are considered active until a full window of history exists.
+// Idle: total traffic at or below idleBytes.
+// Degraded: traffic flows in one direction only (e.g. client stopped responding).
+func (a *activityTracker) classify(window time.Duration, idleBytes uint64) vpnv1.SessionStatus {
+	first, last := a.samples[0], a.samples[len(a.samples)-1]
+	if last.at.Sub(first.at) < window {
+		return vpnv1.SessionStatus_SESSION_STATUS_ACTIVE
+	}
+
+	deltaRX := counterDelta(first.rx, last.rx)
+	deltaTX := counterDelta(first.tx, last.tx)
+
+	switch {
+	case deltaRX+deltaTX <= idleBytes:
+		return vpnv1.SessionStatus_SESSION_STATUS_IDLE
+	case deltaRX == 0 || deltaTX == 0:
+		return vpnv1.SessionStatus_SESSION_STATUS_DEGRADED
+	default:
+		return vpnv1.SessionStatus_SESSION_STATUS_ACTIVE
+	}
+}
+
+// counterDelta returns the growth of a traffic counter, treating a decrease
+// as a counter reset
+func counterDelta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// updateActivity samples traffic for all sessions and reclassifies them
+func (p *Poller) updateActivity(ctx context.Context, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counts := make(map[vpnv1.SessionStatus]int)
+	for id, session := range p.sessions {
+		tracker, ok := p.activity[id]
+		if !ok {
+			tracker = &activityTracker{lastReport: now}
+			p.activity[id] = tracker
+		}
+		tracker.observe(now, session.BytesRX, session.BytesTX, p.activityWindow)
+
+		status := tracker.classify(p.activityWindow, p.idleThreshold)
+		if status != session.Status {
+			p.logger.DebugContext(ctx, "session status changed",
+				slog.Int("id", id),
+				slog.String("username", session.Username),
+				slog.String("from", session.Status.String()),
+				slog.String("to", status.String()),
+			)
+			previous := session.Status
+			session.Status = status
+			// The first classification of a restored session is not a change
+			if previous != vpnv1.SessionStatus_SESSION_STATUS_UNSPECIFIED {
+				p.emitEvent(ctx, SessionEvent{
+					Type:           SessionStatusChanged,
+					Session:        *session,
+					PreviousStatus: previous,
+				})
+			}
+		}
+		counts[status]++
+	}
+
+	p.metrics.RecordSessionsByStatus(ctx, counts)
+}
+
+// reportSessionUpdates sends status updates for sessions whose update
+// interval has elapsed and disconnects sessions the portal rejects
+func (p *Poller) reportSessionUpdates(ctx context.Context, now time.Time) {
+	ctx, span := p.tracer.Start(ctx, "stats.poller.report_session_updates")
+	defer span.End()
+
+	// Collect due sessions under lock, report outside of it
+	var due []SessionInfo
+	p.mu.Lock()
+	for id, session := range p.sessions {
+		tracker, ok := p.activity[id]
+		if !ok || now.Sub(tracker.lastReport) < p.updateInterval {
+			continue
+		}
+		tracker.lastReport = now
+		due = append(due, *session)
+	}
+	p.mu.Unlock()
+
+	for _, session := range due {
+		shouldDisconnect, reason, err := p.updates.ReportSessionUpdate(
+			ctx,
+			strconv.Itoa(session.ID),
+			session.Username,
+			session.Status,
+			session.BytesRX,
+			session.BytesTX,
+		)
+		if err != nil {
+			p.logger.ErrorContext(ctx, "failed to report session update",
+				slog.Int("id", session.ID),
+				slog.String("error", err.Error()),
+			)
+			p.metrics.RecordPollError(ctx, "session_update")
+			continue
+		}
+		if !shouldDisconnect {
+			continue
+		}
+
+		if reason == "" {
+			reason = DisconnectReasonPortal
+		}
+		p.logger.WarnContext(ctx, "disconnecting session at portal request",
+			slog.Int("id", session.ID),
+			slog.String("username", session.Username),
+			slog.String("reason", reason),
+		)
+		p.mu.RLock()
+		p.emitEvent(ctx, SessionEvent{
+			Type:    SessionPortalDisconnect,
+			Session: session,
+			Reason:  reason,
+		})
+		p.mu.RUnlock()
+		p.disconnectSession(ctx, session.ID, reason)
+	}
+}
+
+// disconnectSession terminates a session via occtl and records the reason,
+// which is attached to the disconnect event. The reason is recorded before
+// calling occtl: the event stream may report the disconnect before the
+// call returns.
+func (p *Poller) disconnectSession(ctx context.Context, id int, reason string) bool {
+	p.mu.Lock()
+	p.disconnectReasons[id] = reason
+	p.mu.Unlock()
+
+	if err := p.occtl.DisconnectID(ctx, strconv.Itoa(id)); err != nil {
+		p.logger.ErrorContext(ctx, "failed to disconnect session",
+			slog.Int("id", id),
+			slog.String("error", err.Error()),
+		)
+		p.metrics.RecordPollError(ctx, "disconnect_id")
+
+		p.mu.Lock()
+		delete(p.disconnectReasons, id)
+		p.mu.Unlock()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,221 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestActivityTracker_Classify(t *testing.T) {
+	const window = 5 * time.Minute
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		samples [][2]uint64 // rx, tx per minute
+		idle    uint64
+		want    vpnv1.SessionStatus
+	}{
+		{
+			name:    "not enough history",
+			samples: [][2]uint64{{0, 0}, {0, 0}},
+			want:    vpnv1.SessionStatus_SESSION_STATUS_ACTIVE,
+		},
+		{
+			name:    "traffic both ways",
+			samples: [][2]uint64{{0, 0}, {100, 200}, {200, 400}, {300, 600}, {400, 800}, {500, 1000}},
+			want:    vpnv1.SessionStatus_SESSION_STATUS_ACTIVE,
+		},
+		{
+			name:    "no traffic",
+			samples: [][2]uint64{{100, 100}, {100, 100}, {100, 100}, {100, 100}, {100, 100}, {100, 100}},
+			want:    vpnv1.SessionStatus_SESSION_STATUS_IDLE,
+		},
+		{
+			name:    "traffic below idle threshold",
+			samples: [][2]uint64{{0, 0}, {10, 10}, {20, 20}, {30, 30}, {40, 40}, {50, 50}},
+			idle:    1024,
+			want:    vpnv1.SessionStatus_SESSION_STATUS_IDLE,
+		},
+		{
+			name:    "one-way traffic",
+			samples: [][2]uint64{{100, 0}, {100, 500}, {100, 1000}, {100, 1500}, {100, 2000}, {100, 2500}},
+			want:    vpnv1.SessionStatus_SESSION_STATUS_DEGRADED,
+		},
+		{
+			name:    "old traffic falls out of the window",
+			samples: [][2]uint64{{0, 0}, {5000, 5000}, {5000, 5000}, {5000, 5000}, {5000, 5000}, {5000, 5000}, {5000, 5000}},
+			want:    vpnv1.SessionStatus_SESSION_STATUS_IDLE,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &activityTracker{}
+			for i, s := range tt.samples {
+				tracker.observe(start.Add(time.Duration(i)*time.Minute), s[0], s[1], window)
+			}
+			assert.Equal(t, tt.want, tracker.classify(window, tt.idle))
+		})
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	assert.Equal(t, uint64(50), counterDelta(100, 150))
+	assert.Equal(t, uint64(20), counterDelta(100, 20), "counter reset")
+}
+
+// fakeUpdateReporter records session updates and requests disconnects for listed users
+type fakeUpdateReporter struct {
+	mu         sync.Mutex
+	reports    map[string]vpnv1.SessionStatus
+	disconnect map[string]string
+}
+
+func (r *fakeUpdateReporter) ReportSessionUpdate(_ context.Context, _, username string, status vpnv1.SessionStatus, _, _ uint64) (bool, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reports[username] = status
+	reason, ok := r.disconnect[username]
+	return ok, reason, nil
+}
+
+func TestPoller_ReportSessionUpdates(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl.AddMockUser(2, "bob", "10.10.0.3", "203.0.113.11")
+
+	reporter := &fakeUpdateReporter{
+		reports:    make(map[string]vpnv1.SessionStatus),
+		disconnect: map[string]string{"bob": "account suspended"},
+	}
+
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager:   occtl,
+		Logger:         slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:         tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:          metricnoop.NewMeterProvider().Meter("test"),
+		Interval:       time.Hour,
+		UpdateReporter: reporter,
+		UpdateInterval: time.Nanosecond,
+	})
+	require.NoError(t, err)
+
+	events := make(chan SessionEvent, 16)
+	p.RegisterCallback(func(_ context.Context, event SessionEvent) {
+		events <- event
+	})
+
+	// First poll starts tracking, second poll reports and disconnects bob
+	p.poll()
+	assert.Empty(t, reporter.reports)
+
+	p.poll()
+	assert.Equal(t, vpnv1.SessionStatus_SESSION_STATUS_ACTIVE, reporter.reports["alice"])
+	assert.Contains(t, reporter.reports, "bob")
+	assert.Equal(t, []string{"bob"}, occtl.GetDisconnectedUsers())
+
+	portal := waitEvent(t, events, SessionPortalDisconnect)
+	assert.Equal(t, "bob", portal.Session.Username)
+	assert.Equal(t, "account suspended", portal.Reason)
+
+	// Third poll observes the disconnect with the portal's reason
+	p.poll()
+	event := waitEvent(t, events, SessionDisconnected)
+	assert.Equal(t, "bob", event.Session.Username)
+	assert.Equal(t, "account suspended", event.Session.DisconnectReason)
+}
+
+func TestPoller_StatusChangedEvent(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager:   occtl,
+		Logger:         slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:         tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:          metricnoop.NewMeterProvider().Meter("test"),
+		Interval:       time.Hour,
+		ActivityWindow: time.Nanosecond,
+	})
+	require.NoError(t, err)
+
+	events := make(chan SessionEvent, 16)
+	p.RegisterCallback(func(_ context.Context, event SessionEvent) {
+		events <- event
+	})
+
+	// No traffic between the polls: alice becomes idle
+	p.poll()
+	p.poll()
+
+	event := waitEvent(t, events, SessionStatusChanged)
+	assert.Equal(t, "alice", event.Session.Username)
+	assert.Equal(t, vpnv1.SessionStatus_SESSION_STATUS_ACTIVE, event.PreviousStatus)
+	assert.Equal(t, vpnv1.SessionStatus_SESSION_STATUS_IDLE, event.Session.Status)
+}
+
+// streamingOcctl reports a disconnect on the event stream before
+// DisconnectID returns, like occtl racing with the stream
+type streamingOcctl struct {
+	*ocserv.MockOcctlManager
+	onDisconnect func(id string)
+}
+
+func (o *streamingOcctl) DisconnectID(ctx context.Context, id string) error {
+	if err := o.MockOcctlManager.DisconnectID(ctx, id); err != nil {
+		return err
+	}
+	o.onDisconnect(id)
+	return nil
+}
+
+func TestPoller_DisconnectReasonRecordedBeforeStreamEvent(t *testing.T) {
+	mock := ocserv.NewMockOcctlManager()
+	mock.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl := &streamingOcctl{MockOcctlManager: mock}
+
+	p, events := newRevalidatePoller(t, occtl, nil, 1)
+	occtl.onDisconnect = func(id string) {
+		p.handleStreamEvent(ocserv.Event{
+			EventType: ocserv.EventDisconnect,
+			SessionID: id,
+			User:      &ocserv.User{ID: 1, Username: "alice"},
+		})
+	}
+
+	p.poll()
+	waitEvent(t, events, SessionConnected)
+
+	require.True(t, p.disconnectSession(context.Background(), 1, DisconnectReasonPortal))
+	event := waitEvent(t, events, SessionDisconnected)
+	assert.Equal(t, "alice", event.Session.Username)
+	assert.Equal(t, DisconnectReasonPortal, event.Session.DisconnectReason)
+}
+
+func TestPoller_DisconnectReasonDroppedOnFailure(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl.SetDisconnectError(errors.New("occtl failed"))
+
+	p, _ := newRevalidatePoller(t, occtl, nil, 1)
+	p.poll()
+
+	assert.False(t, p.disconnectSession(context.Background(), 1, DisconnectReasonPortal))
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	assert.Empty(t, p.disconnectReasons)
+}
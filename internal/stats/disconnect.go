@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+)
+
+// DisconnectReport is the final accounting of a session, as passed by
+// ocserv to its disconnect script (STATS_BYTES_IN, STATS_BYTES_OUT and
+// STATS_DURATION)
+type DisconnectReport struct {
+	SessionID int
+	Username  string
+	GroupName string
+	ClientIP  string
+	VPNIP     string
+
+	BytesIn  uint64        // received from the client
+	BytesOut uint64        // sent to the client
+	Duration time.Duration // whole seconds
+}
+
+// RecordDisconnect ends a session with the exact counters reported by the
+// disconnect script, instead of waiting for the next poll to notice it is
+// gone with the counters of the previous poll. Sessions that ended before
+// any poll saw them produce a connect/disconnect pair.
+func (p *Poller) RecordDisconnect(ctx context.Context, report DisconnectReport) {
+	p.mu.Lock()
+	defer p.saveState(ctx)
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	session, known := p.sessions[report.SessionID]
+	if !known {
+		p.addSession(ctx, SessionInfo{
+			ID:          report.SessionID,
+			Username:    report.Username,
+			GroupName:   report.GroupName,
+			ClientIP:    report.ClientIP,
+			VPNIP:       report.VPNIP,
+			ConnectedAt: now.Add(-report.Duration),
+			Status:      vpnv1.SessionStatus_SESSION_STATUS_ACTIVE,
+		})
+		session = p.sessions[report.SessionID]
+	}
+
+	session.BytesRX = report.BytesIn
+	session.BytesTX = report.BytesOut
+
+	// occtl may still list the session until ocserv finishes tearing it
+	// down; it must not be picked up again as a new session
+	p.reported[report.SessionID] = struct{}{}
+
+	p.logger.DebugContext(ctx, "disconnect accounting received",
+		slog.Int("id", report.SessionID),
+		slog.String("username", report.Username),
+		slog.Uint64("bytes_in", report.BytesIn),
+		slog.Uint64("bytes_out", report.BytesOut),
+		slog.Duration("duration", report.Duration),
+		slog.Bool("known", known),
+	)
+	p.metrics.RecordDisconnectReport(ctx, known)
+
+	p.removeSessionAt(ctx, report.SessionID, "", session.ConnectedAt.Add(report.Duration))
+}
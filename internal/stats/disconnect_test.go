@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller_RecordDisconnectUsesExactAccounting(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+
+	p, events := newRevalidatePoller(t, occtl, nil, 1)
+	p.poll()
+	waitEvent(t, events, SessionConnected)
+
+	p.RecordDisconnect(context.Background(), DisconnectReport{
+		SessionID: 1,
+		Username:  "alice",
+		BytesIn:   1234,
+		BytesOut:  5678,
+		Duration:  90 * time.Second,
+	})
+
+	event := waitEvent(t, events, SessionDisconnected)
+	assert.Equal(t, uint64(1234), event.Session.BytesRX)
+	assert.Equal(t, uint64(5678), event.Session.BytesTX)
+	assert.Equal(t, 90*time.Second, event.Session.DisconnectedAt.Sub(event.Session.ConnectedAt))
+	assert.Empty(t, p.GetActiveSessions())
+
+	// ocserv still lists the session while tearing it down
+	p.poll()
+	assert.Empty(t, p.GetActiveSessions(), "reported session must not be tracked again")
+
+	occtl.ClearUsers()
+	p.poll()
+	p.mu.RLock()
+	assert.Empty(t, p.reported)
+	p.mu.RUnlock()
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected %s event", event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPoller_RecordDisconnectOfUntrackedSession(t *testing.T) {
+	p, events := newRevalidatePoller(t, ocserv.NewMockOcctlManager(), nil, 1)
+	p.poll()
+
+	p.RecordDisconnect(context.Background(), DisconnectReport{
+		SessionID: 7,
+		Username:  "bob",
+		GroupName: "staff",
+		ClientIP:  "203.0.113.11",
+		VPNIP:     "10.10.0.3",
+		BytesIn:   10,
+		BytesOut:  20,
+		Duration:  2 * time.Second,
+	})
+
+	// Callbacks run concurrently, so the pair may arrive in either order
+	received := make(map[SessionEventType]SessionEvent)
+	for range 2 {
+		select {
+		case event := <-events:
+			received[event.Type] = event
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for session events")
+		}
+	}
+	require.Contains(t, received, SessionConnected)
+	require.Contains(t, received, SessionDisconnected)
+	assert.Equal(t, "bob", received[SessionConnected].Session.Username)
+
+	disconnected := received[SessionDisconnected]
+	require.Equal(t, 7, disconnected.Session.ID)
+	assert.Equal(t, "10.10.0.3", disconnected.Session.VPNIP)
+	assert.Equal(t, uint64(20), disconnected.Session.BytesTX)
+	assert.Equal(t, 2*time.Second, disconnected.Session.DisconnectedAt.Sub(disconnected.Session.ConnectedAt))
+}
+
+func TestPoller_StreamIgnoresReportedSessions(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+
+	p, events := newRevalidatePoller(t, occtl, nil, 1)
+	p.poll()
+	waitEvent(t, events, SessionConnected)
+
+	p.RecordDisconnect(context.Background(), DisconnectReport{SessionID: 1, Username: "alice", Duration: time.Second})
+	waitEvent(t, events, SessionDisconnected)
+
+	users, err := occtl.ShowUsers(context.Background())
+	require.NoError(t, err)
+	p.handleStreamEvent(ocserv.Event{EventType: ocserv.EventDisconnect, SessionID: "1", Username: "alice", User: &users[0]})
+
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected %s event", event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.mu.RLock()
+	assert.Empty(t, p.reported)
+	p.mu.RUnlock()
+}
@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// callbackQueue delivers session events to one callback in the order they
+// were emitted. Emitting never blocks, so events can be emitted while the
+// poller lock is held; a slow callback only delays its own events.
+type callbackQueue struct {
+	callback SessionCallback
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	pending []queuedEvent
+	wake    chan struct{}
+}
+
+// queuedEvent is an event waiting for delivery
+type queuedEvent struct {
+	ctx   context.Context
+	event SessionEvent
+}
+
+func newCallbackQueue(callback SessionCallback, logger *slog.Logger) *callbackQueue {
+	return &callbackQueue{
+		callback: callback,
+		logger:   logger,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// push queues an event. Delivery happens later, so the event keeps the
+// values of ctx (trace, logging) but not its cancellation.
+func (q *callbackQueue) push(ctx context.Context, event SessionEvent) {
+	q.mu.Lock()
+	q.pending = append(q.pending, queuedEvent{ctx: context.WithoutCancel(ctx), event: event})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers queued events until done is closed, then delivers the
+// events still queued and returns
+func (q *callbackQueue) run(done <-chan struct{}) {
+	for {
+		q.mu.Lock()
+		batch := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+
+		for _, queued := range batch {
+			q.deliver(queued)
+		}
+		if len(batch) > 0 {
+			continue
+		}
+
+		select {
+		case <-q.wake:
+		case <-done:
+			q.mu.Lock()
+			batch = q.pending
+			q.pending = nil
+			q.mu.Unlock()
+			for _, queued := range batch {
+				q.deliver(queued)
+			}
+			return
+		}
+	}
+}
+
+// deliver calls the callback, containing its panics
+func (q *callbackQueue) deliver(queued queuedEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.logger.ErrorContext(queued.ctx, "callback panic",
+				slog.Any("panic", r),
+			)
+		}
+	}()
+	q.callback(queued.ctx, queued.event)
+}
@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackQueue(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	var got []int
+	queue := newCallbackQueue(func(_ context.Context, event SessionEvent) {
+		if event.Session.ID == 2 {
+			panic("callback failed")
+		}
+		got = append(got, event.Session.ID)
+	}, logger)
+
+	// Events queued before the worker starts and with a canceled context
+	// are still delivered
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for id := 1; id <= 5; id++ {
+		queue.push(ctx, SessionEvent{Session: SessionInfo{ID: id}})
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	done := make(chan struct{})
+	go func() {
+		queue.run(stop)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queue was not drained")
+	}
+	assert.Equal(t, []int{1, 3, 4, 5}, got, "a panicking callback must not stop delivery")
+}
@@ -0,0 +1,156 @@
+package stats
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+)
+
+// Event stream restart backoff bounds
+const (
+	streamBackoffMin = time.Second
+	streamBackoffMax = time.Minute
+)
+
+// streamLoop consumes the occtl event stream and restarts it with
+// exponential backoff whenever it breaks
+func (p *Poller) streamLoop() {
+	defer p.wg.Done()
+
+	// Events are only applied on top of the initial (recovered) session set
+	select {
+	case <-p.ctx.Done():
+		return
+	case <-p.ready:
+	}
+
+	backoff := streamBackoffMin
+	for {
+		started := time.Now()
+		err := p.events.StreamEvents(p.ctx, p.handleStreamEvent)
+		if p.ctx.Err() != nil {
+			return
+		}
+
+		// Fall back to regular polling until the stream delivers again
+		if p.streaming.Swap(false) {
+			p.triggerResync()
+		}
+
+		// A stream that ran for a while is restarted quickly
+		if time.Since(started) > streamBackoffMax {
+			backoff = streamBackoffMin
+		}
+
+		p.logger.WarnContext(p.ctx, "occtl event stream stopped, falling back to polling",
+			slog.String("error", err.Error()),
+			slog.Duration("retry_in", backoff),
+		)
+		p.metrics.RecordPollError(p.ctx, "event_stream")
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, streamBackoffMax)
+	}
+}
+
+// triggerResync requests an immediate reconciliation poll
+func (p *Poller) triggerResync() {
+	select {
+	case p.resync <- struct{}{}:
+	default:
+	}
+}
+
+// handleStreamEvent applies a single event from the occtl event stream
+func (p *Poller) handleStreamEvent(event ocserv.Event) {
+	ctx := p.ctx
+
+	// The first event proves the stream is healthy; reconcile once to pick
+	// up anything missed while it was down
+	if !p.streaming.Swap(true) {
+		p.logger.InfoContext(ctx, "occtl event stream established, polling relaxed",
+			slog.Duration("reconcile_interval", p.reconcileInterval),
+		)
+		p.triggerResync()
+	}
+
+	switch event.EventType {
+	case ocserv.EventAuthFailure:
+		p.logger.WarnContext(ctx, "authentication failure",
+			slog.String("username", event.Username),
+			slog.String("client_ip", event.RemoteIP),
+			slog.String("reason", event.Reason),
+		)
+
+		p.mu.RLock()
+		p.emitEvent(ctx, SessionEvent{
+			Type: SessionAuthFailed,
+			Session: SessionInfo{
+				Username: event.Username,
+				ClientIP: event.RemoteIP,
+			},
+			Reason: event.Reason,
+		})
+		p.mu.RUnlock()
+		return
+
+	case ocserv.EventConnect, ocserv.EventDisconnect:
+	default:
+		p.logger.DebugContext(ctx, "ignoring unknown occtl event",
+			slog.String("event_type", event.EventType),
+		)
+		return
+	}
+
+	id, err := strconv.Atoi(event.SessionID)
+	if err != nil {
+		p.logger.DebugContext(ctx, "ignoring occtl event without session ID",
+			slog.String("event_type", event.EventType),
+			slog.String("username", event.Username),
+		)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.saveState(ctx)
+	defer p.mu.Unlock()
+
+	// Already ended by the disconnect script with exact counters
+	if _, ok := p.reported[id]; ok {
+		if event.EventType == ocserv.EventDisconnect {
+			delete(p.reported, id)
+		}
+		return
+	}
+
+	_, known := p.sessions[id]
+
+	// A disconnect for a session that was never seen (e.g. shorter than the
+	// poll interval) still produces a connect/disconnect pair
+	if !known && event.User != nil {
+		p.addSession(ctx, p.userToSession(*event.User))
+	}
+
+	if event.EventType == ocserv.EventDisconnect {
+		if event.User != nil {
+			// Final traffic counters
+			if session, ok := p.sessions[id]; ok {
+				session.BytesRX = parseBytes(event.User.RX)
+				session.BytesTX = parseBytes(event.User.TX)
+			}
+		}
+		if event.Reason != "" {
+			p.logger.DebugContext(ctx, "ocserv disconnect reason",
+				slog.Int("id", id),
+				slog.String("reason", event.Reason),
+			)
+		}
+		p.removeSession(ctx, id, "")
+	}
+}
@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func newStreamPoller(t *testing.T, occtl *ocserv.MockOcctlManager) (*Poller, <-chan SessionEvent) {
+	t.Helper()
+
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager:      occtl,
+		EventStream:       occtl,
+		Logger:            slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:            tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:             metricnoop.NewMeterProvider().Meter("test"),
+		Interval:          time.Hour,
+		ReconcileInterval: 2 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	events := make(chan SessionEvent, 16)
+	p.RegisterCallback(func(_ context.Context, event SessionEvent) {
+		events <- event
+	})
+	return p, events
+}
+
+func TestPoller_HandleStreamEvent_ShortSession(t *testing.T) {
+	p, events := newStreamPoller(t, ocserv.NewMockOcctlManager())
+
+	user := &ocserv.User{ID: 42, Username: "alice", RemoteIP: "203.0.113.10", IPv4: "10.10.0.2", RX: "100", TX: "200"}
+
+	// Connect and disconnect between two polls
+	p.handleStreamEvent(ocserv.Event{EventType: ocserv.EventConnect, SessionID: "42", User: user})
+	connected := waitEvent(t, events, SessionConnected)
+	assert.Equal(t, "alice", connected.Session.Username)
+	assert.Equal(t, "10.10.0.2", connected.Session.VPNIP)
+
+	final := *user
+	final.RX, final.TX = "5000", "7000"
+	p.handleStreamEvent(ocserv.Event{EventType: ocserv.EventDisconnect, SessionID: "42", User: &final})
+	disconnected := waitEvent(t, events, SessionDisconnected)
+	assert.Equal(t, uint64(5000), disconnected.Session.BytesRX)
+	assert.Equal(t, uint64(7000), disconnected.Session.BytesTX)
+
+	assert.Empty(t, p.GetActiveSessions())
+	assert.Equal(t, 2*time.Hour, p.currentInterval(), "polling relaxed while streaming")
+}
+
+func TestPoller_HandleStreamEvent_DisconnectOnly(t *testing.T) {
+	p, events := newStreamPoller(t, ocserv.NewMockOcctlManager())
+
+	// The connect was missed entirely; the pair is still reported
+	user := &ocserv.User{ID: 7, Username: "bob", RX: "10", TX: "20"}
+	p.handleStreamEvent(ocserv.Event{EventType: ocserv.EventDisconnect, SessionID: "7", User: user})
+
+	// The pair arrives in order
+	connected := waitNext(t, events)
+	assert.Equal(t, SessionConnected, connected.Type)
+	assert.Equal(t, "bob", connected.Session.Username)
+	disconnected := waitNext(t, events)
+	assert.Equal(t, SessionDisconnected, disconnected.Type)
+	assert.Equal(t, "bob", disconnected.Session.Username)
+}
+
+func TestPoller_HandleStreamEvent_ShortSessionsKeepOrder(t *testing.T) {
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager:      ocserv.NewMockOcctlManager(),
+		Logger:            slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:            tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:             metricnoop.NewMeterProvider().Meter("test"),
+		Interval:          time.Hour,
+		ReconcileInterval: 2 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	const sessions = 200
+	events := make(chan SessionEvent, 2*sessions)
+	p.RegisterCallback(func(_ context.Context, event SessionEvent) {
+		// A slow consumer, like the outbox writing to disk
+		if event.Type == SessionConnected {
+			time.Sleep(time.Microsecond)
+		}
+		events <- event
+	})
+
+	// Sessions shorter than a poll produce back-to-back connect and
+	// disconnect events from concurrent streams
+	var wg sync.WaitGroup
+	for worker := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := worker; i < sessions; i += 4 {
+				user := &ocserv.User{ID: i + 1, Username: "alice", RX: "1", TX: "2"}
+				id := strconv.Itoa(user.ID)
+				p.handleStreamEvent(ocserv.Event{EventType: ocserv.EventDisconnect, SessionID: id, User: user})
+			}
+		}()
+	}
+	wg.Wait()
+
+	connected := make(map[int]bool)
+	for range 2 * sessions {
+		event := waitNext(t, events)
+		switch event.Type {
+		case SessionConnected:
+			connected[event.Session.ID] = true
+		case SessionDisconnected:
+			require.True(t, connected[event.Session.ID], "session %d disconnected before it connected", event.Session.ID)
+		}
+	}
+	require.NoError(t, p.Stop(context.Background()))
+}
+
+func TestPoller_HandleStreamEvent_AuthFailure(t *testing.T) {
+	p, events := newStreamPoller(t, ocserv.NewMockOcctlManager())
+
+	p.handleStreamEvent(ocserv.Event{
+		EventType: ocserv.EventAuthFailure,
+		Username:  "mallory",
+		RemoteIP:  "198.51.100.9",
+		Reason:    "bad password",
+	})
+
+	event := waitEvent(t, events, SessionAuthFailed)
+	assert.Equal(t, "mallory", event.Session.Username)
+	assert.Equal(t, "198.51.100.9", event.Session.ClientIP)
+	assert.Equal(t, "bad password", event.Reason)
+	assert.Empty(t, p.GetActiveSessions())
+}
+
+func TestPoller_StreamRestart(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.SetEvents([]ocserv.Event{
+		{EventType: ocserv.EventConnect, SessionID: "1", User: &ocserv.User{ID: 1, Username: "alice"}},
+	}, errors.New("occtl exited"))
+
+	p, events := newStreamPoller(t, occtl)
+	require.NoError(t, p.Start(context.Background()))
+	defer p.Stop(context.Background())
+
+	waitEvent(t, events, SessionConnected)
+
+	// The broken stream is restarted after the backoff and polling falls back
+	assert.Eventually(t, func() bool { return occtl.GetStreamCalls() >= 2 }, 3*time.Second, 10*time.Millisecond)
+}
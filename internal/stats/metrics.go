@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/cockroachdb/errors"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 )
@@ -20,8 +21,9 @@ var (
 // Metrics holds OpenTelemetry metrics for stats
 type Metrics struct {
 	// Session metrics
-	activeSessions metric.Int64Gauge
-	sessionsTotal  metric.Int64Counter
+	activeSessions   metric.Int64Gauge
+	sessionsByStatus metric.Int64Gauge
+	sessionsTotal    metric.Int64Counter
 
 	// Traffic metrics
 	trafficBytesRX metric.Int64Counter
@@ -30,6 +32,15 @@ type Metrics struct {
 	// Polling metrics
 	pollDuration metric.Float64Histogram
 	pollErrors   metric.Int64Counter
+
+	// Schedule enforcement metrics
+	scheduleActions metric.Int64Counter
+
+	// Session revalidation metrics
+	revalidations metric.Int64Counter
+
+	// Disconnect accounting reported by the disconnect script
+	disconnectReports metric.Int64Counter
 }
 
 // NewMetrics creates and registers OpenTelemetry metrics
@@ -43,6 +54,15 @@ func NewMetrics(meter metric.Meter) (*Metrics, error) {
 		return nil, errors.Wrap(err, "create active sessions gauge")
 	}
 
+	sessionsByStatus, err := meter.Int64Gauge(
+		"ocserv.sessions.by_status",
+		metric.WithDescription("Number of active VPN sessions by activity status"),
+		metric.WithUnit("{session}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create sessions by status gauge")
+	}
+
 	sessionsTotal, err := meter.Int64Counter(
 		"ocserv.sessions.total",
 		metric.WithDescription("Total number of VPN sessions"),
@@ -89,13 +109,45 @@ func NewMetrics(meter metric.Meter) (*Metrics, error) {
 		return nil, errors.Wrap(err, "create poll errors counter")
 	}
 
+	scheduleActions, err := meter.Int64Counter(
+		"ocserv.schedule.actions",
+		metric.WithDescription("Number of access schedule warnings and disconnects"),
+		metric.WithUnit("{action}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create schedule actions counter")
+	}
+
+	revalidations, err := meter.Int64Counter(
+		"ocserv.sessions.revalidations",
+		metric.WithDescription("Number of session revalidations with the portal by result"),
+		metric.WithUnit("{validation}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create revalidations counter")
+	}
+
+	disconnectReports, err := meter.Int64Counter(
+		"ocserv.sessions.disconnect_reports",
+		metric.WithDescription("Number of disconnects reported by the disconnect script, by whether the session was tracked"),
+		metric.WithUnit("{report}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "create disconnect reports counter")
+	}
+
 	return &Metrics{
-		activeSessions: activeSessions,
-		sessionsTotal:  sessionsTotal,
-		trafficBytesRX: trafficBytesRX,
-		trafficBytesTX: trafficBytesTX,
-		pollDuration:   pollDuration,
-		pollErrors:     pollErrors,
+		activeSessions:   activeSessions,
+		sessionsByStatus: sessionsByStatus,
+		sessionsTotal:    sessionsTotal,
+		trafficBytesRX:   trafficBytesRX,
+		trafficBytesTX:   trafficBytesTX,
+		pollDuration:     pollDuration,
+		pollErrors:       pollErrors,
+		scheduleActions:  scheduleActions,
+		revalidations:    revalidations,
+
+		disconnectReports: disconnectReports,
 	}, nil
 }
 
@@ -104,6 +156,19 @@ func (m *Metrics) RecordActiveSessions(ctx context.Context, count int) {
 	m.activeSessions.Record(ctx, int64(count))
 }
 
+// RecordSessionsByStatus records the number of sessions in each activity status
+func (m *Metrics) RecordSessionsByStatus(ctx context.Context, counts map[vpnv1.SessionStatus]int) {
+	for _, status := range []vpnv1.SessionStatus{
+		vpnv1.SessionStatus_SESSION_STATUS_ACTIVE,
+		vpnv1.SessionStatus_SESSION_STATUS_IDLE,
+		vpnv1.SessionStatus_SESSION_STATUS_DEGRADED,
+	} {
+		m.sessionsByStatus.Record(ctx, int64(counts[status]),
+			metric.WithAttributes(attribute.String("status", status.String())),
+		)
+	}
+}
+
 // RecordSessionConnected increments the total session counter
 func (m *Metrics) RecordSessionConnected(ctx context.Context, username, groupName string) {
 	m.sessionsTotal.Add(ctx, 1,
@@ -151,3 +216,32 @@ func (m *Metrics) RecordPollError(ctx context.Context, errorType string) {
 		),
 	)
 }
+
+// RecordScheduleAction records a schedule warning or disconnect for a rule
+func (m *Metrics) RecordScheduleAction(ctx context.Context, rule, action string) {
+	m.scheduleActions.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("rule", rule),
+			attribute.String("action", action),
+		),
+	)
+}
+
+// RecordRevalidation records a session revalidation result (valid, revoked or error)
+func (m *Metrics) RecordRevalidation(ctx context.Context, result string) {
+	m.revalidations.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.String("result", result),
+		),
+	)
+}
+
+// RecordDisconnectReport records disconnect accounting from the disconnect
+// script; tracked is false for sessions no poll had seen yet
+func (m *Metrics) RecordDisconnectReport(ctx context.Context, tracked bool) {
+	m.disconnectReports.Add(ctx, 1,
+		metric.WithAttributes(
+			attribute.Bool("tracked", tracked),
+		),
+	)
+}
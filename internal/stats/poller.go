@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -15,14 +17,26 @@ import (
 
 // SessionInfo represents a VPN session for tracking
 type SessionInfo struct {
-	ID          int
-	Username    string
-	GroupName   string
-	ClientIP    string
-	VPNIP       string
-	ConnectedAt time.Time
-	BytesRX     uint64
-	BytesTX     uint64
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	GroupName   string    `json:"group_name"`
+	ClientIP    string    `json:"client_ip"`
+	VPNIP       string    `json:"vpn_ip"`
+	VPNIPv6     string    `json:"vpn_ipv6,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	BytesRX     uint64    `json:"bytes_rx"`
+	BytesTX     uint64    `json:"bytes_tx"`
+
+	// Status is the activity classification derived from traffic deltas
+	Status vpnv1.SessionStatus `json:"status"`
+
+	// DisconnectedAt is set on disconnect events
+	DisconnectedAt time.Time `json:"disconnected_at,omitzero"`
+
+	// DisconnectReason is set on disconnect events when the agent
+	// terminated the session itself (e.g. "schedule")
+	DisconnectReason string `json:"disconnect_reason,omitempty"`
 }
 
 // SessionEventType defines the type of session event
@@ -32,12 +46,48 @@ const (
 	SessionConnected    SessionEventType = "connected"
 	SessionDisconnected SessionEventType = "disconnected"
 	SessionUpdated      SessionEventType = "updated"
+
+	// SessionScheduleWarning is emitted once per session when its access
+	// window closes within the configured grace period
+	SessionScheduleWarning SessionEventType = "schedule_warning"
+
+	// SessionAuthFailed is emitted for failed authentications reported by
+	// the event stream; Session carries only the username and client IP
+	SessionAuthFailed SessionEventType = "auth_failed"
+
+	// SessionStatusChanged is emitted when the activity classification of a
+	// session changes (e.g. it becomes idle)
+	SessionStatusChanged SessionEventType = "status_changed"
+
+	// SessionPortalDisconnect is emitted when the portal asks for a session
+	// to be disconnected in reply to a session update (quota or other
+	// portal-side limit); Reason carries the portal's reason
+	SessionPortalDisconnect SessionEventType = "portal_disconnect"
+
+	// SessionRevoked is emitted when periodic revalidation finds a session
+	// invalid or the portal forces its disconnect; Reason carries the
+	// portal's reason
+	SessionRevoked SessionEventType = "revoked"
 )
 
 // SessionEvent represents a session state change
 type SessionEvent struct {
 	Type    SessionEventType
 	Session SessionInfo
+
+	// Deadline is when the session will be disconnected (schedule warnings only)
+	Deadline time.Time
+
+	// Reason is the failure reason (auth failures) or the portal's
+	// disconnect reason (portal disconnects and revocations)
+	Reason string
+
+	// PreviousStatus is the status before the change (status changes only)
+	PreviousStatus vpnv1.SessionStatus
+
+	// Recovered marks synthetic events produced by startup reconciliation
+	// for changes that happened while the agent was not running
+	Recovered bool
 }
 
 // SessionCallback is called when session events occur
@@ -45,16 +95,54 @@ type SessionCallback func(ctx context.Context, event SessionEvent)
 
 // Poller polls ocserv for active sessions and metrics
 type Poller struct {
-	occtl    *ocserv.OcctlManager
+	occtl    ocserv.OcctlInterface
 	logger   *slog.Logger
 	tracer   trace.Tracer
 	metrics  *Metrics
 	interval time.Duration
 
+	// Access schedule enforcement
+	schedule      ScheduleChecker
+	scheduleGrace time.Duration
+
+	// Activity classification and periodic status updates
+	updates        UpdateReporter
+	updateInterval time.Duration
+	activityWindow time.Duration
+	idleThreshold  uint64
+
+	// Periodic revalidation of active sessions with the portal
+	validator             SessionValidator
+	revalidateInterval    time.Duration
+	revalidateJitter      time.Duration
+	revalidateConcurrency int
+
+	// Event stream; while it is healthy listing sessions via occtl drops to
+	// reconcileInterval, session checks keep running at interval
+	events            ocserv.EventStreamer
+	reconcileInterval time.Duration
+	streaming         atomic.Bool
+	resync            chan struct{}
+
+	// Persisted state; recovery runs on the first successful poll
+	statePath    string
+	pendingState *persistedState
+	ready        chan struct{} // closed once the initial reconcile completed
+	readyOnce    sync.Once
+
 	// Session tracking
-	sessions  map[int]*SessionInfo
-	callbacks []SessionCallback
-	mu        sync.RWMutex
+	sessions          map[int]*SessionInfo
+	activity          map[int]*activityTracker
+	scheduleWarned    map[int]time.Time // session ID -> window close time already warned about
+	disconnectReasons map[int]string    // session ID -> reason for agent-initiated disconnects
+	reported          map[int]struct{}  // sessions ended by RecordDisconnect that occtl may still list
+	callbacks         []*callbackQueue
+	mu                sync.RWMutex
+
+	// Callback dispatch; each callback has its own ordered queue
+	dispatchStop chan struct{}
+	dispatchOnce sync.Once
+	dispatchWG   sync.WaitGroup
 
 	// Control
 	ctx    context.Context
@@ -64,11 +152,43 @@ type Poller struct {
 
 // PollerConfig configures the stats poller
 type PollerConfig struct {
-	OcctlManager *ocserv.OcctlManager
+	OcctlManager ocserv.OcctlInterface
 	Logger       *slog.Logger
 	Tracer       trace.Tracer
 	Meter        metric.Meter
 	Interval     time.Duration
+
+	// Schedule enables access window enforcement for active sessions (optional)
+	Schedule ScheduleChecker
+	// ScheduleGrace is how long before a window closes the warning event is emitted
+	ScheduleGrace time.Duration
+
+	// UpdateReporter receives periodic session status updates (optional)
+	UpdateReporter UpdateReporter
+	// UpdateInterval is how often each session's status is reported (default 1m)
+	UpdateInterval time.Duration
+	// ActivityWindow is the period traffic deltas are measured over (default 5m)
+	ActivityWindow time.Duration
+	// IdleThreshold is the traffic within the window at or below which a session is idle
+	IdleThreshold uint64
+
+	// Validator enables periodic revalidation of active sessions (optional)
+	Validator SessionValidator
+	// RevalidateInterval is how often every session is revalidated (default 5m)
+	RevalidateInterval time.Duration
+	// RevalidateJitter is the maximum random delay added to each revalidation cycle (default 10% of the interval)
+	RevalidateJitter time.Duration
+	// RevalidateConcurrency limits concurrent validation calls (default 4)
+	RevalidateConcurrency int
+
+	// EventStream enables real-time session tracking via 'occtl show events' (optional)
+	EventStream ocserv.EventStreamer
+	// ReconcileInterval is how often occtl is listed while the event stream is healthy (default 5m);
+	// activity, schedule and status update checks keep running at Interval
+	ReconcileInterval time.Duration
+
+	// StatePath persists tracked sessions across agent restarts (optional)
+	StatePath string
 }
 
 // NewPoller creates a new stats poller
@@ -88,6 +208,24 @@ func NewPoller(cfg *PollerConfig) (*Poller, error) {
 	if cfg.Interval == 0 {
 		cfg.Interval = 10 * time.Second
 	}
+	if cfg.UpdateInterval == 0 {
+		cfg.UpdateInterval = time.Minute
+	}
+	if cfg.ActivityWindow == 0 {
+		cfg.ActivityWindow = 5 * time.Minute
+	}
+	if cfg.ReconcileInterval == 0 {
+		cfg.ReconcileInterval = 5 * time.Minute
+	}
+	if cfg.RevalidateInterval == 0 {
+		cfg.RevalidateInterval = 5 * time.Minute
+	}
+	if cfg.RevalidateJitter == 0 {
+		cfg.RevalidateJitter = cfg.RevalidateInterval / 10
+	}
+	if cfg.RevalidateConcurrency <= 0 {
+		cfg.RevalidateConcurrency = 4
+	}
 
 	// Initialize metrics
 	metrics, err := NewMetrics(cfg.Meter)
@@ -98,15 +236,35 @@ func NewPoller(cfg *PollerConfig) (*Poller, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &Poller{
-		occtl:     cfg.OcctlManager,
-		logger:    cfg.Logger,
-		tracer:    cfg.Tracer,
-		metrics:   metrics,
-		interval:  cfg.Interval,
-		sessions:  make(map[int]*SessionInfo),
-		callbacks: make([]SessionCallback, 0),
-		ctx:       ctx,
-		cancel:    cancel,
+		occtl:                 cfg.OcctlManager,
+		logger:                cfg.Logger,
+		tracer:                cfg.Tracer,
+		metrics:               metrics,
+		interval:              cfg.Interval,
+		schedule:              cfg.Schedule,
+		scheduleGrace:         cfg.ScheduleGrace,
+		updates:               cfg.UpdateReporter,
+		updateInterval:        cfg.UpdateInterval,
+		activityWindow:        cfg.ActivityWindow,
+		idleThreshold:         cfg.IdleThreshold,
+		validator:             cfg.Validator,
+		revalidateInterval:    cfg.RevalidateInterval,
+		revalidateJitter:      cfg.RevalidateJitter,
+		revalidateConcurrency: cfg.RevalidateConcurrency,
+		events:                cfg.EventStream,
+		reconcileInterval:     cfg.ReconcileInterval,
+		resync:                make(chan struct{}, 1),
+		statePath:             cfg.StatePath,
+		ready:                 make(chan struct{}),
+		sessions:              make(map[int]*SessionInfo),
+		activity:              make(map[int]*activityTracker),
+		scheduleWarned:        make(map[int]time.Time),
+		disconnectReasons:     make(map[int]string),
+		reported:              make(map[int]struct{}),
+		callbacks:             make([]*callbackQueue, 0),
+		dispatchStop:          make(chan struct{}),
+		ctx:                   ctx,
+		cancel:                cancel,
 	}, nil
 }
 
@@ -121,12 +279,39 @@ func (p *Poller) Start(ctx context.Context) error {
 
 	p.logger.InfoContext(ctx, "starting stats poller",
 		slog.Duration("interval", p.interval),
+		slog.Bool("event_stream", p.events != nil),
+		slog.Bool("revalidation", p.validator != nil),
 	)
 
+	// Load state from the previous run; it is reconciled by the first poll
+	if p.statePath != "" {
+		state, err := loadState(p.statePath)
+		if err != nil {
+			p.logger.WarnContext(ctx, "ignoring unreadable session state",
+				slog.String("path", p.statePath),
+				slog.String("error", err.Error()),
+			)
+			state = &persistedState{Version: stateVersion}
+		}
+		p.pendingState = state
+	}
+
 	// Start polling loop
 	p.wg.Add(1)
 	go p.pollLoop()
 
+	// Start event stream consumer
+	if p.events != nil {
+		p.wg.Add(1)
+		go p.streamLoop()
+	}
+
+	// Start session revalidation
+	if p.validator != nil {
+		p.wg.Add(1)
+		go p.revalidateLoop()
+	}
+
 	return nil
 }
 
@@ -140,10 +325,13 @@ func (p *Poller) Stop(ctx context.Context) error {
 	// Cancel context to signal shutdown
 	p.cancel()
 
-	// Wait for polling loop to finish with timeout
+	// Wait for polling loop to finish with timeout, then deliver the
+	// events still queued for callbacks
 	done := make(chan struct{})
 	go func() {
 		p.wg.Wait()
+		p.dispatchOnce.Do(func() { close(p.dispatchStop) })
+		p.dispatchWG.Wait()
 		close(done)
 	}()
 
@@ -154,14 +342,26 @@ func (p *Poller) Stop(ctx context.Context) error {
 		p.logger.WarnContext(ctx, "stats poller shutdown timeout")
 	}
 
+	// Persist final state for reconciliation on the next start
+	p.saveState(ctx)
+
 	return nil
 }
 
-// RegisterCallback registers a callback for session events
+// RegisterCallback registers a callback for session events. Events are
+// delivered to each callback one at a time, in the order they occurred.
 func (p *Poller) RegisterCallback(cb SessionCallback) {
+	queue := newCallbackQueue(cb, p.logger)
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.callbacks = append(p.callbacks, cb)
+	p.callbacks = append(p.callbacks, queue)
+
+	p.dispatchWG.Add(1)
+	go func() {
+		defer p.dispatchWG.Done()
+		queue.run(p.dispatchStop)
+	}()
 }
 
 // GetActiveSessions returns a snapshot of active sessions
@@ -176,28 +376,72 @@ func (p *Poller) GetActiveSessions() []SessionInfo {
 	return sessions
 }
 
-// pollLoop is the main polling loop
+// CountSessions returns the number of active sessions of a user
+func (p *Poller) CountSessions(username string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	count := 0
+	for _, s := range p.sessions {
+		if s.Username == username {
+			count++
+		}
+	}
+	return count
+}
+
+// pollLoop is the main polling loop. It ticks at the polling interval:
+// sessions are checked (activity, schedules, status updates) on every tick,
+// while occtl is only listed again once the current reconcile interval has
+// passed, which is relaxed while the event stream is healthy.
 func (p *Poller) pollLoop() {
 	defer p.wg.Done()
 
-	ticker := time.NewTicker(p.interval)
-	defer ticker.Stop()
-
 	// Initial poll
 	p.poll()
+	lastReconcile := time.Now()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-p.ctx.Done():
 			return
-		case <-ticker.C:
+		case now := <-ticker.C:
+			if now.Sub(lastReconcile) < p.currentInterval() {
+				p.checkSessions(p.ctx, now)
+				continue
+			}
+			p.poll()
+		case <-p.resync:
+			// Event stream state changed: catch up on missed events
 			p.poll()
 		}
+		lastReconcile = time.Now()
+	}
+}
+
+// currentInterval returns the reconciliation interval, which is relaxed
+// while the event stream is healthy
+func (p *Poller) currentInterval() time.Duration {
+	if p.streaming.Load() {
+		return p.reconcileInterval
 	}
+	return p.interval
 }
 
-// poll performs a single poll operation
+// poll reconciles tracked sessions with occtl and then checks them
 func (p *Poller) poll() {
+	if !p.reconcile() {
+		return
+	}
+	p.checkSessions(p.ctx, time.Now())
+}
+
+// reconcile lists the active sessions via occtl and applies the changes
+// to the tracked sessions. It returns false if occtl could not be queried.
+func (p *Poller) reconcile() bool {
 	ctx, span := p.tracer.Start(p.ctx, "stats.poller.poll")
 	defer span.End()
 
@@ -210,18 +454,52 @@ func (p *Poller) poll() {
 			slog.String("error", err.Error()),
 		)
 		p.metrics.RecordPollError(ctx, "show_users")
-		return
+		return false
 	}
 
 	// Record poll duration
 	duration := time.Since(start)
 	p.metrics.RecordPollDuration(ctx, duration)
 
+	// Reconcile state from the previous run before regular tracking
+	if p.pendingState != nil {
+		p.recoverSessions(ctx, p.pendingState, users)
+		p.pendingState = nil
+	}
+
 	// Process users and detect changes
 	p.reconcileSessions(ctx, users)
+	p.readyOnce.Do(func() { close(p.ready) })
 
 	// Update metrics
 	p.updateMetrics(ctx, users)
+	return true
+}
+
+// checkSessions classifies tracked sessions by recent traffic, enforces
+// access schedules and reports session status to the portal. It does
+// nothing until the initial reconcile completed.
+func (p *Poller) checkSessions(ctx context.Context, now time.Time) {
+	select {
+	case <-p.ready:
+	default:
+		return
+	}
+
+	// Classify sessions by recent traffic
+	p.updateActivity(ctx, now)
+
+	// Enforce access schedules on the reconciled sessions
+	if p.schedule != nil {
+		p.enforceSchedule(ctx, now)
+	}
+
+	// Report session status to the portal
+	if p.updates != nil {
+		p.reportSessionUpdates(ctx, now)
+	}
+
+	p.saveState(ctx)
 }
 
 // reconcileSessions compares current users with tracked sessions
@@ -236,27 +514,18 @@ func (p *Poller) reconcileSessions(ctx context.Context, users []ocserv.User) {
 	for _, user := range users {
 		currentIDs[user.ID] = true
 
+		// Already ended by the disconnect script, still being torn down
+		if _, ok := p.reported[user.ID]; ok {
+			continue
+		}
+
 		// Check if this is a new session
 		if existing, ok := p.sessions[user.ID]; !ok {
-			// New session
-			session := p.userToSession(user)
-			p.sessions[user.ID] = &session
-
-			p.logger.InfoContext(ctx, "new session detected",
-				slog.Int("id", user.ID),
-				slog.String("username", user.Username),
-				slog.String("client_ip", user.RemoteIP),
-				slog.String("vpn_ip", user.IPv4),
-			)
-
-			// Emit event
-			p.emitEvent(ctx, SessionEvent{
-				Type:    SessionConnected,
-				Session: session,
-			})
+			p.addSession(ctx, p.userToSession(user))
 		} else {
 			// Existing session - check for updates
 			session := p.userToSession(user)
+			session.Status = existing.Status
 
 			// Check if traffic stats changed significantly
 			if session.BytesRX != existing.BytesRX || session.BytesTX != existing.BytesTX {
@@ -272,26 +541,80 @@ func (p *Poller) reconcileSessions(ctx context.Context, users []ocserv.User) {
 	}
 
 	// Find disconnected sessions
-	for id, session := range p.sessions {
+	for id := range p.sessions {
 		if !currentIDs[id] {
-			p.logger.InfoContext(ctx, "session disconnected",
-				slog.Int("id", id),
-				slog.String("username", session.Username),
-				slog.Duration("duration", time.Since(session.ConnectedAt)),
-			)
-
-			// Emit event
-			p.emitEvent(ctx, SessionEvent{
-				Type:    SessionDisconnected,
-				Session: *session,
-			})
+			p.removeSession(ctx, id, "")
+		}
+	}
 
-			// Remove from tracking
-			delete(p.sessions, id)
+	// Forget reported sessions once ocserv no longer lists them
+	for id := range p.reported {
+		if !currentIDs[id] {
+			delete(p.reported, id)
 		}
 	}
 }
 
+// addSession starts tracking a new session and emits a connect event.
+// Must be called with p.mu held.
+func (p *Poller) addSession(ctx context.Context, session SessionInfo) {
+	p.sessions[session.ID] = &session
+
+	p.logger.InfoContext(ctx, "new session detected",
+		slog.Int("id", session.ID),
+		slog.String("username", session.Username),
+		slog.String("client_ip", session.ClientIP),
+		slog.String("vpn_ip", session.VPNIP),
+	)
+
+	// Emit event
+	p.emitEvent(ctx, SessionEvent{
+		Type:    SessionConnected,
+		Session: session,
+	})
+}
+
+// removeSession stops tracking a session and emits a disconnect event.
+// A reason recorded for an agent-initiated disconnect takes precedence over
+// the given reason. Must be called with p.mu held.
+func (p *Poller) removeSession(ctx context.Context, id int, reason string) {
+	p.removeSessionAt(ctx, id, reason, time.Now())
+}
+
+// removeSessionAt is removeSession for a session that ended at the given
+// time. Must be called with p.mu held.
+func (p *Poller) removeSessionAt(ctx context.Context, id int, reason string, disconnectedAt time.Time) {
+	session, ok := p.sessions[id]
+	if !ok {
+		return
+	}
+
+	session.DisconnectedAt = disconnectedAt
+	session.DisconnectReason = reason
+	if agentReason, ok := p.disconnectReasons[id]; ok {
+		session.DisconnectReason = agentReason
+		delete(p.disconnectReasons, id)
+	}
+	delete(p.scheduleWarned, id)
+	delete(p.activity, id)
+
+	p.logger.InfoContext(ctx, "session disconnected",
+		slog.Int("id", id),
+		slog.String("username", session.Username),
+		slog.Duration("duration", session.DisconnectedAt.Sub(session.ConnectedAt)),
+		slog.String("reason", session.DisconnectReason),
+	)
+
+	// Emit event
+	p.emitEvent(ctx, SessionEvent{
+		Type:    SessionDisconnected,
+		Session: *session,
+	})
+
+	// Remove from tracking
+	delete(p.sessions, id)
+}
+
 // userToSession converts ocserv.User to SessionInfo
 func (p *Poller) userToSession(user ocserv.User) SessionInfo {
 	return SessionInfo{
@@ -300,25 +623,20 @@ func (p *Poller) userToSession(user ocserv.User) SessionInfo {
 		GroupName:   user.Groupname,
 		ClientIP:    user.RemoteIP,
 		VPNIP:       user.IPv4,
+		VPNIPv6:     user.IPv6,
+		UserAgent:   user.UserAgent,
 		ConnectedAt: time.Unix(user.RawConnectedAt, 0),
 		BytesRX:     parseBytes(user.RX),
 		BytesTX:     parseBytes(user.TX),
+		Status:      vpnv1.SessionStatus_SESSION_STATUS_ACTIVE,
 	}
 }
 
-// emitEvent calls all registered callbacks
+// emitEvent queues the event for all registered callbacks without
+// blocking; callbacks see events in the order they were emitted
 func (p *Poller) emitEvent(ctx context.Context, event SessionEvent) {
-	for _, cb := range p.callbacks {
-		go func(callback SessionCallback) {
-			defer func() {
-				if r := recover(); r != nil {
-					p.logger.ErrorContext(ctx, "callback panic",
-						slog.Any("panic", r),
-					)
-				}
-			}()
-			callback(ctx, event)
-		}(cb)
+	for _, queue := range p.callbacks {
+		queue.push(ctx, event)
 	}
 }
 
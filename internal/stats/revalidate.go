@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DisconnectReasonRevoked is recorded on sessions terminated because the
+// portal no longer considers them valid
+const DisconnectReasonRevoked = "revoked"
+
+// DisconnectReasonPolicyChanged is recorded on sessions terminated because
+// the portal changed the policy of their group
+const DisconnectReasonPolicyChanged = "policy_changed"
+
+// SessionValidator revalidates active sessions with the portal
+type SessionValidator interface {
+	ValidateSession(ctx context.Context, sessionID, username, clientIP string) (*vpnv1.ValidateSessionResponse, error)
+}
+
+// revalidateLoop revalidates all sessions every revalidation interval
+// plus a random jitter, so agents don't hit the portal in lockstep
+func (p *Poller) revalidateLoop() {
+	defer p.wg.Done()
+
+	// Sessions are known once the initial reconcile completed
+	select {
+	case <-p.ctx.Done():
+		return
+	case <-p.ready:
+	}
+
+	timer := time.NewTimer(p.revalidateDelay())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-timer.C:
+			p.revalidateSessions(p.ctx)
+			timer.Reset(p.revalidateDelay())
+		}
+	}
+}
+
+// revalidateDelay returns the wait before the next revalidation cycle
+func (p *Poller) revalidateDelay() time.Duration {
+	if p.revalidateJitter <= 0 {
+		return p.revalidateInterval
+	}
+	return p.revalidateInterval + rand.N(p.revalidateJitter)
+}
+
+// revalidateSessions validates every active session with the portal, at
+// most revalidateConcurrency at a time, and disconnects revoked sessions
+func (p *Poller) revalidateSessions(ctx context.Context) {
+	sessions := p.GetActiveSessions()
+
+	ctx, span := p.tracer.Start(ctx, "stats.poller.revalidate_sessions",
+		trace.WithAttributes(
+			attribute.Int("sessions", len(sessions)),
+		),
+	)
+	defer span.End()
+
+	sem := make(chan struct{}, p.revalidateConcurrency)
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			p.revalidateSession(ctx, session)
+		}()
+	}
+	wg.Wait()
+}
+
+// revalidateSession validates one session and disconnects it if the portal
+// reports it invalid or asks for a forced disconnect. Sessions are kept
+// when the portal is unreachable.
+func (p *Poller) revalidateSession(ctx context.Context, session SessionInfo) {
+	resp, err := p.validator.ValidateSession(ctx, strconv.Itoa(session.ID), session.Username, session.ClientIP)
+	if err != nil {
+		p.logger.ErrorContext(ctx, "failed to revalidate session",
+			slog.Int("id", session.ID),
+			slog.String("username", session.Username),
+			slog.String("error", err.Error()),
+		)
+		p.metrics.RecordRevalidation(ctx, "error")
+		return
+	}
+	if resp.Valid && !resp.ForceDisconnect {
+		p.metrics.RecordRevalidation(ctx, "valid")
+		return
+	}
+
+	p.metrics.RecordRevalidation(ctx, "revoked")
+	p.logger.WarnContext(ctx, "disconnecting session revoked by portal",
+		slog.Int("id", session.ID),
+		slog.String("username", session.Username),
+		slog.Bool("valid", resp.Valid),
+		slog.Bool("force_disconnect", resp.ForceDisconnect),
+		slog.String("reason", resp.InvalidReason),
+	)
+	p.mu.RLock()
+	p.emitEvent(ctx, SessionEvent{
+		Type:    SessionRevoked,
+		Session: session,
+		Reason:  resp.InvalidReason,
+	})
+	p.mu.RUnlock()
+	p.disconnectSession(ctx, session.ID, DisconnectReasonRevoked)
+}
+
+// DisconnectSessions disconnects all active sessions matching match and
+// returns how many were disconnected
+func (p *Poller) DisconnectSessions(ctx context.Context, match func(SessionInfo) bool, reason string) int {
+	disconnected := 0
+	for _, session := range p.GetActiveSessions() {
+		if !match(session) {
+			continue
+		}
+
+		p.logger.WarnContext(ctx, "disconnecting session",
+			slog.Int("id", session.ID),
+			slog.String("username", session.Username),
+			slog.String("reason", reason),
+		)
+		if p.disconnectSession(ctx, session.ID, reason) {
+			disconnected++
+		}
+	}
+	return disconnected
+}
@@ -0,0 +1,184 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	vpnv1 "github.com/dantte-lp/ocserv-agent/pkg/proto/vpn/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// fakeValidator is a SessionValidator returning per-user verdicts
+type fakeValidator struct {
+	verdicts map[string]*vpnv1.ValidateSessionResponse
+	err      error
+	delay    time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	calls    atomic.Int32
+}
+
+func (v *fakeValidator) ValidateSession(_ context.Context, _, username, _ string) (*vpnv1.ValidateSessionResponse, error) {
+	v.calls.Add(1)
+
+	v.mu.Lock()
+	v.inFlight++
+	v.peak = max(v.peak, v.inFlight)
+	v.mu.Unlock()
+
+	time.Sleep(v.delay)
+
+	v.mu.Lock()
+	v.inFlight--
+	v.mu.Unlock()
+
+	if v.err != nil {
+		return nil, v.err
+	}
+	if verdict, ok := v.verdicts[username]; ok {
+		return verdict, nil
+	}
+	return &vpnv1.ValidateSessionResponse{Valid: true}, nil
+}
+
+func newRevalidatePoller(t *testing.T, occtl ocserv.OcctlInterface, validator SessionValidator, concurrency int) (*Poller, <-chan SessionEvent) {
+	t.Helper()
+
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager:          occtl,
+		Logger:                slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:                tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:                 metricnoop.NewMeterProvider().Meter("test"),
+		Interval:              time.Hour,
+		Validator:             validator,
+		RevalidateConcurrency: concurrency,
+	})
+	require.NoError(t, err)
+
+	events := make(chan SessionEvent, 16)
+	p.RegisterCallback(func(_ context.Context, event SessionEvent) {
+		events <- event
+	})
+	return p, events
+}
+
+func TestPoller_RevalidateDisconnectsRevokedSessions(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl.AddMockUser(2, "bob", "10.10.0.3", "203.0.113.11")
+	occtl.AddMockUser(3, "carol", "10.10.0.4", "203.0.113.12")
+
+	validator := &fakeValidator{verdicts: map[string]*vpnv1.ValidateSessionResponse{
+		"alice": {Valid: false, InvalidReason: "user blocked"},
+		"carol": {Valid: true, ForceDisconnect: true, InvalidReason: "password changed"},
+	}}
+	p, events := newRevalidatePoller(t, occtl, validator, 2)
+
+	p.poll()
+	p.revalidateSessions(context.Background())
+
+	assert.ElementsMatch(t, []string{"alice", "carol"}, occtl.GetDisconnectedUsers())
+	assert.Equal(t, int32(3), validator.calls.Load())
+
+	event := waitEvent(t, events, SessionRevoked)
+	assert.Contains(t, []string{"user blocked", "password changed"}, event.Reason)
+
+	// Next poll observes the disconnects and attaches the reason
+	p.poll()
+	for range 2 {
+		event := waitEvent(t, events, SessionDisconnected)
+		assert.Equal(t, DisconnectReasonRevoked, event.Session.DisconnectReason)
+	}
+
+	sessions := p.GetActiveSessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "bob", sessions[0].Username)
+}
+
+func TestPoller_RevalidateKeepsSessionsWhenPortalUnreachable(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+
+	p, _ := newRevalidatePoller(t, occtl, &fakeValidator{err: errors.New("portal unreachable")}, 1)
+
+	p.poll()
+	p.revalidateSessions(context.Background())
+
+	assert.Empty(t, occtl.GetDisconnectedUsers())
+	assert.Len(t, p.GetActiveSessions(), 1)
+}
+
+func TestPoller_RevalidateConcurrencyLimit(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	for i := 1; i <= 10; i++ {
+		occtl.AddMockUser(i, "user", "10.10.0.2", "203.0.113.10")
+	}
+
+	validator := &fakeValidator{delay: 10 * time.Millisecond}
+	p, _ := newRevalidatePoller(t, occtl, validator, 3)
+
+	p.poll()
+	p.revalidateSessions(context.Background())
+
+	assert.Equal(t, int32(10), validator.calls.Load())
+	assert.LessOrEqual(t, validator.peak, 3)
+	assert.Greater(t, validator.peak, 1)
+}
+
+func TestPoller_RevalidateDelayJitter(t *testing.T) {
+	p := &Poller{revalidateInterval: time.Minute, revalidateJitter: 10 * time.Second}
+	for range 100 {
+		delay := p.revalidateDelay()
+		assert.GreaterOrEqual(t, delay, time.Minute)
+		assert.Less(t, delay, time.Minute+10*time.Second)
+	}
+
+	p.revalidateJitter = 0
+	assert.Equal(t, time.Minute, p.revalidateDelay())
+}
+
+func TestPoller_DisconnectSessions(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl.AddMockUser(2, "alice", "10.10.0.3", "203.0.113.11")
+	occtl.AddMockUser(3, "bob", "10.10.0.4", "203.0.113.12")
+
+	p, events := newRevalidatePoller(t, occtl, nil, 1)
+	p.poll()
+
+	n := p.DisconnectSessions(context.Background(), func(s SessionInfo) bool {
+		return s.Username == "alice"
+	}, DisconnectReasonPolicyChanged)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"alice", "alice"}, occtl.GetDisconnectedUsers())
+
+	p.poll()
+	event := waitEvent(t, events, SessionDisconnected)
+	assert.Equal(t, DisconnectReasonPolicyChanged, event.Session.DisconnectReason)
+}
+
+func TestPoller_CountSessions(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl.AddMockUser(2, "alice", "10.10.0.3", "203.0.113.11")
+	occtl.AddMockUser(3, "bob", "10.10.0.4", "203.0.113.12")
+
+	p, _ := newRevalidatePoller(t, occtl, nil, 1)
+	p.poll()
+
+	assert.Equal(t, 2, p.CountSessions("alice"))
+	assert.Equal(t, 1, p.CountSessions("bob"))
+	assert.Zero(t, p.CountSessions("carol"))
+}
@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
+)
+
+// DisconnectReasonSchedule is recorded on sessions terminated because their
+// access window closed
+const DisconnectReasonSchedule = "schedule"
+
+// ScheduleChecker evaluates access schedules for a user
+type ScheduleChecker interface {
+	Check(username, groupName string, now time.Time) schedule.Decision
+}
+
+// enforceSchedule warns sessions whose access window is about to close and
+// disconnects sessions that are outside their window
+func (p *Poller) enforceSchedule(ctx context.Context, now time.Time) {
+	ctx, span := p.tracer.Start(ctx, "stats.poller.enforce_schedule")
+	defer span.End()
+
+	// Evaluate under lock, disconnect outside of it: occtl calls may be slow
+	type expired struct {
+		session SessionInfo
+		rule    string
+	}
+	var toDisconnect []expired
+
+	p.mu.Lock()
+	for id, session := range p.sessions {
+		decision := p.schedule.Check(session.Username, session.GroupName, now)
+
+		if !decision.Allowed {
+			toDisconnect = append(toDisconnect, expired{session: *session, rule: decision.Rule})
+			continue
+		}
+
+		if decision.ClosesAt.IsZero() || decision.ClosesAt.Sub(now) > p.scheduleGrace {
+			continue
+		}
+		if warned, ok := p.scheduleWarned[id]; ok && warned.Equal(decision.ClosesAt) {
+			continue
+		}
+		p.scheduleWarned[id] = decision.ClosesAt
+
+		p.logger.InfoContext(ctx, "session access window closing",
+			slog.Int("id", id),
+			slog.String("username", session.Username),
+			slog.String("rule", decision.Rule),
+			slog.Time("closes_at", decision.ClosesAt),
+		)
+		p.metrics.RecordScheduleAction(ctx, decision.Rule, "warning")
+
+		p.emitEvent(ctx, SessionEvent{
+			Type:     SessionScheduleWarning,
+			Session:  *session,
+			Deadline: decision.ClosesAt,
+		})
+	}
+	p.mu.Unlock()
+
+	for _, e := range toDisconnect {
+		p.logger.WarnContext(ctx, "disconnecting session outside access window",
+			slog.Int("id", e.session.ID),
+			slog.String("username", e.session.Username),
+			slog.String("rule", e.rule),
+		)
+
+		if p.disconnectSession(ctx, e.session.ID, DisconnectReasonSchedule) {
+			p.metrics.RecordScheduleAction(ctx, e.rule, "disconnect")
+		}
+	}
+}
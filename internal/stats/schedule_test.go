@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	"github.com/dantte-lp/ocserv-agent/internal/schedule"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+// scheduleFunc adapts a function to ScheduleChecker
+type scheduleFunc func(username, groupName string, now time.Time) schedule.Decision
+
+func (f scheduleFunc) Check(username, groupName string, now time.Time) schedule.Decision {
+	return f(username, groupName, now)
+}
+
+func newSchedulePoller(t *testing.T, occtl ocserv.OcctlInterface, checker ScheduleChecker) (*Poller, <-chan SessionEvent) {
+	t.Helper()
+
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager:  occtl,
+		Logger:        slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:        tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:         metricnoop.NewMeterProvider().Meter("test"),
+		Interval:      time.Hour,
+		Schedule:      checker,
+		ScheduleGrace: 5 * time.Minute,
+	})
+	require.NoError(t, err)
+
+	events := make(chan SessionEvent, 16)
+	p.RegisterCallback(func(_ context.Context, event SessionEvent) {
+		events <- event
+	})
+	return p, events
+}
+
+// waitEvent waits for the next event of the given type
+func waitEvent(t *testing.T, events <-chan SessionEvent, typ SessionEventType) SessionEvent {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == typ {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s event", typ)
+		}
+	}
+}
+
+// waitNext returns the next event of any type
+func waitNext(t *testing.T, events <-chan SessionEvent) SessionEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for an event")
+		return SessionEvent{}
+	}
+}
+
+func TestPoller_ScheduleWarning(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+
+	closesAt := time.Now().Add(2 * time.Minute).Truncate(time.Minute)
+	p, events := newSchedulePoller(t, occtl, scheduleFunc(func(_, _ string, _ time.Time) schedule.Decision {
+		return schedule.Decision{Allowed: true, Rule: "office-hours", ClosesAt: closesAt}
+	}))
+
+	p.poll()
+	event := waitEvent(t, events, SessionScheduleWarning)
+	assert.Equal(t, "alice", event.Session.Username)
+	assert.Equal(t, closesAt, event.Deadline)
+
+	// The warning is emitted only once per window
+	p.poll()
+	select {
+	case event := <-events:
+		assert.NotEqual(t, SessionScheduleWarning, event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Empty(t, occtl.GetDisconnectedUsers())
+}
+
+func TestPoller_ScheduleDisconnect(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl.AddMockUser(2, "bob", "10.10.0.3", "203.0.113.11")
+
+	p, events := newSchedulePoller(t, occtl, scheduleFunc(func(username, _ string, _ time.Time) schedule.Decision {
+		if username == "alice" {
+			return schedule.Decision{Allowed: false, Rule: "contractors"}
+		}
+		return schedule.Decision{Allowed: true}
+	}))
+
+	// First poll detects the sessions and disconnects alice
+	p.poll()
+	assert.Equal(t, []string{"alice"}, occtl.GetDisconnectedUsers())
+
+	// Next poll observes the disconnect and attaches the reason
+	p.poll()
+	event := waitEvent(t, events, SessionDisconnected)
+	assert.Equal(t, "alice", event.Session.Username)
+	assert.Equal(t, DisconnectReasonSchedule, event.Session.DisconnectReason)
+
+	sessions := p.GetActiveSessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "bob", sessions[0].Username)
+}
+
+func TestPoller_ScheduleEnforcedWhileStreaming(t *testing.T) {
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+
+	var closed atomic.Bool
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager:      occtl,
+		Logger:            slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:            tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:             metricnoop.NewMeterProvider().Meter("test"),
+		Interval:          20 * time.Millisecond,
+		ReconcileInterval: time.Hour,
+		Schedule: scheduleFunc(func(_, _ string, _ time.Time) schedule.Decision {
+			return schedule.Decision{Allowed: !closed.Load(), Rule: "office-hours"}
+		}),
+	})
+	require.NoError(t, err)
+
+	// A healthy event stream relaxes listing sessions via occtl to the
+	// reconcile interval, but not the schedule checks
+	p.streaming.Store(true)
+	require.NoError(t, p.Start(context.Background()))
+	defer p.Stop(context.Background())
+
+	require.Eventually(t, func() bool { return len(p.GetActiveSessions()) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Empty(t, occtl.GetDisconnectedUsers())
+
+	// The window closes; the session is disconnected within a few ticks
+	closed.Store(true)
+	assert.Eventually(t, func() bool {
+		return len(occtl.GetDisconnectedUsers()) == 1
+	}, time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,178 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+)
+
+// stateVersion is the format version of the persisted session state
+const stateVersion = 1
+
+// persistedState is the on-disk form of the tracked sessions
+type persistedState struct {
+	Version  int           `json:"version"`
+	SavedAt  time.Time     `json:"saved_at"`
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+// loadState reads persisted session state. A missing file yields an empty state.
+func loadState(path string) (*persistedState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &persistedState{Version: stateVersion}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state file: %w", err)
+	}
+
+	var state persistedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse state file: %w", err)
+	}
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state file version %d", state.Version)
+	}
+
+	return &state, nil
+}
+
+// writeState atomically replaces the state file; it is readable by the owner only
+func writeState(path string, state *persistedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+
+	// CreateTemp opens the file with mode 0600
+	tmp, err := os.CreateTemp(dir, ".sessions-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp state file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close state: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replace state file: %w", err)
+	}
+	return nil
+}
+
+// saveState persists the tracked sessions if a state file is configured
+func (p *Poller) saveState(ctx context.Context) {
+	if p.statePath == "" {
+		return
+	}
+
+	p.mu.RLock()
+	state := &persistedState{
+		Version:  stateVersion,
+		SavedAt:  time.Now(),
+		Sessions: make([]SessionInfo, 0, len(p.sessions)),
+	}
+	for _, s := range p.sessions {
+		state.Sessions = append(state.Sessions, *s)
+	}
+	p.mu.RUnlock()
+
+	if err := writeState(p.statePath, state); err != nil {
+		p.logger.ErrorContext(ctx, "failed to save session state",
+			slog.String("path", p.statePath),
+			slog.String("error", err.Error()),
+		)
+		p.metrics.RecordPollError(ctx, "state_save")
+	}
+}
+
+// recoverSessions reconciles the state persisted by the previous run with the
+// sessions ocserv currently reports. Sessions still present are restored
+// silently; sessions that ended or started while the agent was down produce
+// synthetic disconnect and connect events marked as recovered.
+func (p *Poller) recoverSessions(ctx context.Context, state *persistedState, users []ocserv.User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[int]ocserv.User, len(users))
+	for _, user := range users {
+		current[user.ID] = user
+	}
+
+	var restored, connected, disconnected int
+
+	for _, s := range state.Sessions {
+		session := s
+
+		// ocserv reuses IDs after a restart, so the connect time must match too
+		if user, ok := current[s.ID]; ok && user.Username == s.Username && user.RawConnectedAt == s.ConnectedAt.Unix() {
+			p.sessions[s.ID] = &session
+			restored++
+			continue
+		}
+
+		// Ended while the agent was down; the last save is the best known end time
+		session.DisconnectedAt = state.SavedAt
+		disconnected++
+
+		p.logger.InfoContext(ctx, "recovered session disconnect",
+			slog.Int("id", session.ID),
+			slog.String("username", session.Username),
+			slog.Time("last_seen", state.SavedAt),
+		)
+		p.emitEvent(ctx, SessionEvent{
+			Type:      SessionDisconnected,
+			Session:   session,
+			Recovered: true,
+		})
+	}
+
+	for _, user := range users {
+		if _, ok := p.sessions[user.ID]; ok {
+			continue
+		}
+
+		// Started while the agent was down
+		session := p.userToSession(user)
+		p.sessions[user.ID] = &session
+		connected++
+
+		p.logger.InfoContext(ctx, "recovered session connect",
+			slog.Int("id", session.ID),
+			slog.String("username", session.Username),
+		)
+		p.emitEvent(ctx, SessionEvent{
+			Type:      SessionConnected,
+			Session:   session,
+			Recovered: true,
+		})
+	}
+
+	p.logger.InfoContext(ctx, "session state reconciled",
+		slog.Int("restored", restored),
+		slog.Int("connected", connected),
+		slog.Int("disconnected", disconnected),
+	)
+}
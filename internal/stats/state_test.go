@@ -0,0 +1,119 @@
+package stats
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/ocserv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestStateFile_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "sessions.json")
+
+	state, err := loadState(path)
+	require.NoError(t, err, "missing file is not an error")
+	assert.Empty(t, state.Sessions)
+
+	saved := &persistedState{
+		Version: stateVersion,
+		SavedAt: time.Unix(1761177600, 0).UTC(),
+		Sessions: []SessionInfo{
+			{ID: 1, Username: "alice", ConnectedAt: time.Unix(1761170000, 0).UTC(), BytesRX: 10, BytesTX: 20},
+		},
+	}
+	require.NoError(t, writeState(path, saved))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	loaded, err := loadState(path)
+	require.NoError(t, err)
+	assert.Equal(t, saved, loaded)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = loadState(path)
+	assert.Error(t, err)
+}
+
+func TestPoller_RecoverSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	lastSave := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	occtl := ocserv.NewMockOcctlManager()
+	occtl.AddMockUser(1, "alice", "10.10.0.2", "203.0.113.10")
+	occtl.AddMockUser(3, "carol", "10.10.0.4", "203.0.113.12")
+	occtl.AddMockUser(4, "eve", "10.10.0.5", "203.0.113.13")
+	users, err := occtl.ShowUsers(context.Background())
+	require.NoError(t, err)
+
+	// alice is still connected, bob ended while the agent was down and
+	// session ID 4 was reused by ocserv for a different user
+	require.NoError(t, writeState(path, &persistedState{
+		Version: stateVersion,
+		SavedAt: lastSave,
+		Sessions: []SessionInfo{
+			{ID: 1, Username: "alice", ConnectedAt: time.Unix(users[0].RawConnectedAt, 0), BytesRX: 5},
+			{ID: 2, Username: "bob", ConnectedAt: lastSave.Add(-time.Hour), BytesRX: 100, BytesTX: 200},
+			{ID: 4, Username: "dave", ConnectedAt: lastSave.Add(-2 * time.Hour)},
+		},
+	}))
+
+	p, err := NewPoller(&PollerConfig{
+		OcctlManager: occtl,
+		Logger:       slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})),
+		Tracer:       tracenoop.NewTracerProvider().Tracer("test"),
+		Meter:        metricnoop.NewMeterProvider().Meter("test"),
+		Interval:     time.Hour,
+		StatePath:    path,
+	})
+	require.NoError(t, err)
+
+	events := make(chan SessionEvent, 16)
+	p.RegisterCallback(func(_ context.Context, event SessionEvent) {
+		events <- event
+	})
+
+	require.NoError(t, p.Start(context.Background()))
+
+	got := make(map[string]SessionEvent)
+	for len(got) < 4 {
+		select {
+		case event := <-events:
+			if event.Type == SessionUpdated {
+				continue
+			}
+			got[string(event.Type)+":"+event.Session.Username] = event
+		case <-time.After(time.Second):
+			t.Fatalf("timed out, got %d events", len(got))
+		}
+	}
+	require.NoError(t, p.Stop(context.Background()))
+
+	assert.NotContains(t, got, "connected:alice", "restored sessions are not re-reported")
+	for _, key := range []string{"disconnected:bob", "disconnected:dave", "connected:carol", "connected:eve"} {
+		require.Contains(t, got, key)
+		assert.True(t, got[key].Recovered, key)
+	}
+
+	bob := got["disconnected:bob"].Session
+	assert.True(t, lastSave.Equal(bob.DisconnectedAt), "disconnect time is the last save")
+	assert.Equal(t, uint64(200), bob.BytesTX)
+
+	// The state written on stop reflects the reconciled sessions
+	state, err := loadState(path)
+	require.NoError(t, err)
+	var names []string
+	for _, s := range state.Sessions {
+		names = append(names, s.Username)
+	}
+	assert.ElementsMatch(t, []string{"alice", "carol", "eve"}, names)
+}
@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// sessionsBucket - bucket bbolt с сессиями (sessionID -> JSON VPNSession)
+var sessionsBucket = []byte("sessions")
+
+// BoltSessionStore хранит VPN сессии в embedded bbolt файле,
+// сессии переживают рестарт агента
+type BoltSessionStore struct {
+	db     *bolt.DB
+	ttl    time.Duration      // TTL для сессий (0 = без TTL)
+	cancel context.CancelFunc // Остановка cleanup goroutine
+	wg     sync.WaitGroup
+}
+
+// Ensure BoltSessionStore implements SessionStore
+var _ SessionStore = (*BoltSessionStore)(nil)
+
+// NewBoltSessionStore открывает (или создает) bbolt session store
+func NewBoltSessionStore(path string, ttl time.Duration) (*BoltSessionStore, error) {
+	if path == "" {
+		return nil, errors.New("session store path is required")
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open session store %s", path)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "create sessions bucket")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &BoltSessionStore{
+		db:     db,
+		ttl:    ttl,
+		cancel: cancel,
+	}
+
+	// Запустить cleanup goroutine если TTL установлен
+	if ttl > 0 {
+		store.wg.Add(1)
+		go store.cleanupExpiredSessions(ctx)
+	}
+
+	return store, nil
+}
+
+// Close останавливает cleanup и закрывает файл
+func (s *BoltSessionStore) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+// Add добавляет новую сессию в store
+func (s *BoltSessionStore) Add(session *VPNSession) error {
+	if session == nil {
+		return errors.New("session cannot be nil")
+	}
+
+	if session.SessionID == "" {
+		return errors.New("session ID cannot be empty")
+	}
+
+	if session.Username == "" {
+		return errors.New("username cannot be empty")
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		s.prepare(session)
+		return putSession(tx, session)
+	})
+}
+
+// prepare заполняет значения по умолчанию как in-memory store
+func (s *BoltSessionStore) prepare(session *VPNSession) {
+	now := time.Now()
+
+	if session.LastActivity.IsZero() {
+		session.LastActivity = now
+	}
+	if session.ConnectedAt.IsZero() {
+		session.ConnectedAt = now
+	}
+	if s.ttl > 0 {
+		expiresAt := now.Add(s.ttl)
+		session.ExpiresAt = &expiresAt
+	}
+	if session.Metadata == nil {
+		session.Metadata = make(map[string]string)
+	}
+}
+
+// Get возвращает сессию по ID
+func (s *BoltSessionStore) Get(sessionID string) (*VPNSession, error) {
+	if sessionID == "" {
+		return nil, errors.New("session ID cannot be empty")
+	}
+
+	var session *VPNSession
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		session, err = getSession(tx, sessionID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Проверить TTL
+	if session.expired(time.Now()) {
+		return nil, errors.Newf("session expired: %s", sessionID)
+	}
+
+	return session, nil
+}
+
+// Update обновляет существующую сессию
+func (s *BoltSessionStore) Update(sessionID string, updateFn func(*VPNSession) error) error {
+	if sessionID == "" {
+		return errors.New("session ID cannot be empty")
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		session, err := getSession(tx, sessionID)
+		if err != nil {
+			return err
+		}
+
+		// Выполнить update функцию
+		if err := updateFn(session); err != nil {
+			return errors.Wrap(err, "update function failed")
+		}
+
+		// Обновить LastActivity и ExpiresAt
+		session.LastActivity = time.Now()
+		if s.ttl > 0 {
+			expiresAt := time.Now().Add(s.ttl)
+			session.ExpiresAt = &expiresAt
+		}
+
+		return putSession(tx, session)
+	})
+}
+
+// Remove удаляет сессию по ID
+func (s *BoltSessionStore) Remove(sessionID string) error {
+	if sessionID == "" {
+		return errors.New("session ID cannot be empty")
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+		if b.Get([]byte(sessionID)) == nil {
+			return errors.Newf("session not found: %s", sessionID)
+		}
+		return b.Delete([]byte(sessionID))
+	})
+}
+
+// List возвращает все активные сессии
+func (s *BoltSessionStore) List() []*VPNSession {
+	return s.filter(func(*VPNSession) bool { return true })
+}
+
+// ListByUsername возвращает все сессии для указанного пользователя
+func (s *BoltSessionStore) ListByUsername(username string) []*VPNSession {
+	if username == "" {
+		return []*VPNSession{}
+	}
+
+	return s.filter(func(session *VPNSession) bool {
+		return session.Username == username
+	})
+}
+
+// Count возвращает количество активных сессий
+func (s *BoltSessionStore) Count() int {
+	return len(s.List())
+}
+
+// CountByUsername возвращает количество сессий для пользователя
+func (s *BoltSessionStore) CountByUsername(username string) int {
+	return len(s.ListByUsername(username))
+}
+
+// Clear удаляет все сессии
+func (s *BoltSessionStore) Clear() {
+	_ = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(sessionsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(sessionsBucket)
+		return err
+	})
+}
+
+// RemoveByUsername удаляет все сессии пользователя
+func (s *BoltSessionStore) RemoveByUsername(username string) int {
+	if username == "" {
+		return 0
+	}
+
+	return s.removeWhere(func(session *VPNSession) bool {
+		return session.Username == username
+	})
+}
+
+// UpdateStats обновляет статистику сессии (bytes in/out)
+func (s *BoltSessionStore) UpdateStats(sessionID string, bytesIn, bytesOut uint64) error {
+	return s.Update(sessionID, func(session *VPNSession) error {
+		session.BytesIn = bytesIn
+		session.BytesOut = bytesOut
+		return nil
+	})
+}
+
+// GetStats возвращает статистику по всем сессиям
+func (s *BoltSessionStore) GetStats() SessionStats {
+	stats := SessionStats{
+		UserSessions: make(map[string]int),
+	}
+
+	for _, session := range s.List() {
+		stats.TotalSessions++
+		stats.TotalBytesIn += session.BytesIn
+		stats.TotalBytesOut += session.BytesOut
+		stats.UserSessions[session.Username]++
+	}
+
+	return stats
+}
+
+// Exists проверяет существование сессии
+func (s *BoltSessionStore) Exists(sessionID string) bool {
+	_, err := s.Get(sessionID)
+	return err == nil
+}
+
+// GetOrCreate возвращает существующую сессию или создает новую.
+// Проверка и создание выполняются в одной транзакции.
+func (s *BoltSessionStore) GetOrCreate(session *VPNSession) (*VPNSession, bool, error) {
+	if session == nil || session.SessionID == "" {
+		return nil, false, errors.New("invalid session")
+	}
+	if session.Username == "" {
+		return nil, false, errors.Wrap(errors.New("username cannot be empty"), "failed to add session")
+	}
+
+	var (
+		result  *VPNSession
+		created bool
+	)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		existing, err := getSession(tx, session.SessionID)
+		if err == nil && !existing.expired(time.Now()) {
+			result = existing
+			return nil
+		}
+
+		s.prepare(session)
+		if err := putSession(tx, session); err != nil {
+			return errors.Wrap(err, "failed to add session")
+		}
+		result, created = session, true
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return result, created, nil
+}
+
+// filter возвращает неистёкшие сессии, удовлетворяющие условию
+func (s *BoltSessionStore) filter(match func(*VPNSession) bool) []*VPNSession {
+	sessions := make([]*VPNSession, 0)
+	now := time.Now()
+
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(_, v []byte) error {
+			var session VPNSession
+			if err := json.Unmarshal(v, &session); err != nil {
+				return nil // Пропустить поврежденные записи
+			}
+			// Пропустить истёкшие сессии
+			if session.expired(now) || !match(&session) {
+				return nil
+			}
+			sessions = append(sessions, &session)
+			return nil
+		})
+	})
+
+	return sessions
+}
+
+// removeWhere удаляет сессии, удовлетворяющие условию, и возвращает их количество
+func (s *BoltSessionStore) removeWhere(match func(*VPNSession) bool) int {
+	count := 0
+
+	_ = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(sessionsBucket)
+
+		var keys [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			var session VPNSession
+			if err := json.Unmarshal(v, &session); err != nil || match(&session) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+
+	return count
+}
+
+// cleanupExpiredSessions периодически удаляет истёкшие сессии
+func (s *BoltSessionStore) cleanupExpiredSessions(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.ttl / 2) // Cleanup каждые ttl/2
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			s.removeWhere(func(session *VPNSession) bool {
+				return session.expired(now)
+			})
+		}
+	}
+}
+
+// getSession читает сессию из bucket
+func getSession(tx *bolt.Tx, sessionID string) (*VPNSession, error) {
+	data := tx.Bucket(sessionsBucket).Get([]byte(sessionID))
+	if data == nil {
+		return nil, errors.Newf("session not found: %s", sessionID)
+	}
+
+	var session VPNSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, errors.Wrapf(err, "decode session %s", sessionID)
+	}
+	return &session, nil
+}
+
+// putSession записывает сессию в bucket
+func putSession(tx *bolt.Tx, session *VPNSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrapf(err, "encode session %s", session.SessionID)
+	}
+	return tx.Bucket(sessionsBucket).Put([]byte(session.SessionID), data)
+}
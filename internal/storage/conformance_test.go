@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeFactory создает пустой store с заданным TTL для conformance тестов
+type storeFactory func(t *testing.T, ttl time.Duration) SessionStore
+
+// Каждая реализация SessionStore должна проходить общий набор тестов
+func TestSessionStoreConformance(t *testing.T) {
+	backends := map[string]storeFactory{
+		BackendMemory: func(t *testing.T, ttl time.Duration) SessionStore {
+			store := NewSessionStore(ttl)
+			t.Cleanup(func() { _ = store.Close() })
+			return store
+		},
+		BackendBolt: func(t *testing.T, ttl time.Duration) SessionStore {
+			store, err := NewBoltSessionStore(filepath.Join(t.TempDir(), "sessions.db"), ttl)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = store.Close() })
+			return store
+		},
+	}
+
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			runConformance(t, newStore)
+		})
+	}
+}
+
+func runConformance(t *testing.T, newStore storeFactory) {
+	t.Run("add validates input", func(t *testing.T) {
+		store := newStore(t, 0)
+
+		assert.Error(t, store.Add(nil))
+		assert.Error(t, store.Add(&VPNSession{Username: "alice"}))
+		assert.Error(t, store.Add(&VPNSession{SessionID: "s1"}))
+		assert.Equal(t, 0, store.Count())
+	})
+
+	t.Run("add and get", func(t *testing.T) {
+		store := newStore(t, 0)
+
+		require.NoError(t, store.Add(&VPNSession{
+			SessionID: "s1",
+			Username:  "alice",
+			ClientIP:  "203.0.113.10",
+			VpnIP:     "10.0.0.2",
+			Metadata:  map[string]string{"device": "laptop"},
+		}))
+
+		got, err := store.Get("s1")
+		require.NoError(t, err)
+		assert.Equal(t, "alice", got.Username)
+		assert.Equal(t, "203.0.113.10", got.ClientIP)
+		assert.Equal(t, "10.0.0.2", got.VpnIP)
+		assert.Equal(t, "laptop", got.Metadata["device"])
+		assert.False(t, got.ConnectedAt.IsZero())
+		assert.False(t, got.LastActivity.IsZero())
+		assert.Nil(t, got.ExpiresAt, "no TTL configured")
+
+		_, err = store.Get("missing")
+		assert.Error(t, err)
+		_, err = store.Get("")
+		assert.Error(t, err)
+	})
+
+	t.Run("add overwrites existing session", func(t *testing.T) {
+		store := newStore(t, 0)
+
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice"}))
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "bob"}))
+
+		got, err := store.Get("s1")
+		require.NoError(t, err)
+		assert.Equal(t, "bob", got.Username)
+		assert.Equal(t, 1, store.Count())
+	})
+
+	t.Run("update", func(t *testing.T) {
+		store := newStore(t, 0)
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice"}))
+
+		require.NoError(t, store.Update("s1", func(s *VPNSession) error {
+			s.VpnIP = "10.0.0.9"
+			return nil
+		}))
+		got, err := store.Get("s1")
+		require.NoError(t, err)
+		assert.Equal(t, "10.0.0.9", got.VpnIP)
+
+		// Errors from the update function are returned
+		err = store.Update("s1", func(*VPNSession) error {
+			return errors.New("boom")
+		})
+		assert.ErrorContains(t, err, "boom")
+
+		assert.Error(t, store.Update("missing", func(*VPNSession) error { return nil }))
+		assert.Error(t, store.Update("", func(*VPNSession) error { return nil }))
+	})
+
+	t.Run("update stats", func(t *testing.T) {
+		store := newStore(t, 0)
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice"}))
+
+		require.NoError(t, store.UpdateStats("s1", 100, 200))
+		got, err := store.Get("s1")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(100), got.BytesIn)
+		assert.Equal(t, uint64(200), got.BytesOut)
+
+		assert.Error(t, store.UpdateStats("missing", 1, 1))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		store := newStore(t, 0)
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice"}))
+
+		require.NoError(t, store.Remove("s1"))
+		assert.False(t, store.Exists("s1"))
+		assert.Error(t, store.Remove("s1"))
+		assert.Error(t, store.Remove(""))
+	})
+
+	t.Run("list and count by username", func(t *testing.T) {
+		store := newStore(t, 0)
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice", BytesIn: 10, BytesOut: 1}))
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s2", Username: "alice", BytesIn: 20, BytesOut: 2}))
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s3", Username: "bob", BytesIn: 30, BytesOut: 3}))
+
+		assert.Len(t, store.List(), 3)
+		assert.Equal(t, 3, store.Count())
+		assert.Len(t, store.ListByUsername("alice"), 2)
+		assert.Equal(t, 1, store.CountByUsername("bob"))
+		assert.Empty(t, store.ListByUsername("carol"))
+		assert.Empty(t, store.ListByUsername(""))
+
+		stats := store.GetStats()
+		assert.Equal(t, 3, stats.TotalSessions)
+		assert.Equal(t, uint64(60), stats.TotalBytesIn)
+		assert.Equal(t, uint64(6), stats.TotalBytesOut)
+		assert.Equal(t, map[string]int{"alice": 2, "bob": 1}, stats.UserSessions)
+	})
+
+	t.Run("remove by username and clear", func(t *testing.T) {
+		store := newStore(t, 0)
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice"}))
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s2", Username: "alice"}))
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s3", Username: "bob"}))
+
+		assert.Equal(t, 2, store.RemoveByUsername("alice"))
+		assert.Equal(t, 0, store.RemoveByUsername("alice"))
+		assert.Equal(t, 0, store.RemoveByUsername(""))
+		assert.Equal(t, 1, store.Count())
+
+		store.Clear()
+		assert.Equal(t, 0, store.Count())
+
+		// The store stays usable after Clear
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s4", Username: "carol"}))
+		assert.Equal(t, 1, store.Count())
+	})
+
+	t.Run("get or create", func(t *testing.T) {
+		store := newStore(t, 0)
+
+		got, created, err := store.GetOrCreate(&VPNSession{SessionID: "s1", Username: "alice"})
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, "alice", got.Username)
+
+		got, created, err = store.GetOrCreate(&VPNSession{SessionID: "s1", Username: "bob"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "alice", got.Username, "existing session is returned")
+
+		_, _, err = store.GetOrCreate(nil)
+		assert.Error(t, err)
+		_, _, err = store.GetOrCreate(&VPNSession{Username: "alice"})
+		assert.Error(t, err)
+		_, _, err = store.GetOrCreate(&VPNSession{SessionID: "s2"})
+		assert.Error(t, err)
+	})
+
+	t.Run("ttl expiry", func(t *testing.T) {
+		store := newStore(t, time.Hour)
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice"}))
+
+		got, err := store.Get("s1")
+		require.NoError(t, err)
+		require.NotNil(t, got.ExpiresAt)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *got.ExpiresAt, time.Minute)
+
+		// Update extends the expiry
+		initial := *got.ExpiresAt
+		time.Sleep(10 * time.Millisecond)
+		require.NoError(t, store.UpdateStats("s1", 1, 1))
+		got, err = store.Get("s1")
+		require.NoError(t, err)
+		assert.True(t, got.ExpiresAt.After(initial))
+	})
+
+	t.Run("expired sessions are hidden and swept", func(t *testing.T) {
+		store := newStore(t, 100*time.Millisecond)
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice"}))
+		assert.True(t, store.Exists("s1"))
+
+		time.Sleep(120 * time.Millisecond)
+		assert.False(t, store.Exists("s1"))
+		assert.Empty(t, store.List())
+		assert.Equal(t, 0, store.GetStats().TotalSessions)
+
+		// An expired session is replaced by GetOrCreate
+		_, created, err := store.GetOrCreate(&VPNSession{SessionID: "s1", Username: "bob"})
+		require.NoError(t, err)
+		assert.True(t, created)
+
+		// The sweep removes expired sessions entirely
+		require.NoError(t, store.Add(&VPNSession{SessionID: "s2", Username: "carol"}))
+		assert.Eventually(t, func() bool {
+			return store.Remove("s2") != nil
+		}, time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		store := newStore(t, 0)
+
+		var wg sync.WaitGroup
+		for i := range 10 {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := range 10 {
+					id := fmt.Sprintf("s-%d-%d", i, j)
+					assert.NoError(t, store.Add(&VPNSession{SessionID: id, Username: fmt.Sprintf("user-%d", i)}))
+					assert.NoError(t, store.UpdateStats(id, uint64(j), uint64(j)))
+					_ = store.List()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, 100, store.Count())
+		assert.Equal(t, 10, store.CountByUsername("user-3"))
+	})
+}
+
+func TestBoltSessionStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+
+	store, err := NewBoltSessionStore(path, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Add(&VPNSession{SessionID: "s1", Username: "alice", BytesIn: 42}))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewBoltSessionStore(path, 0)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	got, err := reopened.Get("s1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", got.Username)
+	assert.Equal(t, uint64(42), got.BytesIn)
+}
+
+func TestOpenSessionStore(t *testing.T) {
+	store, err := OpenSessionStore(BackendMemory, "", 0)
+	require.NoError(t, err)
+	assert.IsType(t, &MemorySessionStore{}, store)
+	require.NoError(t, store.Close())
+
+	store, err = OpenSessionStore(BackendBolt, filepath.Join(t.TempDir(), "s.db"), 0)
+	require.NoError(t, err)
+	assert.IsType(t, &BoltSessionStore{}, store)
+	require.NoError(t, store.Close())
+
+	_, err = OpenSessionStore(BackendBolt, "", 0)
+	assert.Error(t, err)
+
+	_, err = OpenSessionStore("redis", "", 0)
+	assert.Error(t, err)
+}
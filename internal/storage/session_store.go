@@ -23,30 +23,42 @@ type VPNSession struct {
 	ExpiresAt    *time.Time        // Время истечения (для TTL)
 }
 
-// SessionStore хранит активные VPN сессии в памяти
-type SessionStore struct {
+// MemorySessionStore хранит активные VPN сессии в памяти
+type MemorySessionStore struct {
 	sessions map[string]*VPNSession // sessionID -> session
 	mu       sync.RWMutex           // Mutex для thread-safe доступа
 	ttl      time.Duration          // TTL для сессий (0 = без TTL)
+	cancel   context.CancelFunc     // Остановка cleanup goroutine
 }
 
+// Ensure MemorySessionStore implements SessionStore
+var _ SessionStore = (*MemorySessionStore)(nil)
+
 // NewSessionStore создает новый in-memory session store
-func NewSessionStore(ttl time.Duration) *SessionStore {
-	store := &SessionStore{
+func NewSessionStore(ttl time.Duration) *MemorySessionStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	store := &MemorySessionStore{
 		sessions: make(map[string]*VPNSession),
 		ttl:      ttl,
+		cancel:   cancel,
 	}
 
 	// Запустить cleanup goroutine если TTL установлен
 	if ttl > 0 {
-		go store.cleanupExpiredSessions(context.Background())
+		go store.cleanupExpiredSessions(ctx)
 	}
 
 	return store
 }
 
+// Close останавливает cleanup goroutine
+func (s *MemorySessionStore) Close() error {
+	s.cancel()
+	return nil
+}
+
 // Add добавляет новую сессию в store
-func (s *SessionStore) Add(session *VPNSession) error {
+func (s *MemorySessionStore) Add(session *VPNSession) error {
 	if session == nil {
 		return errors.New("session cannot be nil")
 	}
@@ -89,7 +101,7 @@ func (s *SessionStore) Add(session *VPNSession) error {
 }
 
 // Get возвращает сессию по ID
-func (s *SessionStore) Get(sessionID string) (*VPNSession, error) {
+func (s *MemorySessionStore) Get(sessionID string) (*VPNSession, error) {
 	if sessionID == "" {
 		return nil, errors.New("session ID cannot be empty")
 	}
@@ -111,7 +123,7 @@ func (s *SessionStore) Get(sessionID string) (*VPNSession, error) {
 }
 
 // Update обновляет существующую сессию
-func (s *SessionStore) Update(sessionID string, updateFn func(*VPNSession) error) error {
+func (s *MemorySessionStore) Update(sessionID string, updateFn func(*VPNSession) error) error {
 	if sessionID == "" {
 		return errors.New("session ID cannot be empty")
 	}
@@ -142,7 +154,7 @@ func (s *SessionStore) Update(sessionID string, updateFn func(*VPNSession) error
 }
 
 // Remove удаляет сессию по ID
-func (s *SessionStore) Remove(sessionID string) error {
+func (s *MemorySessionStore) Remove(sessionID string) error {
 	if sessionID == "" {
 		return errors.New("session ID cannot be empty")
 	}
@@ -160,7 +172,7 @@ func (s *SessionStore) Remove(sessionID string) error {
 }
 
 // List возвращает все активные сессии
-func (s *SessionStore) List() []*VPNSession {
+func (s *MemorySessionStore) List() []*VPNSession {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -179,7 +191,7 @@ func (s *SessionStore) List() []*VPNSession {
 }
 
 // ListByUsername возвращает все сессии для указанного пользователя
-func (s *SessionStore) ListByUsername(username string) []*VPNSession {
+func (s *MemorySessionStore) ListByUsername(username string) []*VPNSession {
 	if username == "" {
 		return []*VPNSession{}
 	}
@@ -205,7 +217,7 @@ func (s *SessionStore) ListByUsername(username string) []*VPNSession {
 }
 
 // Count возвращает количество активных сессий
-func (s *SessionStore) Count() int {
+func (s *MemorySessionStore) Count() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -224,12 +236,12 @@ func (s *SessionStore) Count() int {
 }
 
 // CountByUsername возвращает количество сессий для пользователя
-func (s *SessionStore) CountByUsername(username string) int {
+func (s *MemorySessionStore) CountByUsername(username string) int {
 	return len(s.ListByUsername(username))
 }
 
 // Clear удаляет все сессии
-func (s *SessionStore) Clear() {
+func (s *MemorySessionStore) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -237,7 +249,7 @@ func (s *SessionStore) Clear() {
 }
 
 // RemoveByUsername удаляет все сессии пользователя
-func (s *SessionStore) RemoveByUsername(username string) int {
+func (s *MemorySessionStore) RemoveByUsername(username string) int {
 	if username == "" {
 		return 0
 	}
@@ -257,7 +269,7 @@ func (s *SessionStore) RemoveByUsername(username string) int {
 }
 
 // UpdateStats обновляет статистику сессии (bytes in/out)
-func (s *SessionStore) UpdateStats(sessionID string, bytesIn, bytesOut uint64) error {
+func (s *MemorySessionStore) UpdateStats(sessionID string, bytesIn, bytesOut uint64) error {
 	return s.Update(sessionID, func(session *VPNSession) error {
 		session.BytesIn = bytesIn
 		session.BytesOut = bytesOut
@@ -266,7 +278,7 @@ func (s *SessionStore) UpdateStats(sessionID string, bytesIn, bytesOut uint64) e
 }
 
 // cleanupExpiredSessions периодически удаляет истёкшие сессии
-func (s *SessionStore) cleanupExpiredSessions(ctx context.Context) {
+func (s *MemorySessionStore) cleanupExpiredSessions(ctx context.Context) {
 	ticker := time.NewTicker(s.ttl / 2) // Cleanup каждые ttl/2
 	defer ticker.Stop()
 
@@ -281,7 +293,7 @@ func (s *SessionStore) cleanupExpiredSessions(ctx context.Context) {
 }
 
 // removeExpiredSessions удаляет истёкшие сессии
-func (s *SessionStore) removeExpiredSessions() {
+func (s *MemorySessionStore) removeExpiredSessions() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -294,7 +306,7 @@ func (s *SessionStore) removeExpiredSessions() {
 }
 
 // GetStats возвращает статистику по всем сессиям
-func (s *SessionStore) GetStats() SessionStats {
+func (s *MemorySessionStore) GetStats() SessionStats {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -322,6 +334,11 @@ func (s *SessionStore) GetStats() SessionStats {
 	return stats
 }
 
+// expired проверяет истечение TTL сессии
+func (v *VPNSession) expired(now time.Time) bool {
+	return v.ExpiresAt != nil && now.After(*v.ExpiresAt)
+}
+
 // SessionStats содержит статистику по сессиям
 type SessionStats struct {
 	TotalSessions  int            // Общее количество сессий
@@ -331,13 +348,13 @@ type SessionStats struct {
 }
 
 // Exists проверяет существование сессии
-func (s *SessionStore) Exists(sessionID string) bool {
+func (s *MemorySessionStore) Exists(sessionID string) bool {
 	_, err := s.Get(sessionID)
 	return err == nil
 }
 
 // GetOrCreate возвращает существующую сессию или создает новую
-func (s *SessionStore) GetOrCreate(session *VPNSession) (*VPNSession, bool, error) {
+func (s *MemorySessionStore) GetOrCreate(session *VPNSession) (*VPNSession, bool, error) {
 	if session == nil || session.SessionID == "" {
 		return nil, false, errors.New("invalid session")
 	}
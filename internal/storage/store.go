@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/cockroachdb/errors"
+)
+
+// SessionStore хранит активные VPN сессии.
+//
+// Все реализации имеют одинаковую семантику (см. conformance тесты):
+// TTL продлевается при Add/Update, истёкшие сессии не возвращаются
+// Get/List/Count и периодически удаляются фоновым sweep.
+type SessionStore interface {
+	// Add добавляет сессию (перезаписывает существующую с тем же ID)
+	Add(session *VPNSession) error
+	// Get возвращает сессию по ID
+	Get(sessionID string) (*VPNSession, error)
+	// Update изменяет сессию через updateFn и продлевает TTL
+	Update(sessionID string, updateFn func(*VPNSession) error) error
+	// Remove удаляет сессию по ID
+	Remove(sessionID string) error
+	// List возвращает все активные сессии
+	List() []*VPNSession
+	// ListByUsername возвращает активные сессии пользователя
+	ListByUsername(username string) []*VPNSession
+	// Count возвращает количество активных сессий
+	Count() int
+	// CountByUsername возвращает количество сессий пользователя
+	CountByUsername(username string) int
+	// Clear удаляет все сессии
+	Clear()
+	// RemoveByUsername удаляет все сессии пользователя и возвращает их количество
+	RemoveByUsername(username string) int
+	// UpdateStats обновляет статистику сессии (bytes in/out)
+	UpdateStats(sessionID string, bytesIn, bytesOut uint64) error
+	// GetStats возвращает статистику по всем сессиям
+	GetStats() SessionStats
+	// Exists проверяет существование сессии
+	Exists(sessionID string) bool
+	// GetOrCreate возвращает существующую сессию или создает новую
+	GetOrCreate(session *VPNSession) (*VPNSession, bool, error)
+	// Close освобождает ресурсы store
+	Close() error
+}
+
+// Поддерживаемые backend'ы session store
+const (
+	BackendMemory = "memory"
+	BackendBolt   = "bolt"
+)
+
+// OpenSessionStore создает session store выбранного backend'а.
+// path используется только on-disk backend'ами.
+func OpenSessionStore(backend, path string, ttl time.Duration) (SessionStore, error) {
+	switch backend {
+	case "", BackendMemory:
+		return NewSessionStore(ttl), nil
+	case BackendBolt:
+		return NewBoltSessionStore(path, ttl)
+	default:
+		return nil, errors.Newf("unknown session store backend: %s", backend)
+	}
+}
@@ -0,0 +1,81 @@
+package telemetry
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HealthCheck сообщает состояние компонента агента для /health endpoint:
+// набор проверок и признак того, что компонент исправен.
+type HealthCheck func() (checks map[string]string, healthy bool)
+
+// healthRegistry хранит проверки, которые выполняет /health endpoint.
+type healthRegistry struct {
+	mu     sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+// defaultHealth — проверки /health endpoint Prometheus сервера.
+var defaultHealth = &healthRegistry{}
+
+// RegisterHealthCheck добавляет проверку компонента name в /health endpoint.
+// Повторная регистрация с тем же именем заменяет проверку.
+func RegisterHealthCheck(name string, check HealthCheck) {
+	defaultHealth.register(name, check)
+}
+
+func (h *healthRegistry) register(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.checks == nil {
+		h.checks = make(map[string]HealthCheck)
+	}
+	h.checks[name] = check
+}
+
+// ServeHTTP выполняет все проверки. Ответ — "OK" или "DEGRADED" со
+// списком проверок; при неисправном компоненте возвращается 503.
+func (h *healthRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	healthy := true
+	results := make(map[string]string)
+	for _, check := range h.checks {
+		checks, ok := check()
+		if !ok {
+			healthy = false
+		}
+		for name, result := range checks {
+			results[name] = result
+		}
+	}
+
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body strings.Builder
+	if healthy {
+		body.WriteString("OK")
+	} else {
+		body.WriteString("DEGRADED")
+	}
+	for _, name := range names {
+		fmt.Fprintf(&body, "\n%s: %s", name, results[name])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_, _ = w.Write([]byte(body.String()))
+}
@@ -0,0 +1,64 @@
+package telemetry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthRegistry(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]HealthCheck
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "no checks",
+			wantStatus: http.StatusOK,
+			wantBody:   "OK",
+		},
+		{
+			name: "healthy component",
+			checks: map[string]HealthCheck{
+				"portal": func() (map[string]string, bool) {
+					return map[string]string{"portal": "ok", "portal_breaker:a:9090": "closed"}, true
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "OK\nportal: ok\nportal_breaker:a:9090: closed",
+		},
+		{
+			name: "degraded component",
+			checks: map[string]HealthCheck{
+				"portal": func() (map[string]string, bool) {
+					return map[string]string{"portal": "degraded", "portal_breaker:a:9090": "open"}, false
+				},
+				"ipc": func() (map[string]string, bool) {
+					return map[string]string{"ipc": "ok"}, true
+				},
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "DEGRADED\nipc: ok\nportal: degraded\nportal_breaker:a:9090: open",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := &healthRegistry{}
+			for name, check := range tt.checks {
+				registry.register(name, check)
+			}
+
+			rec := httptest.NewRecorder()
+			registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
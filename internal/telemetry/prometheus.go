@@ -49,10 +49,7 @@ func NewPrometheusServer(ctx context.Context, cfg config.TelemetryConfig, res *r
 	// Prometheus exporter implements prometheus.Collector interface
 	// Use promhttp.Handler() для HTTP endpoint
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK"))
-	})
+	mux.Handle("/health", defaultHealth)
 
 	// Создаем HTTP сервер
 	server := &http.Server{
@@ -0,0 +1,484 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// SinkConfig configures a single webhook receiver
+type SinkConfig struct {
+	Name   string
+	URL    string
+	Secret string
+	// Events are path.Match patterns of event types to deliver
+	// (e.g. "session.*"); empty = all events
+	Events  []string
+	Headers map[string]string
+}
+
+// Config configures the dispatcher
+type Config struct {
+	Sinks []SinkConfig
+	// MaxAttempts is the number of delivery attempts per event and sink
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds a single HTTP request
+	Timeout time.Duration
+	// DeadLetterFile receives events that could not be delivered, one
+	// JSON object per line (empty = only log them)
+	DeadLetterFile string
+	// QueueSize bounds events waiting per sink; events beyond it are
+	// dropped
+	QueueSize int
+
+	// Client overrides the HTTP client (tests)
+	Client *http.Client
+
+	Logger *slog.Logger
+	Meter  metric.Meter
+}
+
+// NewConfig builds the dispatcher configuration from the agent's webhooks
+// settings
+func NewConfig(cfg *config.WebhooksConfig, logger *slog.Logger, meter metric.Meter) *Config {
+	sinks := make([]SinkConfig, 0, len(cfg.Sinks))
+	for _, sink := range cfg.Sinks {
+		sinks = append(sinks, SinkConfig{
+			Name:    sink.Name,
+			URL:     sink.URL,
+			Secret:  sink.Secret,
+			Events:  sink.Events,
+			Headers: sink.Headers,
+		})
+	}
+
+	return &Config{
+		Sinks:          sinks,
+		MaxAttempts:    cfg.MaxAttempts,
+		InitialBackoff: cfg.InitialBackoff,
+		MaxBackoff:     cfg.MaxBackoff,
+		Timeout:        cfg.Timeout,
+		DeadLetterFile: cfg.DeadLetterFile,
+		QueueSize:      cfg.QueueSize,
+		Logger:         logger,
+		Meter:          meter,
+	}
+}
+
+// delivery is a queued event for one sink
+type delivery struct {
+	event Event
+	body  []byte
+}
+
+// sink is a receiver with its own queue and worker
+type sink struct {
+	SinkConfig
+	queue chan delivery
+}
+
+// deadLetter is a line of the dead-letter file
+type deadLetter struct {
+	Sink     string          `json:"sink"`
+	URL      string          `json:"url"`
+	Event    json.RawMessage `json:"event"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// Dispatcher fans events out to webhook sinks.
+//
+// Each sink has its own queue and worker, so a slow or failing receiver
+// does not delay the others. Publish never blocks the caller.
+type Dispatcher struct {
+	sinks          []*sink
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	deadLetterFile string
+	client         *http.Client
+	logger         *slog.Logger
+
+	mu     sync.RWMutex
+	closed bool
+
+	deadLetterMu sync.Mutex
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Metrics
+	deliveriesTotal metric.Int64Counter
+	retriesTotal    metric.Int64Counter
+}
+
+// NewDispatcher creates a webhook dispatcher
+func NewDispatcher(cfg *Config) (*Dispatcher, error) {
+	if len(cfg.Sinks) == 0 {
+		return nil, fmt.Errorf("at least one sink is required")
+	}
+	if cfg.Logger == nil {
+		return nil, fmt.Errorf("logger is required")
+	}
+	if cfg.Meter == nil {
+		return nil, fmt.Errorf("meter is required")
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	sinks := make([]*sink, 0, len(cfg.Sinks))
+	for _, sc := range cfg.Sinks {
+		if sc.URL == "" {
+			return nil, fmt.Errorf("sink %q: url is required", sc.Name)
+		}
+		if sc.Secret == "" {
+			return nil, fmt.Errorf("sink %q: secret is required", sc.Name)
+		}
+		for _, pattern := range sc.Events {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("sink %q: invalid event pattern %q: %w", sc.Name, pattern, err)
+			}
+		}
+		if sc.Name == "" {
+			sc.Name = sc.URL
+		}
+		sinks = append(sinks, &sink{SinkConfig: sc, queue: make(chan delivery, cfg.QueueSize)})
+	}
+
+	deliveriesTotal, err := cfg.Meter.Int64Counter(
+		"webhook.deliveries.total",
+		metric.WithDescription("Total number of webhook deliveries by sink and result"),
+		metric.WithUnit("{delivery}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create deliveries counter: %w", err)
+	}
+
+	retriesTotal, err := cfg.Meter.Int64Counter(
+		"webhook.retries.total",
+		metric.WithDescription("Total number of webhook delivery retries by sink"),
+		metric.WithUnit("{retry}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create retries counter: %w", err)
+	}
+
+	return &Dispatcher{
+		sinks:           sinks,
+		maxAttempts:     cfg.MaxAttempts,
+		initialBackoff:  cfg.InitialBackoff,
+		maxBackoff:      cfg.MaxBackoff,
+		deadLetterFile:  cfg.DeadLetterFile,
+		client:          client,
+		logger:          cfg.Logger,
+		deliveriesTotal: deliveriesTotal,
+		retriesTotal:    retriesTotal,
+	}, nil
+}
+
+// Start launches one delivery worker per sink
+func (d *Dispatcher) Start(ctx context.Context) {
+	// Deliveries outlive the caller's cancellation until Stop gives up
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	d.cancel = cancel
+
+	for _, s := range d.sinks {
+		d.wg.Add(1)
+		go d.run(runCtx, s)
+	}
+}
+
+// Stop stops accepting events and waits for queued ones to be delivered.
+// When ctx expires first, pending deliveries are moved to the dead-letter
+// file.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for _, s := range d.sinks {
+		close(s.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		d.stopWorkers()
+		return nil
+	case <-ctx.Done():
+		// Abort in-flight requests and backoffs; the workers dead-letter
+		// what is left and exit promptly
+		d.stopWorkers()
+		<-done
+		return fmt.Errorf("webhook dispatcher shutdown: %w", ctx.Err())
+	}
+}
+
+// stopWorkers cancels the workers' context
+func (d *Dispatcher) stopWorkers() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// Publish queues an event for every sink whose filter matches its type
+func (d *Dispatcher) Publish(ctx context.Context, eventType string, data any) {
+	event := Event{
+		ID:   newEventID(),
+		Type: eventType,
+		Time: time.Now().UTC(),
+		Data: data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.ErrorContext(ctx, "failed to encode webhook event",
+			slog.String("event", eventType),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+
+	for _, s := range d.sinks {
+		if !s.accepts(eventType) {
+			continue
+		}
+		select {
+		case s.queue <- delivery{event: event, body: body}:
+		default:
+			d.record(ctx, s, "dropped")
+			d.logger.WarnContext(ctx, "webhook queue full, dropping event",
+				slog.String("sink", s.Name),
+				slog.String("event", eventType),
+				slog.String("event_id", event.ID),
+			)
+		}
+	}
+}
+
+// accepts reports whether the sink's filter matches an event type
+func (s *sink) accepts(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, pattern := range s.Events {
+		if ok, _ := path.Match(pattern, eventType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// run delivers queued events until the queue is closed
+func (d *Dispatcher) run(ctx context.Context, s *sink) {
+	defer d.wg.Done()
+
+	for dl := range s.queue {
+		attempts, err := d.deliver(ctx, s, dl)
+		if err == nil {
+			d.record(ctx, s, "delivered")
+			continue
+		}
+
+		d.record(ctx, s, "dead_letter")
+		d.logger.ErrorContext(ctx, "webhook delivery failed",
+			slog.String("sink", s.Name),
+			slog.String("event", dl.event.Type),
+			slog.String("event_id", dl.event.ID),
+			slog.Int("attempts", attempts),
+			slog.String("error", err.Error()),
+		)
+		d.writeDeadLetter(ctx, s, dl, attempts, err)
+	}
+}
+
+// deliver posts an event, retrying transient failures with exponential
+// backoff. It returns the number of attempts made.
+func (d *Dispatcher) deliver(ctx context.Context, s *sink, dl delivery) (int, error) {
+	var lastErr error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return attempt - 1, fmt.Errorf("%w (last error: %v)", err, lastErr)
+		}
+
+		retryAfter, retry, err := d.post(ctx, s, dl)
+		if err == nil {
+			return attempt, nil
+		}
+		lastErr = err
+		if !retry || attempt == d.maxAttempts {
+			return attempt, err
+		}
+
+		wait := d.backoff(attempt)
+		if retryAfter > wait {
+			wait = min(retryAfter, d.maxBackoff)
+		}
+		d.retriesTotal.Add(ctx, 1, metric.WithAttributes(attribute.String("sink", s.Name)))
+		d.logger.WarnContext(ctx, "webhook delivery failed, retrying",
+			slog.String("sink", s.Name),
+			slog.String("event_id", dl.event.ID),
+			slog.Int("attempt", attempt),
+			slog.Duration("backoff", wait),
+			slog.String("error", err.Error()),
+		)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, fmt.Errorf("%w (last error: %v)", ctx.Err(), lastErr)
+		}
+	}
+
+	return d.maxAttempts, lastErr
+}
+
+// post performs a single delivery. retry reports whether the failure is
+// transient (network error, 429 or 5xx); retryAfter is the receiver's
+// Retry-After hint, if any.
+func (d *Dispatcher) post(ctx context.Context, s *sink, dl delivery) (retryAfter time.Duration, retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return 0, false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ocserv-agent-webhook")
+	req.Header.Set(HeaderEvent, dl.event.Type)
+	req.Header.Set(HeaderID, dl.event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign([]byte(s.Secret), timestamp, dl.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, false, nil
+	}
+
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		return retryAfter, true, err
+	}
+	return 0, false, err
+}
+
+// backoff returns the jittered wait after the given failed attempt
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	wait := d.initialBackoff
+	for i := 1; i < attempt && wait < d.maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, d.maxBackoff)
+
+	// Full wait minus up to half of it, so sinks recovering from an
+	// outage are not hit by every agent at once
+	return wait - rand.N(wait/2+1) // #nosec G404 - jitter does not need a CSPRNG
+}
+
+// writeDeadLetter appends an undeliverable event to the dead-letter file
+func (d *Dispatcher) writeDeadLetter(ctx context.Context, s *sink, dl delivery, attempts int, cause error) {
+	if d.deadLetterFile == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetter{
+		Sink:     s.Name,
+		URL:      s.URL,
+		Event:    dl.body,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		FailedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	d.deadLetterMu.Lock()
+	defer d.deadLetterMu.Unlock()
+
+	f, err := os.OpenFile(d.deadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err == nil {
+		_, err = f.Write(line)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		d.logger.ErrorContext(ctx, "failed to write webhook dead letter",
+			slog.String("file", d.deadLetterFile),
+			slog.String("event_id", dl.event.ID),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// record counts a delivery outcome
+func (d *Dispatcher) record(ctx context.Context, s *sink, result string) {
+	d.deliveriesTotal.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("sink", s.Name),
+		attribute.String("result", result),
+	))
+}
@@ -0,0 +1,290 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dantte-lp/ocserv-agent/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+const testSecret = "webhook-secret"
+
+// receiver is an httptest webhook endpoint that answers with a scripted
+// sequence of status codes (200 once the script runs out)
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		r.mu.Lock()
+		r.received = append(r.received, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.received)
+}
+
+func newTestDispatcher(t *testing.T, cfg Config) *Dispatcher {
+	t.Helper()
+
+	if cfg.InitialBackoff == 0 {
+		cfg.InitialBackoff = time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 5 * time.Millisecond
+	}
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg.Meter = noop.NewMeterProvider().Meter("test")
+
+	d, err := NewDispatcher(&cfg)
+	require.NoError(t, err)
+	d.Start(context.Background())
+
+	return d
+}
+
+func stopDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, d.Stop(ctx))
+}
+
+func TestDispatcherSignsDeliveries(t *testing.T) {
+	r := newReceiver(t)
+	d := newTestDispatcher(t, Config{Sinks: []SinkConfig{{
+		Name:    "siem",
+		URL:     r.URL,
+		Secret:  testSecret,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}}})
+
+	d.Publish(context.Background(), EventSessionConnected, SessionData{SessionID: "42", Username: "alice"})
+	stopDispatcher(t, d)
+
+	require.Equal(t, 1, r.count())
+	req, body := r.received[0], r.bodies[0]
+
+	assert.Equal(t, EventSessionConnected, req.Header.Get(HeaderEvent))
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.True(t, Verify([]byte(testSecret), req.Header.Get(HeaderTimestamp), body, req.Header.Get(HeaderSignature)))
+	assert.False(t, Verify([]byte("other"), req.Header.Get(HeaderTimestamp), body, req.Header.Get(HeaderSignature)))
+
+	var event struct {
+		ID   string      `json:"id"`
+		Type string      `json:"type"`
+		Data SessionData `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &event))
+	assert.Equal(t, req.Header.Get(HeaderID), event.ID)
+	assert.Equal(t, EventSessionConnected, event.Type)
+	assert.Equal(t, "alice", event.Data.Username)
+}
+
+func TestDispatcherRetriesTransientFailures(t *testing.T) {
+	r := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	d := newTestDispatcher(t, Config{
+		Sinks:          []SinkConfig{{Name: "siem", URL: r.URL, Secret: testSecret}},
+		MaxAttempts:    3,
+		DeadLetterFile: deadLetters,
+	})
+
+	d.Publish(context.Background(), EventAdminReload, AdminData{Action: "reload"})
+	stopDispatcher(t, d)
+
+	assert.Equal(t, 3, r.count())
+	// All attempts carry the same event ID so receivers can deduplicate
+	assert.Equal(t, r.received[0].Header.Get(HeaderID), r.received[2].Header.Get(HeaderID))
+	assert.NoFileExists(t, deadLetters)
+}
+
+func TestDispatcherDeadLetters(t *testing.T) {
+	exhausted := newReceiver(t, 500, 500, 500)
+	rejected := newReceiver(t, http.StatusBadRequest)
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	d := newTestDispatcher(t, Config{
+		Sinks: []SinkConfig{
+			{Name: "exhausted", URL: exhausted.URL, Secret: testSecret},
+			{Name: "rejected", URL: rejected.URL, Secret: testSecret},
+		},
+		MaxAttempts:    2,
+		DeadLetterFile: deadLetters,
+	})
+
+	d.Publish(context.Background(), EventSessionDisconnected, SessionData{SessionID: "1", Username: "bob"})
+	stopDispatcher(t, d)
+
+	assert.Equal(t, 2, exhausted.count())
+	assert.Equal(t, 1, rejected.count()) // 4xx is not retried
+
+	f, err := os.Open(deadLetters)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+
+	attempts := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var dl deadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &dl))
+		attempts[dl.Sink] = dl.Attempts
+		assert.Contains(t, string(dl.Event), `"username":"bob"`)
+		assert.NotEmpty(t, dl.Error)
+	}
+	assert.Equal(t, map[string]int{"exhausted": 2, "rejected": 1}, attempts)
+}
+
+func TestDispatcherEventFilters(t *testing.T) {
+	sessions := newReceiver(t)
+	admin := newReceiver(t)
+	all := newReceiver(t)
+	d := newTestDispatcher(t, Config{Sinks: []SinkConfig{
+		{Name: "sessions", URL: sessions.URL, Secret: testSecret, Events: []string{"session.*"}},
+		{Name: "admin", URL: admin.URL, Secret: testSecret, Events: []string{"admin.disconnect", "admin.reload"}},
+		{Name: "all", URL: all.URL, Secret: testSecret},
+	}})
+
+	ctx := context.Background()
+	d.Publish(ctx, EventSessionConnected, SessionData{})
+	d.Publish(ctx, EventSessionIdle, SessionData{})
+	d.Publish(ctx, EventAdminDisconnect, AdminData{Action: "disconnect"})
+	d.Publish(ctx, EventAdminConfigUpdate, AdminData{Action: "config_update"})
+	stopDispatcher(t, d)
+
+	assert.Equal(t, 2, sessions.count())
+	assert.Equal(t, 1, admin.count())
+	assert.Equal(t, 4, all.count())
+}
+
+func TestDispatcherStopDeadLettersPending(t *testing.T) {
+	r := newReceiver(t, 500, 500, 500, 500, 500)
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+	d := newTestDispatcher(t, Config{
+		Sinks:          []SinkConfig{{Name: "down", URL: r.URL, Secret: testSecret}},
+		MaxAttempts:    5,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+		DeadLetterFile: deadLetters,
+	})
+
+	d.Publish(context.Background(), EventSessionConnected, SessionData{SessionID: "1"})
+	d.Publish(context.Background(), EventSessionConnected, SessionData{SessionID: "2"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, d.Stop(ctx))
+
+	data, err := os.ReadFile(deadLetters)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(data, []byte("\n")))
+
+	// Publishing after Stop is a no-op
+	d.Publish(context.Background(), EventSessionConnected, SessionData{})
+}
+
+func TestNewDispatcherValidation(t *testing.T) {
+	logger := slog.Default()
+	meter := noop.NewMeterProvider().Meter("test")
+
+	_, err := NewDispatcher(&Config{Logger: logger, Meter: meter})
+	assert.Error(t, err)
+
+	_, err = NewDispatcher(&Config{Sinks: []SinkConfig{{URL: "http://x"}}, Logger: logger, Meter: meter})
+	assert.Error(t, err, "secret is required")
+
+	_, err = NewDispatcher(&Config{
+		Sinks:  []SinkConfig{{URL: "http://x", Secret: "s", Events: []string{"session.["}}},
+		Logger: logger,
+		Meter:  meter,
+	})
+	assert.Error(t, err, "invalid pattern")
+}
+
+func TestNewConfig(t *testing.T) {
+	logger := slog.Default()
+	meter := noop.NewMeterProvider().Meter("test")
+
+	cfg := NewConfig(&config.WebhooksConfig{
+		Sinks: []config.WebhookSinkConfig{{
+			Name:    "siem",
+			URL:     "https://siem.example.com/hook",
+			Secret:  testSecret,
+			Events:  []string{"session.*"},
+			Headers: map[string]string{"X-Tenant": "acme"},
+		}},
+		MaxAttempts:    3,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		Timeout:        5 * time.Second,
+		DeadLetterFile: "/var/lib/ocserv-agent/webhooks.dead",
+		QueueSize:      64,
+	}, logger, meter)
+
+	assert.Equal(t, []SinkConfig{{
+		Name:    "siem",
+		URL:     "https://siem.example.com/hook",
+		Secret:  testSecret,
+		Events:  []string{"session.*"},
+		Headers: map[string]string{"X-Tenant": "acme"},
+	}}, cfg.Sinks)
+	assert.Equal(t, 3, cfg.MaxAttempts)
+	assert.Equal(t, time.Second, cfg.InitialBackoff)
+	assert.Equal(t, time.Minute, cfg.MaxBackoff)
+	assert.Equal(t, 5*time.Second, cfg.Timeout)
+	assert.Equal(t, "/var/lib/ocserv-agent/webhooks.dead", cfg.DeadLetterFile)
+	assert.Equal(t, 64, cfg.QueueSize)
+	assert.Same(t, logger, cfg.Logger)
+	assert.Equal(t, meter, cfg.Meter)
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	d := &Dispatcher{initialBackoff: time.Second, maxBackoff: 10 * time.Second}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		wait := d.backoff(attempt)
+		assert.LessOrEqual(t, wait, 10*time.Second)
+		assert.GreaterOrEqual(t, wait, 500*time.Millisecond)
+	}
+	assert.GreaterOrEqual(t, d.backoff(8), 5*time.Second)
+}
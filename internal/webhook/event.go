@@ -0,0 +1,106 @@
+// Package webhook delivers session and admin events to HTTP sinks.
+//
+// Every delivery is a JSON POST signed with HMAC-SHA256 over the
+// timestamp and body, so receivers can authenticate the agent and reject
+// replays. Failed deliveries are retried with exponential backoff and end
+// up in a dead-letter file once the retries are exhausted.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Event types
+const (
+	EventSessionConnected    = "session.connected"
+	EventSessionDisconnected = "session.disconnected"
+	EventSessionIdle         = "session.idle"
+	// EventSessionQuota is sent when the portal disconnects a session
+	// because of a quota or another portal-side limit
+	EventSessionQuota = "session.quota"
+	// EventSessionRevoked is sent when periodic revalidation finds a
+	// session revoked by the portal
+	EventSessionRevoked = "session.revoked"
+	// EventSessionScheduleWarning is sent once per session when its access
+	// window closes within the configured grace period
+	EventSessionScheduleWarning = "session.schedule_warning"
+
+	EventAdminDisconnect   = "admin.disconnect"
+	EventAdminConfigUpdate = "admin.config_update"
+	EventAdminReload       = "admin.reload"
+)
+
+// Delivery headers
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-ID"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// signaturePrefix names the signature scheme in HeaderSignature
+const signaturePrefix = "sha256="
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// SessionData is the payload of session.* events
+type SessionData struct {
+	SessionID       string `json:"session_id"`
+	Username        string `json:"username"`
+	GroupName       string `json:"group,omitempty"`
+	ClientIP        string `json:"client_ip,omitempty"`
+	VPNIPv4         string `json:"vpn_ipv4,omitempty"`
+	VPNIPv6         string `json:"vpn_ipv6,omitempty"`
+	Status          string `json:"status,omitempty"`
+	PreviousStatus  string `json:"previous_status,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+	BytesIn         uint64 `json:"bytes_in,omitempty"`
+	BytesOut        uint64 `json:"bytes_out,omitempty"`
+	DurationSeconds int64  `json:"duration_seconds,omitempty"`
+
+	// Deadline is when the session will be disconnected (schedule warnings only)
+	Deadline time.Time `json:"deadline,omitzero"`
+}
+
+// AdminData is the payload of admin.* events
+type AdminData struct {
+	Action  string            `json:"action"`
+	Target  string            `json:"target,omitempty"` // username, config name, ...
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Sign returns the HeaderSignature value for a body sent at timestamp
+// (Unix seconds as sent in HeaderTimestamp)
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a HeaderSignature value in constant time
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// newEventID returns a random event identifier
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}